@@ -0,0 +1,284 @@
+package apu
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// https://www.nesdev.org/wiki/APU
+//
+// APU按CPU周期运行: Clock(cycles)推进状态，同时按SampleRate采样。
+// 采样是一个采样周期内输出的平均值，再经过一个高通滤波去掉直流分量，范围大约是[-1, 1]。
+
+const (
+	CPUFrequency      = 1789773 // NTSC
+	DefaultSampleRate = 44100
+)
+
+var lengthTable = [32]byte{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// Sink 接收APU输出的采样，例如WAV文件或者声卡
+type Sink interface {
+	WriteSamples(samples []float32) error
+}
+
+type APU struct {
+	Pulse1   Pulse
+	Pulse2   Pulse
+	Triangle Triangle
+	Noise    Noise
+	DMC      DMC
+
+	// SampleRate 每秒输出多少个采样，修改后下一个采样开始生效
+	SampleRate int
+	// Expansions 卡带上的扩展音源，和APU一起按CPU周期运行并混音
+	Expansions []Expansion
+
+	cycle      uint64
+	frameStep  int
+	fiveStep   bool
+	irqInhibit bool
+	frameIRQ   bool
+
+	sampleSum   float64
+	sampleCount int
+	sampleTime  float64 // 距离下一个采样还有多少个CPU周期
+	prevIn      float64
+	prevOut     float64
+	samples     []float32
+}
+
+// New read用来读取DMC的采样数据，一般是CPU的Read
+func New(read func(addr uint16) byte) *APU {
+	a := &APU{SampleRate: DefaultSampleRate}
+	a.DMC.read = read
+	a.Reset()
+	return a
+}
+
+// Reset 上电状态，所有声道关闭
+func (a *APU) Reset() {
+	read := a.DMC.read
+	rate := a.SampleRate
+	expansions := a.Expansions
+	*a = APU{SampleRate: rate, Expansions: expansions}
+	a.Pulse1.sweepNegateOnes = true
+	a.Noise.shift = 1
+	a.DMC.read = read
+	a.DMC.period = dmcPeriods[0]
+	a.DMC.bufferEmpty = true
+	a.DMC.silence = true
+	a.DMC.bits = 8
+	a.Noise.period = noisePeriods[0]
+}
+
+func (a *APU) Write(addr uint16, val byte) {
+	switch {
+	case addr >= 0x4000 && addr <= 0x4003:
+		a.Pulse1.write(addr-0x4000, val)
+	case addr >= 0x4004 && addr <= 0x4007:
+		a.Pulse2.write(addr-0x4004, val)
+	case addr >= 0x4008 && addr <= 0x400B:
+		a.Triangle.write(addr-0x4008, val)
+	case addr >= 0x400C && addr <= 0x400F:
+		a.Noise.write(addr-0x400C, val)
+	case addr >= 0x4010 && addr <= 0x4013:
+		a.DMC.write(addr-0x4010, val)
+	case addr == 0x4015:
+		a.Pulse1.setEnabled(val&0x01 != 0)
+		a.Pulse2.setEnabled(val&0x02 != 0)
+		a.Triangle.setEnabled(val&0x04 != 0)
+		a.Noise.setEnabled(val&0x08 != 0)
+		a.DMC.setEnabled(val&0x10 != 0)
+	case addr == 0x4017:
+		a.fiveStep = val&0x80 != 0
+		a.irqInhibit = val&0x40 != 0
+		if a.irqInhibit {
+			a.frameIRQ = false
+		}
+		a.frameStep = 0
+		a.cycle = 0
+		if a.fiveStep { // 5步模式写入时立即产生一次半帧和四分之一帧时钟
+			a.quarterFrame()
+			a.halfFrame()
+		}
+	}
+}
+
+// Read 只有$4015可以读，读的时候清除帧中断
+func (a *APU) Read(addr uint16) byte {
+	val := a.Peek(addr)
+	if addr == 0x4015 {
+		a.frameIRQ = false
+	}
+	return val
+}
+
+func (a *APU) Peek(addr uint16) byte {
+	if addr != 0x4015 {
+		return 0
+	}
+	var val byte
+	if a.Pulse1.length > 0 {
+		val |= 0x01
+	}
+	if a.Pulse2.length > 0 {
+		val |= 0x02
+	}
+	if a.Triangle.length > 0 {
+		val |= 0x04
+	}
+	if a.Noise.length > 0 {
+		val |= 0x08
+	}
+	if a.DMC.remaining > 0 {
+		val |= 0x10
+	}
+	if a.frameIRQ {
+		val |= 0x40
+	}
+	if a.DMC.irq {
+		val |= 0x80
+	}
+	return val
+}
+
+// IRQ 帧计数器或DMC是否在请求中断
+func (a *APU) IRQ() bool {
+	return a.frameIRQ || a.DMC.irq
+}
+
+// WriteState 把各个通道、帧计数器和采样的状态写到w，用来计算模拟器状态的hash。
+// 扩展音源实现了WriteState时也一起写出。
+func (a *APU) WriteState(w io.Writer) {
+	binary.Write(w, binary.LittleEndian, &a.Pulse1)
+	binary.Write(w, binary.LittleEndian, &a.Pulse2)
+	binary.Write(w, binary.LittleEndian, &a.Triangle)
+	binary.Write(w, binary.LittleEndian, &a.Noise)
+	a.DMC.writeState(w)
+	binary.Write(w, binary.LittleEndian, []bool{a.fiveStep, a.irqInhibit, a.frameIRQ})
+	binary.Write(w, binary.LittleEndian, []int64{int64(a.cycle), int64(a.frameStep), int64(a.sampleCount)})
+	binary.Write(w, binary.LittleEndian, []float64{a.sampleSum, a.sampleTime, a.prevIn, a.prevOut})
+	for _, e := range a.Expansions {
+		if s, ok := e.(interface{ WriteState(w io.Writer) }); ok {
+			s.WriteState(w)
+		}
+	}
+}
+
+// Clock 前进cycles个CPU周期
+func (a *APU) Clock(cycles int) {
+	for i := 0; i < cycles; i++ {
+		a.step()
+	}
+}
+
+// 帧计数器的时钟点，单位是CPU周期
+var frameSteps = [5]uint64{7457, 14913, 22371, 29829, 37281}
+
+func (a *APU) step() {
+	a.cycle += 1
+	if a.frameStep < len(frameSteps) && a.cycle == frameSteps[a.frameStep] {
+		a.clockFrame()
+	}
+	if a.cycle&1 == 0 {
+		a.Pulse1.clockTimer()
+		a.Pulse2.clockTimer()
+	}
+	a.Triangle.clockTimer()
+	a.Noise.clockTimer()
+	a.DMC.clockTimer()
+	out := a.output()
+	for _, e := range a.Expansions {
+		e.Clock()
+		out += e.Output()
+	}
+
+	a.sampleSum += out
+	a.sampleCount += 1
+	a.sampleTime -= 1
+	if a.sampleTime <= 0 {
+		a.sampleTime += float64(CPUFrequency) / float64(a.SampleRate)
+		a.emitSample()
+	}
+}
+
+func (a *APU) clockFrame() {
+	step := a.frameStep
+	a.frameStep += 1
+	if !a.fiveStep {
+		a.quarterFrame()
+		if step == 1 || step == 3 {
+			a.halfFrame()
+		}
+		if step == 3 {
+			if !a.irqInhibit {
+				a.frameIRQ = true
+			}
+			a.frameStep = 0
+			a.cycle = 0
+		}
+		return
+	}
+	if step == 3 { // 5步模式的第4步什么都不做
+		return
+	}
+	a.quarterFrame()
+	if step == 1 || step == 4 {
+		a.halfFrame()
+	}
+	if step == 4 {
+		a.frameStep = 0
+		a.cycle = 0
+	}
+}
+
+func (a *APU) quarterFrame() {
+	a.Pulse1.envelope.clock()
+	a.Pulse2.envelope.clock()
+	a.Triangle.clockLinear()
+	a.Noise.envelope.clock()
+}
+
+func (a *APU) halfFrame() {
+	a.Pulse1.clockLength()
+	a.Pulse1.clockSweep()
+	a.Pulse2.clockLength()
+	a.Pulse2.clockSweep()
+	a.Triangle.clockLength()
+	a.Noise.clockLength()
+}
+
+// output 非线性混音 https://www.nesdev.org/wiki/APU_Mixer 范围[0, 1)
+func (a *APU) output() float64 {
+	var pulseOut, tndOut float64
+	if p := float64(a.Pulse1.output() + a.Pulse2.output()); p > 0 {
+		pulseOut = 95.88 / (8128/p + 100)
+	}
+	t, n, d := float64(a.Triangle.output()), float64(a.Noise.output()), float64(a.DMC.output())
+	if t+n+d > 0 {
+		tndOut = 159.79 / (1/(t/8227+n/12241+d/22638) + 100)
+	}
+	return pulseOut + tndOut
+}
+
+// emitSample 取平均值之后用一阶高通滤波(约37Hz)去掉直流分量
+func (a *APU) emitSample() {
+	in := a.sampleSum / float64(a.sampleCount)
+	a.sampleSum, a.sampleCount = 0, 0
+	alpha := 1 / (1 + 2*math.Pi*37/float64(a.SampleRate))
+	out := alpha * (a.prevOut + in - a.prevIn)
+	a.prevIn, a.prevOut = in, out
+	a.samples = append(a.samples, float32(out*2))
+}
+
+// Samples 取出上次调用之后产生的采样
+func (a *APU) Samples() []float32 {
+	res := a.samples
+	a.samples = nil
+	return res
+}
@@ -0,0 +1,96 @@
+package apu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 方波1: 固定音量15，50%占空比，period=253，频率约440Hz
+func playSquare(a *APU) {
+	a.Write(0x4015, 0x01)
+	a.Write(0x4000, 0xBF)
+	a.Write(0x4002, 253)
+	a.Write(0x4003, 0x08)
+}
+
+func TestPulse(t *testing.T) {
+	a := New(nil)
+	playSquare(a)
+	require.Equal(t, byte(0x01), a.Peek(0x4015))
+	a.Clock(CPUFrequency / 10)
+	samples := a.Samples()
+	require.InDelta(t, DefaultSampleRate/10, len(samples), 1)
+
+	// 数一下过零的次数来估计频率
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings += 1
+		}
+	}
+	require.InDelta(t, 88, crossings, 4)
+
+	a.Write(0x4015, 0x00)
+	require.Equal(t, byte(0x00), a.Peek(0x4015)&0x1F)
+}
+
+func TestFrameIRQ(t *testing.T) {
+	a := New(nil)
+	a.Write(0x4017, 0x00)
+	a.Clock(29830)
+	require.True(t, a.IRQ())
+	require.Equal(t, byte(0x40), a.Read(0x4015))
+	require.False(t, a.IRQ())
+
+	a.Write(0x4017, 0x40)
+	a.Clock(29830)
+	require.False(t, a.IRQ())
+}
+
+func TestWavWriter(t *testing.T) {
+	f, err := os.CreateTemp("", "apu-*.wav")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w, err := NewWavWriter(f, 22050)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSamples([]float32{0, 0.5, -2}))
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Len(t, data, 44+6)
+	require.Equal(t, "RIFF", string(data[:4]))
+	require.Equal(t, uint32(42), binary.LittleEndian.Uint32(data[4:]))
+	require.Equal(t, uint32(22050), binary.LittleEndian.Uint32(data[24:]))
+	require.Equal(t, uint32(6), binary.LittleEndian.Uint32(data[40:]))
+	require.Equal(t, int16(-32767), int16(binary.LittleEndian.Uint16(data[48:])))
+
+	samples, rate, err := ReadWav(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 22050, rate)
+	require.Len(t, samples, 3)
+	require.InDelta(t, 0.5, samples[1], 0.001)
+	require.InDelta(t, -1, samples[2], 0.001)
+	_, _, err = ReadWav(bytes.NewReader(data[:40]))
+	require.Error(t, err)
+}
+
+func TestCommandSink(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "pcm")
+	c, err := NewCommandSink("dd of="+out+" bs={rate} status=none", 8000)
+	require.NoError(t, err)
+	require.NoError(t, c.WriteSamples([]float32{0, 0.5, -2}))
+	require.NoError(t, c.Close())
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 0, 0xFF, 0x3F, 0x01, 0x80}, data)
+
+	_, err = NewCommandSink(" ", 8000)
+	require.Error(t, err)
+}
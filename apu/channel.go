@@ -0,0 +1,417 @@
+package apu
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// envelope 方波和噪声共用的音量包络
+type envelope struct {
+	start    bool
+	loop     bool // 和长度计数器的halt是同一位
+	constant bool
+	volume   byte // 固定音量，或者包络的周期
+	divider  byte
+	decay    byte
+}
+
+func (e *envelope) write(val byte) {
+	e.loop = val&0x20 != 0
+	e.constant = val&0x10 != 0
+	e.volume = val & 0x0F
+}
+
+func (e *envelope) clock() {
+	if e.start {
+		e.start = false
+		e.decay = 15
+		e.divider = e.volume
+		return
+	}
+	if e.divider > 0 {
+		e.divider -= 1
+		return
+	}
+	e.divider = e.volume
+	if e.decay > 0 {
+		e.decay -= 1
+	} else if e.loop {
+		e.decay = 15
+	}
+}
+
+func (e *envelope) output() byte {
+	if e.constant {
+		return e.volume
+	}
+	return e.decay
+}
+
+var dutyTable = [4][8]byte{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+// Pulse 方波 $4000-$4003 / $4004-$4007
+type Pulse struct {
+	enabled  bool
+	duty     byte
+	seq      byte
+	period   uint16
+	timer    uint16
+	length   byte
+	envelope envelope
+
+	sweepEnabled    bool
+	sweepPeriod     byte
+	sweepNegate     bool
+	sweepShift      byte
+	sweepReload     bool
+	sweepDivider    byte
+	sweepNegateOnes bool // 方波1取反时多减1
+	noSweep         bool // MMC5的方波没有sweep单元，period小于8也不会静音
+}
+
+func (p *Pulse) write(reg uint16, val byte) {
+	switch reg {
+	case 0:
+		p.duty = val >> 6
+		p.envelope.write(val)
+	case 1:
+		p.sweepEnabled = val&0x80 != 0
+		p.sweepPeriod = val >> 4 & 0x07
+		p.sweepNegate = val&0x08 != 0
+		p.sweepShift = val & 0x07
+		p.sweepReload = true
+	case 2:
+		p.period = p.period&0x0700 | uint16(val)
+	case 3:
+		p.period = p.period&0x00FF | uint16(val&0x07)<<8
+		if p.enabled {
+			p.length = lengthTable[val>>3]
+		}
+		p.seq = 0
+		p.envelope.start = true
+	}
+}
+
+func (p *Pulse) setEnabled(enabled bool) {
+	p.enabled = enabled
+	if !enabled {
+		p.length = 0
+	}
+}
+
+func (p *Pulse) clockTimer() {
+	if p.timer == 0 {
+		p.timer = p.period
+		p.seq = (p.seq + 1) & 7
+	} else {
+		p.timer -= 1
+	}
+}
+
+func (p *Pulse) clockLength() {
+	if !p.envelope.loop && p.length > 0 {
+		p.length -= 1
+	}
+}
+
+func (p *Pulse) sweepTarget() uint16 {
+	change := p.period >> p.sweepShift
+	if !p.sweepNegate {
+		return p.period + change
+	}
+	if p.sweepNegateOnes {
+		change += 1
+	}
+	if change > p.period {
+		return 0
+	}
+	return p.period - change
+}
+
+func (p *Pulse) muted() bool {
+	if p.noSweep {
+		return false
+	}
+	return p.period < 8 || p.sweepTarget() > 0x7FF
+}
+
+func (p *Pulse) clockSweep() {
+	if p.sweepDivider == 0 && p.sweepEnabled && p.sweepShift > 0 && !p.muted() {
+		p.period = p.sweepTarget()
+	}
+	if p.sweepDivider == 0 || p.sweepReload {
+		p.sweepDivider = p.sweepPeriod
+		p.sweepReload = false
+	} else {
+		p.sweepDivider -= 1
+	}
+}
+
+func (p *Pulse) output() byte {
+	if p.length == 0 || p.muted() || dutyTable[p.duty][p.seq] == 0 {
+		return 0
+	}
+	return p.envelope.output()
+}
+
+var triangleTable = [32]byte{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// Triangle 三角波 $4008-$400B
+type Triangle struct {
+	enabled      bool
+	control      bool // 同时是长度计数器的halt
+	linearReload byte
+	linear       byte
+	reloadFlag   bool
+	period       uint16
+	timer        uint16
+	seq          byte
+	length       byte
+}
+
+func (t *Triangle) write(reg uint16, val byte) {
+	switch reg {
+	case 0:
+		t.control = val&0x80 != 0
+		t.linearReload = val & 0x7F
+	case 2:
+		t.period = t.period&0x0700 | uint16(val)
+	case 3:
+		t.period = t.period&0x00FF | uint16(val&0x07)<<8
+		if t.enabled {
+			t.length = lengthTable[val>>3]
+		}
+		t.reloadFlag = true
+	}
+}
+
+func (t *Triangle) setEnabled(enabled bool) {
+	t.enabled = enabled
+	if !enabled {
+		t.length = 0
+	}
+}
+
+func (t *Triangle) clockTimer() {
+	if t.timer > 0 {
+		t.timer -= 1
+		return
+	}
+	t.timer = t.period
+	if t.length > 0 && t.linear > 0 {
+		t.seq = (t.seq + 1) & 31
+	}
+}
+
+func (t *Triangle) clockLinear() {
+	if t.reloadFlag {
+		t.linear = t.linearReload
+	} else if t.linear > 0 {
+		t.linear -= 1
+	}
+	if !t.control {
+		t.reloadFlag = false
+	}
+}
+
+func (t *Triangle) clockLength() {
+	if !t.control && t.length > 0 {
+		t.length -= 1
+	}
+}
+
+func (t *Triangle) output() byte {
+	if t.period < 2 { // 超声波频率听不见，输出中间的电平避免爆音
+		return 7
+	}
+	return triangleTable[t.seq]
+}
+
+var noisePeriods = [16]uint16{4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068}
+
+// Noise 噪声 $400C-$400F
+type Noise struct {
+	enabled  bool
+	mode     bool
+	period   uint16
+	timer    uint16
+	shift    uint16 // 15位的线性反馈移位寄存器
+	length   byte
+	envelope envelope
+}
+
+func (n *Noise) write(reg uint16, val byte) {
+	switch reg {
+	case 0:
+		n.envelope.write(val)
+	case 2:
+		n.mode = val&0x80 != 0
+		n.period = noisePeriods[val&0x0F]
+	case 3:
+		if n.enabled {
+			n.length = lengthTable[val>>3]
+		}
+		n.envelope.start = true
+	}
+}
+
+func (n *Noise) setEnabled(enabled bool) {
+	n.enabled = enabled
+	if !enabled {
+		n.length = 0
+	}
+}
+
+func (n *Noise) clockTimer() {
+	if n.timer > 0 {
+		n.timer -= 1
+		return
+	}
+	n.timer = n.period - 1
+	bit := uint16(1)
+	if n.mode {
+		bit = 6
+	}
+	feedback := (n.shift ^ n.shift>>bit) & 1
+	n.shift = n.shift>>1 | feedback<<14
+}
+
+func (n *Noise) clockLength() {
+	if !n.envelope.loop && n.length > 0 {
+		n.length -= 1
+	}
+}
+
+func (n *Noise) output() byte {
+	if n.length == 0 || n.shift&1 != 0 {
+		return 0
+	}
+	return n.envelope.output()
+}
+
+var dmcPeriods = [16]uint16{428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54}
+
+// DMC 增量调制采样 $4010-$4013
+type DMC struct {
+	read      func(addr uint16) byte
+	irqEnable bool
+	irq       bool
+	loop      bool
+	period    uint16
+	timer     uint16
+	level     byte
+
+	sampleAddr   uint16
+	sampleLength uint16
+	addr         uint16
+	remaining    uint16
+
+	buffer      byte
+	bufferEmpty bool
+	shift       byte
+	bits        byte
+	silence     bool
+}
+
+// writeState read是函数，不能直接用binary.Write
+func (d *DMC) writeState(w io.Writer) {
+	binary.Write(w, binary.LittleEndian, []bool{d.irqEnable, d.irq, d.loop, d.bufferEmpty, d.silence})
+	binary.Write(w, binary.LittleEndian, []uint16{d.period, d.timer, d.sampleAddr, d.sampleLength, d.addr, d.remaining})
+	w.Write([]byte{d.level, d.buffer, d.shift, d.bits})
+}
+
+func (d *DMC) write(reg uint16, val byte) {
+	switch reg {
+	case 0:
+		d.irqEnable = val&0x80 != 0
+		d.loop = val&0x40 != 0
+		d.period = dmcPeriods[val&0x0F]
+		if !d.irqEnable {
+			d.irq = false
+		}
+	case 1:
+		d.level = val & 0x7F
+	case 2:
+		d.sampleAddr = 0xC000 + uint16(val)*64
+	case 3:
+		d.sampleLength = uint16(val)*16 + 1
+	}
+}
+
+func (d *DMC) setEnabled(enabled bool) {
+	d.irq = false
+	if !enabled {
+		d.remaining = 0
+	} else if d.remaining == 0 {
+		d.restart()
+	}
+}
+
+func (d *DMC) restart() {
+	d.addr = d.sampleAddr
+	d.remaining = d.sampleLength
+}
+
+// fetch 采样缓冲空了的时候从内存读取下一个字节
+func (d *DMC) fetch() {
+	if !d.bufferEmpty || d.remaining == 0 || d.read == nil {
+		return
+	}
+	d.buffer = d.read(d.addr)
+	d.bufferEmpty = false
+	if d.addr == 0xFFFF {
+		d.addr = 0x8000
+	} else {
+		d.addr += 1
+	}
+	d.remaining -= 1
+	if d.remaining == 0 {
+		if d.loop {
+			d.restart()
+		} else if d.irqEnable {
+			d.irq = true
+		}
+	}
+}
+
+func (d *DMC) clockTimer() {
+	d.fetch()
+	if d.timer > 0 {
+		d.timer -= 1
+		return
+	}
+	d.timer = d.period - 1
+	if !d.silence {
+		if d.shift&1 != 0 {
+			if d.level <= 125 {
+				d.level += 2
+			}
+		} else if d.level >= 2 {
+			d.level -= 2
+		}
+	}
+	d.shift >>= 1
+	d.bits -= 1
+	if d.bits == 0 { // 开始新的输出周期
+		d.bits = 8
+		if d.bufferEmpty {
+			d.silence = true
+		} else {
+			d.silence = false
+			d.shift = d.buffer
+			d.bufferEmpty = true
+		}
+	}
+}
+
+func (d *DMC) output() byte {
+	return d.level
+}
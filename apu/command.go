@@ -0,0 +1,53 @@
+package apu
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// DefaultPlayCommand 从标准输入播放16位单声道PCM的命令，{rate}会换成采样率。Linux上的aplay来自alsa-utils
+const DefaultPlayCommand = "aplay -q -t raw -f S16_LE -c 1 -r {rate}"
+
+// CommandSink 把采样交给外部播放程序的标准输入，例如aplay，这样不需要链接声卡的库。
+//
+// 播放程序按采样率消耗数据，它的缓冲写满时WriteSamples会阻塞，所以写入的速度就是实际播放的速度。
+type CommandSink struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	buf []byte
+}
+
+// NewCommandSink 启动command，参数用空格分开
+func NewCommandSink(command string, sampleRate int) (*CommandSink, error) {
+	args := strings.Fields(strings.ReplaceAll(command, "{rate}", strconv.Itoa(sampleRate)))
+	if len(args) == 0 {
+		return nil, errors.New("empty play command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &CommandSink{cmd: cmd, in: in}, nil
+}
+
+func (c *CommandSink) WriteSamples(samples []float32) error {
+	c.buf = appendPCM16(c.buf[:0], samples)
+	_, err := c.in.Write(c.buf)
+	return err
+}
+
+// Close 关闭标准输入，等播放程序放完剩下的采样后退出
+func (c *CommandSink) Close() error {
+	c.in.Close()
+	return c.cmd.Wait()
+}
@@ -0,0 +1,26 @@
+package apu
+
+// Expansion 卡带上的扩展音源。APU每个CPU周期调用一次Clock，
+// Output和APU自己的混音相加之后一起采样，所以Output要按下面的比例换算成APU的电平。
+//
+// 寄存器写入由卡带(或者NSF的总线)转发给Write，不属于这个音源的地址直接忽略。
+type Expansion interface {
+	Write(addr uint16, val byte)
+	Clock()
+	Output() float64
+}
+
+// 各个音源相对APU的音量，参考 https://www.nesdev.org/wiki/Expansion_audio 里的比例。
+// APU方波音量每一级的线性近似值是0.00752，VRC6和MMC5的方波和它一样响。
+const (
+	pulseLevel   = 0.00752
+	mmc5PCMLevel = 0.002  // 8位PCM
+	vrc7Level    = 0.15   // 一个通道满音量
+	s5bLevel     = 0.15   // 一个通道满音量
+	n163Level    = 0.0012 // 每一级(采样-8)*音量
+)
+
+// AddExpansion 加入一个扩展音源
+func (a *APU) AddExpansion(e Expansion) {
+	a.Expansions = append(a.Expansions, e)
+}
@@ -0,0 +1,132 @@
+package apu
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// render 运行cycles个周期，返回输出的最小值和最大值
+func render(e Expansion, cycles int) (float64, float64) {
+	min, max := e.Output(), e.Output()
+	for i := 0; i < cycles; i++ {
+		e.Clock()
+		out := e.Output()
+		if out < min {
+			min = out
+		}
+		if out > max {
+			max = out
+		}
+	}
+	return min, max
+}
+
+func TestVRC6(t *testing.T) {
+	v := NewVRC6()
+	v.Write(0x9000, 0x7F) // 50%占空比，音量15
+	v.Write(0x9001, 0xFF)
+	v.Write(0x9002, 0x80)
+	min, max := render(v, 10000)
+	require.Equal(t, 0.0, min)
+	require.InDelta(t, 15*pulseLevel, max, 1e-9)
+
+	v.Write(0x9002, 0x00)
+	v.Write(0xB000, 0x2A) // 锯齿波最大的不溢出的rate
+	v.Write(0xB001, 0x10)
+	v.Write(0xB002, 0x80)
+	_, max = render(v, 10000)
+	require.InDelta(t, float64(42*6>>3)*pulseLevel, max, 1e-9)
+}
+
+func TestMMC5(t *testing.T) {
+	m := NewMMC5()
+	m.Write(0x5015, 0x01)
+	m.Write(0x5000, 0xBF)
+	m.Write(0x5002, 0x04) // period小于8也不静音
+	m.Write(0x5003, 0x08)
+	require.Equal(t, byte(0x01), m.Peek(0x5015))
+	_, max := render(m, 1000)
+	require.InDelta(t, 15*pulseLevel, max, 1e-9)
+
+	m.Write(0x5015, 0x00)
+	m.Write(0x5011, 0x80)
+	m.Write(0x5011, 0x00) // 写0被忽略
+	require.InDelta(t, 0x80*mmc5PCMLevel, m.Output(), 1e-9)
+}
+
+func TestS5B(t *testing.T) {
+	s := NewS5B()
+	write := func(reg, val byte) {
+		s.Write(0xC000, reg)
+		s.Write(0xE000, val)
+	}
+	write(0, 100)
+	write(7, 0x3E) // 只打开A通道的方波
+	write(8, 0x0F)
+	min, max := render(s, 100000)
+	require.Equal(t, 0.0, min)
+	require.InDelta(t, s5bLevel, max, 1e-9)
+
+	// 包络 /|/|，电平每一步增加
+	write(8, 0x10)
+	write(11, 1)
+	write(13, 0x0C)
+	require.Equal(t, byte(0), s.envLevel())
+	render(s, 16*10)
+	require.Equal(t, byte(10), s.envLevel())
+}
+
+func TestN163(t *testing.T) {
+	n := NewN163()
+	n.Write(0xF800, 0x80) // 从0开始自动加1
+	for i := 0; i < 8; i++ {
+		n.Write(0x4800, 0xF0) // 方波: 每个字节的低4位是0，高4位是15
+	}
+	n.Write(0xF800, 0x78)
+	n.Write(0x4800, 0x00)
+	n.Write(0xF800, 0x7A)
+	n.Write(0x4800, 0x01)
+	n.Write(0xF800, 0x7C)
+	n.Write(0x4800, 0xF0) // 长度16个采样
+	n.Write(0xF800, 0x7F)
+	n.Write(0x4800, 0x0F) // 一个通道，音量15
+
+	n.Write(0xF800, 0xFF)
+	require.Equal(t, byte(0x0F), n.Read(0x4800))
+	require.Equal(t, byte(0xF0), n.Read(0x4800)) // 地址回到0
+
+	min, max := render(n, 100000)
+	require.InDelta(t, -8*15*n163Level, min, 1e-9)
+	require.InDelta(t, 7*15*n163Level, max, 1e-9)
+}
+
+func TestVRC7(t *testing.T) {
+	v := NewVRC7()
+	write := func(reg, val byte) {
+		v.Write(0x9010, reg)
+		v.Write(0x9030, val)
+	}
+	write(0x10, 0xAC)
+	write(0x30, 0x30) // 3号音色，最大音量
+	write(0x20, 0x1A) // key on，block 5
+	min, max := render(v, 36*2000)
+	require.Less(t, min, -0.02)
+	require.Greater(t, max, 0.02)
+	require.LessOrEqual(t, max, vrc7Level)
+
+	write(0x20, 0x0A) // key off之后逐渐消失
+	render(v, CPUFrequency)
+	min, max = render(v, 36*100)
+	require.InDelta(t, 0, min, 1e-6)
+	require.InDelta(t, 0, max, 1e-6)
+}
+
+func TestAPUExpansion(t *testing.T) {
+	a := New(nil)
+	m := NewMMC5()
+	a.AddExpansion(m)
+	m.Write(0x5011, 0xFF)
+	a.Clock(CPUFrequency / 10)
+	samples := a.Samples()
+	require.Greater(t, samples[0], float32(0.1)) // 高通滤波之前的跳变
+}
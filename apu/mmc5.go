@@ -0,0 +1,74 @@
+package apu
+
+// MMC5 两个没有sweep的方波和一个8位PCM https://www.nesdev.org/wiki/MMC5_audio
+//
+// PCM只支持写模式($5011)，读模式需要卡带在CPU读$8000-$BFFF时配合，这里没有。
+type MMC5 struct {
+	Pulse1  Pulse
+	Pulse2  Pulse
+	pcm     byte
+	pcmRead bool
+	cycle   int
+}
+
+// mmc5FramePeriod 包络和长度计数器都是240Hz，没有APU那样的帧计数器模式
+const mmc5FramePeriod = 7457
+
+func NewMMC5() *MMC5 {
+	m := &MMC5{}
+	m.Pulse1.noSweep = true
+	m.Pulse2.noSweep = true
+	return m
+}
+
+func (m *MMC5) Write(addr uint16, val byte) {
+	switch {
+	case addr >= 0x5000 && addr <= 0x5003:
+		m.Pulse1.write(addr-0x5000, val)
+	case addr >= 0x5004 && addr <= 0x5007:
+		m.Pulse2.write(addr-0x5004, val)
+	case addr == 0x5010:
+		m.pcmRead = val&0x01 != 0
+	case addr == 0x5011:
+		if !m.pcmRead && val != 0 { // 写0不会改变输出
+			m.pcm = val
+		}
+	case addr == 0x5015:
+		m.Pulse1.setEnabled(val&0x01 != 0)
+		m.Pulse2.setEnabled(val&0x02 != 0)
+	}
+}
+
+// Peek $5015 返回两个方波的长度计数器状态
+func (m *MMC5) Peek(addr uint16) byte {
+	if addr != 0x5015 {
+		return 0
+	}
+	var val byte
+	if m.Pulse1.length > 0 {
+		val |= 0x01
+	}
+	if m.Pulse2.length > 0 {
+		val |= 0x02
+	}
+	return val
+}
+
+func (m *MMC5) Clock() {
+	m.cycle += 1
+	if m.cycle == mmc5FramePeriod {
+		m.cycle = 0
+		m.Pulse1.envelope.clock()
+		m.Pulse2.envelope.clock()
+		m.Pulse1.clockLength()
+		m.Pulse2.clockLength()
+	}
+	if m.cycle&1 == 0 {
+		m.Pulse1.clockTimer()
+		m.Pulse2.clockTimer()
+	}
+}
+
+func (m *MMC5) Output() float64 {
+	return float64(m.Pulse1.output()+m.Pulse2.output())*pulseLevel + float64(m.pcm)*mmc5PCMLevel
+}
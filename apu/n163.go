@@ -0,0 +1,99 @@
+package apu
+
+// N163 Namco 163 的波表音源 https://www.nesdev.org/wiki/Namco_163_audio
+//
+// 128字节的内部RAM同时保存波形和通道寄存器，通过$F800选择地址(bit7自动加1)，
+// $4800读写数据。最多8个通道，每15个CPU周期轮流更新一个通道，
+// 硬件上是分时输出的，这里直接输出启用的通道的平均值。
+type N163 struct {
+	Ram     [128]byte
+	addr    byte
+	autoInc bool
+
+	cycle   int
+	channel int    // 下一个更新的通道，从7往下数
+	outputs [8]int // 每个通道最后一次更新的输出
+}
+
+func NewN163() *N163 {
+	return &N163{channel: 7}
+}
+
+func (n *N163) Write(addr uint16, val byte) {
+	switch {
+	case addr >= 0xF800:
+		n.addr = val & 0x7F
+		n.autoInc = val&0x80 != 0
+	case addr >= 0x4800 && addr <= 0x4FFF:
+		n.Ram[n.addr] = val
+		n.increase()
+	}
+}
+
+// Read $4800 读内部RAM
+func (n *N163) Read(addr uint16) byte {
+	val := n.Peek(addr)
+	if addr >= 0x4800 && addr <= 0x4FFF {
+		n.increase()
+	}
+	return val
+}
+
+func (n *N163) Peek(addr uint16) byte {
+	if addr >= 0x4800 && addr <= 0x4FFF {
+		return n.Ram[n.addr]
+	}
+	return 0
+}
+
+func (n *N163) increase() {
+	if n.autoInc {
+		n.addr = (n.addr + 1) & 0x7F
+	}
+}
+
+// channels 启用的通道数，$7F的bit4-6
+func (n *N163) channels() int {
+	return int(n.Ram[0x7F]>>4&0x07) + 1
+}
+
+func (n *N163) Clock() {
+	n.cycle += 1
+	if n.cycle < 15 {
+		return
+	}
+	n.cycle = 0
+	n.update(n.channel)
+	n.channel -= 1
+	if n.channel < 8-n.channels() {
+		n.channel = 7
+	}
+}
+
+// update 通道寄存器在$40+ch*8，相位和频率都是24位，相位的高8位是波形中的位置
+func (n *N163) update(ch int) {
+	r := n.Ram[0x40+ch*8:]
+	freq := uint32(r[0]) | uint32(r[2])<<8 | uint32(r[4]&0x03)<<16
+	phase := uint32(r[1]) | uint32(r[3])<<8 | uint32(r[5])<<16
+	length := (256 - uint32(r[4]&0xFC)) << 16
+	phase = (phase + freq) % length
+	r[1], r[3], r[5] = byte(phase), byte(phase>>8), byte(phase>>16)
+
+	pos := (int(phase>>16) + int(r[6])) & 0xFF
+	sample := n.Ram[pos/2]
+	if pos&1 == 0 {
+		sample &= 0x0F
+	} else {
+		sample >>= 4
+	}
+	n.outputs[ch] = (int(sample) - 8) * int(r[7]&0x0F)
+}
+
+func (n *N163) Output() float64 {
+	count := n.channels()
+	sum := 0
+	for ch := 8 - count; ch < 8; ch++ {
+		sum += n.outputs[ch]
+	}
+	return float64(sum) / float64(count) * n163Level
+}
@@ -0,0 +1,142 @@
+package apu
+
+import "math"
+
+// S5B Sunsoft 5B，和AY-3-8910一样的三个方波、噪声和包络
+// https://www.nesdev.org/wiki/Sunsoft_5B_audio
+//
+// $C000写寄存器编号，$E000写寄存器的值。
+type S5B struct {
+	reg  byte
+	regs [16]byte
+
+	tone       [3]s5bTone
+	noiseTimer uint16
+	noise      uint32 // 17位的线性反馈移位寄存器
+	noiseBit   bool
+
+	envTimer   uint16
+	envStep    byte
+	envAttack  bool
+	envHolding bool
+	divider    int
+}
+
+type s5bTone struct {
+	timer uint16
+	bit   bool
+}
+
+// s5bVolumes 5B的音量是对数的，32级，每级1.5dB
+var s5bVolumes = func() [32]float64 {
+	var t [32]float64
+	for i := 1; i < 32; i++ {
+		t[i] = math.Pow(10, -float64(31-i)*1.5/20)
+	}
+	return t
+}()
+
+func NewS5B() *S5B {
+	return &S5B{noise: 1}
+}
+
+func (s *S5B) Write(addr uint16, val byte) {
+	switch addr {
+	case 0xC000:
+		s.reg = val & 0x0F
+	case 0xE000:
+		s.regs[s.reg] = val
+		if s.reg == 13 {
+			s.envStep = 0
+			s.envHolding = false
+			s.envAttack = val&0x04 != 0
+		}
+	}
+}
+
+func (s *S5B) tonePeriod(ch int) uint16 {
+	return uint16(s.regs[ch*2]) | uint16(s.regs[ch*2+1]&0x0F)<<8
+}
+
+// Clock 5B内部先2分频，方波和噪声再16分频，所以每16个CPU周期前进一步
+func (s *S5B) Clock() {
+	s.divider += 1
+	if s.divider < 16 {
+		return
+	}
+	s.divider = 0
+	for i := range s.tone {
+		t := &s.tone[i]
+		t.timer += 1
+		if t.timer >= s.tonePeriod(i) {
+			t.timer = 0
+			t.bit = !t.bit
+		}
+	}
+	s.noiseTimer += 1
+	if s.noiseTimer >= uint16(s.regs[6]&0x1F)*2 {
+		s.noiseTimer = 0
+		feedback := (s.noise ^ s.noise>>3) & 1
+		s.noise = s.noise>>1 | feedback<<16
+		s.noiseBit = s.noise&1 != 0
+	}
+	s.envTimer += 1
+	if s.envTimer >= uint16(s.regs[11])|uint16(s.regs[12])<<8 {
+		s.envTimer = 0
+		s.clockEnvelope()
+	}
+}
+
+// clockEnvelope 形状寄存器的4位是 continue/attack/alternate/hold
+func (s *S5B) clockEnvelope() {
+	if s.envHolding {
+		return
+	}
+	s.envStep += 1
+	if s.envStep < 32 {
+		return
+	}
+	shape := s.regs[13]
+	s.envStep = 0
+	if shape&0x08 == 0 {
+		s.envHolding = true
+		s.envAttack = false
+		s.envStep = 31 // 电平是31-31=0
+		return
+	}
+	if shape&0x02 != 0 {
+		s.envAttack = !s.envAttack
+	}
+	if shape&0x01 != 0 {
+		// 没有alternate时保持在刚才那段的终点，有alternate时保持在起点
+		s.envHolding = true
+		s.envStep = 31
+	}
+}
+
+func (s *S5B) envLevel() byte {
+	if s.envAttack {
+		return s.envStep
+	}
+	return 31 - s.envStep
+}
+
+func (s *S5B) Output() float64 {
+	mixer := s.regs[7]
+	var out float64
+	for i := range s.tone {
+		toneOff := mixer>>i&1 != 0
+		noiseOff := mixer>>(i+3)&1 != 0
+		if !(s.tone[i].bit || toneOff) || !(s.noiseBit || noiseOff) {
+			continue
+		}
+		level := s.regs[8+i]&0x0F*2 + 1
+		if s.regs[8+i]&0x10 != 0 {
+			level = s.envLevel()
+		} else if s.regs[8+i]&0x0F == 0 {
+			level = 0
+		}
+		out += s5bVolumes[level]
+	}
+	return out * s5bLevel
+}
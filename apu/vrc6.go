@@ -0,0 +1,142 @@
+package apu
+
+// VRC6 两个方波和一个锯齿波 https://www.nesdev.org/wiki/VRC6_audio
+//
+// 地址使用VRC6a(Akumajou Densetsu)的接法，VRC6b的A0和A1是交换的，由卡带转换之后再写进来。
+type VRC6 struct {
+	Pulse1 vrc6Pulse
+	Pulse2 vrc6Pulse
+	Saw    vrc6Saw
+	halt   bool
+	shift  byte
+}
+
+func NewVRC6() *VRC6 {
+	return &VRC6{}
+}
+
+func (v *VRC6) Write(addr uint16, val byte) {
+	switch addr {
+	case 0x9000, 0x9001, 0x9002:
+		v.Pulse1.write(addr-0x9000, val)
+	case 0x9003:
+		v.halt = val&0x01 != 0
+		switch {
+		case val&0x02 != 0:
+			v.shift = 4
+		case val&0x04 != 0:
+			v.shift = 8
+		default:
+			v.shift = 0
+		}
+	case 0xA000, 0xA001, 0xA002:
+		v.Pulse2.write(addr-0xA000, val)
+	case 0xB000, 0xB001, 0xB002:
+		v.Saw.write(addr-0xB000, val)
+	}
+}
+
+func (v *VRC6) Clock() {
+	if v.halt {
+		return
+	}
+	v.Pulse1.clock(v.shift)
+	v.Pulse2.clock(v.shift)
+	v.Saw.clock(v.shift)
+}
+
+func (v *VRC6) Output() float64 {
+	return float64(v.Pulse1.output()+v.Pulse2.output()+v.Saw.output()) * pulseLevel
+}
+
+type vrc6Pulse struct {
+	enabled bool
+	mode    bool // 为true时忽略占空比，一直输出音量
+	duty    byte
+	volume  byte
+	period  uint16
+	timer   uint16
+	step    byte
+}
+
+func (p *vrc6Pulse) write(reg uint16, val byte) {
+	switch reg {
+	case 0:
+		p.mode = val&0x80 != 0
+		p.duty = val >> 4 & 0x07
+		p.volume = val & 0x0F
+	case 1:
+		p.period = p.period&0x0F00 | uint16(val)
+	case 2:
+		p.period = p.period&0x00FF | uint16(val&0x0F)<<8
+		p.enabled = val&0x80 != 0
+		if !p.enabled {
+			p.step = 15
+		}
+	}
+}
+
+func (p *vrc6Pulse) clock(shift byte) {
+	if !p.enabled {
+		return
+	}
+	if p.timer > 0 {
+		p.timer -= 1
+		return
+	}
+	p.timer = p.period >> shift
+	p.step = (p.step - 1) & 0x0F
+}
+
+func (p *vrc6Pulse) output() byte {
+	if !p.enabled || !p.mode && p.step > p.duty {
+		return 0
+	}
+	return p.volume
+}
+
+type vrc6Saw struct {
+	enabled bool
+	rate    byte
+	period  uint16
+	timer   uint16
+	step    byte
+	acc     byte
+}
+
+func (s *vrc6Saw) write(reg uint16, val byte) {
+	switch reg {
+	case 0:
+		s.rate = val & 0x3F
+	case 1:
+		s.period = s.period&0x0F00 | uint16(val)
+	case 2:
+		s.period = s.period&0x00FF | uint16(val&0x0F)<<8
+		s.enabled = val&0x80 != 0
+		if !s.enabled {
+			s.step, s.acc = 0, 0
+		}
+	}
+}
+
+// clock 累加器每两步加一次rate，第14步清零
+func (s *vrc6Saw) clock(shift byte) {
+	if !s.enabled {
+		return
+	}
+	if s.timer > 0 {
+		s.timer -= 1
+		return
+	}
+	s.timer = s.period >> shift
+	s.step += 1
+	if s.step == 14 {
+		s.step, s.acc = 0, 0
+	} else if s.step&1 == 0 {
+		s.acc += s.rate
+	}
+}
+
+func (s *vrc6Saw) output() byte {
+	return s.acc >> 3
+}
@@ -0,0 +1,259 @@
+package apu
+
+import "math"
+
+// VRC7 6个通道的FM音源，是YM2413(OPLL)的简化版 https://www.nesdev.org/wiki/VRC7_audio
+//
+// 这里只实现了OPLL的一个子集: 两个算子的FM、反馈、半波整流、ADSR包络、AM和颤音，
+// 包络用dB线性变化来近似，没有实现KSL。
+// $9010写寄存器编号，$9030写寄存器的值。
+type VRC7 struct {
+	addr     byte
+	regs     [0x40]byte
+	channels [6]opllChannel
+	divider  int
+	amPhase  float64
+	vibPhase float64
+	out      float64
+}
+
+// 内置的15个音色，0号音色是$00-$07的自定义音色
+var vrc7Patches = [15][8]byte{
+	{0x03, 0x21, 0x05, 0x06, 0xE8, 0x81, 0x42, 0x27},
+	{0x13, 0x41, 0x14, 0x0D, 0xD8, 0xF6, 0x23, 0x12},
+	{0x11, 0x11, 0x08, 0x08, 0xFA, 0xB2, 0x20, 0x12},
+	{0x31, 0x61, 0x0C, 0x07, 0xA8, 0x64, 0x61, 0x27},
+	{0x32, 0x21, 0x1E, 0x06, 0xE1, 0x76, 0x01, 0x28},
+	{0x02, 0x01, 0x06, 0x00, 0xA3, 0xE2, 0xF4, 0xF4},
+	{0x21, 0x61, 0x1D, 0x07, 0x82, 0x81, 0x11, 0x07},
+	{0x23, 0x21, 0x22, 0x17, 0xA2, 0x72, 0x01, 0x17},
+	{0x35, 0x11, 0x25, 0x00, 0x40, 0x73, 0x72, 0x01},
+	{0xB5, 0x01, 0x0F, 0x0F, 0xA8, 0xA5, 0x51, 0x02},
+	{0x17, 0xC1, 0x24, 0x07, 0xF8, 0xF8, 0x22, 0x12},
+	{0x71, 0x23, 0x11, 0x06, 0x65, 0x74, 0x18, 0x16},
+	{0x01, 0x02, 0xD3, 0x05, 0xC9, 0x95, 0x03, 0x02},
+	{0x61, 0x63, 0x0C, 0x00, 0x94, 0xC0, 0x33, 0xF6},
+	{0x21, 0x72, 0x0D, 0x00, 0xC1, 0xD5, 0x56, 0x06},
+}
+
+var opllMultiples = [16]float64{0.5, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 10, 12, 12, 15, 15}
+
+const (
+	opllSampleRate = float64(CPUFrequency) / 36 // 3.58MHz / 72
+	opllMaxAtten   = 48.0                       // 衰减到这么多dB就认为没有声音了
+	opllAMDepth    = 4.8                        // dB
+	opllAMFreq     = 3.7
+	opllVibDepth   = 0.004 // 约7音分
+	opllVibFreq    = 6.4
+)
+
+type opllState int
+
+const (
+	opllOff opllState = iota
+	opllAttack
+	opllDecay
+	opllSustain
+	opllRelease
+)
+
+type opllOperator struct {
+	phase float64 // 单位是周期，[0, 1)
+	atten float64 // 包络的衰减，dB
+	state opllState
+	out   [2]float64 // 最近两次输出，调制器的反馈用
+}
+
+type opllChannel struct {
+	mod opllOperator
+	car opllOperator
+	key bool
+}
+
+func NewVRC7() *VRC7 {
+	v := &VRC7{}
+	for i := range v.channels {
+		v.channels[i].mod.atten = opllMaxAtten
+		v.channels[i].car.atten = opllMaxAtten
+	}
+	return v
+}
+
+func (v *VRC7) Write(addr uint16, val byte) {
+	switch addr {
+	case 0x9010:
+		v.addr = val & 0x3F
+	case 0x9030:
+		v.regs[v.addr] = val
+		if v.addr >= 0x20 && v.addr <= 0x25 {
+			ch := &v.channels[v.addr-0x20]
+			key := val&0x10 != 0
+			if key && !ch.key {
+				ch.mod.keyOn()
+				ch.car.keyOn()
+			} else if !key && ch.key {
+				ch.mod.keyOff()
+				ch.car.keyOff()
+			}
+			ch.key = key
+		}
+	}
+}
+
+func (op *opllOperator) keyOn() {
+	op.phase = 0
+	op.state = opllAttack
+}
+
+func (op *opllOperator) keyOff() {
+	if op.state != opllOff {
+		op.state = opllRelease
+	}
+}
+
+func (v *VRC7) patch(ch int) []byte {
+	inst := v.regs[0x30+ch] >> 4
+	if inst == 0 {
+		return v.regs[0:8]
+	}
+	return vrc7Patches[inst-1][:]
+}
+
+// Clock 每36个CPU周期生成一个OPLL采样，中间保持上一次的输出
+func (v *VRC7) Clock() {
+	v.divider += 1
+	if v.divider < 36 {
+		return
+	}
+	v.divider = 0
+	v.amPhase = math.Mod(v.amPhase+opllAMFreq/opllSampleRate, 1)
+	v.vibPhase = math.Mod(v.vibPhase+opllVibFreq/opllSampleRate, 1)
+	am := opllAMDepth * (1 + math.Sin(2*math.Pi*v.amPhase)) / 2
+	vib := 1 + opllVibDepth*math.Sin(2*math.Pi*v.vibPhase)
+
+	v.out = 0
+	for i := range v.channels {
+		v.out += v.sampleChannel(i, am, vib)
+	}
+}
+
+func (v *VRC7) sampleChannel(i int, am, vib float64) float64 {
+	ch := &v.channels[i]
+	p := v.patch(i)
+	fnum := uint16(v.regs[0x10+i]) | uint16(v.regs[0x20+i]&0x01)<<8
+	block := v.regs[0x20+i] >> 1 & 0x07
+	sustain := v.regs[0x20+i]&0x20 != 0
+	volume := v.regs[0x30+i] & 0x0F
+	inc := float64(fnum) * float64(uint(1)<<block) / (1 << 19)
+	rks := int(block)<<1 | int(fnum>>8)
+
+	// 调制器
+	mod := &ch.mod
+	mod.clockEnvelope(p[0], p[4], p[6], rks, sustain)
+	mod.phase = math.Mod(mod.phase+opllPhaseInc(p[0], inc, vib), 1)
+	feedback := 0.0
+	if fb := p[3] & 0x07; fb > 0 {
+		feedback = (mod.out[0] + mod.out[1]) / 2 * math.Pow(2, float64(fb)-6)
+	}
+	modOut := opllWave(mod.phase+feedback, p[3]&0x08 != 0) *
+		opllAmp(float64(p[2]&0x3F)*0.75+mod.atten+opllAM(p[0], am))
+	mod.out[1], mod.out[0] = mod.out[0], modOut
+
+	// 载波
+	car := &ch.car
+	car.clockEnvelope(p[1], p[5], p[7], rks, sustain)
+	car.phase = math.Mod(car.phase+opllPhaseInc(p[1], inc, vib), 1)
+	return opllWave(car.phase+modOut*2, p[3]&0x10 != 0) *
+		opllAmp(float64(volume)*3+car.atten+opllAM(p[1], am))
+}
+
+// opllPhaseInc 算子寄存器: AM(7) VIB(6) EG(5) KSR(4) MULT(3-0)
+func opllPhaseInc(reg byte, inc, vib float64) float64 {
+	inc *= opllMultiples[reg&0x0F]
+	if reg&0x40 != 0 {
+		inc *= vib
+	}
+	return inc
+}
+
+func opllAM(reg byte, am float64) float64 {
+	if reg&0x80 != 0 {
+		return am
+	}
+	return 0
+}
+
+// opllWave 正弦波，rectify为true时负半周输出0
+func opllWave(phase float64, rectify bool) float64 {
+	s := math.Sin(2 * math.Pi * phase)
+	if rectify && s < 0 {
+		return 0
+	}
+	return s
+}
+
+func opllAmp(atten float64) float64 {
+	if atten >= opllMaxAtten {
+		return 0
+	}
+	return math.Pow(10, -atten/20)
+}
+
+// opllRate 返回每个采样变化多少dB，rate为0时不变化
+func opllRate(rate byte, rks int, ksr bool) float64 {
+	if rate == 0 {
+		return 0
+	}
+	if !ksr {
+		rks >>= 2
+	}
+	eff := int(rate)*4 + rks
+	if eff > 63 {
+		eff = 63
+	}
+	seconds := 10.0 * math.Pow(2, -float64(eff-4)/4) // 衰减48dB需要的时间
+	return opllMaxAtten / (seconds * opllSampleRate)
+}
+
+// clockEnvelope ar: AR(7-4) DR(3-0), sr: SL(7-4) RR(3-0)
+func (op *opllOperator) clockEnvelope(reg, ar, sr byte, rks int, sustain bool) {
+	ksr := reg&0x10 != 0
+	sustained := reg&0x20 != 0
+	switch op.state {
+	case opllAttack:
+		op.atten -= opllRate(ar>>4, rks, ksr) * 14 // 起音比衰减快很多
+		if op.atten <= 0 {
+			op.atten = 0
+			op.state = opllDecay
+		}
+	case opllDecay:
+		op.atten += opllRate(ar&0x0F, rks, ksr)
+		if level := float64(sr>>4) * 3; op.atten >= level {
+			op.atten = level
+			op.state = opllSustain
+		}
+	case opllSustain:
+		if !sustained { // 打击类音色在持续阶段继续按RR衰减
+			op.atten += opllRate(sr&0x0F, rks, ksr)
+		}
+	case opllRelease:
+		switch {
+		case sustain:
+			op.atten += opllRate(5, rks, ksr)
+		case sustained:
+			op.atten += opllRate(sr&0x0F, rks, ksr)
+		default:
+			op.atten += opllRate(7, rks, ksr)
+		}
+	}
+	if op.atten >= opllMaxAtten && op.state != opllAttack {
+		op.atten = opllMaxAtten
+		if op.state == opllRelease {
+			op.state = opllOff
+		}
+	}
+}
+
+func (v *VRC7) Output() float64 {
+	return v.out * vrc7Level
+}
@@ -0,0 +1,128 @@
+package apu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// WavWriter 把采样写成16位单声道PCM的WAV文件，Close时回填文件头中的长度
+type WavWriter struct {
+	w          io.WriteSeeker
+	sampleRate int
+	dataSize   uint32
+	buf        []byte
+}
+
+func NewWavWriter(w io.WriteSeeker, sampleRate int) (*WavWriter, error) {
+	ww := &WavWriter{w: w, sampleRate: sampleRate}
+	if _, err := w.Write(ww.header()); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+func (ww *WavWriter) header() []byte {
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 36+ww.dataSize)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16) // fmt块的大小
+	binary.LittleEndian.PutUint16(h[20:], 1)  // PCM
+	binary.LittleEndian.PutUint16(h[22:], 1)  // 单声道
+	binary.LittleEndian.PutUint32(h[24:], uint32(ww.sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(ww.sampleRate*2))
+	binary.LittleEndian.PutUint16(h[32:], 2)
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], ww.dataSize)
+	return h
+}
+
+// WriteSamples 超出[-1, 1]的采样会被截断
+func (ww *WavWriter) WriteSamples(samples []float32) error {
+	ww.buf = appendPCM16(ww.buf[:0], samples)
+	n, err := ww.w.Write(ww.buf)
+	ww.dataSize += uint32(n)
+	return err
+}
+
+// appendPCM16 把采样转换成16位小端的PCM，超出[-1, 1]的采样会被截断
+func appendPCM16(buf []byte, samples []float32) []byte {
+	for _, s := range samples {
+		if s > 1 {
+			s = 1
+		} else if s < -1 {
+			s = -1
+		}
+		v := int16(s * 32767)
+		buf = append(buf, byte(v), byte(uint16(v)>>8))
+	}
+	return buf
+}
+
+// Close 回填文件头，不会关闭底层的文件
+func (ww *WavWriter) Close() error {
+	if _, err := ww.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ww.w.Write(ww.header()); err != nil {
+		return err
+	}
+	_, err := ww.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// ReadWav 读取8位或16位PCM的WAV文件，多声道时只取第一个声道，采样的范围是[-1, 1]
+func ReadWav(r io.Reader) (samples []float32, sampleRate int, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a wav file")
+	}
+	var channels, bits int
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if size > len(data)-pos {
+			size = len(data) - pos // 录到一半的文件，长度没有回填
+		}
+		chunk := data[pos : pos+size]
+		pos += size + size&1
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, 0, errors.New("wav fmt chunk too short")
+			}
+			if format := binary.LittleEndian.Uint16(chunk[0:]); format != 1 {
+				return nil, 0, fmt.Errorf("unsupported wav format %d, only PCM is supported", format)
+			}
+			channels = int(binary.LittleEndian.Uint16(chunk[2:]))
+			sampleRate = int(binary.LittleEndian.Uint32(chunk[4:]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:]))
+			if channels == 0 || bits != 8 && bits != 16 {
+				return nil, 0, fmt.Errorf("unsupported wav: %d channels, %d bits", channels, bits)
+			}
+		case "data":
+			if channels == 0 {
+				return nil, 0, errors.New("wav data chunk before fmt chunk")
+			}
+			frame := channels * bits / 8
+			samples = make([]float32, 0, len(chunk)/frame)
+			for i := 0; i+frame <= len(chunk); i += frame {
+				if bits == 8 {
+					samples = append(samples, float32(int(chunk[i])-128)/128)
+				} else {
+					samples = append(samples, float32(int16(binary.LittleEndian.Uint16(chunk[i:])))/32768)
+				}
+			}
+			return samples, sampleRate, nil
+		}
+	}
+	return nil, 0, errors.New("wav file has no data chunk")
+}
@@ -0,0 +1,202 @@
+// Package cheat 实现金手指: Game Genie修改CPU读到的ROM数据，Pro Action Replay每帧锁定RAM的值
+package cheat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Kind int
+
+const (
+	GameGenie Kind = iota
+	ProActionReplay
+)
+
+func (k Kind) String() string {
+	if k == GameGenie {
+		return "Game Genie"
+	}
+	return "PAR"
+}
+
+type Cheat struct {
+	Code       string
+	Name       string
+	Kind       Kind
+	Addr       uint16
+	Value      byte
+	Compare    byte
+	HasCompare bool // 8位的Game Genie码只在原来的值等于Compare时才替换
+	Enabled    bool
+}
+
+func (c *Cheat) String() string {
+	if c.HasCompare {
+		return fmt.Sprintf("%s  $%04X?%02X:%02X", c.Code, c.Addr, c.Compare, c.Value)
+	}
+	return fmt.Sprintf("%s  $%04X:%02X", c.Code, c.Addr, c.Value)
+}
+
+// ggLetters Game Genie的16个字母，下标就是字母代表的4bit值
+const ggLetters = "APZLGITYEOXUKSVN"
+
+// DecodeGameGenie 解码6位或8位的Game Genie码，见 https://www.nesdev.org/wiki/Game_Genie
+func DecodeGameGenie(code string) (*Cheat, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 6 && len(code) != 8 {
+		return nil, fmt.Errorf("game genie code %q must have 6 or 8 letters", code)
+	}
+	n := make([]uint16, len(code))
+	for i := range code {
+		v := strings.IndexByte(ggLetters, code[i])
+		if v < 0 {
+			return nil, fmt.Errorf("bad game genie letter %q in %q", code[i], code)
+		}
+		n[i] = uint16(v)
+	}
+	addr := 0x8000 | (n[3]&7)<<12 | (n[5]&7)<<8 | (n[4]&8)<<8 |
+		(n[2]&7)<<4 | (n[1]&8)<<4 | n[4]&7 | n[3]&8
+	value := (n[1]&7)<<4 | (n[0]&8)<<4 | n[0]&7
+	c := &Cheat{Code: code, Kind: GameGenie, Addr: addr, Enabled: true}
+	if len(code) == 6 {
+		value |= n[5] & 8
+	} else {
+		value |= n[7] & 8
+		c.Compare = byte((n[7]&7)<<4 | (n[6]&8)<<4 | n[6]&7 | n[5]&8)
+		c.HasCompare = true
+	}
+	c.Value = byte(value)
+	return c, nil
+}
+
+// DecodePAR 解码Pro Action Replay的RAM锁定码，支持 AAAA:VV、AAAAVV 和 00AAAAVV 三种写法
+func DecodePAR(code string) (*Cheat, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	digits := strings.ReplaceAll(code, ":", "")
+	if len(digits) == 8 && strings.HasPrefix(digits, "00") {
+		digits = digits[2:]
+	}
+	if len(digits) != 6 {
+		return nil, fmt.Errorf("bad PAR code %q", code)
+	}
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad PAR code %q", code)
+	}
+	addr := uint16(v >> 8)
+	if addr >= 0x8000 {
+		return nil, fmt.Errorf("PAR code %q must target RAM below $8000", code)
+	}
+	return &Cheat{Code: code, Kind: ProActionReplay, Addr: addr, Value: byte(v), Enabled: true}, nil
+}
+
+// Decode 根据写法自动判断是Game Genie还是PAR
+func Decode(code string) (*Cheat, error) {
+	upper := strings.ToUpper(strings.TrimSpace(code))
+	if (len(upper) == 6 || len(upper) == 8) && strings.Trim(upper, ggLetters) == "" {
+		return DecodeGameGenie(upper)
+	}
+	return DecodePAR(upper)
+}
+
+// Poker 修改内存，每帧锁定RAM时使用，memo.Memo满足这个接口
+type Poker interface {
+	Poke(addr uint16, val byte)
+}
+
+// Engine 管理一个游戏的金手指。PatchRead在CPU读ROM的时候调用，需要很快，
+// 所以启用的Game Genie码放在一个只读的map里，修改金手指时整个替换。
+type Engine struct {
+	mu      sync.Mutex
+	cheats  []*Cheat
+	patches atomic.Value // map[uint16][]Cheat
+}
+
+func NewEngine() *Engine {
+	e := &Engine{}
+	e.patches.Store(map[uint16][]Cheat{})
+	return e
+}
+
+// Set 替换全部的金手指
+func (e *Engine) Set(cheats []*Cheat) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cheats = cheats
+	e.rebuild()
+}
+
+func (e *Engine) Add(c *Cheat) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cheats = append(e.cheats, c)
+	e.rebuild()
+}
+
+// Remove 删除第i个金手指
+func (e *Engine) Remove(i int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if i < 0 || i >= len(e.cheats) {
+		return
+	}
+	e.cheats = append(e.cheats[:i], e.cheats[i+1:]...)
+	e.rebuild()
+}
+
+func (e *Engine) SetEnabled(i int, enabled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if i < 0 || i >= len(e.cheats) {
+		return
+	}
+	e.cheats[i].Enabled = enabled
+	e.rebuild()
+}
+
+// Cheats 返回全部金手指的拷贝
+func (e *Engine) Cheats() []Cheat {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := make([]Cheat, 0, len(e.cheats))
+	for _, c := range e.cheats {
+		res = append(res, *c)
+	}
+	return res
+}
+
+func (e *Engine) rebuild() {
+	patches := map[uint16][]Cheat{}
+	for _, c := range e.cheats {
+		if c.Enabled && c.Kind == GameGenie {
+			patches[c.Addr] = append(patches[c.Addr], *c)
+		}
+	}
+	e.patches.Store(patches)
+}
+
+// PatchRead 实现memo.RomPatcher
+func (e *Engine) PatchRead(addr uint16, val byte) byte {
+	patches := e.patches.Load().(map[uint16][]Cheat)
+	for _, c := range patches[addr] {
+		if !c.HasCompare || c.Compare == val {
+			return c.Value
+		}
+	}
+	return val
+}
+
+// Freeze 把PAR码的值写回RAM，每帧调用一次
+func (e *Engine) Freeze(mem Poker) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, c := range e.cheats {
+		if c.Enabled && c.Kind == ProActionReplay {
+			mem.Poke(c.Addr, c.Value)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package cheat
+
+import (
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeGameGenie(t *testing.T) {
+	c, err := DecodeGameGenie("gossip")
+	require.NoError(t, err)
+	require.Equal(t, uint16(0xD1DD), c.Addr)
+	require.Equal(t, byte(0x14), c.Value)
+	require.False(t, c.HasCompare)
+
+	c, err = DecodeGameGenie("ZEXPYGLA")
+	require.NoError(t, err)
+	require.Equal(t, uint16(0x94A7), c.Addr)
+	require.Equal(t, byte(0x02), c.Value)
+	require.Equal(t, byte(0x03), c.Compare)
+	require.True(t, c.HasCompare)
+
+	for _, code := range []string{"GOSSI", "GOSSIB", "GOSSIPGOS"} {
+		_, err := DecodeGameGenie(code)
+		require.Error(t, err, code)
+	}
+}
+
+func TestDecodePAR(t *testing.T) {
+	for _, code := range []string{"0075:09", "007509", "00007509"} {
+		c, err := Decode(code)
+		require.NoError(t, err, code)
+		require.Equal(t, ProActionReplay, c.Kind)
+		require.Equal(t, uint16(0x0075), c.Addr)
+		require.Equal(t, byte(0x09), c.Value)
+	}
+	_, err := DecodePAR("8000:01")
+	require.Error(t, err)
+	_, err = DecodePAR("75:09")
+	require.Error(t, err)
+}
+
+type fakeMem map[uint16]byte
+
+func (m fakeMem) Poke(addr uint16, val byte) {
+	m[addr] = val
+}
+
+func TestEngine(t *testing.T) {
+	e := NewEngine()
+	gg, _ := Decode("GOSSIP")
+	compare, _ := Decode("ZEXPYGLA")
+	par, _ := Decode("0075:09")
+	e.Set([]*Cheat{gg, compare, par})
+
+	require.Equal(t, byte(0x14), e.PatchRead(0xD1DD, 0xEA))
+	require.Equal(t, byte(0x02), e.PatchRead(0x94A7, 0x03))
+	require.Equal(t, byte(0x04), e.PatchRead(0x94A7, 0x04)) // 原值不等于compare时不替换
+	require.Equal(t, byte(0xEA), e.PatchRead(0x8000, 0xEA))
+
+	mem := fakeMem{}
+	e.Freeze(mem)
+	require.Equal(t, fakeMem{0x0075: 0x09}, mem)
+
+	e.SetEnabled(0, false)
+	require.Equal(t, byte(0xEA), e.PatchRead(0xD1DD, 0xEA))
+	e.Remove(2)
+	mem = fakeMem{}
+	e.Freeze(mem)
+	require.Empty(t, mem)
+}
+
+func TestCheatFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "cheats.txt")
+	cheats, err := LoadFile(fileName, "aaaa")
+	require.NoError(t, err)
+	require.Empty(t, cheats)
+
+	gg, _ := Decode("GOSSIP")
+	gg.Name = "infinite lives"
+	par, _ := Decode("0075:09")
+	par.Enabled = false
+	require.NoError(t, SaveFile(fileName, "aaaa", []Cheat{*gg, *par}))
+	require.NoError(t, SaveFile(fileName, "BBBB", []Cheat{*par}))
+
+	cheats, err = LoadFile(fileName, "AAAA")
+	require.NoError(t, err)
+	require.Equal(t, []*Cheat{gg, par}, cheats)
+	cheats, err = LoadFile(fileName, "bbbb")
+	require.NoError(t, err)
+	require.Len(t, cheats, 1)
+}
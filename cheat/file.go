@@ -0,0 +1,141 @@
+package cheat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// 金手指文件按游戏分段，段名是rom.NesRom.Hash()，每行一个金手指，+表示启用，-表示禁用，后面是码和名字:
+//
+//	[0a1b2c...]
+//	+ SXIOPO infinite lives
+//	- 0075:09 99 coins
+//
+// # 开头的行是注释。
+
+// LoadFile 读取romHash对应的金手指，文件不存在时返回空
+func LoadFile(fileName, romHash string) ([]*Cheat, error) {
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sections, _, err := parseFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	res := make([]*Cheat, 0)
+	for _, line := range sections[strings.ToLower(romHash)] {
+		c, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// SaveFile 保存romHash对应的金手指，文件中其它游戏的金手指保持不变
+func SaveFile(fileName, romHash string, cheats []Cheat) error {
+	sections := map[string][]string{}
+	order := make([]string, 0)
+	if f, err := os.Open(fileName); err == nil {
+		sections, order, err = parseFile(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	romHash = strings.ToLower(romHash)
+	if _, ok := sections[romHash]; !ok {
+		order = append(order, romHash)
+	}
+	lines := make([]string, 0, len(cheats))
+	for _, c := range cheats {
+		lines = append(lines, formatLine(&c))
+	}
+	sections[romHash] = lines
+
+	sb := &strings.Builder{}
+	for _, name := range order {
+		fmt.Fprintf(sb, "[%s]\n", name)
+		for _, line := range sections[name] {
+			sb.WriteString(line + "\n")
+		}
+	}
+	return ioutil.WriteFile(fileName, []byte(sb.String()), 0644)
+}
+
+func sectionName(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if len(line) > 2 && line[0] == '[' && line[len(line)-1] == ']' {
+		return strings.ToLower(line[1 : len(line)-1]), true
+	}
+	return "", false
+}
+
+// parseFile 返回每一段的金手指，以及段在文件中出现的顺序
+func parseFile(r io.Reader) (map[string][]string, []string, error) {
+	sections := map[string][]string{}
+	order := make([]string, 0)
+	current := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if name, ok := sectionName(line); ok {
+			current = name
+			if _, ok := sections[name]; !ok {
+				sections[name] = nil
+				order = append(order, name)
+			}
+			continue
+		}
+		if current == "" {
+			return nil, nil, fmt.Errorf("cheat %q is outside of a rom section", line)
+		}
+		sections[current] = append(sections[current], line)
+	}
+	return sections, order, scanner.Err()
+}
+
+func parseLine(line string) (*Cheat, error) {
+	enabled := true
+	switch line[0] {
+	case '+':
+		line = line[1:]
+	case '-':
+		enabled = false
+		line = line[1:]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty cheat line")
+	}
+	c, err := Decode(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	c.Name = strings.Join(fields[1:], " ")
+	c.Enabled = enabled
+	return c, nil
+}
+
+func formatLine(c *Cheat) string {
+	prefix := "+"
+	if !c.Enabled {
+		prefix = "-"
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", prefix, c.Code, c.Name))
+}
@@ -0,0 +1,170 @@
+package cheat
+
+import "fmt"
+
+// Peeker 没有副作用地读取内存，memo.Memo满足这个接口
+type Peeker interface {
+	Peek(addr uint16) byte
+}
+
+// Region 是一段可以搜索的地址，包含End
+type Region struct {
+	Start uint16
+	End   uint16
+}
+
+// DefaultRegions 2k内部RAM和$6000-$7FFF的PRG-RAM
+var DefaultRegions = []Region{{0x0000, 0x07FF}, {0x6000, 0x7FFF}}
+
+type SearchOp int
+
+const (
+	OpEqual     SearchOp = iota // 和上一次快照相同
+	OpChanged                   // 和上一次快照不同
+	OpIncreased                 // 比上一次快照大
+	OpDecreased                 // 比上一次快照小
+	OpValue                     // 等于指定的值
+)
+
+var searchOpNames = map[SearchOp]string{
+	OpEqual:     "equal",
+	OpChanged:   "changed",
+	OpIncreased: "increased",
+	OpDecreased: "decreased",
+	OpValue:     "value",
+}
+
+func (op SearchOp) String() string {
+	return searchOpNames[op]
+}
+
+// Candidate 一个还没有被排除的地址
+type Candidate struct {
+	Addr  uint16
+	Value int // 当前的值
+	Prev  int // 上一次快照的值
+}
+
+func (c Candidate) String() string {
+	return fmt.Sprintf("$%04X  %d (prev %d)", c.Addr, c.Value, c.Prev)
+}
+
+// Search 在内存中查找游戏变量，例如命数、血量和坐标。
+// 每次Filter都会和上一次的快照比较，留下满足条件的地址，然后把当前的值作为新的快照。
+type Search struct {
+	mem     Peeker
+	regions []Region
+	size    int // 1或2个字节
+	signed  bool
+
+	candidates []uint16
+	prev       map[uint16]int
+}
+
+func NewSearch(mem Peeker, regions []Region) *Search {
+	s := &Search{mem: mem, regions: regions, size: 1}
+	s.Reset()
+	return s
+}
+
+// SetView 设置按8位还是16位(小端)、有符号还是无符号来看待内存，会重新开始搜索
+func (s *Search) SetView(size int, signed bool) error {
+	if size != 1 && size != 2 {
+		return fmt.Errorf("bad search size %d", size)
+	}
+	s.size = size
+	s.signed = signed
+	s.Reset()
+	return nil
+}
+
+// Reset 所有地址都重新成为候选，并且保存快照
+func (s *Search) Reset() {
+	s.candidates = s.candidates[:0]
+	for _, r := range s.regions {
+		for addr := int(r.Start); addr+s.size-1 <= int(r.End); addr++ {
+			s.candidates = append(s.candidates, uint16(addr))
+		}
+	}
+	s.snapshot()
+}
+
+func (s *Search) snapshot() {
+	s.prev = make(map[uint16]int, len(s.candidates))
+	for _, addr := range s.candidates {
+		s.prev[addr] = s.read(addr)
+	}
+}
+
+func (s *Search) read(addr uint16) int {
+	v := int(s.mem.Peek(addr))
+	if s.size == 2 {
+		v |= int(s.mem.Peek(addr+1)) << 8
+		if s.signed {
+			return int(int16(v))
+		}
+		return v
+	}
+	if s.signed {
+		return int(int8(v))
+	}
+	return v
+}
+
+// Filter 排除不满足条件的地址，value只在OpValue时使用，返回剩下的候选数量
+func (s *Search) Filter(op SearchOp, value int) int {
+	res := s.candidates[:0]
+	for _, addr := range s.candidates {
+		cur, prev := s.read(addr), s.prev[addr]
+		var ok bool
+		switch op {
+		case OpEqual:
+			ok = cur == prev
+		case OpChanged:
+			ok = cur != prev
+		case OpIncreased:
+			ok = cur > prev
+		case OpDecreased:
+			ok = cur < prev
+		case OpValue:
+			ok = cur == value
+		}
+		if ok {
+			res = append(res, addr)
+		}
+	}
+	s.candidates = res
+	s.snapshot()
+	return len(s.candidates)
+}
+
+func (s *Search) Count() int {
+	return len(s.candidates)
+}
+
+// Candidates 返回最多limit个候选，limit<=0时返回全部
+func (s *Search) Candidates(limit int) []Candidate {
+	n := len(s.candidates)
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	res := make([]Candidate, 0, n)
+	for _, addr := range s.candidates[:n] {
+		res = append(res, Candidate{Addr: addr, Value: s.read(addr), Prev: s.prev[addr]})
+	}
+	return res
+}
+
+// Freeze 生成锁定候选当前值的PAR码。16位的视图按小端生成Addr和Addr+1两个码，负数按补码存储
+func (s *Search) Freeze(c Candidate) ([]*Cheat, error) {
+	value := uint16(c.Value)
+	res := make([]*Cheat, 0, s.size)
+	for i := 0; i < s.size; i++ {
+		par, err := DecodePAR(fmt.Sprintf("%04X:%02X", c.Addr+uint16(i), byte(value>>(8*i))))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, par)
+	}
+	return res, nil
+}
@@ -0,0 +1,60 @@
+package cheat
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func (m fakeMem) Peek(addr uint16) byte {
+	return m[addr]
+}
+
+func TestSearch(t *testing.T) {
+	mem := fakeMem{0x10: 3, 0x20: 3, 0x6000: 3}
+	s := NewSearch(mem, DefaultRegions)
+	require.Equal(t, 0x800+0x2000, s.Count())
+
+	require.Equal(t, 3, s.Filter(OpValue, 3))
+	mem[0x10], mem[0x20] = 2, 4 // 掉了一条命
+	require.Equal(t, 1, s.Filter(OpDecreased, 0))
+	require.Equal(t, []Candidate{{Addr: 0x10, Value: 2, Prev: 2}}, s.Candidates(0))
+	require.Equal(t, 1, s.Filter(OpEqual, 0))
+	mem[0x10] = 1
+	require.Equal(t, 1, s.Filter(OpChanged, 0))
+	require.Equal(t, 0, s.Filter(OpIncreased, 0))
+}
+
+func TestSearchView(t *testing.T) {
+	mem := fakeMem{0x100: 0x34, 0x101: 0x12, 0x200: 0xFF}
+	s := NewSearch(mem, []Region{{0x0000, 0x07FF}})
+	require.NoError(t, s.SetView(2, false))
+	require.Equal(t, 0x7FF, s.Count()) // 最后一个字节不能作为16位的开头
+	require.Equal(t, 1, s.Filter(OpValue, 0x1234))
+
+	require.NoError(t, s.SetView(1, true))
+	require.Equal(t, 1, s.Filter(OpValue, -1))
+	require.Equal(t, uint16(0x200), s.Candidates(1)[0].Addr)
+	mem[0x200] = 0x01
+	require.Equal(t, 1, s.Filter(OpIncreased, 0)) // 有符号时 -1 < 1
+
+	require.Error(t, s.SetView(3, false))
+}
+
+func TestSearchFreeze(t *testing.T) {
+	mem := fakeMem{0x100: 0xFE, 0x101: 0xFF}
+	s := NewSearch(mem, []Region{{0x0000, 0x07FF}})
+	require.NoError(t, s.SetView(2, true))
+	require.Equal(t, 1, s.Filter(OpValue, -2))
+	pars, err := s.Freeze(s.Candidates(1)[0])
+	require.NoError(t, err)
+	require.Len(t, pars, 2)
+	require.Equal(t, []uint16{0x100, 0x101}, []uint16{pars[0].Addr, pars[1].Addr})
+	require.Equal(t, []byte{0xFE, 0xFF}, []byte{pars[0].Value, pars[1].Value})
+
+	require.NoError(t, s.SetView(1, true))
+	require.Equal(t, 1, s.Filter(OpValue, -2))
+	pars, err = s.Freeze(s.Candidates(1)[0])
+	require.NoError(t, err)
+	require.Len(t, pars, 1)
+	require.Equal(t, "0100:FE", pars[0].Code)
+}
@@ -0,0 +1,80 @@
+// nsf2wav 不打开窗口，把NSF/NSFe中的一首曲子渲染成WAV文件
+package main
+
+import (
+	"fc-emulator/apu"
+	"fc-emulator/nsf"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var track = flag.Int("track", 0, "track number starting from 1, default is the start song of the file")
+var outFile = flag.String("o", "", "output wav file, default is <nsf name>-<track>.wav")
+var sampleRate = flag.Int("rate", apu.DefaultSampleRate, "sample rate")
+var length = flag.Duration("length", 0, "play time before fading out, default is the nsfe track time or 150s")
+var fade = flag.Duration("fade", -1, "fade out time, default is the nsfe fade time or 8s")
+var list = flag.Bool("list", false, "print the tracks and exit")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: nsf2wav [flags] file.nsf\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	fileName := flag.Arg(0)
+	f, err := nsf.Load(fileName)
+	if err != nil {
+		log.Fatal("load nsf fail: ", err)
+	}
+	if *list {
+		fmt.Println(f)
+		for _, i := range f.Order() { // NSFe的plst决定顺序
+			fmt.Printf("%3d %s\n", i+1, f.Label(i))
+		}
+		return
+	}
+
+	t := f.StartSong
+	if *track > 0 {
+		t = *track - 1
+	}
+	player := nsf.NewPlayer(f, *sampleRate)
+	if err := player.SetTrack(t); err != nil {
+		log.Fatal(err)
+	}
+	if *length > 0 {
+		player.Length = *length
+	}
+	if *fade >= 0 {
+		player.Fade = *fade
+	}
+
+	if *outFile == "" {
+		*outFile = fmt.Sprintf("%s-%d.wav", strings.TrimSuffix(fileName, ".nsf"), t+1)
+	}
+	out, err := os.Create(*outFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	wav, err := apu.NewWavWriter(out, *sampleRate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	start := time.Now()
+	if err := player.Render(wav); err != nil {
+		log.Fatal(err)
+	}
+	if err := wav.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%s: %s rendered to %s in %v", f.Label(t), player.Elapsed(), *outFile, time.Since(start))
+}
@@ -0,0 +1,166 @@
+// romdbgen 把NesCartDB导出的xml转换成rom/romdb.txt的格式，生成的文件用 -romdb 加载
+//
+//	go run ./cmd/romdbgen -o romdb.txt NesCarts.xml
+//
+// NesCartDB中cartridge的crc和sha1是PRG+CHR的，和rom.NesRom.CRC32、Hash()一致。
+// NesCartDB没有submapper，一律写0；板子上没有镜像焊盘时镜像由mapper控制，写 - 。
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+var outFile = flag.String("o", "", "output file, default is stdout")
+
+type cartDB struct {
+	Games []cartGame `xml:"game"`
+}
+
+type cartGame struct {
+	Name       string      `xml:"name,attr"`
+	Cartridges []cartridge `xml:"cartridge"`
+}
+
+type cartridge struct {
+	System string    `xml:"system,attr"`
+	CRC    string    `xml:"crc,attr"`
+	SHA1   string    `xml:"sha1,attr"`
+	Boards []cartPCB `xml:"board"`
+}
+
+type cartPCB struct {
+	Mapper int       `xml:"mapper,attr"`
+	WRAM   []cartRAM `xml:"wram"`
+	VRAM   []cartRAM `xml:"vram"`
+	Chips  []cartRAM `xml:"chip"`
+	Pad    *cartPad  `xml:"pad"`
+}
+
+type cartRAM struct {
+	Size    string `xml:"size,attr"`
+	Battery int    `xml:"battery,attr"`
+}
+
+// cartPad 板子上的镜像焊盘，焊上H是垂直镜像，焊上V是水平镜像
+type cartPad struct {
+	H int `xml:"h,attr"`
+	V int `xml:"v,attr"`
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: romdbgen [flags] NesCarts.xml\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	in, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	out := os.Stdout
+	if len(*outFile) > 0 {
+		if out, err = os.Create(*outFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w := bufio.NewWriter(out)
+	if err = convert(in, w); err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// convert 读取NesCartDB的xml，按crc32排序写出数据库
+func convert(r io.Reader, w io.Writer) error {
+	var db cartDB
+	if err := xml.NewDecoder(r).Decode(&db); err != nil {
+		return err
+	}
+	lines := make([]string, 0)
+	for _, game := range db.Games {
+		title := strings.Join(strings.Fields(game.Name), " ")
+		for _, cart := range game.Cartridges {
+			if len(cart.CRC) != 8 || len(cart.Boards) == 0 {
+				continue
+			}
+			board := cart.Boards[0]
+			sha1 := strings.ToLower(cart.SHA1)
+			if len(sha1) == 0 {
+				sha1 = "-"
+			}
+			lines = append(lines, fmt.Sprintf("%s %s %d 0 %s %d %s %s", strings.ToUpper(cart.CRC), sha1,
+				board.Mapper, mirroring(&board), boolToInt(hasBattery(&board)), region(cart.System), title))
+		}
+	}
+	sort.Strings(lines)
+	fmt.Fprintln(w, "# ROM数据库，用PRG+CHR(不包括文件头和trainer)的crc32查找，sha1为 - 时只比较crc32")
+	fmt.Fprintln(w, "# 每行: crc32 sha1 mapper submapper mirroring(horizontal/vertical/four，- 表示由mapper控制) battery(0/1) region(NTSC/PAL/dual) title")
+	fmt.Fprintln(w, "# 由cmd/romdbgen从NesCartDB生成")
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mirroring(board *cartPCB) string {
+	for _, vram := range board.VRAM {
+		if vram.Size != "" { // 卡带上额外的显存用于4屏
+			return "four"
+		}
+	}
+	switch {
+	case board.Pad == nil:
+		return "-"
+	case board.Pad.H == 1:
+		return "vertical"
+	case board.Pad.V == 1:
+		return "horizontal"
+	}
+	return "-"
+}
+
+func hasBattery(board *cartPCB) bool {
+	for _, list := range [][]cartRAM{board.WRAM, board.VRAM, board.Chips} {
+		for _, ram := range list {
+			if ram.Battery == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// region NesCartDB的system: NES-NTSC、NES-PAL、NES-PAL-A、NES-PAL-B、Famicom、Dendy
+func region(system string) string {
+	if strings.HasPrefix(system, "NES-PAL") || system == "Dendy" {
+		return "PAL"
+	}
+	return "NTSC"
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
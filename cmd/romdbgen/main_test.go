@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fc-emulator/rom"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testXML = `<?xml version="1.0" encoding="UTF-8"?>
+<database version="1.0">
+<game name="Zelda no Densetsu" region="Japan">
+  <cartridge system="Famicom" crc="0000ABCD" sha1="ABCDEF">
+    <board type="HVC-SNROM" mapper="1">
+      <wram size="8k" battery="1"/>
+    </board>
+  </cartridge>
+</game>
+<game name="Gauntlet">
+  <cartridge system="NES-PAL-B" crc="00001234">
+    <board type="NES-TR1ROM" mapper="64">
+      <vram size="2k"/>
+    </board>
+  </cartridge>
+  <cartridge system="NES-NTSC" crc="00000001" sha1="01">
+    <board type="NES-NROM-256" mapper="0">
+      <pad h="1" v="0"/>
+    </board>
+  </cartridge>
+</game>
+</database>`
+
+func TestConvert(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, convert(strings.NewReader(testXML), buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, []string{
+		"00000001 01 0 0 vertical 0 NTSC Gauntlet",
+		"00001234 - 64 0 four 0 PAL Gauntlet",
+		"0000ABCD abcdef 1 0 - 1 NTSC Zelda no Densetsu",
+	}, lines[3:])
+
+	// 生成的文件可以直接被rom.LoadDB读取
+	dir, err := ioutil.TempDir("", "romdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "romdb.txt")
+	require.NoError(t, ioutil.WriteFile(file, buf.Bytes(), 0644))
+	require.NoError(t, rom.LoadDB(file))
+	entry := rom.LookupDB(0x1234, "")
+	require.NotNil(t, entry)
+	require.True(t, entry.FourScreen)
+	require.Equal(t, rom.RegionPAL, entry.Region)
+	require.Nil(t, rom.LookupDB(0xABCD, "0000"))
+}
@@ -0,0 +1,477 @@
+package cpu
+
+import (
+	"fc-emulator/cpu/addressing"
+	"fc-emulator/cpu/opcode"
+	"fc-emulator/utils"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 一个简单的6502汇编器，主要用于给测试生成机器码。
+//
+// 语法:
+//   - 语句之间用换行或者 ';' 分隔, "//" 之后为注释
+//   - 标签: "loop:"，可以和指令写在同一行
+//   - 伪指令: .org $8000 / .byte $01, 2, %0101 / .word label, $1234
+//   - 数字: $FF(十六进制), %0101(二进制), 255(十进制), 标签名，可以用 + - 连接
+//   - <expr 取低字节, >expr 取高字节
+//   - 寻址: #imm, zp, zp,X, zp,Y, abs, abs,X, abs,Y, (ind), (zp,X), (zp),Y, A
+//
+// 编码使用的是CPU自己的instructionTable，所以汇编器能生成的指令和CPU能执行的指令是一致的。
+
+const DefaultOrigin uint16 = 0x8000
+
+type Program struct {
+	Origin uint16
+	Code   []byte
+	Labels map[string]uint16
+}
+
+// End 返回程序最后一个字节之后的地址，程序到$FFFF结束时返回0x10000，所以用int
+func (p *Program) End() int {
+	return int(p.Origin) + len(p.Code)
+}
+
+type asmStatement struct {
+	line      int
+	directive string
+	code      opcode.Code
+	mode      addressing.Mode
+	operands  []string
+	addr      uint16
+	size      int
+}
+
+// 非官方助记符的别名
+var mnemonicAlias = map[string]opcode.Code{
+	"ISC": opcode.ISB,
+}
+
+// 同一个指令和寻址模式有多个opcode时，优先使用的opcode
+var preferredOpcode = map[opcode.Code]map[addressing.Mode]byte{
+	opcode.NOP: {addressing.IMP: 0xEA},
+}
+
+var (
+	encodeTable     map[opcode.Code]map[addressing.Mode]byte
+	encodeTableOnce sync.Once // 汇编器可能在多个goroutine里同时使用，例如并行的测试
+)
+
+func getEncodeTable() map[opcode.Code]map[addressing.Mode]byte {
+	encodeTableOnce.Do(func() {
+		encodeTable = buildEncodeTable()
+	})
+	return encodeTable
+}
+
+func buildEncodeTable() map[opcode.Code]map[addressing.Mode]byte {
+	table := map[opcode.Code]map[addressing.Mode]byte{}
+	for i := len(instructionTable) - 1; i >= 0; i-- { // 倒序遍历，相同指令时保留最小的opcode
+		ins := instructionTable[i]
+		if ins == nil {
+			continue
+		}
+		if table[ins.Code] == nil {
+			table[ins.Code] = map[addressing.Mode]byte{}
+		}
+		table[ins.Code][ins.Mode] = byte(i)
+	}
+	for code, modes := range preferredOpcode {
+		for mode, op := range modes {
+			table[code][mode] = op
+		}
+	}
+	return table
+}
+
+func lookupMnemonic(name string) (opcode.Code, bool) {
+	name = strings.ToUpper(name)
+	if code, ok := mnemonicAlias[name]; ok {
+		return code, true
+	}
+	for code := opcode.Code(0); code < opcode.INVALID; code++ {
+		if code.String() == name {
+			return code, true
+		}
+	}
+	return opcode.INVALID, false
+}
+
+// Encode 返回指令在指定寻址模式下的opcode
+func Encode(code opcode.Code, mode addressing.Mode) (byte, bool) {
+	op, ok := getEncodeTable()[code][mode]
+	return op, ok
+}
+
+func operandSize(mode addressing.Mode) int {
+	switch mode {
+	case addressing.IMP:
+		return 0
+	case addressing.ABS, addressing.ABX, addressing.ABY, addressing.IND:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Assemble 把汇编源码翻译成机器码
+func Assemble(src string) (*Program, error) {
+	statements, labels, err := asmParse(src)
+	if err != nil {
+		return nil, err
+	}
+	// 第一遍：确定每条语句的地址和长度
+	addrs := map[string]uint16{}
+	pc := DefaultOrigin
+	origin := -1
+	for _, st := range statements {
+		if st.directive == ".org" {
+			v, err := asmEval(st.operands[0], addrs)
+			if err != nil {
+				return nil, asmError(st.line, err)
+			}
+			if origin >= 0 && v < pc {
+				return nil, asmError(st.line, fmt.Errorf(".org $%04X is behind current address $%04X", v, pc))
+			}
+			pc = v
+			st.addr = pc
+			continue
+		}
+		if origin < 0 {
+			origin = int(pc)
+		}
+		st.addr = pc
+		for _, name := range labels[st] {
+			addrs[name] = pc
+		}
+		if err := asmLayout(st, addrs); err != nil {
+			return nil, asmError(st.line, err)
+		}
+		pc += uint16(st.size)
+	}
+	if origin < 0 {
+		origin = int(pc)
+	}
+	// 末尾的标签
+	for _, name := range labels[nil] {
+		addrs[name] = pc
+	}
+
+	// 第二遍：生成机器码
+	prog := &Program{Origin: uint16(origin), Labels: addrs}
+	for _, st := range statements {
+		if st.directive == ".org" {
+			if int(st.addr) > prog.End() && len(prog.Code) > 0 {
+				prog.Code = append(prog.Code, make([]byte, int(st.addr)-prog.End())...)
+			}
+			continue
+		}
+		data, err := asmEmit(st, addrs)
+		if err != nil {
+			return nil, asmError(st.line, err)
+		}
+		prog.Code = append(prog.Code, data...)
+	}
+	return prog, nil
+}
+
+func asmError(line int, err error) error {
+	return fmt.Errorf("asm line %d: %v", line, err)
+}
+
+func asmParse(src string) ([]*asmStatement, map[*asmStatement][]string, error) {
+	statements := make([]*asmStatement, 0)
+	labels := map[*asmStatement][]string{}
+	pending := make([]string, 0)
+	for lineNo, line := range strings.Split(src, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		for _, text := range strings.Split(line, ";") {
+			text = strings.TrimSpace(text)
+			for {
+				i := strings.Index(text, ":")
+				if i <= 0 || !isIdentifier(text[:i]) {
+					break
+				}
+				pending = append(pending, text[:i])
+				text = strings.TrimSpace(text[i+1:])
+			}
+			if text == "" {
+				continue
+			}
+			st, err := asmParseStatement(text)
+			if err != nil {
+				return nil, nil, asmError(lineNo+1, err)
+			}
+			st.line = lineNo + 1
+			if st.directive == ".org" && len(pending) > 0 {
+				return nil, nil, asmError(st.line, fmt.Errorf("label before .org"))
+			}
+			statements = append(statements, st)
+			if len(pending) > 0 {
+				labels[st] = pending
+				pending = make([]string, 0)
+			}
+		}
+	}
+	labels[nil] = pending
+	return statements, labels, nil
+}
+
+func asmParseStatement(text string) (*asmStatement, error) {
+	name, rest := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		name, rest = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	if strings.HasPrefix(name, ".") {
+		directive := strings.ToLower(name)
+		operands := splitOperands(rest)
+		switch directive {
+		case ".org":
+			if len(operands) != 1 {
+				return nil, fmt.Errorf(".org needs one operand")
+			}
+		case ".byte", ".word":
+			if len(operands) == 0 {
+				return nil, fmt.Errorf("%s needs operands", directive)
+			}
+		default:
+			return nil, fmt.Errorf("unknown directive %s", name)
+		}
+		return &asmStatement{directive: directive, operands: operands}, nil
+	}
+	code, ok := lookupMnemonic(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown mnemonic %s", name)
+	}
+	st := &asmStatement{code: code}
+	if rest == "" || strings.ToUpper(rest) == "A" {
+		st.mode = addressing.IMP
+		return st, nil
+	}
+	operand := strings.Join(strings.Fields(rest), "")
+	upper := strings.ToUpper(operand)
+	switch {
+	case strings.HasPrefix(operand, "#"):
+		st.mode = addressing.IMM
+		st.operands = []string{operand[1:]}
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(upper, ",X)"):
+		st.mode = addressing.INX
+		st.operands = []string{operand[1 : len(operand)-3]}
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(upper, "),Y"):
+		st.mode = addressing.INY
+		st.operands = []string{operand[1 : len(operand)-3]}
+	case strings.HasPrefix(operand, "(") && strings.HasSuffix(operand, ")"):
+		st.mode = addressing.IND
+		st.operands = []string{operand[1 : len(operand)-1]}
+	case strings.HasSuffix(upper, ",X"):
+		st.mode = addressing.ABX
+		st.operands = []string{operand[:len(operand)-2]}
+	case strings.HasSuffix(upper, ",Y"):
+		st.mode = addressing.ABY
+		st.operands = []string{operand[:len(operand)-2]}
+	default:
+		st.mode = addressing.ABS
+		st.operands = []string{operand}
+	}
+	if _, ok := getEncodeTable()[code][addressing.REL]; ok {
+		if st.mode != addressing.ABS {
+			return nil, fmt.Errorf("%s only supports relative addressing", code)
+		}
+		st.mode = addressing.REL
+	}
+	return st, nil
+}
+
+// 确定指令最终的寻址模式和长度，能用零页寻址时使用零页寻址
+func asmLayout(st *asmStatement, addrs map[string]uint16) error {
+	switch st.directive {
+	case ".byte":
+		st.size = len(st.operands)
+		return nil
+	case ".word":
+		st.size = 2 * len(st.operands)
+		return nil
+	}
+	zpMode := map[addressing.Mode]addressing.Mode{
+		addressing.ABS: addressing.ZPG,
+		addressing.ABX: addressing.ZPX,
+		addressing.ABY: addressing.ZPY,
+	}
+	if zp, ok := zpMode[st.mode]; ok {
+		_, hasZp := Encode(st.code, zp)
+		// 引用了还未定义的标签时，按绝对寻址处理
+		if v, err := asmEval(st.operands[0], addrs); hasZp && err == nil && v <= 0xFF {
+			st.mode = zp
+		}
+	}
+	if _, ok := Encode(st.code, st.mode); !ok {
+		return fmt.Errorf("%s does not support addressing mode %s", st.code, st.mode)
+	}
+	st.size = 1 + operandSize(st.mode)
+	return nil
+}
+
+func asmEmit(st *asmStatement, addrs map[string]uint16) ([]byte, error) {
+	res := make([]byte, 0, st.size)
+	switch st.directive {
+	case ".byte":
+		for _, operand := range st.operands {
+			v, err := asmEval(operand, addrs)
+			if err != nil {
+				return nil, err
+			}
+			if v > 0xFF {
+				return nil, fmt.Errorf("byte value out of range: %s", operand)
+			}
+			res = append(res, byte(v))
+		}
+		return res, nil
+	case ".word":
+		for _, operand := range st.operands {
+			v, err := asmEval(operand, addrs)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, byte(v&0xFF), byte(v>>8))
+		}
+		return res, nil
+	}
+	op, _ := Encode(st.code, st.mode)
+	res = append(res, op)
+	if st.mode == addressing.IMP {
+		return res, nil
+	}
+	v, err := asmEval(st.operands[0], addrs)
+	if err != nil {
+		return nil, err
+	}
+	switch st.mode {
+	case addressing.REL:
+		offset := int(v) - int(st.addr+2)
+		if offset < -128 || offset > 127 {
+			return nil, fmt.Errorf("branch target out of range: %s", st.operands[0])
+		}
+		res = append(res, byte(int8(offset)))
+	case addressing.ABS, addressing.ABX, addressing.ABY, addressing.IND:
+		res = append(res, byte(v&0xFF), byte(v>>8))
+	default:
+		if v > 0xFF {
+			return nil, fmt.Errorf("operand out of range for %s: %s", st.mode, st.operands[0])
+		}
+		res = append(res, byte(v))
+	}
+	return res, nil
+}
+
+func splitOperands(s string) []string {
+	res := make([]string, 0)
+	if strings.TrimSpace(s) == "" {
+		return res
+	}
+	for _, v := range strings.Split(s, ",") {
+		res = append(res, strings.TrimSpace(v))
+	}
+	return res
+}
+
+// asmEval 计算表达式的值，引用未定义的标签时返回错误
+func asmEval(expr string, addrs map[string]uint16) (uint16, error) {
+	expr = strings.Join(strings.Fields(expr), "")
+	if expr == "" {
+		return 0, fmt.Errorf("missing operand")
+	}
+	selector := byte(0)
+	if expr[0] == '<' || expr[0] == '>' {
+		selector = expr[0]
+		expr = expr[1:]
+	}
+	var total int
+	sign := 1
+	start := 0
+	for i := 0; i <= len(expr); i++ {
+		if i < len(expr) && (expr[i] != '+' && expr[i] != '-' || i == start) {
+			continue
+		}
+		v, err := asmTerm(expr[start:i], addrs)
+		if err != nil {
+			return 0, err
+		}
+		total += sign * int(v)
+		if i < len(expr) && expr[i] == '-' {
+			sign = -1
+		} else {
+			sign = 1
+		}
+		start = i + 1
+	}
+	v := uint16(total)
+	switch selector {
+	case '<':
+		v &= 0xFF
+	case '>':
+		v >>= 8
+	}
+	return v, nil
+}
+
+func asmTerm(term string, addrs map[string]uint16) (uint16, error) {
+	var v uint64
+	var err error
+	switch {
+	case term == "":
+		return 0, fmt.Errorf("missing operand")
+	case term[0] == '$':
+		v, err = strconv.ParseUint(term[1:], 16, 16)
+	case term[0] == '%':
+		v, err = strconv.ParseUint(term[1:], 2, 16)
+	case term[0] >= '0' && term[0] <= '9':
+		v, err = strconv.ParseUint(term, 10, 16)
+	default:
+		addr, ok := addrs[term]
+		if !ok {
+			return 0, fmt.Errorf("undefined label %s", term)
+		}
+		return addr, nil
+	}
+	if err != nil {
+		return 0, utils.NewError("bad number", term)
+	}
+	return uint16(v), nil
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		isLetter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		isDigit := r >= '0' && r <= '9'
+		if !isLetter && !(isDigit && i > 0) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// BuildNesImage 生成一个最小的iNES镜像(Mapper0, 32k PRG, 8k CHR)。
+// 程序被放在它自己的地址上，程序没有覆盖到的中断向量都指向程序的起始地址。
+func BuildNesImage(prog *Program) ([]byte, error) {
+	if prog.Origin < 0x8000 || int(prog.Origin)+len(prog.Code) > 0x10000 {
+		return nil, fmt.Errorf("program $%04X-$%04X is out of PRG space", prog.Origin, prog.End())
+	}
+	header := []byte{'N', 'E', 'S', 0x1A, 2, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	prg := make([]byte, 32*utils.Kb)
+	copy(prg[prog.Origin-0x8000:], prog.Code)
+	for _, vector := range []uint16{IV_NMI, IV_RESET, IV_IRQ} {
+		if vector >= prog.Origin && int(vector) < prog.End() {
+			continue
+		}
+		offset := vector - 0x8000
+		prg[offset] = byte(prog.Origin & 0xFF)
+		prg[offset+1] = byte(prog.Origin >> 8)
+	}
+	image := append(header, prg...)
+	return append(image, make([]byte, 8*utils.Kb)...), nil
+}
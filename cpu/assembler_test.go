@@ -0,0 +1,176 @@
+package cpu
+
+import (
+	"fc-emulator/memo"
+	"fc-emulator/pad"
+	"fc-emulator/ppu"
+	"fc-emulator/rom"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// asm 汇编源码并生成一个加载了该程序的CPU，PC指向程序起始地址
+func asm(t *testing.T, src string) *CPU {
+	prog, err := Assemble(src)
+	require.NoError(t, err)
+	image, err := BuildNesImage(prog)
+	require.NoError(t, err)
+	nesRom, err := rom.ParseBytes(image)
+	require.NoError(t, err)
+	c := NewCPU(memo.NewMemo(nesRom, ppu.NewPPU(nesRom), pad.NewPad(), pad.NewPad()), false)
+	c.Reset()
+	return c
+}
+
+func run(t *testing.T, c *CPU, count int) {
+	for i := 0; i < count; i++ {
+		_, err := c.ExecuteOneInstruction()
+		require.NoError(t, err)
+	}
+}
+
+func TestAssembleModes(t *testing.T) {
+	prog, err := Assemble(`
+		LDA #$10
+		LDA $10
+		LDA $10,X
+		LDX $10,Y
+		LDA $1234
+		LDA $1234,X
+		LDA $1234,Y
+		LDA ($10,X)
+		LDA ($10),Y
+		JMP ($1234)
+		ASL A
+		ASL
+		NOP
+	`)
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0xA9, 0x10,
+		0xA5, 0x10,
+		0xB5, 0x10,
+		0xB6, 0x10,
+		0xAD, 0x34, 0x12,
+		0xBD, 0x34, 0x12,
+		0xB9, 0x34, 0x12,
+		0xA1, 0x10,
+		0xB1, 0x10,
+		0x6C, 0x34, 0x12,
+		0x0A,
+		0x0A,
+		0xEA,
+	}, prog.Code)
+}
+
+func TestAssembleLabelsAndDirectives(t *testing.T) {
+	prog, err := Assemble(`
+		.org $C000
+	start:
+		LDX #0
+	loop: INX; CPX #<data; BNE loop
+		JMP end
+	data: .byte $01, 2, %11
+		.word start, data+1
+	end:
+		STA $20,Y    // no ZPY for STA, falls back to ABY
+		DCP $10; ISC $10; LAX $10; SAX $10
+	`)
+	require.NoError(t, err)
+	require.Equal(t, uint16(0xC000), prog.Origin)
+	require.Equal(t, uint16(0xC002), prog.Labels["loop"])
+	require.Equal(t, []byte{
+		0xA2, 0x00,
+		0xE8,
+		0xE0, 0x0A,
+		0xD0, 0xFB,
+		0x4C, 0x11, 0xC0,
+		0x01, 0x02, 0x03,
+		0x00, 0xC0, 0x0B, 0xC0,
+		0x99, 0x20, 0x00,
+		0xC7, 0x10, 0xE7, 0x10, 0xA7, 0x10, 0x87, 0x10,
+	}, prog.Code)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, src := range []string{
+		"FOO #1",
+		"LDA",
+		"STA #1",
+		"BNE far; .org $9000; far: NOP",
+		"JMP missing",
+		".org $9000; NOP; .org $8000",
+	} {
+		_, err := Assemble(src)
+		require.Error(t, err, src)
+	}
+}
+
+// 每一条CPU支持的指令都必须能被汇编器编码
+func TestEncodeCoversInstructionTable(t *testing.T) {
+	for op, ins := range instructionTable {
+		if ins == nil {
+			continue
+		}
+		code, ok := Encode(ins.Code, ins.Mode)
+		require.True(t, ok)
+		require.Equal(t, ins.Code, instructionTable[code].Code)
+		require.Equal(t, ins.Mode, instructionTable[code].Mode, "opcode 0x%02X", op)
+	}
+}
+
+func TestAsmRunOnCPU(t *testing.T) {
+	c := asm(t, "LDA #$10; STA $0200")
+	run(t, c, 2)
+	require.Equal(t, byte(0x10), c.memo.Read(0x0200))
+
+	c = asm(t, `
+		LDX #5
+		LDA #0
+	loop:
+		CLC
+		ADC #3
+		DEX
+		BNE loop
+		STA $00
+	`)
+	run(t, c, 2+4*5+1)
+	require.Equal(t, byte(15), c.memo.Read(0x0000))
+	require.Equal(t, byte(0), c.register.X)
+}
+
+func TestBuildNesImageKeepsVectors(t *testing.T) {
+	prog, err := Assemble(`
+	.org $8000
+reset:
+	NOP
+nmi:
+	RTI
+	.org $FFFA
+	.word nmi, reset, reset
+`)
+	require.NoError(t, err)
+	require.Equal(t, 0x10000, prog.End())
+	image, err := BuildNesImage(prog)
+	require.NoError(t, err)
+	vectors := image[16+0x7FFA : 16+0x8000]
+	require.Equal(t, []byte{0x01, 0x80, 0x00, 0x80, 0x00, 0x80}, vectors)
+}
+
+func TestCLIAndInterruptStatus(t *testing.T) {
+	c := asm(t, "SEI; CLI; NOP")
+	run(t, c, 2)
+	require.False(t, c.register.getFlag(FLAG_I))
+
+	c.ExecIRQ()
+	require.True(t, c.register.getFlag(FLAG_I))
+	// 中断压栈的状态B为0，U为1
+	p := c.StackPop()
+	require.Equal(t, uint8(FLAG_U), p&uint8(FLAG_U))
+	require.Equal(t, uint8(0), p&uint8(FLAG_B))
+
+	// I为1时忽略IRQ
+	pc := c.register.PC
+	c.ExecIRQ()
+	require.Equal(t, pc, c.register.PC)
+}
@@ -10,13 +10,20 @@ import (
 
 type Bus interface {
 	Tick(int)
+	Cycles() uint64
 }
 
 type DefaultBus struct {
+	cycles uint64
 }
 
 func (bus *DefaultBus) Tick(n int) {
+	bus.cycles += uint64(n)
+}
 
+// Cycles 返回上电以来CPU执行的总周期数
+func (bus *DefaultBus) Cycles() uint64 {
+	return bus.cycles
 }
 
 type CPU struct {
@@ -54,6 +61,15 @@ func (c *CPU) Reset() {
 	c.memo.Write(0x4015, 0x00) // all channels enabled
 }
 
+// Register 返回CPU寄存器，调试器通过它查看和修改CPU状态
+func (c *CPU) Register() *Register {
+	return c.register
+}
+
+func (c *CPU) Cycles() uint64 {
+	return c.bus.Cycles()
+}
+
 func (c *CPU) increasePC() {
 	c.register.IncreasePC()
 }
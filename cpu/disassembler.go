@@ -0,0 +1,105 @@
+package cpu
+
+import (
+	"fc-emulator/cpu/addressing"
+	"fmt"
+)
+
+// Line 是一条反汇编出来的指令
+type Line struct {
+	Addr  uint16
+	Bytes []byte
+	Text  string
+}
+
+func (l *Line) String() string {
+	hex := ""
+	for _, b := range l.Bytes {
+		hex += fmt.Sprintf("%02X ", b)
+	}
+	return fmt.Sprintf("%04X  %-9s %s", l.Addr, hex, l.Text)
+}
+
+// Disassemble 反汇编addr处的一条指令，read必须是没有副作用的读函数。
+// 不认识的opcode按 .byte 输出，长度为1。
+func Disassemble(read func(addr uint16) byte, addr uint16) *Line {
+	op := read(addr)
+	instruction := instructionTable[op]
+	if instruction == nil {
+		return &Line{Addr: addr, Bytes: []byte{op}, Text: fmt.Sprintf(".byte $%02X", op)}
+	}
+	size := 1 + operandSize(instruction.Mode)
+	bytes := make([]byte, 0, size)
+	for i := 0; i < size; i++ {
+		bytes = append(bytes, read(addr+uint16(i)))
+	}
+	var operand uint16
+	if size == 2 {
+		operand = uint16(bytes[1])
+	} else if size == 3 {
+		operand = uint16(bytes[2])<<8 | uint16(bytes[1])
+	}
+	name := instruction.Code.String()
+	var text string
+	switch instruction.Mode {
+	case addressing.IMP:
+		text = name
+	case addressing.IMM:
+		text = fmt.Sprintf("%s #$%02X", name, operand)
+	case addressing.ZPG:
+		text = fmt.Sprintf("%s $%02X", name, operand)
+	case addressing.ZPX:
+		text = fmt.Sprintf("%s $%02X,X", name, operand)
+	case addressing.ZPY:
+		text = fmt.Sprintf("%s $%02X,Y", name, operand)
+	case addressing.INX:
+		text = fmt.Sprintf("%s ($%02X,X)", name, operand)
+	case addressing.INY:
+		text = fmt.Sprintf("%s ($%02X),Y", name, operand)
+	case addressing.ABS:
+		text = fmt.Sprintf("%s $%04X", name, operand)
+	case addressing.ABX:
+		text = fmt.Sprintf("%s $%04X,X", name, operand)
+	case addressing.ABY:
+		text = fmt.Sprintf("%s $%04X,Y", name, operand)
+	case addressing.IND:
+		text = fmt.Sprintf("%s ($%04X)", name, operand)
+	case addressing.REL:
+		target := addr + 2 + uint16(int8(operand))
+		text = fmt.Sprintf("%s $%04X", name, target)
+	}
+	return &Line{Addr: addr, Bytes: bytes, Text: text}
+}
+
+// DisassembleAround 反汇编pc附近的指令，pc之前最多before条，pc及之后after条。
+// 6502的指令是变长的，往前反汇编时从更早的地址开始同步，选一个能正好落在pc上的起点。
+func DisassembleAround(read func(addr uint16) byte, pc uint16, before, after int) []*Line {
+	res := make([]*Line, 0, before+after)
+	for back := before * 3; back > 0; back-- {
+		start := pc - uint16(back)
+		if start > pc {
+			continue
+		}
+		lines := make([]*Line, 0)
+		addr := start
+		for addr < pc {
+			line := Disassemble(read, addr)
+			lines = append(lines, line)
+			addr += uint16(len(line.Bytes))
+		}
+		if addr == pc {
+			if len(lines) > before {
+				lines = lines[len(lines)-before:]
+			}
+			res = append(res, lines...)
+			break
+		}
+	}
+	addr := pc
+	for i := 0; i < after; i++ {
+		line := Disassemble(read, addr)
+		res = append(res, line)
+		addr += uint16(len(line.Bytes))
+	}
+	return res
+}
@@ -0,0 +1,37 @@
+package cpu
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	prog, err := Assemble(`
+	start:
+		LDA #$10; STA $0200; LDX $10,Y; LDA ($20,X); STA ($20),Y
+		JMP ($1234); ASL A; BNE start; LAX $1234,Y; NOP
+		.byte $02
+	`)
+	require.NoError(t, err)
+	read := func(addr uint16) byte {
+		return prog.Code[addr-prog.Origin]
+	}
+	texts := make([]string, 0)
+	for addr := prog.Origin; int(addr) < prog.End(); {
+		line := Disassemble(read, addr)
+		texts = append(texts, line.Text)
+		addr += uint16(len(line.Bytes))
+	}
+	require.Equal(t, "LDX $10,Y", texts[2])
+	require.Equal(t, "BNE $8000", texts[7])
+	require.Equal(t, ".byte $02", texts[10])
+	again, err := Assemble(strings.Join(texts, "\n"))
+	require.NoError(t, err)
+	require.Equal(t, prog.Code, again.Code)
+
+	lines := DisassembleAround(read, prog.Labels["start"]+7, 2, 2)
+	require.Len(t, lines, 4)
+	require.Equal(t, "LDX $10,Y", lines[1].Text)
+	require.Equal(t, "LDA ($20,X)", lines[2].Text)
+}
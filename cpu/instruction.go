@@ -122,6 +122,9 @@ var instructionTable = [256]*Instruction{
 	// Set the interrupt disable flag to one.
 	0x78: {opcode.SEI, addressing.IMP, (*CPU).SEI, 2, false},
 
+	// Clears the interrupt disable flag allowing normal interrupt requests to be serviced.
+	0x58: {opcode.CLI, addressing.IMP, (*CPU).CLI, 2, false},
+
 	// Set the decimal mode flag to one.
 	0xF8: {opcode.SED, addressing.IMP, (*CPU).SED, 2, false},
 
@@ -767,6 +770,10 @@ func (c *CPU) SEI(operandAddr uint16) { // set interrupt disable
 	c.register.setFlag(FLAG_I, true)
 }
 
+func (c *CPU) CLI(operandAddr uint16) { // clear interrupt disable
+	c.register.setFlag(FLAG_I, false)
+}
+
 func (c *CPU) SED(operandAddr uint16) {
 	c.register.setFlag(FLAG_D, true)
 }
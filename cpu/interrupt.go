@@ -9,15 +9,14 @@ const (
 	IV_BRK   uint16 = 0xFFFE
 )
 
+// ExecIRQ I标志为1时忽略，IRQ是电平触发的，调用方需要在中断源清除之前一直调用
 func (c *CPU) ExecIRQ() {
-	fmt.Println("EXECUTE IRQ")
 	if c.register.getFlag(FLAG_I) {
-		fmt.Println("IRQ Skip")
 		return
 	}
 
 	c.StackPushWord(c.register.PC)
-	c.StackPush((c.register.P | uint8(FLAG_U)) | (^uint8(FLAG_B)))
+	c.StackPush((c.register.P | uint8(FLAG_U)) &^ uint8(FLAG_B))
 	c.register.setFlag(FLAG_I, true)
 	c.register.PC = c.memo.ReadWord(IV_IRQ)
 }
@@ -26,7 +25,7 @@ func (c *CPU) ExecIRQ() {
 func (c *CPU) ExecNMI() {
 	//fmt.Println("EXECUTE NMI")
 	c.StackPushWord(c.register.PC)
-	c.StackPush((c.register.P | uint8(FLAG_U)) | (^uint8(FLAG_B)))
+	c.StackPush((c.register.P | uint8(FLAG_U)) &^ uint8(FLAG_B))
 	c.register.setFlag(FLAG_I, true)
 	c.register.PC = c.memo.ReadWord(IV_NMI)
 }
@@ -0,0 +1,681 @@
+package debug
+
+import (
+	"errors"
+	"fc-emulator/cpu"
+	"fc-emulator/memo"
+	"fc-emulator/ppu"
+	"fmt"
+	"sync"
+)
+
+// Debugger 位于cpu.CPU和memo.Memo之间，提供断点、watchpoint和单步执行。
+// 模拟器的执行循环通过Execute执行指令，命中断点时Execute会阻塞，直到调用Continue或Step*。
+//
+// 暂停总是发生在指令执行之前，所以暂停时PC指向的是下一条将要执行的指令。
+// watchpoint在指令执行过程中被触发，等这条指令执行完之后才会暂停。
+
+type Space int
+
+const (
+	CPUSpace Space = iota
+	PPUSpace
+)
+
+var spaceNames = map[Space]string{
+	CPUSpace: "cpu",
+	PPUSpace: "ppu",
+}
+
+func (s Space) String() string {
+	return spaceNames[s]
+}
+
+type AccessKind int
+
+const (
+	AccessRead AccessKind = 1 << iota
+	AccessWrite
+	AccessExec
+)
+
+func (k AccessKind) String() string {
+	res := ""
+	for _, v := range []struct {
+		kind AccessKind
+		name string
+	}{{AccessRead, "r"}, {AccessWrite, "w"}, {AccessExec, "x"}} {
+		if k&v.kind != 0 {
+			res += v.name
+		}
+	}
+	return res
+}
+
+// ParseAccessKind 解析 "r" "w" "x" "rw" 这样的写法
+func ParseAccessKind(s string) (AccessKind, error) {
+	var kind AccessKind
+	for _, c := range s {
+		switch c {
+		case 'r', 'R':
+			kind |= AccessRead
+		case 'w', 'W':
+			kind |= AccessWrite
+		case 'x', 'X':
+			kind |= AccessExec
+		default:
+			return 0, fmt.Errorf("bad access kind %q", s)
+		}
+	}
+	if kind == 0 {
+		return 0, fmt.Errorf("empty access kind")
+	}
+	return kind, nil
+}
+
+type Breakpoint struct {
+	ID      int
+	Addr    uint16
+	Cond    *Condition
+	Enabled bool
+	Hits    int
+}
+
+type Watchpoint struct {
+	ID      int
+	Space   Space
+	Start   uint16
+	End     uint16 // 包含End
+	Kind    AccessKind
+	Cond    *Condition
+	Enabled bool
+	Hits    int
+}
+
+func (w *Watchpoint) match(space Space, addr uint16, kind AccessKind) bool {
+	return w.Enabled && w.Space == space && w.Kind&kind != 0 && addr >= w.Start && addr <= w.End
+}
+
+type PauseReason int
+
+const (
+	PauseManual PauseReason = iota
+	PauseBreakpoint
+	PauseWatchpoint
+	PauseStep
+	PauseScanline
+	PauseNMI
+)
+
+var pauseReasonNames = map[PauseReason]string{
+	PauseManual:     "pause",
+	PauseBreakpoint: "breakpoint",
+	PauseWatchpoint: "watchpoint",
+	PauseStep:       "step",
+	PauseScanline:   "scanline",
+	PauseNMI:        "nmi",
+}
+
+func (r PauseReason) String() string {
+	return pauseReasonNames[r]
+}
+
+// Hit 描述了一次暂停的原因
+type Hit struct {
+	Reason PauseReason
+	ID     int // 命中的断点或watchpoint的ID
+	Space  Space
+	Addr   uint16
+	Value  byte
+	Kind   AccessKind
+}
+
+func (h *Hit) String() string {
+	switch h.Reason {
+	case PauseBreakpoint:
+		return fmt.Sprintf("breakpoint #%d at $%04X", h.ID, h.Addr)
+	case PauseWatchpoint:
+		return fmt.Sprintf("watchpoint #%d %s %s $%04X = $%02X", h.ID, h.Space, h.Kind, h.Addr, h.Value)
+	default:
+		return h.Reason.String()
+	}
+}
+
+// State 调试器暂停时的快照
+type State struct {
+	Paused   bool
+	Hit      *Hit
+	Register cpu.Register
+	Cycles   uint64
+	Scanline int
+	Frame    int
+}
+
+type stepMode int
+
+const (
+	modeRun stepMode = iota
+	modeStepInto
+	modeRunTo // step over时运行到JSR的下一条指令
+	modeStepOut
+	modeScanline
+	modeNMI
+)
+
+type Debugger struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	cpu   *cpu.CPU
+	inner memo.Memo
+	Memo  memo.Memo // 交给CPU使用的memo，所有读写都会经过调试器
+
+	breakpoints []*Breakpoint
+	watchpoints []*Watchpoint
+	nextID      int
+
+	paused         bool
+	pauseRequested bool
+	hit            *Hit
+	pending        *Hit
+
+	mode         stepMode
+	stepPC       uint16
+	stepS        uint8
+	stepScanline int
+	executed     int // 恢复运行后执行的指令数
+	skipBreak    bool
+	lastOp       byte
+	lastScanline int
+
+	vblankCycles uint64
+	frame        int
+
+	PauseCallback func(state *State)
+	subscribers   map[chan *State]bool
+}
+
+func NewDebugger(inner memo.Memo) *Debugger {
+	d := &Debugger{
+		inner:       inner,
+		breakpoints: make([]*Breakpoint, 0),
+		watchpoints: make([]*Watchpoint, 0),
+		nextID:      1,
+		subscribers: map[chan *State]bool{},
+	}
+	d.cond = sync.NewCond(&d.mu)
+	d.Memo = &debugMemo{d: d, inner: inner}
+	return d
+}
+
+func (d *Debugger) AttachCPU(c *cpu.CPU) {
+	d.cpu = c
+}
+
+func (d *Debugger) AttachPPU(p *ppu.PPUImpl) {
+	p.Memo.AccessHook = func(addr uint16, val byte, write bool) {
+		kind := AccessRead
+		if write {
+			kind = AccessWrite
+		}
+		d.onAccess(PPUSpace, addr, val, kind)
+	}
+}
+
+// Execute 执行一条指令，需要暂停时阻塞
+func (d *Debugger) Execute() error {
+	d.beforeInstruction()
+	_, err := d.cpu.ExecuteOneInstruction()
+	d.afterInstruction()
+	return err
+}
+
+// EnterVblank 在PPU进入vblank时调用，用于估算当前扫描线
+func (d *Debugger) EnterVblank() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.vblankCycles = d.cpu.Cycles()
+	d.frame += 1
+}
+
+// OnNMI 在CPU进入NMI之后调用
+func (d *Debugger) OnNMI() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mode == modeNMI && d.pending == nil {
+		d.pending = &Hit{Reason: PauseNMI, Addr: d.cpu.Register().PC}
+	}
+}
+
+// Scanline 根据vblank之后经过的CPU周期估算当前扫描线(0-261)，vblank从241开始。
+// PPU目前不是按周期驱动的，所以这只是一个近似值。
+func (d *Debugger) Scanline() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.scanline()
+}
+
+func (d *Debugger) scanline() int {
+	elapsed := d.cpu.Cycles() - d.vblankCycles
+	return int((241 + elapsed*3/341) % 262)
+}
+
+func (d *Debugger) beforeInstruction() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if hit := d.checkPause(); hit != nil {
+		d.paused = true
+		d.hit = hit
+		d.mode = modeRun
+		d.pending = nil
+		d.pauseRequested = false
+		state := d.stateLocked()
+		for ch := range d.subscribers {
+			select {
+			case ch <- state:
+			default: // 订阅者没有及时处理，丢弃旧的状态
+			}
+		}
+		callback := d.PauseCallback
+		if callback != nil {
+			d.mu.Unlock()
+			callback(state)
+			d.mu.Lock()
+		}
+		for d.paused {
+			d.cond.Wait()
+		}
+	}
+	reg := d.cpu.Register()
+	d.lastOp = d.peek(reg.PC)
+	d.lastScanline = d.scanline()
+}
+
+func (d *Debugger) checkPause() *Hit {
+	reg := d.cpu.Register()
+	if d.pending != nil {
+		return d.pending
+	}
+	if d.pauseRequested {
+		return &Hit{Reason: PauseManual, Addr: reg.PC}
+	}
+	if d.executed > 0 {
+		switch d.mode {
+		case modeStepInto:
+			return &Hit{Reason: PauseStep, Addr: reg.PC}
+		case modeRunTo:
+			if reg.PC == d.stepPC && reg.S >= d.stepS {
+				return &Hit{Reason: PauseStep, Addr: reg.PC}
+			}
+		case modeScanline:
+			if line := d.scanline(); line == d.stepScanline && d.lastScanline != line {
+				return &Hit{Reason: PauseScanline, Addr: reg.PC}
+			}
+		}
+	}
+	if d.skipBreak {
+		return nil
+	}
+	env := &debugEnv{d: d}
+	for _, bp := range d.breakpoints {
+		if bp.Enabled && bp.Addr == reg.PC && bp.Cond.Match(env) {
+			bp.Hits += 1
+			return &Hit{Reason: PauseBreakpoint, ID: bp.ID, Addr: reg.PC}
+		}
+	}
+	for _, wp := range d.watchpoints {
+		if wp.match(CPUSpace, reg.PC, AccessExec) && wp.Cond.Match(env) {
+			wp.Hits += 1
+			return &Hit{Reason: PauseWatchpoint, ID: wp.ID, Space: CPUSpace, Addr: reg.PC, Kind: AccessExec}
+		}
+	}
+	return nil
+}
+
+func (d *Debugger) afterInstruction() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.executed += 1
+	d.skipBreak = false
+	if d.mode == modeStepOut && d.pending == nil {
+		isReturn := d.lastOp == 0x60 || d.lastOp == 0x40 // RTS, RTI
+		if isReturn && d.cpu.Register().S > d.stepS {
+			d.pending = &Hit{Reason: PauseStep, Addr: d.cpu.Register().PC}
+		}
+	}
+}
+
+func (d *Debugger) onAccess(space Space, addr uint16, val byte, kind AccessKind) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.watchpoints) == 0 || d.pending != nil {
+		return
+	}
+	env := &debugEnv{d: d, addr: addr, val: val}
+	for _, wp := range d.watchpoints {
+		if wp.match(space, addr, kind) && wp.Cond.Match(env) {
+			wp.Hits += 1
+			d.pending = &Hit{Reason: PauseWatchpoint, ID: wp.ID, Space: space, Addr: addr, Value: val, Kind: kind}
+			return
+		}
+	}
+}
+
+func (d *Debugger) peek(addr uint16) byte {
+	return d.inner.Peek(addr)
+}
+
+// Peek 读取CPU地址空间而不产生副作用
+func (d *Debugger) Peek(addr uint16) byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.peek(addr)
+}
+
+// Poke 修改CPU地址空间而不产生副作用，也不会触发watchpoint
+func (d *Debugger) Poke(addr uint16, val byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inner.Poke(addr, val)
+}
+
+// Subscribe 订阅暂停事件，每次暂停时会把状态发送到返回的channel，调用cancel取消订阅
+func (d *Debugger) Subscribe() (events <-chan *State, cancel func()) {
+	ch := make(chan *State, 1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers[ch] = true
+	return ch, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.subscribers, ch)
+	}
+}
+
+// SetRegister 修改CPU寄存器，应该只在暂停时调用
+func (d *Debugger) SetRegister(fn func(reg *cpu.Register)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(d.cpu.Register())
+}
+
+// Disassemble 反汇编PC附近的指令
+func (d *Debugger) Disassemble(before, after int) []*cpu.Line {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return cpu.DisassembleAround(d.peek, d.cpu.Register().PC, before, after)
+}
+
+// Stack 返回栈中的数据，从栈顶($0100+S+1)到$01FF
+func (d *Debugger) Stack() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make([]byte, 0)
+	for addr := 0x0100 + int(d.cpu.Register().S) + 1; addr <= 0x01FF; addr++ {
+		res = append(res, d.peek(uint16(addr)))
+	}
+	return res
+}
+
+func (d *Debugger) State() *State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stateLocked()
+}
+
+func (d *Debugger) stateLocked() *State {
+	return &State{
+		Paused:   d.paused,
+		Hit:      d.hit,
+		Register: *d.cpu.Register(),
+		Cycles:   d.cpu.Cycles(),
+		Scanline: d.scanline(),
+		Frame:    d.frame,
+	}
+}
+
+func (d *Debugger) IsPaused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// Pause 请求在下一条指令之前暂停
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.paused {
+		d.pauseRequested = true
+	}
+}
+
+var ErrNotPaused = errors.New("debugger is not paused")
+
+func (d *Debugger) resume(mode stepMode) error {
+	if !d.paused {
+		return ErrNotPaused
+	}
+	d.mode = mode
+	d.paused = false
+	d.hit = nil
+	d.executed = 0
+	d.skipBreak = true // 从断点处恢复时，不能立即再次命中同一个断点
+	d.cond.Broadcast()
+	return nil
+}
+
+func (d *Debugger) Continue() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resume(modeRun)
+}
+
+// StepInto 执行一条指令
+func (d *Debugger) StepInto() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resume(modeStepInto)
+}
+
+// StepOver 和StepInto一样，但是遇到JSR时会一直运行到子程序返回
+func (d *Debugger) StepOver() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	reg := d.cpu.Register()
+	if d.peek(reg.PC) != 0x20 { // JSR
+		return d.resume(modeStepInto)
+	}
+	d.stepPC = reg.PC + 3
+	d.stepS = reg.S
+	return d.resume(modeRunTo)
+}
+
+// StepOut 运行到当前子程序或中断处理程序返回(RTS/RTI)
+func (d *Debugger) StepOut() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stepS = d.cpu.Register().S
+	return d.resume(modeStepOut)
+}
+
+// RunToScanline 运行到指定的扫描线
+func (d *Debugger) RunToScanline(line int) error {
+	if line < 0 || line >= 262 {
+		return fmt.Errorf("scanline %d out of range", line)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stepScanline = line
+	return d.resume(modeScanline)
+}
+
+// RunToNMI 运行到下一次NMI，在NMI处理程序的第一条指令处暂停
+func (d *Debugger) RunToNMI() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resume(modeNMI)
+}
+
+func (d *Debugger) AddBreakpoint(addr uint16, cond string) (*Breakpoint, error) {
+	c, err := parseOptionalCondition(cond)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bp := &Breakpoint{ID: d.nextID, Addr: addr, Cond: c, Enabled: true}
+	d.nextID += 1
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp, nil
+}
+
+func (d *Debugger) AddWatchpoint(space Space, start, end uint16, kind AccessKind, cond string) (*Watchpoint, error) {
+	if end < start {
+		return nil, fmt.Errorf("bad watch range $%04X-$%04X", start, end)
+	}
+	if space == PPUSpace && kind&AccessExec != 0 {
+		return nil, fmt.Errorf("execute watchpoint is only supported in cpu space")
+	}
+	c, err := parseOptionalCondition(cond)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wp := &Watchpoint{ID: d.nextID, Space: space, Start: start, End: end, Kind: kind, Cond: c, Enabled: true}
+	d.nextID += 1
+	d.watchpoints = append(d.watchpoints, wp)
+	return wp, nil
+}
+
+func parseOptionalCondition(cond string) (*Condition, error) {
+	if cond == "" {
+		return nil, nil
+	}
+	return ParseCondition(cond)
+}
+
+// Remove 删除一个断点或watchpoint
+func (d *Debugger) Remove(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	for i, wp := range d.watchpoints {
+		if wp.ID == id {
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetEnabled 启用或禁用一个断点或watchpoint
+func (d *Debugger) SetEnabled(id int, enabled bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, bp := range d.breakpoints {
+		if bp.ID == id {
+			bp.Enabled = enabled
+			return true
+		}
+	}
+	for _, wp := range d.watchpoints {
+		if wp.ID == id {
+			wp.Enabled = enabled
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints 返回断点的拷贝
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make([]Breakpoint, 0, len(d.breakpoints))
+	for _, bp := range d.breakpoints {
+		res = append(res, *bp)
+	}
+	return res
+}
+
+// Watchpoints 返回watchpoint的拷贝
+func (d *Debugger) Watchpoints() []Watchpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make([]Watchpoint, 0, len(d.watchpoints))
+	for _, wp := range d.watchpoints {
+		res = append(res, *wp)
+	}
+	return res
+}
+
+// debugEnv 是条件表达式的求值环境
+type debugEnv struct {
+	d    *Debugger
+	addr uint16
+	val  byte
+}
+
+func (e *debugEnv) Var(name string) (int, bool) {
+	reg := e.d.cpu.Register()
+	switch name {
+	case "A":
+		return int(reg.A), true
+	case "X":
+		return int(reg.X), true
+	case "Y":
+		return int(reg.Y), true
+	case "S":
+		return int(reg.S), true
+	case "P":
+		return int(reg.P), true
+	case "PC":
+		return int(reg.PC), true
+	case "ADDR":
+		return int(e.addr), true
+	case "VAL":
+		return int(e.val), true
+	}
+	return 0, false
+}
+
+func (e *debugEnv) Mem(addr uint16) byte {
+	return e.d.peek(addr)
+}
+
+// debugMemo 包装CPU的memo，把每次读写交给调试器检查watchpoint
+type debugMemo struct {
+	d     *Debugger
+	inner memo.Memo
+}
+
+func (m *debugMemo) Read(addr uint16) byte {
+	val := m.inner.Read(addr)
+	m.d.onAccess(CPUSpace, addr, val, AccessRead)
+	return val
+}
+
+func (m *debugMemo) ReadWord(addr uint16) uint16 {
+	byte1 := m.Read(addr)
+	byte2 := m.Read(addr + 1)
+	return uint16(byte2)<<8 | uint16(byte1)
+}
+
+func (m *debugMemo) Peek(addr uint16) byte {
+	return m.inner.Peek(addr)
+}
+
+func (m *debugMemo) Poke(addr uint16, val byte) {
+	m.inner.Poke(addr, val)
+}
+
+func (m *debugMemo) Write(addr uint16, val byte) {
+	m.d.onAccess(CPUSpace, addr, val, AccessWrite)
+	m.inner.Write(addr, val)
+}
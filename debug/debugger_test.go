@@ -0,0 +1,191 @@
+package debug
+
+import (
+	"fc-emulator/cpu"
+	"fc-emulator/memo"
+	"fc-emulator/pad"
+	"fc-emulator/ppu"
+	"fc-emulator/rom"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type testEnv struct {
+	mem map[uint16]byte
+}
+
+func (e *testEnv) Var(name string) (int, bool) {
+	if name == "A" {
+		return 0xFF, true
+	}
+	return 0, false
+}
+
+func (e *testEnv) Mem(addr uint16) byte {
+	return e.mem[addr]
+}
+
+func TestCondition(t *testing.T) {
+	env := &testEnv{mem: map[uint16]byte{0x0300: 4}}
+	cases := map[string]bool{
+		"A == #$FF && [$0300] > 3":   true,
+		"A == #$FF && [$0300] > 4":   false,
+		"A != $FF || [$0300] == 4":   true,
+		"!(A == 255)":                false,
+		"[$02FF + 1] & %100 == 4":    true,
+		"(A & $0F) == 15 && X == 0":  true,
+		"[$0300] >= 4 && [$0300]<=4": true,
+	}
+	for src, expect := range cases {
+		c, err := ParseCondition(src)
+		require.NoError(t, err, src)
+		require.Equal(t, expect, c.Match(env), src)
+	}
+	for _, src := range []string{"A ==", "[$0300", "A @ 1", "Q == 1"} {
+		_, err := ParseCondition(src)
+		require.Error(t, err, src)
+	}
+}
+
+// startDebugger 加载一段汇编程序，调用setup之后在后台运行，暂停时把状态发到返回的channel
+func startDebugger(t *testing.T, src string, setup func(d *Debugger, prog *cpu.Program)) (*Debugger, *cpu.Program, chan *State) {
+	prog, err := cpu.Assemble(src)
+	require.NoError(t, err)
+	image, err := cpu.BuildNesImage(prog)
+	require.NoError(t, err)
+	nesRom, err := rom.ParseBytes(image)
+	require.NoError(t, err)
+	p := ppu.NewPPU(nesRom)
+	d := NewDebugger(memo.NewMemo(nesRom, p, pad.NewPad(), pad.NewPad()))
+	c := cpu.NewCPU(d.Memo, false)
+	d.AttachCPU(c)
+	d.AttachPPU(p.(*ppu.PPUImpl))
+	c.Reset()
+	paused := make(chan *State, 16)
+	d.PauseCallback = func(state *State) {
+		paused <- state
+	}
+	if setup != nil {
+		setup(d, prog)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		d.Pause()
+		_ = d.Continue()
+	})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := d.Execute(); err != nil {
+				panic(err)
+			}
+		}
+	}()
+	return d, prog, paused
+}
+
+func waitPause(t *testing.T, paused chan *State) *State {
+	select {
+	case state := <-paused:
+		return state
+	case <-time.After(2 * time.Second):
+		t.Fatal("debugger did not pause")
+		return nil
+	}
+}
+
+const testProgram = `
+	.org $8000
+reset:
+	LDX #0
+loop:
+	INX
+	STX $0300
+	JSR sub
+	JMP loop
+sub:
+	LDA $0300
+	NOP
+	RTS
+`
+
+func TestBreakpointAndStep(t *testing.T) {
+	d, prog, paused := startDebugger(t, "JMP wait; wait: JMP wait", nil)
+	d.Pause()
+	state := waitPause(t, paused)
+	require.Equal(t, PauseManual, state.Hit.Reason)
+	require.NoError(t, d.StepInto())
+	state = waitPause(t, paused)
+	require.Equal(t, PauseStep, state.Hit.Reason)
+	require.Equal(t, prog.Labels["wait"], state.Register.PC)
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	d, prog, paused := startDebugger(t, testProgram, func(d *Debugger, prog *cpu.Program) {
+		_, err := d.AddBreakpoint(prog.Labels["sub"], "X == 3 && [$0300] == 3")
+		require.NoError(t, err)
+	})
+	state := waitPause(t, paused)
+	require.Equal(t, PauseBreakpoint, state.Hit.Reason)
+	require.Equal(t, prog.Labels["sub"], state.Register.PC)
+	require.Equal(t, byte(3), state.Register.X)
+	require.Equal(t, 1, d.Breakpoints()[0].Hits)
+}
+
+func TestWatchpoint(t *testing.T) {
+	_, prog, paused := startDebugger(t, testProgram, func(d *Debugger, prog *cpu.Program) {
+		_, err := d.AddWatchpoint(CPUSpace, 0x0300, 0x0300, AccessWrite, "VAL == 5")
+		require.NoError(t, err)
+	})
+	state := waitPause(t, paused)
+	require.Equal(t, PauseWatchpoint, state.Hit.Reason)
+	require.Equal(t, uint16(0x0300), state.Hit.Addr)
+	require.Equal(t, byte(5), state.Hit.Value)
+	// 暂停在写入指令的下一条指令
+	require.Equal(t, prog.Labels["loop"]+4, state.Register.PC)
+}
+
+func TestStepOverAndOut(t *testing.T) {
+	var bp *Breakpoint
+	d, prog, paused := startDebugger(t, testProgram, func(d *Debugger, prog *cpu.Program) {
+		bp, _ = d.AddBreakpoint(prog.Labels["loop"]+4, "") // JSR sub
+	})
+	state := waitPause(t, paused)
+	require.Equal(t, prog.Labels["loop"]+4, state.Register.PC)
+	d.Remove(bp.ID)
+
+	require.NoError(t, d.StepOver())
+	state = waitPause(t, paused)
+	require.Equal(t, prog.Labels["loop"]+7, state.Register.PC)
+
+	_, err := d.AddBreakpoint(prog.Labels["sub"]+3, "") // NOP
+	require.NoError(t, err)
+	require.NoError(t, d.Continue())
+	state = waitPause(t, paused)
+	require.Equal(t, prog.Labels["sub"]+3, state.Register.PC)
+
+	require.NoError(t, d.StepOut())
+	state = waitPause(t, paused)
+	require.Equal(t, prog.Labels["loop"]+7, state.Register.PC)
+}
+
+func TestPPUWatchpoint(t *testing.T) {
+	_, _, paused := startDebugger(t, `
+		LDA #$20; STA $2006; LDA #$00; STA $2006
+		LDA #$42; STA $2007
+	wait: JMP wait
+	`, func(d *Debugger, prog *cpu.Program) {
+		_, err := d.AddWatchpoint(PPUSpace, 0x2000, 0x23FF, AccessWrite, "")
+		require.NoError(t, err)
+	})
+	state := waitPause(t, paused)
+	require.Equal(t, PPUSpace, state.Hit.Space)
+	require.Equal(t, uint16(0x2000), state.Hit.Addr)
+	require.Equal(t, byte(0x42), state.Hit.Value)
+}
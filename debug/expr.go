@@ -0,0 +1,283 @@
+package debug
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 断点条件表达式，例如: A == #$FF && [$0300] > 3
+//
+// 支持:
+//   - 寄存器: A X Y S P PC
+//   - 访问信息: ADDR(被访问的地址) VAL(读出或写入的值)，只对watchpoint有意义
+//   - 数字: #$FF $FF %1010 255，"#" 只是为了和汇编的写法保持一致
+//   - 内存: [expr] 读取CPU地址空间的一个字节，不会产生副作用
+//   - 运算符: || && == != < <= > >= + - & | ! 和括号
+
+type Env interface {
+	Var(name string) (int, bool)
+	Mem(addr uint16) byte
+}
+
+type Expr interface {
+	Eval(env Env) int
+}
+
+// Condition 编译后的条件表达式
+type Condition struct {
+	Source string
+	expr   Expr
+}
+
+func ParseCondition(src string) (*Condition, error) {
+	p := &exprParser{src: src}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in condition %q", p.tokens[p.pos], src)
+	}
+	return &Condition{Source: src, expr: expr}, nil
+}
+
+func (c *Condition) Match(env Env) bool {
+	if c == nil {
+		return true
+	}
+	return c.expr.Eval(env) != 0
+}
+
+func (c *Condition) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.Source
+}
+
+type numExpr int
+
+func (e numExpr) Eval(env Env) int {
+	return int(e)
+}
+
+type varExpr string
+
+func (e varExpr) Eval(env Env) int {
+	v, _ := env.Var(string(e))
+	return v
+}
+
+type memExpr struct {
+	addr Expr
+}
+
+func (e *memExpr) Eval(env Env) int {
+	return int(env.Mem(uint16(e.addr.Eval(env))))
+}
+
+type notExpr struct {
+	x Expr
+}
+
+func (e *notExpr) Eval(env Env) int {
+	return boolInt(e.x.Eval(env) == 0)
+}
+
+type binaryExpr struct {
+	op   string
+	x, y Expr
+}
+
+func (e *binaryExpr) Eval(env Env) int {
+	x := e.x.Eval(env)
+	switch e.op {
+	case "||":
+		return boolInt(x != 0 || e.y.Eval(env) != 0)
+	case "&&":
+		return boolInt(x != 0 && e.y.Eval(env) != 0)
+	}
+	y := e.y.Eval(env)
+	switch e.op {
+	case "==":
+		return boolInt(x == y)
+	case "!=":
+		return boolInt(x != y)
+	case "<":
+		return boolInt(x < y)
+	case "<=":
+		return boolInt(x <= y)
+	case ">":
+		return boolInt(x > y)
+	case ">=":
+		return boolInt(x >= y)
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "&":
+		return x & y
+	case "|":
+		return x | y
+	default:
+		panic("unknown operator " + e.op)
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 运算符优先级，数字越大优先级越高
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"|": 4,
+	"&": 5,
+	"+": 6, "-": 6,
+}
+
+var registerNames = map[string]bool{
+	"A": true, "X": true, "Y": true, "S": true, "P": true, "PC": true,
+	"ADDR": true, "VAL": true,
+}
+
+type exprParser struct {
+	src    string
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.ContainsRune("()[]", rune(c)):
+			p.tokens = append(p.tokens, s[i:i+1])
+			i++
+		case strings.ContainsRune("|&=!<>+-", rune(c)):
+			if i+1 < len(s) {
+				if _, ok := binaryPrecedence[s[i:i+2]]; ok {
+					p.tokens = append(p.tokens, s[i:i+2])
+					i += 2
+					continue
+				}
+			}
+			p.tokens = append(p.tokens, s[i:i+1])
+			i++
+		default:
+			j := i + 1
+			for j < len(s) && isWordChar(s[j]) {
+				j++
+			}
+			if !isWordChar(c) && c != '#' && c != '$' && c != '%' {
+				return fmt.Errorf("unexpected character %q in condition %q", c, p.src)
+			}
+			p.tokens = append(p.tokens, s[i:j])
+			i = j
+		}
+	}
+	return nil
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c == '%' || c == '#' ||
+		(c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) parseBinary(minPrecedence int) (Expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		precedence, ok := binaryPrecedence[op]
+		if !ok || precedence <= minPrecedence {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseBinary(precedence)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	t := p.next()
+	switch t {
+	case "":
+		return nil, fmt.Errorf("unexpected end of condition %q", p.src)
+	case "!":
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x: x}, nil
+	case "(", "[":
+		x, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		closing := map[string]string{"(": ")", "[": "]"}[t]
+		if p.next() != closing {
+			return nil, fmt.Errorf("missing %q in condition %q", closing, p.src)
+		}
+		if t == "[" {
+			return &memExpr{addr: x}, nil
+		}
+		return x, nil
+	}
+	if name := strings.ToUpper(t); registerNames[name] {
+		return varExpr(name), nil
+	}
+	v, err := ParseNumber(t)
+	if err != nil {
+		return nil, fmt.Errorf("bad operand %q in condition %q", t, p.src)
+	}
+	return numExpr(v), nil
+}
+
+// ParseNumber 解析 #$FF / $FF / 0xFF / %1010 / 255 这几种写法的数字
+func ParseNumber(s string) (int, error) {
+	s = strings.TrimPrefix(s, "#")
+	var v int64
+	var err error
+	switch {
+	case strings.HasPrefix(s, "$"):
+		v, err = strconv.ParseInt(s[1:], 16, 32)
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		v, err = strconv.ParseInt(s[2:], 16, 32)
+	case strings.HasPrefix(s, "%"):
+		v, err = strconv.ParseInt(s[1:], 2, 32)
+	default:
+		v, err = strconv.ParseInt(s, 10, 32)
+	}
+	return int(v), err
+}
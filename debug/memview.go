@@ -0,0 +1,154 @@
+package debug
+
+import (
+	"encoding/hex"
+	"fc-emulator/memo"
+	"fc-emulator/ppu"
+	"fmt"
+	"strings"
+)
+
+// MemoryView 是内存查看器看到的一块地址空间，读取不能有副作用
+type MemoryView interface {
+	Name() string
+	Size() int
+	Peek(addr int) byte
+	// Poke 修改内存，不支持修改的地址返回false
+	Poke(addr int, val byte) bool
+}
+
+// cpuView CPU地址空间，修改ROM区域时会直接修改ROM的数据
+type cpuView struct {
+	memo memo.Memo
+}
+
+func NewCPUView(m memo.Memo) MemoryView {
+	return &cpuView{memo: m}
+}
+
+func (v *cpuView) Name() string { return "CPU" }
+func (v *cpuView) Size() int    { return 0x10000 }
+
+func (v *cpuView) Peek(addr int) byte {
+	return v.memo.Peek(uint16(addr))
+}
+
+// Poke 写完读回来不一样时返回false，例如只读的寄存器、没有接东西的地址或者被金手指替换的ROM
+func (v *cpuView) Poke(addr int, val byte) bool {
+	v.memo.Poke(uint16(addr), val)
+	return v.memo.Peek(uint16(addr)) == val
+}
+
+// ppuView PPU地址空间 $0000-$3FFF，调色板的镜像和PPU看到的一样
+type ppuView struct {
+	ppu *ppu.PPUImpl
+}
+
+func ppuAddr(addr int) uint16 {
+	if addr >= 0x3F00 {
+		return ppu.PaletteMirror(uint16(addr))
+	}
+	return uint16(addr)
+}
+
+func NewPPUView(p *ppu.PPUImpl) MemoryView {
+	return &ppuView{ppu: p}
+}
+
+func (v *ppuView) Name() string { return "PPU" }
+func (v *ppuView) Size() int    { return 0x4000 }
+
+func (v *ppuView) Peek(addr int) byte {
+	return v.ppu.Memo.Peek(ppuAddr(addr))
+}
+
+func (v *ppuView) Poke(addr int, val byte) bool {
+	v.ppu.Memo.Poke(ppuAddr(addr), val)
+	return true
+}
+
+type oamView struct {
+	ppu *ppu.PPUImpl
+}
+
+func NewOAMView(p *ppu.PPUImpl) MemoryView {
+	return &oamView{ppu: p}
+}
+
+func (v *oamView) Name() string { return "OAM" }
+func (v *oamView) Size() int    { return len(v.ppu.OAM) }
+
+func (v *oamView) Peek(addr int) byte {
+	return v.ppu.OAM[addr]
+}
+
+func (v *oamView) Poke(addr int, val byte) bool {
+	v.ppu.OAM[addr] = val
+	return true
+}
+
+// paletteView 调色板 $3F00-$3F1F，地址从0开始，$10/$14/$18/$1C是$00/$04/$08/$0C的镜像
+type paletteView struct {
+	ppu *ppu.PPUImpl
+}
+
+func NewPaletteView(p *ppu.PPUImpl) MemoryView {
+	return &paletteView{ppu: p}
+}
+
+func (v *paletteView) Name() string { return "Palette" }
+func (v *paletteView) Size() int    { return 0x20 }
+
+func (v *paletteView) Peek(addr int) byte {
+	return v.ppu.Memo.Peek(ppu.PaletteMirror(uint16(addr)))
+}
+
+func (v *paletteView) Poke(addr int, val byte) bool {
+	v.ppu.Memo.Poke(ppu.PaletteMirror(uint16(addr)), val&0x3F)
+	return true
+}
+
+// Snapshot 拷贝一块内存，用来比较哪些字节发生了变化
+func Snapshot(v MemoryView, start, length int) []byte {
+	res := make([]byte, 0, length)
+	for addr := start; addr < start+length && addr < v.Size(); addr++ {
+		res = append(res, v.Peek(addr))
+	}
+	return res
+}
+
+// ParseSearchPattern 解析搜索内容: 带引号的是文本，例如 "SMB"，否则按十六进制字节解析，例如 A9 10
+func ParseSearchPattern(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return []byte(s[1 : len(s)-1]), nil
+	}
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "$", ""), " ", "")
+	res, err := hex.DecodeString(s)
+	if err != nil || len(res) == 0 {
+		return nil, fmt.Errorf("bad search pattern %q", s)
+	}
+	return res, nil
+}
+
+// Find 从start开始查找pattern，找到末尾后从头继续，返回找到的地址
+func Find(v MemoryView, pattern []byte, start int) (int, bool) {
+	size := v.Size()
+	for i := 0; i < size; i++ {
+		addr := (start + i) % size
+		if addr+len(pattern) > size {
+			continue
+		}
+		match := true
+		for j, b := range pattern {
+			if v.Peek(addr+j) != b {
+				match = false
+				break
+			}
+		}
+		if match {
+			return addr, true
+		}
+	}
+	return 0, false
+}
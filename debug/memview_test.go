@@ -0,0 +1,58 @@
+package debug
+
+import (
+	"fc-emulator/cpu"
+	"fc-emulator/memo"
+	"fc-emulator/pad"
+	"fc-emulator/ppu"
+	"fc-emulator/rom"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMemoryViews(t *testing.T) {
+	prog, err := cpu.Assemble("LDA #$10; STA $0200; .byte $53, $4D, $42")
+	require.NoError(t, err)
+	image, err := cpu.BuildNesImage(prog)
+	require.NoError(t, err)
+	nesRom, err := rom.ParseBytes(image)
+	require.NoError(t, err)
+	p := ppu.NewPPU(nesRom).(*ppu.PPUImpl)
+	m := memo.NewMemo(nesRom, p, pad.NewPad(), pad.NewPad())
+
+	view := NewCPUView(m)
+	require.True(t, view.Poke(0x0801, 0x42)) // RAM mirror
+	require.Equal(t, byte(0x42), view.Peek(0x0001))
+	require.True(t, view.Poke(0x8000, 0xEA))
+	require.Equal(t, byte(0xEA), view.Peek(0x8000))
+	require.True(t, view.Poke(0x8000, 0xA9))
+	require.False(t, view.Poke(0x5000, 0x12)) // 没有接东西
+
+	// Peek不能清除vblank标志
+	view.Peek(0x2002)
+	require.Equal(t, byte(0x80), m.Read(0x2002)&0x80)
+
+	pattern, err := ParseSearchPattern(`"SMB"`)
+	require.NoError(t, err)
+	addr, ok := Find(view, pattern, 0x8000)
+	require.True(t, ok)
+	require.Equal(t, 0x8005, addr)
+	pattern, err = ParseSearchPattern("A9 10")
+	require.NoError(t, err)
+	addr, ok = Find(view, pattern, 0x8001) // 从后面开始搜索，绕回到开头
+	require.True(t, ok)
+	require.Equal(t, 0x8000, addr)
+	_, err = ParseSearchPattern("zz")
+	require.Error(t, err)
+
+	palette := NewPaletteView(p)
+	palette.Poke(1, 0xFF)
+	require.Equal(t, byte(0x3F), p.Memo.Data[0x3F01])
+	palette.Poke(0x10, 0x21) // 精灵调色板0的背景色就是$3F00
+	require.Equal(t, byte(0x21), p.Memo.Data[0x3F00])
+	require.Equal(t, byte(0x21), palette.Peek(0x10))
+	require.Equal(t, byte(0x21), NewPPUView(p).Peek(0x3F30))
+	oam := NewOAMView(p)
+	oam.Poke(4, 9)
+	require.Equal(t, []byte{0, 9}, Snapshot(oam, 3, 2))
+}
@@ -0,0 +1,145 @@
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Handler 提供调试器的HTTP JSON接口，方便脚本或者其他前端远程控制模拟器
+//
+//	GET    /state
+//	POST   /pause  /continue  /step/into  /step/over  /step/out  /run/nmi
+//	POST   /run/scanline?line=241
+//	GET    /breakpoints
+//	POST   /breakpoints?addr=$C000&cond=A==#$FF
+//	GET    /watchpoints
+//	POST   /watchpoints?space=cpu&start=$0300&end=$0300&kind=rw&cond=VAL>3
+//	DELETE /breakpoints?id=1  (或者 /watchpoints?id=1)
+func (d *Debugger) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.State())
+	})
+	commands := map[string]func() error{
+		"/pause":     func() error { d.Pause(); return nil },
+		"/continue":  d.Continue,
+		"/step/into": d.StepInto,
+		"/step/over": d.StepOver,
+		"/step/out":  d.StepOut,
+		"/run/nmi":   d.RunToNMI,
+	}
+	for path, fn := range commands {
+		fn := fn
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if err := fn(); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			writeJSON(w, d.State())
+		})
+	}
+	mux.HandleFunc("/run/scanline", func(w http.ResponseWriter, r *http.Request) {
+		line, err := strconv.Atoi(r.URL.Query().Get("line"))
+		if err == nil {
+			err = d.RunToScanline(line)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, d.State())
+	})
+	mux.HandleFunc("/breakpoints", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, d.Breakpoints())
+		case http.MethodPost:
+			addr, err := ParseNumber(q.Get("addr"))
+			if err != nil {
+				http.Error(w, "bad addr", http.StatusBadRequest)
+				return
+			}
+			bp, err := d.AddBreakpoint(uint16(addr), q.Get("cond"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, bp)
+		case http.MethodDelete:
+			d.handleRemove(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/watchpoints", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, d.Watchpoints())
+		case http.MethodPost:
+			space := CPUSpace
+			if q.Get("space") == "ppu" {
+				space = PPUSpace
+			}
+			start, err1 := ParseNumber(q.Get("start"))
+			end, err2 := ParseNumber(q.Get("end"))
+			if q.Get("end") == "" {
+				end, err2 = start, nil
+			}
+			kind, err3 := ParseAccessKind(q.Get("kind"))
+			for _, err := range []error{err1, err2, err3} {
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			wp, err := d.AddWatchpoint(space, uint16(start), uint16(end), kind, q.Get("cond"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, wp)
+		case http.MethodDelete:
+			d.handleRemove(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+func (d *Debugger) handleRemove(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || !d.Remove(id) {
+		http.Error(w, "no such id", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (c *Condition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (s Space) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (k AccessKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (r PauseReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
@@ -0,0 +1,115 @@
+package emu
+
+import (
+	"fc-emulator/fds"
+	"fc-emulator/rom"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+)
+
+// DefaultFdsBios 没有指定EmuOpt.FdsBios时，在磁盘镜像所在的目录中查找BIOS
+const DefaultFdsBios = "disksys.rom"
+
+// DiskSaveFile 磁盘的写入保存在这个文件中，内容是相对原始镜像的IPS补丁，原始镜像永远不会被修改
+func DiskSaveFile(fileName string) string {
+	return fileName + ".sav"
+}
+
+// LoadDisk 加载FDS磁盘镜像，data是原始镜像的内容，存在存档时先应用存档
+func (e *Emu) LoadDisk(fileName string, data []byte) error {
+	biosFile := e.Opt.FdsBios
+	if biosFile == "" {
+		biosFile = filepath.Join(filepath.Dir(fileName), DefaultFdsBios)
+	}
+	bios, err := ioutil.ReadFile(biosFile)
+	if err != nil {
+		return fmt.Errorf("load fds bios: %w", err)
+	}
+	diskData := data
+	saveFile := DiskSaveFile(fileName)
+	if save, err := ioutil.ReadFile(saveFile); err == nil {
+		if diskData, err = rom.ApplyPatch(data, save); err != nil {
+			return fmt.Errorf("apply disk save %s: %w", saveFile, err)
+		}
+	}
+	img, err := fds.Parse(diskData)
+	if err != nil {
+		return err
+	}
+	adapter, err := fds.NewAdapter(bios, img)
+	if err != nil {
+		return err
+	}
+	e.RomFileName = filepath.Base(fileName)
+	e.diskFile = fileName
+	e.diskData = data
+	e.Disk = adapter
+	e.attach(diskRom(data), adapter)
+	return nil
+}
+
+// diskRom FDS没有PRG ROM和CHR ROM，PrgRom中放原始的磁盘镜像，这样ROM的Hash和录像的校验和才能区分游戏
+func diskRom(data []byte) *rom.NesRom {
+	// FDS在iNES中是mapper 20
+	header := []byte{'N', 'E', 'S', 0x1A, 0, 0, 0x40, 0x10, 0, 0, 0, 0, 0, 0, 0, 0}
+	return &rom.NesRom{
+		Header:       rom.NewHeader(header),
+		PrgRom:       data,
+		MapperNumber: 20,
+		CRC32:        crc32.ChecksumIEEE(data),
+	}
+}
+
+// InsertDisk 在下一帧开始时换到第side面，side为fds.NoDisk时拔出磁盘
+func (e *Emu) InsertDisk(side int) error {
+	if e.Disk == nil {
+		return fmt.Errorf("%s is not a disk", e.RomFileName)
+	}
+	if side != fds.NoDisk && (side < 0 || side >= e.Disk.Sides()) {
+		return fmt.Errorf("side %d out of range, disk has %d sides", side, e.Disk.Sides())
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.diskChange = true
+	e.nextSide = side
+	return nil
+}
+
+// clockDisk 让RAM适配器跟上CPU的周期，并且把它的IRQ交给CPU
+func (e *Emu) clockDisk() {
+	cycles := e.CPU.Cycles()
+	e.Disk.Clock(int(cycles - e.diskCycles))
+	e.diskCycles = cycles
+	if e.Disk.IRQ() {
+		e.CPU.ExecIRQ()
+	}
+}
+
+// diskBeginFrame 在帧开始时换磁盘，磁盘写完后(马达停止)保存存档
+func (e *Emu) diskBeginFrame() {
+	if e.diskChange {
+		e.diskChange = false
+		if e.nextSide == fds.NoDisk {
+			e.Disk.Eject()
+		} else {
+			_ = e.Disk.Insert(e.nextSide)
+		}
+	}
+	if e.Disk.Dirty() && !e.Disk.MotorOn() {
+		if err := e.saveDisk(); err != nil {
+			log.Println("save disk fail: ", err)
+		}
+	}
+}
+
+func (e *Emu) saveDisk() error {
+	e.Disk.ClearDirty()
+	if e.diskFile == "" {
+		return nil
+	}
+	patch := rom.CreateIPS(e.diskData, e.Disk.Image().Bytes())
+	return ioutil.WriteFile(DiskSaveFile(e.diskFile), patch, 0644)
+}
@@ -1,28 +1,93 @@
 package emu
 
 import (
+	"errors"
+	"fc-emulator/apu"
+	"fc-emulator/cheat"
 	"fc-emulator/cpu"
+	"fc-emulator/debug"
+	"fc-emulator/fds"
 	"fc-emulator/memo"
+	"fc-emulator/movie"
 	"fc-emulator/pad"
 	"fc-emulator/ppu"
 	"fc-emulator/rom"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
 type Emu struct {
+	tapeClock     uint64 // 录音机看到的CPU周期，用atomic访问，放在开头保证32位平台上对齐
 	CPU           *cpu.CPU
 	PPU           ppu.PPU
 	Opt           *EmuOpt
 	Rom           *rom.NesRom
-	Pad1          pad.Pad
-	Pad2          pad.Pad
+	Memo          memo.Memo       // CPU的地址空间，不经过调试器
+	Pad1          *pad.LatchedPad // 键盘输入在每帧开始时才交给手柄
+	Pad2          *pad.LatchedPad
+	Pad3          *pad.LatchedPad // 只有接了四人适配器时才会被读到
+	Pad4          *pad.LatchedPad
+	Zapper        *pad.Zapper       // 2号口插了光枪时不为nil，代替Pad2
+	Arkanoid      *pad.Arkanoid     // 2号口或扩展口插了Arkanoid手柄时不为nil
+	PowerPad      *pad.PowerPad     // 2号口插了Power Pad或扩展口插了Family Trainer时不为nil
+	Keyboard      *pad.Keyboard     // 扩展口插了Family BASIC键盘时不为nil
+	Tape          *pad.DataRecorder // 接在键盘上的录音机
+	Debugger      *debug.Debugger
+	Cheats        *cheat.Engine
+	Disk          *fds.Adapter // 加载FDS磁盘时不为nil
+	APU           *apu.APU     // 每帧的声音写到AudioSink，录像时还写到WAV文件
+	AudioSink     apu.Sink     // 不为nil时播放声音，例如apu.CommandSink，出错后不再使用
 	FrameCallback func()
+	RomFileName   string
+
+	mu        sync.Mutex
+	frame     uint64        // 上电以来的帧数
+	commands  movie.Command // 下一帧开始时要执行的命令
+	powerOn   bool          // 开始录制或回放之前先上电，不记录到录像中
+	recording *movie.Movie
+	player    *movie.Player
+
+	diskFile   string
+	diskData   []byte // 原始的磁盘镜像，存档是相对它的补丁
+	diskCycles uint64 // RAM适配器已经走过的CPU周期
+	diskChange bool
+	nextSide   int
+
+	apuFrames uint64 // 上电以来APU运行的帧数
+	apuCycles int64  // 上电以来APU运行的CPU周期
+	video     *videoRecorder
+	videoErr  error // 录像时出的错，录像已经停止，StopVideo时返回
 }
 
 type EmuOpt struct {
-	Debug bool
+	Debug          bool
+	RamPolicy      RamPolicy         // 上电时RAM和显存的内容
+	Seed           int64             // RamRandom的随机数种子，为0时每次上电都不一样
+	Patches        []string          // 加载ROM时依次应用的补丁，为空时自动查找和ROM同名的补丁
+	FdsBios        string            // FDS的BIOS文件，为空时使用磁盘镜像所在目录中的disksys.rom
+	Port2          Device            // 2号口插的设备
+	Expansion      Expansion         // 红白机扩展口插的设备，使用四人适配器时忽略
+	FourPlayer     FourPlayerMode    // 四人适配器
+	PowerPadLayout map[string]int    // 跳舞毯的按键布局，为nil时使用pad.DefaultPowerPadLayout
+	KeyboardLayout map[string]string // 覆盖pad.DefaultKeyboardLayout中的按键
+	Screenshot     ScreenshotOpt     // SaveScreenshot的选项
+	Video          ScreenshotOpt     // StartVideo录像的画面处理
 }
 
+// instructionsPerFrame 每帧执行的指令数
+const instructionsPerFrame = 1000
+
+// cpuCyclesPerFrame NTSC每帧的CPU周期数。
+//
+// 每帧执行固定数量的指令，PPU和APU都不看CPU.Cycles()，而是按帧内的进度(已经执行的指令/每帧的指令)运行:
+// APU在一帧里正好运行cpuCyclesPerFrame个周期，所以帧计数器、长度计数器、帧IRQ和产生的声音都和帧率一致，
+// 但是和CPU实际执行的周期不一致，在一帧之内用CPU周期计时去等$4015变化的程序看到的时间会不一样。
+// FDS的RAM适配器是按CPU周期运行的，见clockDisk。
+const cpuCyclesPerFrame = 29780.5
+
 func NewEmu(opt *EmuOpt) *Emu {
 	if opt == nil {
 		opt = &EmuOpt{Debug: false}
@@ -30,45 +95,271 @@ func NewEmu(opt *EmuOpt) *Emu {
 	return &Emu{Opt: opt}
 }
 
+// Load 加载ROM或者FDS磁盘镜像
 func (e *Emu) Load(fileName string) error {
-	nesRom, err := rom.LoadNesRom(fileName)
-	e.Rom = nesRom
-	_ppu := ppu.NewPPU(nesRom)
+	data, err := rom.ReadRomFile(fileName)
+	if err != nil {
+		return err
+	}
+	if fds.IsImage(data) {
+		return e.LoadDisk(fileName, data)
+	}
+	nesRom, err := rom.LoadNesRom(fileName, e.Opt.Patches...)
 	if err != nil {
 		return err
 	}
+	e.RomFileName = filepath.Base(fileName)
+	return e.LoadRom(nesRom)
+}
+
+func (e *Emu) LoadRom(nesRom *rom.NesRom) error {
+	e.Disk = nil
+	e.attach(nesRom, nil)
+	return nil
+}
+
+// attach 创建各个部件并上电，cart不为nil时$4020以后的地址交给cart
+func (e *Emu) attach(nesRom *rom.NesRom, cart memo.Cartridge) {
+	e.Rom = nesRom
+	_ppu := ppu.NewPPU(nesRom)
 	e.PPU = _ppu
-	pad1 := pad.NewPad()
-	pad2 := pad.NewPad()
-	cpuMemo := memo.NewMemo(nesRom, _ppu, pad1, pad2)
-	c := cpu.NewCPU(cpuMemo, e.Opt.Debug)
-	c.Reset()
+	var cpuMemo *memo.DefaultMemo
+	if cart != nil {
+		cpuMemo = memo.NewMemoWithCartridge(cart, _ppu, nil, nil)
+	} else {
+		cpuMemo = memo.NewMemo(nesRom, _ppu, nil, nil).(*memo.DefaultMemo)
+	}
+	e.attachInput(cpuMemo, _ppu.(*ppu.PPUImpl))
+	// DMC的采样和CPU一样从卡带读，会经过金手指
+	e.APU = apu.New(cpuMemo.Read)
+	cpuMemo.APU = e.APU
+	if e.Disk != nil {
+		e.APU.AddExpansion(&e.Disk.Audio)
+	}
+	cheats := cheat.NewEngine()
+	cpuMemo.Patcher = cheats
+	debugger := debug.NewDebugger(cpuMemo)
+	c := cpu.NewCPU(debugger.Memo, e.Opt.Debug)
+	debugger.AttachCPU(c)
+	debugger.AttachPPU(_ppu.(*ppu.PPUImpl))
 	e.CPU = c
-	e.Pad1 = pad1
-	e.Pad2 = pad2
-	return nil
+	e.Debugger = debugger
+	e.Memo = cpuMemo
+	e.Cheats = cheats
+	e.diskCycles = c.Cycles()
+	e.doPowerOn()
 }
 
 func (e *Emu) Start() {
-	cnt := 0
 	for {
-		e.PPU.EnterVblank()
-		if e.PPU.CanInterrupt() {
-			e.CPU.ExecNMI()
-		}
-		for i := 0; i < 1000; i++ {
-			_, err := e.CPU.ExecuteOneInstruction()
-			if err != nil {
-				panic(err)
-			}
-			cnt += 1
-			//if cnt%1000 == 0 {
-			//	//fmt.Println("Count ", cnt)
-			//}
-		}
+		e.RunFrame()
 		if e.FrameCallback != nil {
 			e.FrameCallback()
 		}
-		time.Sleep(30 * time.Millisecond)
+		if e.AudioSink == nil { // 播放声音时写入会等声卡，不用再等
+			time.Sleep(30 * time.Millisecond)
+		}
+	}
+}
+
+// RunFrame 运行一帧: 先处理输入和命令，然后进入vblank，再执行一帧的指令，最后录像
+func (e *Emu) RunFrame() {
+	e.beginFrame()
+	if e.Tape != nil {
+		e.syncTapeClock()
+	}
+	e.PPU.EnterVblank()
+	e.Debugger.EnterVblank()
+	if e.PPU.CanInterrupt() {
+		e.CPU.ExecNMI()
+		e.Debugger.OnNMI()
+	}
+	for i := 0; i < instructionsPerFrame; i++ {
+		err := e.Debugger.Execute()
+		if err != nil {
+			panic(err)
+		}
+		e.PPU.SetFrameProgress(float64(i+1) / instructionsPerFrame)
+		e.clockAPU(float64(i+1) / instructionsPerFrame)
+		if e.Disk != nil {
+			e.clockDisk()
+		}
+		if e.Tape != nil {
+			e.syncTapeClock()
+		}
+	}
+	e.apuFrames++
+	samples := e.APU.Samples()
+	e.recordVideo(samples)
+	e.playAudio(samples)
+}
+
+// playAudio 把这一帧的声音交给AudioSink，出错时关掉声音，模拟继续运行
+func (e *Emu) playAudio(samples []float32) {
+	if e.AudioSink == nil {
+		return
+	}
+	if err := e.AudioSink.WriteSamples(samples); err != nil {
+		log.Println("play audio fail: ", err)
+		e.AudioSink = nil
+	}
+}
+
+// clockAPU 让APU跟上这一帧的进度，并且把它的IRQ(帧计数器、DMC)交给CPU
+func (e *Emu) clockAPU(progress float64) {
+	target := int64((float64(e.apuFrames) + progress) * cpuCyclesPerFrame)
+	e.APU.Clock(int(target - e.apuCycles))
+	e.apuCycles = target
+	if e.APU.IRQ() {
+		e.CPU.ExecIRQ()
+	}
+}
+
+// beginFrame 在每帧开始时锁存手柄输入，执行复位命令，并且录制或回放录像
+func (e *Emu) beginFrame() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.powerOn {
+		e.powerOn = false
+		e.doPowerOn()
+	}
+	commands := e.commands
+	e.commands = 0
+	var frame movie.Frame
+	if e.player != nil {
+		next, ok := e.player.Next()
+		if ok {
+			frame = next
+			commands = frame.Commands
+			// 没有Four Score的录像里手柄3、4是0，回放时没有输入
+			e.Pad1.Set(frame.Pad1)
+			e.Pad2.Set(frame.Pad2)
+			e.Pad3.Set(frame.Pad3)
+			e.Pad4.Set(frame.Pad4)
+		} else {
+			e.stopPlayback()
+		}
+	}
+	if e.player == nil {
+		frame.Pad1, frame.Pad2 = e.Pad1.Latch(), e.Pad2.Latch()
+		frame.Pad3, frame.Pad4 = e.Pad3.Latch(), e.Pad4.Latch()
+	}
+	if commands&movie.PowerOn != 0 {
+		e.doPowerOn()
+	} else if commands&movie.SoftReset != 0 {
+		e.CPU.Reset()
+	}
+	if e.Disk != nil {
+		e.diskBeginFrame()
+	}
+	if e.Zapper != nil {
+		e.Zapper.NewFrame()
+	}
+	e.Cheats.Freeze(e.Memo)
+	e.frame++
+	if e.recording != nil {
+		frame.Commands = commands
+		if !e.recording.FourScore {
+			frame.Pad3, frame.Pad4 = 0, 0
+		}
+		e.recording.Frames = append(e.recording.Frames, frame)
+	}
+}
+
+// Reset 在下一帧开始时按下复位键
+func (e *Emu) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.commands |= movie.SoftReset
+}
+
+// PowerCycle 在下一帧开始时重新上电
+func (e *Emu) PowerCycle() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.commands |= movie.PowerOn
+}
+
+// movieFourScore 检查输入设备能不能用FM2录像记录: 只支持两个标准手柄，或者Four Score上的四个手柄
+func (e *Emu) movieFourScore() (bool, error) {
+	if e.Opt.Port2 != DevicePad {
+		return false, fmt.Errorf("fm2 movie does not support %s on port 2", e.Opt.Port2)
+	}
+	switch e.Opt.FourPlayer {
+	case FourPlayerNES:
+		return true, nil
+	case FourPlayerFamicom:
+		return false, errors.New("fm2 movie does not support the famicom four player adapter")
+	}
+	if e.Opt.Expansion != ExpansionNone {
+		return false, fmt.Errorf("fm2 movie does not support %s on the expansion port", e.Opt.Expansion)
+	}
+	return false, nil
+}
+
+// StartRecording 从上电开始录制录像，之后每一帧的输入和复位命令都会被记录下来
+func (e *Emu) StartRecording() error {
+	fourScore, err := e.movieFourScore()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopPlayback()
+	e.recording = movie.New(e.Rom, e.RomFileName)
+	e.recording.FourScore = fourScore
+	e.powerOn = true
+	e.commands = 0
+	return nil
+}
+
+// StopRecording 停止录制并返回录好的录像，没有在录制时返回nil
+func (e *Emu) StopRecording() *movie.Movie {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	m := e.recording
+	e.recording = nil
+	return m
+}
+
+// PlayMovie 从上电开始回放录像，回放期间忽略键盘输入，回放结束后恢复
+func (e *Emu) PlayMovie(m *movie.Movie) error {
+	if checksum := movie.RomChecksum(e.Rom); m.RomChecksum != "" && m.RomChecksum != checksum {
+		return fmt.Errorf("movie was recorded with rom %s (%s), current rom is %s",
+			m.RomFilename, m.RomChecksum, checksum)
+	}
+	fourScore, err := e.movieFourScore()
+	if err != nil {
+		return err
+	}
+	if m.FourScore != fourScore {
+		return fmt.Errorf("movie fourscore is %v, but four player mode is %s", m.FourScore, e.Opt.FourPlayer)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recording = nil
+	e.player = movie.NewPlayer(m)
+	for _, p := range e.Pads() {
+		p.SetLocked(true)
+	}
+	e.powerOn = true
+	e.commands = 0
+	return nil
+}
+
+// IsPlaying 是否正在回放录像
+func (e *Emu) IsPlaying() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.player != nil
+}
+
+func (e *Emu) stopPlayback() {
+	if e.player == nil {
+		return
+	}
+	e.player = nil
+	for _, p := range e.Pads() {
+		p.SetLocked(false)
 	}
 }
@@ -1,7 +1,23 @@
 package emu
 
 import (
+	"bytes"
+	"errors"
+	"fc-emulator/apu"
+	"fc-emulator/cheat"
+	"fc-emulator/cpu"
+	"fc-emulator/fds"
+	"fc-emulator/memo"
+	"fc-emulator/movie"
+	"fc-emulator/pad"
+	"fc-emulator/rom"
 	"fmt"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -9,3 +25,428 @@ func TestA(t *testing.T) {
 	a := []byte{}
 	fmt.Println(a[100:1000])
 }
+
+// padProgram 每次NMI读取手柄1，把按键累加到$11，帧数记录在$12
+const padProgram = `
+	.org $8000
+reset:
+	LDA #$80
+	STA $2000
+loop:
+	JMP loop
+nmi:
+	LDA #1
+	STA $4016
+	LDA #0
+	STA $4016
+	LDX #8
+read:
+	LDA $4016
+	LSR A
+	ROL $10
+	DEX
+	BNE read
+	LDA $10
+	CLC
+	ADC $11
+	STA $11
+	INC $12
+	RTI
+	.org $FFFA
+	.word nmi, reset, reset
+`
+
+// irqProgram 打开APU的帧中断，每次IRQ把$13加1
+const irqProgram = `
+	.org $8000
+reset:
+	LDA #$00
+	STA $4017
+	CLI
+loop:
+	JMP loop
+irq:
+	INC $13
+	LDA $4015
+	RTI
+	.org $FFFA
+	.word reset, reset, irq
+`
+
+func loadTestEmu(t *testing.T, src string) *Emu {
+	return loadTestEmuOpt(t, src, nil)
+}
+
+func loadTestEmuOpt(t *testing.T, src string, opt *EmuOpt) *Emu {
+	prog, err := cpu.Assemble(src)
+	require.NoError(t, err)
+	image, err := cpu.BuildNesImage(prog)
+	require.NoError(t, err)
+	nesRom, err := rom.ParseBytes(image)
+	require.NoError(t, err)
+	e := NewEmu(opt)
+	require.NoError(t, e.LoadRom(nesRom))
+	return e
+}
+
+func ram(e *Emu) []byte {
+	res := make([]byte, 0, 3)
+	for addr := uint16(0x10); addr <= 0x12; addr++ {
+		res = append(res, e.Memo.Peek(addr))
+	}
+	return res
+}
+
+func TestMovieRecordAndPlay(t *testing.T) {
+	e := loadTestEmu(t, padProgram)
+	for i := 0; i < 5; i++ { // 录制之前的状态不应该影响录像
+		e.Pad1.UpdateButton(pad.BUTTON_START, true)
+		e.RunFrame()
+	}
+	require.NoError(t, e.StartRecording())
+	for i := 0; i < 60; i++ {
+		e.Pad1.UpdateButton(pad.Buttons[i%8], i%3 != 0)
+		if i == 30 {
+			e.Reset()
+		}
+		e.RunFrame()
+	}
+	recorded := ram(e)
+	m := e.StopRecording()
+	require.Len(t, m.Frames, 60)
+	require.Equal(t, movie.SoftReset, m.Frames[30].Commands)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, m.Write(buf))
+	m, err := movie.Parse(buf)
+	require.NoError(t, err)
+
+	require.NoError(t, e.PlayMovie(m))
+	for i := 0; i < 60; i++ {
+		require.True(t, e.IsPlaying())
+		e.Pad1.UpdateButton(pad.BUTTON_A, true) // 回放时忽略键盘
+		e.RunFrame()
+	}
+	require.Equal(t, recorded, ram(e))
+	e.RunFrame()
+	require.False(t, e.IsPlaying())
+
+	other := loadTestEmu(t, "NOP")
+	require.Error(t, other.PlayMovie(m))
+}
+
+func TestMovieFourScore(t *testing.T) {
+	e := loadTestEmuOpt(t, padProgram, &EmuOpt{FourPlayer: FourPlayerNES})
+	require.NoError(t, e.StartRecording())
+	for i := 0; i < 10; i++ {
+		e.Pad3.UpdateButton(pad.BUTTON_A, i%2 == 0)
+		e.Pad4.UpdateButton(pad.BUTTON_B, true)
+		e.RunFrame()
+	}
+	m := e.StopRecording()
+	require.True(t, m.FourScore)
+	require.Equal(t, movie.Frame{Pad3: 0x01, Pad4: 0x02}, m.Frames[0])
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, m.Write(buf))
+	require.Contains(t, buf.String(), "fourscore 1\n")
+	again, err := movie.Parse(buf)
+	require.NoError(t, err)
+	require.Equal(t, m.Frames, again.Frames)
+
+	require.NoError(t, e.PlayMovie(again))
+	e.RunFrame()
+	require.Equal(t, byte(0x01), e.Pad3.State())
+	require.Equal(t, byte(0x02), e.Pad4.State())
+
+	// 没有Four Score时不能回放四个手柄的录像
+	require.Error(t, loadTestEmu(t, padProgram).PlayMovie(again))
+	for _, opt := range []*EmuOpt{
+		{Port2: DeviceZapper},
+		{Port2: DeviceArkanoid, FourPlayer: FourPlayerNES},
+		{FourPlayer: FourPlayerFamicom},
+		{Expansion: ExpansionKeyboard},
+	} {
+		require.Error(t, loadTestEmuOpt(t, padProgram, opt).StartRecording())
+	}
+	require.NoError(t, loadTestEmuOpt(t, padProgram, &EmuOpt{Expansion: ExpansionKeyboard, FourPlayer: FourPlayerNES}).StartRecording())
+}
+
+func runWithInput(t *testing.T, opt *EmuOpt, frames int) *Emu {
+	e := loadTestEmuOpt(t, padProgram, opt)
+	for i := 0; i < frames; i++ {
+		e.Pad1.UpdateButton(pad.Buttons[i%8], i%5 < 2)
+		e.RunFrame()
+	}
+	return e
+}
+
+func TestDeterministicRun(t *testing.T) {
+	opt := &EmuOpt{RamPolicy: RamRandom, Seed: 42}
+	a := runWithInput(t, opt, 120)
+	b := runWithInput(t, opt, 120)
+	require.Equal(t, a.StateHash(), b.StateHash())
+
+	c := runWithInput(t, &EmuOpt{RamPolicy: RamRandom, Seed: 43}, 120)
+	require.NotEqual(t, a.StateHash(), c.StateHash())
+	d := runWithInput(t, opt, 121)
+	require.NotEqual(t, a.StateHash(), d.StateHash())
+
+	// 只有APU或者输入设备内部的状态不一样时也能发现
+	apuOnly := runWithInput(t, opt, 120)
+	apuOnly.APU.Write(0x4008, 0x7F)
+	require.NotEqual(t, a.StateHash(), apuOnly.StateHash())
+	vausOpt := &EmuOpt{RamPolicy: RamRandom, Seed: 42, Port2: DeviceArkanoid}
+	vaus := runWithInput(t, vausOpt, 120)
+	require.Equal(t, vaus.StateHash(), runWithInput(t, vausOpt, 120).StateHash())
+	vaus.Arkanoid.Move(1)
+	require.NotEqual(t, runWithInput(t, vausOpt, 120).StateHash(), vaus.StateHash())
+}
+
+func TestRamPolicy(t *testing.T) {
+	e := loadTestEmuOpt(t, "NOP", &EmuOpt{RamPolicy: RamFF})
+	require.Equal(t, byte(0xFF), e.Memo.Peek(0x0123))
+	e = loadTestEmuOpt(t, "NOP", &EmuOpt{RamPolicy: RamPattern})
+	require.Equal(t, []byte{0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}, e.Memo.(*memo.DefaultMemo).Ram[:8])
+
+	policy, err := ParseRamPolicy("random")
+	require.NoError(t, err)
+	require.Equal(t, RamRandom, policy)
+	_, err = ParseRamPolicy("noise")
+	require.Error(t, err)
+}
+
+func TestCheats(t *testing.T) {
+	// 每帧把$8100处的值加到$20，$30由PAR锁定
+	e := loadTestEmu(t, `
+	.org $8000
+reset:
+	LDA #$80
+	STA $2000
+loop:
+	JMP loop
+nmi:
+	LDA $8100
+	STA $20
+	INC $30
+	RTI
+	.org $8100
+	.byte $01
+	.org $FFFA
+	.word nmi, reset, reset
+`)
+	gg := &cheat.Cheat{Kind: cheat.GameGenie, Addr: 0x8100, Value: 0x42, Enabled: true}
+	par, err := cheat.Decode("0030:07")
+	require.NoError(t, err)
+	e.Cheats.Set([]*cheat.Cheat{gg, par})
+	e.RunFrame()
+	e.RunFrame()
+	require.Equal(t, byte(0x42), e.Memo.Peek(0x20))
+	require.Equal(t, byte(0x08), e.Memo.Peek(0x30)) // 帧开始时锁定为7，NMI里加了1
+}
+
+// diskBios 假的FDS BIOS: 写PRG-RAM，打开重复的IRQ计时器，IRQ里把$10加1
+const diskBios = `
+	.org $E000
+reset:
+	LDA #$01
+	STA $4023
+	LDA #$40
+	STA $4020
+	LDA #$00
+	STA $4021
+	LDA #$03
+	STA $4022
+	LDA #$5A
+	STA $6000
+	CLI
+loop:
+	JMP loop
+irq:
+	LDA $4030
+	INC $10
+	RTI
+	.org $FFFA
+	.word reset, reset, irq
+`
+
+func TestLoadDisk(t *testing.T) {
+	prog, err := cpu.Assemble(diskBios)
+	require.NoError(t, err)
+	bios := make([]byte, fds.BiosSize)
+	copy(bios, prog.Code)
+	side := make([]byte, fds.SideSize)
+	copy(side, "\x01*NINTENDO-HVC*")
+	image := append(append([]byte{}, side...), side...)
+
+	dir := t.TempDir()
+	diskFile := filepath.Join(dir, "game.fds")
+	require.NoError(t, ioutil.WriteFile(diskFile, image, 0644))
+	e := NewEmu(nil)
+	require.Error(t, e.Load(diskFile)) // 没有BIOS
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, DefaultFdsBios), bios, 0644))
+
+	// 存档是相对原始镜像的补丁
+	saved := append([]byte{}, image...)
+	copy(saved[fds.SideSize+16:], "SAV")
+	require.NoError(t, ioutil.WriteFile(DiskSaveFile(diskFile), rom.CreateIPS(image, saved), 0644))
+	require.NoError(t, e.Load(diskFile))
+	require.NotNil(t, e.Disk)
+	require.Equal(t, 20, e.Rom.MapperNumber)
+	require.Equal(t, saved, e.Disk.Image().Bytes())
+	require.Equal(t, []apu.Expansion{&e.Disk.Audio}, e.APU.Expansions) // 波表通道和APU一起混音
+
+	for i := 0; i < 10; i++ {
+		e.RunFrame()
+	}
+	require.Equal(t, byte(0x5A), e.Memo.Peek(0x6000))
+	require.NotZero(t, e.Memo.Peek(0x10))
+
+	require.NoError(t, e.InsertDisk(1))
+	require.Error(t, e.InsertDisk(2))
+	e.RunFrame()
+	require.Equal(t, fds.NoDisk, e.Disk.Disk())
+
+	data, err := ioutil.ReadFile(diskFile)
+	require.NoError(t, err)
+	require.Equal(t, image, data)
+}
+
+func TestAPUIRQ(t *testing.T) {
+	e := loadTestEmu(t, irqProgram)
+	frames := 30
+	for i := 0; i < frames; i++ {
+		e.RunFrame()
+	}
+	// 4步模式的帧中断大约每29830个周期一次，APU每帧运行29780.5个周期
+	require.InDelta(t, frames, int(e.Memo.Peek(0x13)), 1)
+	require.Equal(t, byte(0), e.Memo.Peek(0x4015)&0x40)
+}
+
+// failingSink 写入fail次之后出错，比如播放程序退出了
+type failingSink struct {
+	samples []int
+	fail    int
+}
+
+func (s *failingSink) WriteSamples(samples []float32) error {
+	if len(s.samples) == s.fail {
+		return errors.New("broken pipe")
+	}
+	s.samples = append(s.samples, len(samples))
+	return nil
+}
+
+func TestAudioSink(t *testing.T) {
+	e := loadTestEmu(t, irqProgram)
+	sink := &failingSink{fail: 2}
+	e.AudioSink = sink
+	for i := 0; i < 3; i++ {
+		e.RunFrame()
+	}
+	require.Len(t, sink.samples, 2)
+	require.InDelta(t, apu.DefaultSampleRate/60, sink.samples[0], 2)
+	require.Nil(t, e.AudioSink) // 出错之后不再使用，模拟继续运行
+}
+
+func TestScreenshot(t *testing.T) {
+	e := loadTestEmuOpt(t, padProgram, &EmuOpt{Screenshot: ScreenshotOpt{CropOverscan: true, Scale: 2}})
+	e.RomFileName = "test.nes"
+	for i := 0; i < 3; i++ {
+		e.RunFrame()
+	}
+	require.Equal(t, uint64(3), e.Frame())
+	shot := e.Screenshot()
+	require.Equal(t, image.Rect(0, 0, 256, 240), shot.Bounds())
+
+	dir, err := ioutil.TempDir("", "screenshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path, err := e.SaveScreenshot(dir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "test-000003.png"), path)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	saved, err := png.Decode(f)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 512, 448), saved.Bounds())
+	for _, p := range []image.Point{{0, 0}, {100, 50}, {511, 447}} {
+		r1, g1, b1, _ := saved.At(p.X, p.Y).RGBA()
+		r2, g2, b2, _ := shot.At(p.X/2, p.Y/2+8).RGBA()
+		require.Equal(t, []uint32{r2, g2, b2}, []uint32{r1, g1, b1})
+	}
+
+	e.PowerCycle()
+	e.RunFrame()
+	require.Equal(t, uint64(1), e.Frame())
+}
+
+func TestVideo(t *testing.T) {
+	e := loadTestEmuOpt(t, padProgram, &EmuOpt{Video: ScreenshotOpt{CropOverscan: true}})
+	e.RomFileName = "test.nes"
+	require.Equal(t, "test-000000.y4m", e.VideoName(".y4m"))
+	require.Error(t, e.StartVideo("test.avi"))
+	require.Error(t, e.StopVideo())
+
+	dir, err := ioutil.TempDir("", "video")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.y4m")
+	require.NoError(t, e.StartVideo(path))
+	require.True(t, e.IsRecordingVideo())
+	// 打开方波1，长度计数器不为0时$4015的第0位是1
+	e.Memo.Write(0x4015, 0x01)
+	e.Memo.Write(0x4000, 0xBF)
+	e.Memo.Write(0x4002, 0xFD)
+	e.Memo.Write(0x4003, 0x08)
+	require.Equal(t, byte(0x01), e.Memo.Peek(0x4015)&0x01)
+	frames := 60
+	for i := 0; i < frames; i++ {
+		e.RunFrame()
+	}
+	require.NoError(t, e.StopVideo())
+	require.False(t, e.IsRecordingVideo())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	header := "YUV4MPEG2 W256 H224 F39375000:655171 Ip A1:1 C444 XCOLORRANGE=FULL\n"
+	require.Equal(t, header, string(data[:len(header)]))
+	require.Len(t, data, len(header)+frames*(len("FRAME\n")+3*256*224))
+
+	f, err := os.Open(AudioName(path))
+	require.NoError(t, err)
+	defer f.Close()
+	samples, rate, err := apu.ReadWav(f)
+	require.NoError(t, err)
+	require.Equal(t, apu.DefaultSampleRate, rate)
+	// 60帧的声音和60帧的时间一样长
+	require.InDelta(t, float64(frames)*655171/39375000*apu.DefaultSampleRate, len(samples), 2)
+	loud := false
+	for _, s := range samples {
+		loud = loud || s > 0.1
+	}
+	require.True(t, loud)
+}
+
+func TestTapeClock(t *testing.T) {
+	e := loadTestEmuOpt(t, padProgram, &EmuOpt{Expansion: ExpansionKeyboard})
+	e.RunFrame()
+	done := make(chan struct{})
+	go func() { // 在别的goroutine里操作录音机，go test -race时不应该有数据竞争
+		defer close(done)
+		e.Tape.Record()
+		for i := 0; i < 100; i++ {
+			e.Tape.Position()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		e.RunFrame()
+	}
+	<-done
+	e.RunFrame()
+	pos, _ := e.Tape.Position()
+	require.Equal(t, pad.TapeRecording, e.Tape.State())
+	require.True(t, pos > 0)
+}
@@ -0,0 +1,156 @@
+package emu
+
+import (
+	"fc-emulator/memo"
+	"fc-emulator/pad"
+	"fc-emulator/ppu"
+	"fmt"
+)
+
+// FourPlayerMode 四人适配器的类型
+type FourPlayerMode int
+
+const (
+	FourPlayerNone    FourPlayerMode = iota // 两个口各一个手柄
+	FourPlayerNES                           // NES的Four Score
+	FourPlayerFamicom                       // 红白机扩展口上的手柄3、4
+)
+
+var fourPlayerNames = map[FourPlayerMode]string{
+	FourPlayerNone:    "none",
+	FourPlayerNES:     "nes",
+	FourPlayerFamicom: "famicom",
+}
+
+func (m FourPlayerMode) String() string {
+	return fourPlayerNames[m]
+}
+
+func ParseFourPlayerMode(s string) (FourPlayerMode, error) {
+	for mode, name := range fourPlayerNames {
+		if name == s {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown four player mode %q", s)
+}
+
+// Device 2号口上插的设备
+type Device int
+
+const (
+	DevicePad      Device = iota // 标准手柄
+	DeviceZapper                 // 光枪
+	DeviceArkanoid               // NES版Arkanoid手柄
+	DevicePowerPad               // NES版Power Pad
+)
+
+var deviceNames = map[Device]string{
+	DevicePad:      "pad",
+	DeviceZapper:   "zapper",
+	DeviceArkanoid: "arkanoid",
+	DevicePowerPad: "powerpad",
+}
+
+func (d Device) String() string {
+	return deviceNames[d]
+}
+
+func ParseDevice(s string) (Device, error) {
+	for device, name := range deviceNames {
+		if name == s {
+			return device, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown input device %q", s)
+}
+
+// Expansion 红白机扩展口上插的设备
+type Expansion int
+
+const (
+	ExpansionNone          Expansion = iota
+	ExpansionArkanoid                // 红白机版Arkanoid手柄
+	ExpansionFamilyTrainer           // Family Trainer跳舞毯
+	ExpansionKeyboard                // Family BASIC键盘和录音机
+)
+
+var expansionNames = map[Expansion]string{
+	ExpansionNone:          "none",
+	ExpansionArkanoid:      "arkanoid",
+	ExpansionFamilyTrainer: "familytrainer",
+	ExpansionKeyboard:      "keyboard",
+}
+
+func (x Expansion) String() string {
+	return expansionNames[x]
+}
+
+func ParseExpansion(s string) (Expansion, error) {
+	for expansion, name := range expansionNames {
+		if name == s {
+			return expansion, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown expansion device %q", s)
+}
+
+// attachInput 创建4个手柄，按照EmuOpt把手柄和其他设备接到$4016/$4017上
+func (e *Emu) attachInput(m *memo.DefaultMemo, p *ppu.PPUImpl) {
+	e.Pad1 = pad.NewLatchedPad(pad.NewPad())
+	e.Pad2 = pad.NewLatchedPad(pad.NewPad())
+	e.Pad3 = pad.NewLatchedPad(pad.NewPad())
+	e.Pad4 = pad.NewLatchedPad(pad.NewPad())
+	e.Zapper, e.Arkanoid, e.PowerPad, e.Keyboard, e.Tape = nil, nil, nil, nil, nil
+	pads := [4]pad.InputDevice{e.Pad1, e.Pad2, e.Pad3, e.Pad4}
+	switch e.Opt.Port2 {
+	case DeviceZapper:
+		e.Zapper = pad.NewZapper(p)
+		pads[1] = e.Zapper
+	case DeviceArkanoid:
+		e.Arkanoid = pad.NewArkanoid()
+		pads[1] = e.Arkanoid
+	case DevicePowerPad:
+		e.PowerPad = e.newPowerPad()
+		pads[1] = e.PowerPad
+	}
+	switch e.Opt.FourPlayer {
+	case FourPlayerNES:
+		m.Ports = pad.NewFourScore(pads)
+		return
+	case FourPlayerFamicom:
+		m.Ports = pad.NewFamicomFourPlayer(pads)
+		return
+	}
+	ports := pad.NewPorts(pads[0], pads[1])
+	switch e.Opt.Expansion {
+	case ExpansionArkanoid:
+		e.Arkanoid = pad.NewArkanoid()
+		ports.Expansion = e.Arkanoid
+	case ExpansionFamilyTrainer:
+		e.PowerPad = e.newPowerPad()
+		ports.Expansion = e.PowerPad
+	case ExpansionKeyboard:
+		e.Keyboard = pad.NewKeyboard()
+		for k, v := range e.Opt.KeyboardLayout {
+			e.Keyboard.Layout[k] = v
+		}
+		e.Tape = pad.NewDataRecorder(e.loadTapeClock)
+		e.Keyboard.Tape = e.Tape
+		ports.Expansion = e.Keyboard
+	}
+	m.Ports = ports
+}
+
+func (e *Emu) newPowerPad() *pad.PowerPad {
+	p := pad.NewPowerPad()
+	if e.Opt.PowerPadLayout != nil {
+		p.Layout = e.Opt.PowerPadLayout
+	}
+	return p
+}
+
+// Pads 所有的手柄，按编号排列
+func (e *Emu) Pads() []*pad.LatchedPad {
+	return []*pad.LatchedPad{e.Pad1, e.Pad2, e.Pad3, e.Pad4}
+}
@@ -0,0 +1,137 @@
+package emu
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fc-emulator/memo"
+	"fc-emulator/pad"
+	"fc-emulator/ppu"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RamPolicy 决定上电时RAM和显存的内容。真实的硬件上电时内容是不确定的，有些游戏会读到没有初始化的内存
+type RamPolicy int
+
+const (
+	RamZero    RamPolicy = iota // 全部是0
+	RamFF                       // 全部是0xFF
+	RamPattern                  // 和很多真实硬件类似: 4个0x00和4个0xFF交替
+	RamRandom                   // 随机，种子是EmuOpt.Seed
+)
+
+var ramPolicyNames = map[RamPolicy]string{
+	RamZero:    "zero",
+	RamFF:      "ff",
+	RamPattern: "pattern",
+	RamRandom:  "random",
+}
+
+func (p RamPolicy) String() string {
+	return ramPolicyNames[p]
+}
+
+func ParseRamPolicy(s string) (RamPolicy, error) {
+	for policy, name := range ramPolicyNames {
+		if name == s {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown ram policy %q", s)
+}
+
+func (p RamPolicy) fill(data []byte, rng *rand.Rand) {
+	for i := range data {
+		switch p {
+		case RamZero:
+			data[i] = 0
+		case RamFF:
+			data[i] = 0xFF
+		case RamPattern:
+			if i&4 == 0 {
+				data[i] = 0x00
+			} else {
+				data[i] = 0xFF
+			}
+		case RamRandom:
+			data[i] = byte(rng.Intn(256))
+		}
+	}
+}
+
+// doPowerOn 按照RamPolicy初始化内存，恢复PPU和CPU的上电状态。
+// 相同的种子每次上电得到的内存都一样，Seed为0时每次使用不同的种子。
+func (e *Emu) doPowerOn() {
+	seed := e.Opt.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	if m, ok := e.Memo.(*memo.DefaultMemo); ok {
+		m.PowerOn()
+		e.Opt.RamPolicy.fill(m.Ram[:], rng)
+	}
+	if p, ok := e.PPU.(*ppu.PPUImpl); ok {
+		p.PowerOn()
+		// 调色板的值是颜色的下标，不能随便填
+		e.Opt.RamPolicy.fill(p.Memo.Data[0x2000:0x3F00], rng)
+		e.Opt.RamPolicy.fill(p.OAM[:], rng)
+	}
+	if e.Disk != nil {
+		e.Disk.PowerOn()
+		e.Opt.RamPolicy.fill(e.Disk.PrgRam[:], rng)
+	}
+	for _, p := range e.Pads() {
+		p.Set(0)
+	}
+	e.APU.Reset()
+	e.apuFrames, e.apuCycles = 0, 0
+	e.frame = 0
+	e.CPU.Reset()
+}
+
+// StateHash 计算CPU、内存、PPU、APU、FDS适配器和输入设备全部状态的sha256，相同的种子和输入必须得到相同的hash
+func (e *Emu) StateHash() string {
+	h := sha256.New()
+	reg := e.CPU.Register()
+	h.Write([]byte{reg.A, reg.X, reg.Y, reg.S, reg.P, byte(reg.PC), byte(reg.PC >> 8)})
+	binary.Write(h, binary.LittleEndian, e.CPU.Cycles())
+	if m, ok := e.Memo.(*memo.DefaultMemo); ok {
+		h.Write(m.Ram[:])
+		h.Write(m.Trainer)
+		h.Write(m.PrgRom)
+	}
+	if p, ok := e.PPU.(*ppu.PPUImpl); ok {
+		p.WriteState(h)
+	}
+	e.APU.WriteState(h)
+	if e.Disk != nil {
+		e.Disk.WriteState(h)
+	}
+	for _, p := range e.Pads() {
+		p.WriteState(h)
+	}
+	if e.Zapper != nil {
+		e.Zapper.WriteState(h)
+	}
+	if e.Arkanoid != nil {
+		e.Arkanoid.WriteState(h)
+	}
+	if e.PowerPad != nil {
+		e.PowerPad.WriteState(h)
+	}
+	if e.Keyboard != nil {
+		e.Keyboard.WriteState(h)
+	}
+	if e.Tape != nil {
+		e.Tape.WriteState(h)
+	}
+	if m, ok := e.Memo.(*memo.DefaultMemo); ok {
+		if s, ok := m.Ports.(pad.StateWriter); ok { // Four Score
+			s.WriteState(h)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,65 @@
+package emu
+
+import (
+	"fc-emulator/ppu"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ScreenshotOpt 保存截图时的处理，Screenshot返回的总是原始的256x240画面
+type ScreenshotOpt struct {
+	CropOverscan bool // 去掉上下各8行
+	Scale        int  // 整数倍放大，小于等于1时不放大
+}
+
+// Frame 上电以来运行的帧数
+func (e *Emu) Frame() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.frame
+}
+
+// Screenshot 当前的256x240画面，和屏幕上显示的像素完全一样
+func (e *Emu) Screenshot() image.Image {
+	return ppu.CopyScreen(e.PPU.(*ppu.PPUImpl).Render())
+}
+
+// ScreenshotName 截图的文件名，由ROM的文件名和帧数组成，例如 balloon-000123.png
+func (e *Emu) ScreenshotName() string {
+	return e.captureName("screenshot", ".png")
+}
+
+// captureName 由ROM的文件名和帧数组成的文件名，没有ROM文件名时使用defaultName
+func (e *Emu) captureName(defaultName, ext string) string {
+	name := strings.TrimSuffix(e.RomFileName, filepath.Ext(e.RomFileName))
+	if name == "" {
+		name = defaultName
+	}
+	return fmt.Sprintf("%s-%06d%s", name, e.Frame(), ext)
+}
+
+// process 按opt裁剪和放大
+func (opt ScreenshotOpt) process(img image.Image) image.Image {
+	if opt.CropOverscan {
+		img = ppu.CropOverscan(img)
+	}
+	if opt.Scale > 1 {
+		img = ppu.ScalePixels(img, opt.Scale)
+	}
+	return img
+}
+
+// SaveScreenshot 按EmuOpt.Screenshot处理之后保存成PNG，返回保存的文件。
+// path为空或者是一个目录时，在里面按ScreenshotName生成文件名。
+func (e *Emu) SaveScreenshot(path string) (string, error) {
+	img := e.Screenshot()
+	if path == "" {
+		path = e.ScreenshotName()
+	} else if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, e.ScreenshotName())
+	}
+	return path, ppu.SavePNG(path, e.Opt.Screenshot.process(img))
+}
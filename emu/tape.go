@@ -0,0 +1,48 @@
+package emu
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+var errNoTape = errors.New("no data recorder, the family basic keyboard is not plugged in")
+
+// LoadTape 把WAV文件放进录音机
+func (e *Emu) LoadTape(fileName string) error {
+	if e.Tape == nil {
+		return errNoTape
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return e.Tape.Load(f)
+}
+
+// SaveTape 把录音机里的磁带保存成WAV文件
+func (e *Emu) SaveTape(fileName string) error {
+	if e.Tape == nil {
+		return errNoTape
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := e.Tape.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncTapeClock 每条指令之后记下CPU周期。录音机的按钮在UI的goroutine里按下，
+// 那时不能读CPU，走带的时间用这个快照，最多差一条指令
+func (e *Emu) syncTapeClock() {
+	atomic.StoreUint64(&e.tapeClock, e.CPU.Cycles())
+}
+
+func (e *Emu) loadTapeClock() uint64 {
+	return atomic.LoadUint64(&e.tapeClock)
+}
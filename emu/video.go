@@ -0,0 +1,134 @@
+package emu
+
+import (
+	"errors"
+	"fc-emulator/apu"
+	"fc-emulator/ppu"
+	"fc-emulator/video"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VideoFormats StartVideo支持的扩展名: GIF动画，APNG(.png或.apng)，没有压缩的Y4M
+var VideoFormats = []string{".gif", ".png", ".apng", ".y4m"}
+
+var errNotRecordingVideo = errors.New("not recording video")
+
+// videoRecorder 画面和声音分别写到两个文件，文件名只有扩展名不同
+type videoRecorder struct {
+	frames    video.Encoder
+	audio     *apu.WavWriter
+	videoFile *os.File
+	audioFile *os.File
+}
+
+func (v *videoRecorder) close() error {
+	err := v.frames.Close()
+	if e := v.audio.Close(); err == nil {
+		err = e
+	}
+	if e := v.videoFile.Close(); err == nil {
+		err = e
+	}
+	if e := v.audioFile.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// VideoName 录像的文件名，和截图一样由ROM的文件名和帧数组成，ext是VideoFormats中的一个
+func (e *Emu) VideoName(ext string) string {
+	return e.captureName("video", ext)
+}
+
+// AudioName 和录像放在一起的WAV文件
+func AudioName(videoPath string) string {
+	return strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + ".wav"
+}
+
+// StartVideo 开始录像，格式由扩展名决定，声音同时写到AudioName(path)。
+// 每运行一帧录一帧，和运行的速度无关，录下来的总是NTSC的帧率，可以用ffmpeg把画面和声音合在一起。
+func (e *Emu) StartVideo(path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	known := false
+	for _, f := range VideoFormats {
+		known = known || f == ext
+	}
+	if !known {
+		return fmt.Errorf("unknown video format %q, should be one of %v", ext, VideoFormats)
+	}
+	if e.IsRecordingVideo() {
+		return errors.New("already recording video")
+	}
+	videoFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	audioFile, err := os.Create(AudioName(path))
+	if err != nil {
+		videoFile.Close()
+		return err
+	}
+	v := &videoRecorder{videoFile: videoFile, audioFile: audioFile}
+	switch ext {
+	case ".gif":
+		v.frames = video.NewGIFWriter(videoFile, ppu.ScreenPalette(), video.NTSC)
+	case ".y4m":
+		v.frames = video.NewY4MWriter(videoFile, video.NTSC)
+	default:
+		v.frames = video.NewAPNGWriter(videoFile, video.NTSC)
+	}
+	if v.audio, err = apu.NewWavWriter(audioFile, e.APU.SampleRate); err != nil {
+		videoFile.Close()
+		audioFile.Close()
+		return err
+	}
+	e.mu.Lock()
+	e.video = v
+	e.videoErr = nil
+	e.mu.Unlock()
+	return nil
+}
+
+// StopVideo 停止录像并关闭文件，返回录像过程中出的错
+func (e *Emu) StopVideo() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.video == nil {
+		if err := e.videoErr; err != nil {
+			e.videoErr = nil
+			return err
+		}
+		return errNotRecordingVideo
+	}
+	err := e.video.close()
+	e.video = nil
+	return err
+}
+
+func (e *Emu) IsRecordingVideo() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.video != nil
+}
+
+// recordVideo 在每帧结束时写入这一帧的画面和声音，出错时停止录像
+func (e *Emu) recordVideo(samples []float32) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v := e.video
+	if v == nil {
+		return
+	}
+	err := v.frames.WriteFrame(e.Opt.Video.process(e.Screenshot()))
+	if err == nil {
+		err = v.audio.WriteSamples(samples)
+	}
+	if err != nil {
+		v.close()
+		e.video = nil
+		e.videoErr = err
+	}
+}
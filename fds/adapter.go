@@ -0,0 +1,412 @@
+package fds
+
+import (
+	"encoding/binary"
+	"errors"
+	"fc-emulator/utils"
+	"fmt"
+	"io"
+)
+
+// https://www.nesdev.org/wiki/Family_Computer_Disk_System
+const (
+	BiosSize = 8 * utils.Kb
+
+	NoDisk = -1
+
+	byteCycles  = 150   // 磁头读写一个字节的CPU周期
+	spinUp      = 50000 // 马达启动到开始读数据的周期
+	insertDelay = 100000
+)
+
+// Adapter RAM适配器和磁盘驱动器，接管CPU的$4020-$FFFF:
+//
+//	$4020-$4025 写寄存器: IRQ计时器、磁盘数据和控制
+//	$4030-$4033 读寄存器: 状态、读到的数据、驱动器状态
+//	$4040-$4097 波表音频
+//	$6000-$DFFF 32k PRG-RAM
+//	$E000-$FFFF 8k BIOS
+//
+// 8k的CHR-RAM就是PPU的$0000-$1FFF，PPU的内存本身就可以写，不需要在这里处理。
+type Adapter struct {
+	PrgRam [32 * utils.Kb]byte
+	Bios   [BiosSize]byte
+	Audio  Audio
+
+	image    *Image
+	raw      [][]byte // 每一面加上间隙之后的数据流
+	modified []bool
+	dirty    bool // 上次保存之后有没有写过
+
+	irqReload    uint16
+	irqCounter   uint16
+	irqRepeat    bool
+	irqEnabled   bool
+	timerIRQ     bool
+	diskIRQ      bool
+	diskEnabled  bool // $4023 bit0
+	soundEnabled bool // $4023 bit1
+
+	writeData        byte
+	readData         byte
+	motorOn          bool
+	resetTransfer    bool
+	readMode         bool
+	crcControl       bool
+	diskReady        bool
+	diskIRQEnabled   bool
+	transferComplete bool
+	horizontal       bool // $4025的bit3，PPU还没有实现命名表镜像，只在$4030中返回
+
+	disk        int // 当前插入的面，NoDisk表示没有磁盘
+	nextDisk    int // 换面时先拔出磁盘，过insertDelay个周期后再插入
+	insertTimer int
+	position    int
+	delay       int
+	scanning    bool
+	endOfHead   bool
+	gapEnded    bool
+	prevCRC     bool
+	crc         uint16
+}
+
+// NewAdapter bios是用户提供的8k磁盘BIOS(disksys.rom)，开始时插入第一面
+func NewAdapter(bios []byte, img *Image) (*Adapter, error) {
+	if len(bios) != BiosSize {
+		return nil, fmt.Errorf("fds bios must be %d bytes, got %d", BiosSize, len(bios))
+	}
+	if len(img.Sides) == 0 {
+		return nil, errors.New("fds image has no side")
+	}
+	a := &Adapter{image: img, nextDisk: NoDisk}
+	copy(a.Bios[:], bios)
+	for _, side := range img.Sides {
+		a.raw = append(a.raw, addGaps(side))
+	}
+	a.modified = make([]bool, len(img.Sides))
+	a.PowerOn()
+	a.disk = 0
+	return a, nil
+}
+
+// PowerOn 恢复寄存器的上电状态并清空PRG-RAM，不会换磁盘
+func (a *Adapter) PowerOn() {
+	disk := a.disk
+	*a = Adapter{
+		Bios:     a.Bios,
+		image:    a.image,
+		raw:      a.raw,
+		modified: a.modified,
+		dirty:    a.dirty,
+		disk:     disk,
+		nextDisk: NoDisk,
+	}
+	a.endOfHead = true
+	a.Audio.PowerOn()
+}
+
+// Sides 磁盘的面数
+func (a *Adapter) Sides() int {
+	return len(a.raw)
+}
+
+// Disk 当前插入的面，没有磁盘时返回NoDisk
+func (a *Adapter) Disk() int {
+	return a.disk
+}
+
+// Eject 拔出磁盘
+func (a *Adapter) Eject() {
+	a.disk = NoDisk
+	a.nextDisk = NoDisk
+}
+
+// Insert 换到第side面，会先拔出当前的磁盘，过一会再插入，这样BIOS才能发现磁盘换过了
+func (a *Adapter) Insert(side int) error {
+	if side < 0 || side >= len(a.raw) {
+		return fmt.Errorf("side %d out of range, image has %d sides", side, len(a.raw))
+	}
+	a.disk = NoDisk
+	a.nextDisk = side
+	a.insertTimer = insertDelay
+	return nil
+}
+
+// Modified 磁盘有没有被写过
+func (a *Adapter) Modified() bool {
+	for _, m := range a.modified {
+		if m {
+			return true
+		}
+	}
+	return false
+}
+
+// Dirty 上次ClearDirty之后磁盘有没有被写过
+func (a *Adapter) Dirty() bool {
+	return a.dirty
+}
+
+func (a *Adapter) ClearDirty() {
+	a.dirty = false
+}
+
+// MotorOn 马达在转的时候BIOS可能还在读写磁盘
+func (a *Adapter) MotorOn() bool {
+	return a.motorOn
+}
+
+// Image 返回包含所有写入的磁盘镜像
+func (a *Adapter) Image() *Image {
+	img := &Image{header: a.image.header}
+	for i, raw := range a.raw {
+		side := a.image.Sides[i]
+		if a.modified[i] {
+			side = removeGaps(raw, side)
+		}
+		img.Sides = append(img.Sides, side)
+	}
+	return img
+}
+
+// WriteState 把PRG-RAM、寄存器、磁头位置、磁盘内容和音频的状态写到w，用来计算模拟器状态的hash
+func (a *Adapter) WriteState(w io.Writer) {
+	w.Write(a.PrgRam[:])
+	binary.Write(w, binary.LittleEndian, []bool{
+		a.irqRepeat, a.irqEnabled, a.timerIRQ, a.diskIRQ, a.diskEnabled, a.soundEnabled,
+		a.motorOn, a.resetTransfer, a.readMode, a.crcControl, a.diskReady, a.diskIRQEnabled,
+		a.transferComplete, a.horizontal, a.scanning, a.endOfHead, a.gapEnded, a.prevCRC})
+	binary.Write(w, binary.LittleEndian, []uint16{a.irqReload, a.irqCounter, a.crc})
+	binary.Write(w, binary.LittleEndian, []int64{
+		int64(a.disk), int64(a.nextDisk), int64(a.insertTimer), int64(a.position), int64(a.delay)})
+	w.Write([]byte{a.writeData, a.readData})
+	for _, raw := range a.raw {
+		w.Write(raw)
+	}
+	a.Audio.WriteState(w)
+}
+
+// IRQ IRQ计时器或者磁盘传输是否在请求中断
+func (a *Adapter) IRQ() bool {
+	return a.timerIRQ || a.diskIRQ
+}
+
+// Clock 前进cycles个CPU周期
+func (a *Adapter) Clock(cycles int) {
+	for i := 0; i < cycles; i++ {
+		a.clockTimer()
+		a.clockDisk()
+	}
+	if a.nextDisk != NoDisk {
+		a.insertTimer -= cycles
+		if a.insertTimer <= 0 {
+			a.disk = a.nextDisk
+			a.nextDisk = NoDisk
+		}
+	}
+}
+
+func (a *Adapter) clockTimer() {
+	if !a.irqEnabled {
+		return
+	}
+	if a.irqCounter == 0 {
+		a.timerIRQ = true
+		a.irqCounter = a.irqReload
+		if !a.irqRepeat {
+			a.irqEnabled = false
+		}
+	} else {
+		a.irqCounter -= 1
+	}
+}
+
+func (a *Adapter) clockDisk() {
+	if a.disk == NoDisk || !a.motorOn {
+		a.endOfHead = true
+		a.scanning = false
+		return
+	}
+	if a.resetTransfer && !a.scanning {
+		return
+	}
+	if a.endOfHead { // 磁头回到开头，等马达转起来
+		a.delay = spinUp
+		a.endOfHead = false
+		a.position = 0
+		a.gapEnded = false
+		return
+	}
+	if a.delay > 0 {
+		a.delay -= 1
+		return
+	}
+	a.scanning = true
+	raw := a.raw[a.disk]
+	if a.readMode {
+		a.readByte(raw[a.position])
+	} else {
+		a.writeByte()
+	}
+	a.prevCRC = a.crcControl
+	a.position += 1
+	if a.position >= len(raw) {
+		a.motorOn = false
+	} else {
+		a.delay = byteCycles
+	}
+}
+
+func (a *Adapter) readByte(val byte) {
+	needIRQ := a.diskIRQEnabled
+	if !a.diskReady {
+		a.gapEnded = false
+	} else if val != 0 && !a.gapEnded { // 间隙之后的0x80是块的起始标志，这个字节不产生中断
+		a.gapEnded = true
+		needIRQ = false
+	}
+	if a.gapEnded {
+		a.transferComplete = true
+		a.readData = val
+		if needIRQ {
+			a.diskIRQ = true
+		}
+	}
+}
+
+func (a *Adapter) writeByte() {
+	val := a.writeData
+	if !a.crcControl {
+		a.transferComplete = true
+		if a.diskIRQEnabled {
+			a.diskIRQ = true
+		}
+	}
+	if !a.diskReady {
+		val = 0
+		a.crc = 0
+	}
+	if !a.crcControl {
+		a.crc = updateCRC(a.crc, val)
+	} else {
+		if !a.prevCRC {
+			a.crc = updateCRC(a.crc, 0)
+			a.crc = updateCRC(a.crc, 0)
+		}
+		val = byte(a.crc)
+		a.crc >>= 8
+	}
+	// BIOS写$4024之后要过两个字节的时间才会真正写到磁盘上
+	if a.position >= 2 {
+		a.raw[a.disk][a.position-2] = val
+		a.modified[a.disk] = true
+		a.dirty = true
+	}
+	a.gapEnded = false
+}
+
+func (a *Adapter) Read(addr uint16) byte {
+	switch {
+	case addr == 0x4030 && a.diskEnabled:
+		var val byte
+		if a.timerIRQ {
+			val |= 0x01
+		}
+		if a.transferComplete {
+			val |= 0x02
+		}
+		if a.horizontal {
+			val |= 0x08
+		}
+		a.transferComplete = false
+		a.timerIRQ = false
+		a.diskIRQ = false
+		return val
+	case addr == 0x4031 && a.diskEnabled:
+		a.transferComplete = false
+		a.diskIRQ = false
+		return a.readData
+	case addr >= 0x4032 && addr <= 0x4033 || addr >= 0x6000:
+		return a.Peek(addr)
+	case addr >= 0x4040 && addr <= 0x4097 && a.soundEnabled:
+		return a.Audio.Read(addr)
+	}
+	return 0
+}
+
+func (a *Adapter) Peek(addr uint16) byte {
+	switch {
+	case addr == 0x4032 && a.diskEnabled:
+		var val byte = 0x40
+		if a.disk == NoDisk {
+			val |= 0x01 | 0x04 // 没有磁盘，不能写
+		}
+		if a.disk == NoDisk || !a.scanning {
+			val |= 0x02
+		}
+		return val
+	case addr == 0x4033 && a.diskEnabled:
+		return 0x80 // 电池电压正常，扩展端口没有接东西
+	case addr >= 0x4040 && addr <= 0x4097 && a.soundEnabled:
+		return a.Audio.Peek(addr)
+	case addr >= 0x6000 && addr <= 0xDFFF:
+		return a.PrgRam[addr-0x6000]
+	case addr >= 0xE000:
+		return a.Bios[addr-0xE000]
+	}
+	return 0
+}
+
+func (a *Adapter) Write(addr uint16, val byte) {
+	switch {
+	case addr == 0x4020:
+		a.irqReload = a.irqReload&0xFF00 | uint16(val)
+	case addr == 0x4021:
+		a.irqReload = a.irqReload&0x00FF | uint16(val)<<8
+	case addr == 0x4022:
+		a.irqRepeat = val&0x01 != 0
+		a.irqEnabled = val&0x02 != 0 && a.diskEnabled
+		if a.irqEnabled {
+			a.irqCounter = a.irqReload
+		} else {
+			a.timerIRQ = false
+		}
+	case addr == 0x4023:
+		a.diskEnabled = val&0x01 != 0
+		a.soundEnabled = val&0x02 != 0
+		if !a.diskEnabled {
+			a.irqEnabled = false
+			a.timerIRQ = false
+			a.diskIRQ = false
+		}
+	case addr == 0x4024 && a.diskEnabled:
+		a.writeData = val
+		a.transferComplete = false
+		a.diskIRQ = false
+	case addr == 0x4025 && a.diskEnabled:
+		a.motorOn = val&0x01 != 0
+		a.resetTransfer = val&0x02 != 0
+		a.readMode = val&0x04 != 0
+		a.crcControl = val&0x10 != 0
+		a.diskReady = val&0x40 != 0
+		a.diskIRQEnabled = val&0x80 != 0
+		a.horizontal = val&0x08 != 0
+		a.transferComplete = false
+		a.diskIRQ = false
+	case addr >= 0x4040 && addr <= 0x4097 && a.soundEnabled:
+		a.Audio.Write(addr, val)
+	case addr >= 0x6000 && addr <= 0xDFFF:
+		a.PrgRam[addr-0x6000] = val
+	}
+}
+
+// Poke 直接修改PRG-RAM和BIOS，写寄存器会被忽略
+func (a *Adapter) Poke(addr uint16, val byte) {
+	if addr >= 0xE000 {
+		a.Bios[addr-0xE000] = val
+		return
+	}
+	if addr >= 0x6000 {
+		a.PrgRam[addr-0x6000] = val
+	}
+}
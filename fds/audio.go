@@ -0,0 +1,250 @@
+package fds
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Audio 波表音频通道 https://www.nesdev.org/wiki/FDS_audio
+//
+// 64个6位采样的波表按频率循环播放，调制单元按调制表改变波表的频率，产生颤音之类的效果。
+// 音量和调制深度各有一个包络。实现了apu.Expansion，由APU每个CPU周期调用Clock并混音。
+type Audio struct {
+	Wave      [64]byte // $4040-$407F，每个采样6位
+	WaveWrite bool     // $4089 bit7，为true时才能写波表，同时波表停止前进
+	VolGain   byte     // 音量包络的当前值
+	ModGain   byte     // 调制包络的当前值
+	Freq      uint16   // $4082-$4083 波表频率
+	ModFreq   uint16   // $4086-$4087 调制频率
+	ModTable  [64]byte // 通过$4088写入的调制表，每次写入占两个位置
+	ModCount  int8     // $4085 调制计数器，7位有符号数
+	Master    byte     // $4089 bit0-1 总音量，0最大
+	EnvSpeed  byte     // $408A 包络的总速度
+
+	volEnv   envelope
+	modEnv   envelope
+	waveHalt bool // $4083 bit7
+	envHalt  bool // $4083 bit6
+	modHalt  bool // $4087 bit7
+	wavePos  byte
+	waveAcc  uint16
+	modPos   byte
+	modAcc   uint16
+	writePos byte       // $4088下一次写入的位置
+	output   byte       // 当前波表的输出，写波表时保持不变
+	regs     [0x18]byte // $4080-$4097最后写入的值
+}
+
+// envelope 音量或调制深度的包络
+type envelope struct {
+	disabled bool
+	increase bool
+	speed    byte
+	timer    int
+}
+
+// outputLevel 满音量的输出，大约是APU一个方波满音量的2.4倍
+const outputLevel = 0.27
+
+// masterVolumes $4089的总音量: 2/2、2/3、2/4、2/5，分母是36
+var masterVolumes = [4]int{36, 24, 18, 14}
+
+// modSteps 调制表中每个值对调制计数器的改变，4表示把计数器清零
+var modSteps = [8]int{0, 1, 2, 4, 0, -4, -2, -1}
+
+// PowerOn 上电时包络的总速度是$E8，其它都是0
+func (au *Audio) PowerOn() {
+	*au = Audio{EnvSpeed: 0xE8}
+}
+
+// WriteState 把波表、调制表、包络和各个计数器写到w，用来计算模拟器状态的hash
+func (au *Audio) WriteState(w io.Writer) {
+	w.Write(au.Wave[:])
+	w.Write(au.ModTable[:])
+	w.Write(au.regs[:])
+	w.Write([]byte{au.VolGain, au.ModGain, byte(au.ModCount), au.Master, au.EnvSpeed,
+		au.wavePos, au.modPos, au.writePos, au.output})
+	binary.Write(w, binary.LittleEndian, []bool{au.WaveWrite, au.waveHalt, au.envHalt, au.modHalt,
+		au.volEnv.disabled, au.volEnv.increase, au.modEnv.disabled, au.modEnv.increase})
+	binary.Write(w, binary.LittleEndian, []uint16{au.Freq, au.ModFreq, au.waveAcc, au.modAcc})
+	binary.Write(w, binary.LittleEndian, []int64{
+		int64(au.volEnv.speed), int64(au.volEnv.timer), int64(au.modEnv.speed), int64(au.modEnv.timer)})
+}
+
+func (au *Audio) Read(addr uint16) byte {
+	return au.Peek(addr)
+}
+
+func (au *Audio) Peek(addr uint16) byte {
+	switch {
+	case addr >= 0x4040 && addr <= 0x407F:
+		return au.Wave[addr-0x4040] | 0x40 // 高2位是open bus，通常是$40
+	case addr == 0x4090:
+		return au.VolGain | 0x40
+	case addr == 0x4092:
+		return au.ModGain | 0x40
+	}
+	return 0
+}
+
+func (au *Audio) Write(addr uint16, val byte) {
+	if addr >= 0x4040 && addr <= 0x407F {
+		if au.WaveWrite {
+			au.Wave[addr-0x4040] = val & 0x3F
+		}
+		return
+	}
+	if addr < 0x4080 || addr > 0x4097 {
+		return
+	}
+	au.regs[addr-0x4080] = val
+	switch addr {
+	case 0x4080:
+		au.volEnv.write(val, au.EnvSpeed)
+		if au.volEnv.disabled { // 关闭包络时直接设置音量
+			au.VolGain = val & 0x3F
+		}
+	case 0x4082:
+		au.Freq = au.Freq&0x0F00 | uint16(val)
+	case 0x4083:
+		au.Freq = au.Freq&0x00FF | uint16(val&0x0F)<<8
+		au.waveHalt = val&0x80 != 0
+		au.envHalt = val&0x40 != 0
+		if au.waveHalt {
+			au.wavePos = 0
+			au.waveAcc = 0
+		}
+	case 0x4084:
+		au.modEnv.write(val, au.EnvSpeed)
+		if au.modEnv.disabled {
+			au.ModGain = val & 0x3F
+		}
+	case 0x4085:
+		au.ModCount = int8(val<<1) >> 1
+	case 0x4086:
+		au.ModFreq = au.ModFreq&0x0F00 | uint16(val)
+	case 0x4087:
+		au.ModFreq = au.ModFreq&0x00FF | uint16(val&0x0F)<<8
+		au.modHalt = val&0x80 != 0
+		if au.modHalt {
+			au.modAcc = 0
+		}
+	case 0x4088:
+		// 只有调制停止时才能写，每次写入两个相同的值
+		if au.modHalt {
+			au.ModTable[au.writePos] = val & 0x07
+			au.ModTable[au.writePos+1] = val & 0x07
+			au.writePos = (au.writePos + 2) % byte(len(au.ModTable))
+		}
+	case 0x4089:
+		au.WaveWrite = val&0x80 != 0
+		au.Master = val & 0x03
+	case 0x408A:
+		au.EnvSpeed = val
+	}
+}
+
+func (env *envelope) write(val, master byte) {
+	env.disabled = val&0x80 != 0
+	env.increase = val&0x40 != 0
+	env.speed = val & 0x3F
+	env.reload(master)
+}
+
+func (env *envelope) reload(master byte) {
+	env.timer = 8 * (int(env.speed) + 1) * int(master)
+}
+
+// clock 到时间时把gain向增大或减小的方向改变1，最大32
+func (env *envelope) clock(gain *byte, master byte) {
+	if env.disabled || master == 0 {
+		return
+	}
+	env.timer--
+	if env.timer > 0 {
+		return
+	}
+	env.reload(master)
+	if env.increase && *gain < 32 {
+		*gain++
+	} else if !env.increase && *gain > 0 {
+		*gain--
+	}
+}
+
+// modulation 调制单元对波表频率的改变，算法和舍入方式见nesdev上的说明
+func (au *Audio) modulation() int {
+	temp := int(au.ModCount) * int(au.ModGain)
+	remainder := temp & 0x0F
+	temp >>= 4
+	if remainder > 0 && temp&0x80 == 0 {
+		if au.ModCount < 0 {
+			temp--
+		} else {
+			temp += 2
+		}
+	}
+	if temp >= 192 {
+		temp -= 256
+	} else if temp < -64 {
+		temp += 256
+	}
+	temp *= int(au.Freq)
+	remainder = temp & 0x3F
+	temp >>= 6
+	if remainder >= 32 {
+		temp++
+	}
+	return temp
+}
+
+// Clock 运行一个CPU周期
+func (au *Audio) Clock() {
+	if !au.waveHalt && !au.envHalt {
+		au.volEnv.clock(&au.VolGain, au.EnvSpeed)
+		au.modEnv.clock(&au.ModGain, au.EnvSpeed)
+	}
+	if !au.modHalt && au.ModFreq > 0 {
+		acc := au.modAcc + au.ModFreq
+		if acc < au.modAcc { // 16位溢出时走一步
+			step := au.ModTable[au.modPos]
+			if step == 4 {
+				au.ModCount = 0
+			} else {
+				count := int(au.ModCount) + modSteps[step]
+				if count >= 64 {
+					count -= 128
+				} else if count < -64 {
+					count += 128
+				}
+				au.ModCount = int8(count)
+			}
+			au.modPos = (au.modPos + 1) % byte(len(au.ModTable))
+		}
+		au.modAcc = acc
+	}
+	if au.waveHalt {
+		au.output = au.Wave[0]
+		return
+	}
+	if !au.WaveWrite {
+		au.output = au.Wave[au.wavePos]
+		if freq := int(au.Freq) + au.modulation(); freq > 0 {
+			acc := au.waveAcc + uint16(freq)
+			if acc < au.waveAcc {
+				au.wavePos = (au.wavePos + 1) % byte(len(au.Wave))
+			}
+			au.waveAcc = acc
+		}
+	}
+}
+
+// Output 波表的输出乘以音量(最大32)和总音量
+func (au *Audio) Output() float64 {
+	gain := int(au.VolGain)
+	if gain > 32 {
+		gain = 32
+	}
+	level := int(au.output) * gain * masterVolumes[au.Master]
+	return float64(level) / (63 * 32 * 36) * outputLevel
+}
@@ -0,0 +1,249 @@
+package fds
+
+import (
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+// makeSide 生成一面只有一个文件的磁盘
+func makeSide(fileData []byte) []byte {
+	side := make([]byte, 0, SideSize)
+	info := make([]byte, 56)
+	copy(info, diskMagic)
+	copy(info[16:], "TST")
+	side = append(side, info...)
+	side = append(side, blockFileAmount, 1)
+	header := []byte{blockFileHeader, 0, 0, 'F', 'I', 'L', 'E', ' ', ' ', ' ', ' ', 0x00, 0x60,
+		byte(len(fileData)), byte(len(fileData) >> 8), 0}
+	side = append(side, header...)
+	side = append(side, blockFileData)
+	side = append(side, fileData...)
+	return append(side, make([]byte, SideSize-len(side))...)
+}
+
+func TestParseImage(t *testing.T) {
+	side := makeSide([]byte{1, 2, 3})
+	raw := append(append([]byte{}, side...), side...)
+	img, err := Parse(raw)
+	require.NoError(t, err)
+	require.Len(t, img.Sides, 2)
+	require.Equal(t, raw, img.Bytes())
+
+	headered := append([]byte("FDS\x1A\x01"), make([]byte, 11)...)
+	headered = append(headered, side...)
+	img, err = Parse(headered)
+	require.NoError(t, err)
+	require.Len(t, img.Sides, 1)
+	require.Equal(t, headered, img.Bytes())
+	require.True(t, IsImage(headered))
+	require.Equal(t, "Disk 2 Side B", SideName(3))
+
+	_, err = Parse(side[:100])
+	require.Error(t, err)
+	_, err = Parse(headered[:1000])
+	require.Error(t, err)
+	_, err = Parse(make([]byte, SideSize))
+	require.Error(t, err)
+}
+
+func TestGaps(t *testing.T) {
+	side := makeSide([]byte("hello disk"))
+	raw := addGaps(side)
+	require.Equal(t, byte(0x80), raw[leadInGap])
+	require.Equal(t, side[:56], raw[leadInGap+1:leadInGap+57])
+	require.Equal(t, side, removeGaps(raw, side))
+}
+
+func newTestAdapter(t *testing.T, side []byte) *Adapter {
+	a, err := NewAdapter(make([]byte, BiosSize), &Image{Sides: [][]byte{side}})
+	require.NoError(t, err)
+	return a
+}
+
+// nextByte 让磁头前进一个字节
+func nextByte(a *Adapter) {
+	pos := a.position
+	for a.position == pos && a.motorOn {
+		a.Clock(1)
+	}
+}
+
+func TestIRQTimer(t *testing.T) {
+	a := newTestAdapter(t, makeSide(nil))
+	a.Write(0x4023, 0x01)
+	a.Write(0x4020, 10)
+	a.Write(0x4021, 0)
+	a.Write(0x4022, 0x02) // 不重复
+	a.Clock(10)
+	require.False(t, a.IRQ())
+	a.Clock(1)
+	require.True(t, a.IRQ())
+	require.Equal(t, byte(0x01), a.Read(0x4030)&0x01)
+	require.False(t, a.IRQ())
+	a.Clock(100)
+	require.False(t, a.IRQ())
+}
+
+func TestReadDisk(t *testing.T) {
+	side := makeSide([]byte{0xAA, 0xBB})
+	a := newTestAdapter(t, side)
+	a.Write(0x4023, 0x01)
+	require.Equal(t, byte(0x02), a.Read(0x4032)&0x03) // 有磁盘，还没有开始转
+	a.Write(0x4025, 0x01|0x04|0x40)                   // 马达、读、准备好
+	got := make([]byte, 0)
+	for len(got) < 17 {
+		nextByte(a)
+		if a.Read(0x4030)&0x02 != 0 {
+			got = append(got, a.Read(0x4031))
+		}
+	}
+	require.Equal(t, append([]byte{0x80}, diskMagic...), got[:16])
+	require.Equal(t, byte(0x00), a.Read(0x4032)&0x03)
+
+	a.Eject()
+	require.Equal(t, byte(0x07), a.Read(0x4032)&0x07)
+	require.NoError(t, a.Insert(0))
+	a.Clock(insertDelay)
+	require.Equal(t, 0, a.Disk())
+	require.Error(t, a.Insert(1))
+}
+
+func TestWriteDisk(t *testing.T) {
+	side := makeSide([]byte{1, 2, 3})
+	a := newTestAdapter(t, side)
+	a.Write(0x4023, 0x01)
+	// 写入的字节要过两个字节才落到磁盘上，所以先多写两个0
+	a.Write(0x4025, 0x01)
+	for i := 0; i < 2+leadInGap; i++ {
+		a.Write(0x4024, 0)
+		nextByte(a)
+	}
+	block := append([]byte{}, side[:56]...)
+	copy(block[16:], "NEW")
+	a.Write(0x4025, 0x01|0x40)
+	for _, b := range append([]byte{0x80}, block...) {
+		a.Write(0x4024, b)
+		nextByte(a)
+	}
+	a.Write(0x4025, 0x01|0x40|0x10) // 写CRC
+	nextByte(a)
+	nextByte(a)
+	a.Write(0x4025, 0)
+	require.True(t, a.Modified())
+	require.True(t, a.Dirty())
+	require.False(t, a.MotorOn())
+
+	crc := crc16(block)
+	raw := a.raw[0]
+	require.Equal(t, []byte{byte(crc), byte(crc >> 8)}, raw[leadInGap+57:leadInGap+59])
+	img := a.Image()
+	want := append([]byte{}, side...)
+	copy(want[16:], "NEW")
+	require.Equal(t, want, img.Sides[0])
+	require.Equal(t, side, a.image.Sides[0]) // 原来的镜像不变
+}
+
+// writeSaw 写一个锯齿波，每个采样是它的位置
+func writeSaw(au *Audio) {
+	au.Write(0x4089, 0x80)
+	for i := 0; i < 64; i++ {
+		au.Write(0x4040+uint16(i), byte(i))
+	}
+	au.Write(0x4089, 0x00)
+}
+
+// wavePeriod 波表走完一圈需要的CPU周期
+func wavePeriod(au *Audio) int {
+	au.Clock()
+	for cycles := 1; cycles < 1000000; cycles++ {
+		pos := au.wavePos
+		au.Clock()
+		if au.wavePos == 0 && pos == 63 {
+			return cycles
+		}
+	}
+	return 0
+}
+
+func TestAudio(t *testing.T) {
+	au := &Audio{}
+	au.PowerOn()
+	writeSaw(au)
+	require.Equal(t, float64(0), au.Output())
+
+	au.Write(0x4080, 0x80|32) // 关闭包络，音量32
+	au.Write(0x4082, 0x00)
+	au.Write(0x4083, 0x04) // 频率$400，每64个周期走一步
+	min, max := 1.0, 0.0
+	for i := 0; i < 64*64; i++ {
+		au.Clock()
+		min = math.Min(min, au.Output())
+		max = math.Max(max, au.Output())
+	}
+	require.Equal(t, float64(0), min)
+	require.InDelta(t, outputLevel, max, 0.001)
+
+	au.Write(0x4089, 0x03) // 总音量2/5
+	au.Clock()
+	require.Less(t, au.Output(), max/2)
+
+	// 波表停止时输出第一个采样
+	au.Write(0x4083, 0x80|0x04)
+	au.Clock()
+	require.Equal(t, float64(0), au.Output())
+	require.Equal(t, byte(0), au.wavePos)
+}
+
+func TestAudioEnvelope(t *testing.T) {
+	au := &Audio{}
+	au.PowerOn()
+	au.Write(0x408A, 1)
+	au.Write(0x4080, 0x40) // 增大，速度0: 每8个周期加1
+	au.Write(0x4083, 0x00)
+	for i := 0; i < 8*10; i++ {
+		au.Clock()
+	}
+	require.Equal(t, byte(10), au.VolGain)
+	require.Equal(t, byte(10|0x40), au.Peek(0x4090))
+	for i := 0; i < 8*100; i++ {
+		au.Clock()
+	}
+	require.Equal(t, byte(32), au.VolGain)
+
+	// $4083 bit6 停止包络
+	au.Write(0x4080, 0x00)
+	au.Write(0x4083, 0x40)
+	for i := 0; i < 8*10; i++ {
+		au.Clock()
+	}
+	require.Equal(t, byte(32), au.VolGain)
+}
+
+func TestAudioModulation(t *testing.T) {
+	au := &Audio{}
+	au.PowerOn()
+	writeSaw(au)
+	au.Write(0x4080, 0x80|32)
+	au.Write(0x4082, 0x00)
+	au.Write(0x4083, 0x04)
+	require.InDelta(t, 64*64, wavePeriod(au), 1)
+
+	// 调制表全是+1，计数器一直增大，频率升高
+	au.Write(0x4087, 0x80)
+	for i := 0; i < 32; i++ {
+		au.Write(0x4088, 0x01)
+	}
+	require.Equal(t, byte(1), au.ModTable[63])
+	au.Write(0x4084, 0x80|0x3F)
+	au.Write(0x4085, 0x10)
+	require.Equal(t, int8(0x10), au.ModCount)
+	au.Write(0x4086, 0x00)
+	au.Write(0x4087, 0x08)
+	require.Less(t, wavePeriod(au), 64*64-1)
+	require.NotEqual(t, int8(0x10), au.ModCount)
+
+	// 计数器是7位有符号数
+	au.Write(0x4085, 0x7F)
+	require.Equal(t, int8(-1), au.ModCount)
+}
@@ -0,0 +1,182 @@
+package fds
+
+import (
+	"bytes"
+	"errors"
+	"fc-emulator/rom"
+	"fmt"
+)
+
+// https://www.nesdev.org/wiki/FDS_file_format
+const (
+	SideSize    = 65500 // 每一面的数据大小，不包括间隙和CRC
+	headerMagic = "FDS\x1A"
+	diskMagic   = "\x01*NINTENDO-HVC*"
+)
+
+// Image 一个磁盘镜像，可以有多张磁盘，每张磁盘有A、B两面
+type Image struct {
+	Sides  [][]byte
+	header bool // 文件是否有16字节的fwNES文件头，保存时保持原样
+}
+
+// IsImage 根据文件头判断data是不是FDS镜像
+func IsImage(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(headerMagic)) || bytes.HasPrefix(data, []byte(diskMagic))
+}
+
+// Load 读取磁盘镜像，和ROM一样可以是zip或gzip压缩的
+func Load(fileName string) (*Image, error) {
+	data, err := rom.ReadRomFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse 解析有或者没有fwNES文件头的磁盘镜像
+func Parse(data []byte) (*Image, error) {
+	img := &Image{}
+	if bytes.HasPrefix(data, []byte(headerMagic)) {
+		if len(data) < 16 {
+			return nil, errors.New("fds header is truncated")
+		}
+		img.header = true
+		count := int(data[4])
+		data = data[16:]
+		if len(data) < count*SideSize {
+			return nil, fmt.Errorf("fds header says %d sides, file only has %d bytes", count, len(data))
+		}
+		data = data[:count*SideSize]
+	}
+	if len(data) == 0 || len(data)%SideSize != 0 {
+		return nil, fmt.Errorf("fds size %d is not a multiple of %d", len(data), SideSize)
+	}
+	for i := 0; i < len(data); i += SideSize {
+		side := append([]byte{}, data[i:i+SideSize]...)
+		if !bytes.HasPrefix(side, []byte(diskMagic)) {
+			return nil, fmt.Errorf("fds side %d has no disk info block", len(img.Sides))
+		}
+		img.Sides = append(img.Sides, side)
+	}
+	return img, nil
+}
+
+// Bytes 序列化成和读取时相同的格式
+func (img *Image) Bytes() []byte {
+	res := make([]byte, 0, 16+len(img.Sides)*SideSize)
+	if img.header {
+		res = append(res, headerMagic...)
+		res = append(res, byte(len(img.Sides)))
+		res = append(res, make([]byte, 11)...)
+	}
+	for _, side := range img.Sides {
+		res = append(res, side...)
+	}
+	return res
+}
+
+// SideName 例如 "Disk 1 Side A"
+func SideName(side int) string {
+	return fmt.Sprintf("Disk %d Side %c", side/2+1, 'A'+side%2)
+}
+
+// 磁盘上的数据块，每块前面是间隙和一个0x80的起始标志，后面是2字节的CRC
+const (
+	blockDiskInfo   = 1
+	blockFileAmount = 2
+	blockFileHeader = 3
+	blockFileData   = 4
+
+	leadInGap = 28300 / 8 // 磁盘开头的间隙
+	blockGap  = 976 / 8   // 块之间的间隙
+)
+
+// blockSize 返回从data[pos]开始的块的大小，fileSize是上一个文件头中记录的文件大小
+func blockSize(data []byte, pos int, fileSize *int) int {
+	switch data[pos] {
+	case blockDiskInfo:
+		return 56
+	case blockFileAmount:
+		return 2
+	case blockFileHeader:
+		if pos+16 <= len(data) {
+			*fileSize = int(data[pos+13]) | int(data[pos+14])<<8
+		}
+		return 16
+	case blockFileData:
+		return 1 + *fileSize
+	}
+	return 0
+}
+
+// addGaps 把一面的数据转换成磁头读到的数据流: 加上间隙、起始标志和CRC，剩下的空间都是间隙
+func addGaps(side []byte) []byte {
+	raw := make([]byte, leadInGap, leadInGap+SideSize+SideSize/8)
+	fileSize := 0
+	for pos := 0; pos < len(side); {
+		size := blockSize(side, pos, &fileSize)
+		if size == 0 || pos+size > len(side) {
+			break
+		}
+		raw = append(raw, 0x80)
+		raw = append(raw, side[pos:pos+size]...)
+		crc := crc16(side[pos : pos+size])
+		raw = append(raw, byte(crc), byte(crc>>8))
+		raw = append(raw, make([]byte, blockGap)...)
+		pos += size
+	}
+	for len(raw) < cap(raw) {
+		raw = append(raw, 0)
+	}
+	return raw
+}
+
+// removeGaps 是addGaps的逆过程，从数据流中取出所有的块，最后一块之后的数据保持orig中的样子
+func removeGaps(raw, orig []byte) []byte {
+	side := make([]byte, 0, SideSize)
+	fileSize := 0
+	for pos := 0; pos < len(raw); pos++ {
+		if raw[pos] != 0x80 {
+			continue
+		}
+		pos++
+		if pos >= len(raw) {
+			break
+		}
+		size := blockSize(raw, pos, &fileSize)
+		if size == 0 || pos+size > len(raw) {
+			break
+		}
+		side = append(side, raw[pos:pos+size]...)
+		pos += size + 1 // 跳过CRC，循环里还会再加1
+	}
+	if len(side) > SideSize {
+		side = side[:SideSize]
+	}
+	return append(side, orig[len(side):]...)
+}
+
+// crc16 RAM适配器使用的CRC，多项式0x8408，块的起始标志0x80也参与计算
+func crc16(block []byte) uint16 {
+	var crc uint16 = 0x8000
+	for _, b := range block {
+		crc = updateCRC(crc, b)
+	}
+	crc = updateCRC(crc, 0)
+	return updateCRC(crc, 0)
+}
+
+func updateCRC(crc uint16, val byte) uint16 {
+	for n := 0; n < 8; n++ {
+		carry := crc & 1
+		crc >>= 1
+		if carry != 0 {
+			crc ^= 0x8408
+		}
+		if val&(1<<n) != 0 {
+			crc ^= 0x8000
+		}
+	}
+	return crc
+}
@@ -0,0 +1,474 @@
+// Package gdbstub 实现了GDB远程串行协议(RSP)的服务端，通过TCP把调试器暴露给gdb或者其它RSP客户端。
+//
+// 6502没有标准的gdb寄存器布局，这里约定 g 包的寄存器顺序为:
+//
+//	0:A 1:X 2:Y 3:S 4:P 都是1个字节, 5:PC 2个字节(小端)
+//
+// 支持的命令: ? g G p P m M c s Z0-Z4 z0-z4 D k 以及中断(0x03)。
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fc-emulator/cpu"
+	"fc-emulator/debug"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const interrupt = "\x03"
+
+// ListenAndServe 监听addr，同一时间只服务一个客户端
+func ListenAndServe(addr string, d *debug.Debugger) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(l, d)
+}
+
+func Serve(l net.Listener, d *debug.Debugger) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if err := ServeConn(conn, d); err != nil && err != io.EOF {
+			log.Println("gdb client error: ", err)
+		}
+		conn.Close()
+	}
+}
+
+type point struct {
+	typ  byte
+	addr uint16
+	size int
+}
+
+type session struct {
+	d       *debug.Debugger
+	wmu     sync.Mutex // 读goroutine回复'-'时也会写连接
+	w       *bufio.Writer
+	events  <-chan *debug.State
+	points  map[point]int // gdb的断点 -> 调试器中的ID
+	running bool
+	silent  bool // 连接时的暂停不需要回复
+	noAck   bool
+	pending []string
+	done    bool
+}
+
+// ServeConn 处理一个客户端连接。连接时会暂停模拟器，断开时删除客户端设置的断点并继续运行。
+// 返回之后读连接的goroutine不再发送数据，调用者关闭conn之后它就会退出。
+func ServeConn(conn io.ReadWriter, d *debug.Debugger) error {
+	events, cancel := d.Subscribe()
+	defer cancel()
+	s := &session{
+		d:      d,
+		w:      bufio.NewWriter(conn),
+		events: events,
+		points: map[point]int{},
+	}
+	defer s.detach()
+
+	in := make(chan string)
+	errc := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		errc <- readPackets(bufio.NewReader(conn), in, quit, s.nak)
+		close(in)
+	}()
+
+	if !d.IsPaused() {
+		d.Pause()
+		s.running = true
+		s.silent = true
+	}
+	for !s.done {
+		select {
+		case packet, ok := <-in:
+			if !ok {
+				return <-errc
+			}
+			if packet == interrupt {
+				if s.running {
+					d.Pause()
+				}
+				continue
+			}
+			if s.running {
+				s.pending = append(s.pending, packet)
+				continue
+			}
+			if err := s.handle(packet); err != nil {
+				return err
+			}
+		case state := <-s.events:
+			if !s.running {
+				continue
+			}
+			s.running = false
+			if !s.silent {
+				if err := s.reply(stopReply(state)); err != nil {
+					return err
+				}
+			}
+			s.silent = false
+			for len(s.pending) > 0 && !s.running && !s.done {
+				packet := s.pending[0]
+				s.pending = s.pending[1:]
+				if err := s.handle(packet); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readPackets 从连接中解析 $data#cs 格式的包，校验和错误时调用nak。
+// 会话结束(quit被关闭)之后不再有人接收out，这时直接返回。
+func readPackets(r *bufio.Reader, out chan<- string, quit <-chan struct{}, nak func()) error {
+	send := func(packet string) bool {
+		select {
+		case out <- packet:
+			return true
+		case <-quit:
+			return false
+		}
+	}
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch c {
+		case 0x03:
+			if !send(interrupt) {
+				return nil
+			}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return err
+			}
+			data = data[:len(data)-1]
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				return err
+			}
+			if v, err := strconv.ParseUint(string(sum), 16, 8); err != nil || byte(v) != checksum(data) {
+				nak()
+				continue
+			}
+			if !send(data) {
+				return nil
+			}
+		default:
+			// '+' '-' 以及其它杂项字符，不做重传
+		}
+	}
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (s *session) nak() {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.w.WriteByte('-')
+	s.w.Flush()
+}
+
+func (s *session) reply(data string) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if !s.noAck {
+		s.w.WriteByte('+')
+	}
+	fmt.Fprintf(s.w, "$%s#%02x", data, checksum(data))
+	return s.w.Flush()
+}
+
+func stopReply(state *debug.State) string {
+	hit := state.Hit
+	if hit != nil && hit.Reason == debug.PauseWatchpoint && hit.Space == debug.CPUSpace && hit.Kind != debug.AccessExec {
+		kind := "awatch"
+		if hit.Kind == debug.AccessWrite {
+			kind = "watch"
+		} else if hit.Kind == debug.AccessRead {
+			kind = "rwatch"
+		}
+		return fmt.Sprintf("T05%s:%04x;", kind, hit.Addr)
+	}
+	return "S05"
+}
+
+func (s *session) handle(packet string) error {
+	if len(packet) == 0 {
+		return s.reply("")
+	}
+	cmd, args := packet[0], packet[1:]
+	switch cmd {
+	case '?':
+		return s.reply(stopReply(s.d.State()))
+	case 'g':
+		return s.reply(hex.EncodeToString(encodeRegisters(s.d.State().Register)))
+	case 'G':
+		data, err := hex.DecodeString(args)
+		if err != nil || len(data) != 7 {
+			return s.reply("E01")
+		}
+		s.d.SetRegister(func(reg *cpu.Register) { decodeRegisters(reg, data) })
+		return s.reply("OK")
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n > 5 {
+			return s.reply("E01")
+		}
+		data := encodeRegisters(s.d.State().Register)
+		if n == 5 {
+			return s.reply(hex.EncodeToString(data[5:7]))
+		}
+		return s.reply(hex.EncodeToString(data[n : n+1]))
+	case 'P':
+		return s.reply(s.writeRegister(args))
+	case 'm':
+		return s.reply(s.readMemory(args))
+	case 'M':
+		return s.reply(s.writeMemory(args))
+	case 'c', 's':
+		if len(args) > 0 {
+			addr, err := strconv.ParseUint(args, 16, 16)
+			if err != nil {
+				return s.reply("E01")
+			}
+			s.d.SetRegister(func(reg *cpu.Register) { reg.PC = uint16(addr) })
+		}
+		s.resume(cmd == 's')
+		return nil
+	case 'Z', 'z':
+		return s.reply(s.setPoint(cmd == 'Z', args))
+	case 'D':
+		s.done = true
+		return s.reply("OK")
+	case 'k':
+		s.done = true
+		return nil
+	case 'H', 'T':
+		return s.reply("OK")
+	case 'q', 'Q':
+		if packet == "QStartNoAckMode" {
+			// 先用ack模式回复OK，之后不再发送ack
+			err := s.reply("OK")
+			s.noAck = true
+			return err
+		}
+		return s.reply(query(packet))
+	default:
+		return s.reply("")
+	}
+}
+
+func query(packet string) string {
+	switch {
+	case strings.HasPrefix(packet, "qSupported"):
+		return "PacketSize=1000;QStartNoAckMode+"
+	case packet == "qAttached":
+		return "1"
+	case packet == "qC":
+		return "QC1"
+	case packet == "qfThreadInfo":
+		return "m1"
+	case packet == "qsThreadInfo":
+		return "l"
+	default:
+		return ""
+	}
+}
+
+func (s *session) resume(step bool) {
+	// 丢掉还没处理的旧事件，避免被当成这次恢复运行后的暂停
+	select {
+	case <-s.events:
+	default:
+	}
+	var err error
+	if step {
+		err = s.d.StepInto()
+	} else {
+		err = s.d.Continue()
+	}
+	if err != nil {
+		// 调试器已经在运行(例如被界面恢复了)，等待下一次暂停
+		s.d.Pause()
+	}
+	s.running = true
+}
+
+func encodeRegisters(reg cpu.Register) []byte {
+	return []byte{reg.A, reg.X, reg.Y, reg.S, reg.P, byte(reg.PC), byte(reg.PC >> 8)}
+}
+
+func decodeRegisters(reg *cpu.Register, data []byte) {
+	reg.A, reg.X, reg.Y, reg.S, reg.P = data[0], data[1], data[2], data[3], data[4]
+	reg.PC = uint16(data[5]) | uint16(data[6])<<8
+}
+
+// writeRegister 处理 P n=value，value是目标字节序(小端)的十六进制
+func (s *session) writeRegister(args string) string {
+	parts := strings.SplitN(args, "=", 2)
+	if len(parts) != 2 {
+		return "E01"
+	}
+	n, err := strconv.ParseUint(parts[0], 16, 8)
+	if err != nil || n > 5 {
+		return "E01"
+	}
+	value, err := hex.DecodeString(parts[1])
+	if err != nil || len(value) == 0 {
+		return "E01"
+	}
+	s.d.SetRegister(func(reg *cpu.Register) {
+		data := encodeRegisters(*reg)
+		if n == 5 {
+			copy(data[5:7], value)
+		} else {
+			data[n] = value[0]
+		}
+		decodeRegisters(reg, data)
+	})
+	return "OK"
+}
+
+func parseAddrLen(s string) (uint16, int, error) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("bad address %q", s)
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(addr), int(length), nil
+}
+
+// readMemory 处理 m addr,length，读取没有副作用
+func (s *session) readMemory(args string) string {
+	addr, length, err := parseAddrLen(args)
+	if err != nil {
+		return "E01"
+	}
+	data := make([]byte, 0, length)
+	for i := 0; i < length; i++ {
+		data = append(data, s.d.Peek(addr+uint16(i)))
+	}
+	return hex.EncodeToString(data)
+}
+
+// writeMemory 处理 M addr,length:data
+func (s *session) writeMemory(args string) string {
+	parts := strings.SplitN(args, ":", 2)
+	if len(parts) != 2 {
+		return "E01"
+	}
+	addr, length, err := parseAddrLen(parts[0])
+	if err != nil {
+		return "E01"
+	}
+	data, err := hex.DecodeString(parts[1])
+	if err != nil || len(data) != length {
+		return "E01"
+	}
+	for i, v := range data {
+		s.d.Poke(addr+uint16(i), v)
+	}
+	return "OK"
+}
+
+// setPoint 处理 Z/z type,addr,kind。0和1是断点，2、3、4分别是写、读、读写watchpoint，kind是监视的字节数
+func (s *session) setPoint(insert bool, args string) string {
+	parts := strings.Split(args, ",")
+	if len(parts) < 3 || len(parts[0]) != 1 || parts[0][0] < '0' || parts[0][0] > '4' {
+		return ""
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	size, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	p := point{typ: parts[0][0], addr: uint16(addr), size: int(size)}
+	if !insert {
+		if id, ok := s.points[p]; ok {
+			s.d.Remove(id)
+			delete(s.points, p)
+		}
+		return "OK"
+	}
+	if _, ok := s.points[p]; ok {
+		return "OK"
+	}
+	var id int
+	if p.typ == '0' || p.typ == '1' {
+		bp, err := s.d.AddBreakpoint(p.addr, "")
+		if err != nil {
+			return "E01"
+		}
+		id = bp.ID
+	} else {
+		kind := map[byte]debug.AccessKind{
+			'2': debug.AccessWrite,
+			'3': debug.AccessRead,
+			'4': debug.AccessRead | debug.AccessWrite,
+		}[p.typ]
+		end := p.addr
+		if p.size > 1 {
+			end = p.addr + uint16(p.size-1)
+		}
+		wp, err := s.d.AddWatchpoint(debug.CPUSpace, p.addr, end, kind, "")
+		if err != nil {
+			return "E01"
+		}
+		id = wp.ID
+	}
+	s.points[p] = id
+	return "OK"
+}
+
+// detach 删除客户端设置的断点，让模拟器继续运行
+func (s *session) detach() {
+	for _, id := range s.points {
+		s.d.Remove(id)
+	}
+	s.points = map[point]int{}
+	if s.running {
+		// 还有没完成的暂停请求，等它暂停之后再恢复运行
+		select {
+		case <-s.events:
+		case <-time.After(time.Second):
+		}
+	}
+	if s.d.IsPaused() {
+		_ = s.d.Continue()
+	}
+}
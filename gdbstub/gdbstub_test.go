@@ -0,0 +1,172 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fc-emulator/cpu"
+	"fc-emulator/debug"
+	"fc-emulator/memo"
+	"fc-emulator/pad"
+	"fc-emulator/ppu"
+	"fc-emulator/rom"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProgram = `
+	.org $8000
+reset:
+	LDX #0
+loop:
+	INX
+	STX $0300
+	JSR sub
+	JMP loop
+sub:
+	LDA $0300
+	NOP
+	RTS
+`
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startServer 在后台运行程序，启动gdb服务并连接上去
+func startServer(t *testing.T) (*client, *cpu.Program) {
+	prog, err := cpu.Assemble(testProgram)
+	require.NoError(t, err)
+	image, err := cpu.BuildNesImage(prog)
+	require.NoError(t, err)
+	nesRom, err := rom.ParseBytes(image)
+	require.NoError(t, err)
+	p := ppu.NewPPU(nesRom)
+	d := debug.NewDebugger(memo.NewMemo(nesRom, p, pad.NewPad(), pad.NewPad()))
+	c := cpu.NewCPU(d.Memo, false)
+	d.AttachCPU(c)
+	d.AttachPPU(p.(*ppu.PPUImpl))
+	c.Reset()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := d.Execute(); err != nil {
+				panic(err)
+			}
+		}
+	}()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go Serve(l, d)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		l.Close()
+		close(done)
+		d.Pause()
+		_ = d.Continue()
+	})
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}, prog
+}
+
+func (c *client) send(data string) {
+	_, err := fmt.Fprintf(c.conn, "$%s#%02x", data, checksum(data))
+	require.NoError(c.t, err)
+}
+
+func (c *client) recv() string {
+	require.NoError(c.t, c.conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for {
+		b, err := c.r.ReadByte()
+		require.NoError(c.t, err)
+		if b != '$' {
+			continue
+		}
+		data, err := c.r.ReadString('#')
+		require.NoError(c.t, err)
+		data = data[:len(data)-1]
+		sum := make([]byte, 2)
+		_, err = c.r.Read(sum)
+		require.NoError(c.t, err)
+		require.Equal(c.t, fmt.Sprintf("%02x", checksum(data)), string(sum))
+		return data
+	}
+}
+
+func (c *client) call(data string) string {
+	c.send(data)
+	return c.recv()
+}
+
+func TestRegistersAndMemory(t *testing.T) {
+	c, _ := startServer(t)
+	require.Equal(t, "PacketSize=1000;QStartNoAckMode+", c.call("qSupported:swbreak+"))
+	require.Equal(t, "S05", c.call("?"))
+	require.Len(t, c.call("g"), 14)
+
+	require.Equal(t, "OK", c.call("P0=5a"))
+	require.Equal(t, "5a", c.call("p0"))
+	require.Equal(t, "OK", c.call("P5=0080"))
+	require.Equal(t, "0080", c.call("p5"))
+
+	require.Equal(t, "OK", c.call("M0400,3:010203"))
+	require.Equal(t, "010203", c.call("m0400,3"))
+	require.Equal(t, "a200", c.call("m8000,2"))
+	require.Equal(t, "", c.call("vMustReplyEmpty"))
+}
+
+func TestBreakpointWatchpointAndStep(t *testing.T) {
+	c, prog := startServer(t)
+	require.Equal(t, "OK", c.call("QStartNoAckMode"))
+	require.Equal(t, "S05", c.call("?"))
+
+	sub := prog.Labels["sub"]
+	require.Equal(t, "OK", c.call(fmt.Sprintf("Z0,%04x,1", sub)))
+	require.Equal(t, "S05", c.call("c"))
+	require.Equal(t, fmt.Sprintf("%02x%02x", byte(sub), byte(sub>>8)), c.call("p5"))
+
+	// 单步执行 LDA $0300
+	require.Equal(t, "S05", c.call("s"))
+	pc := sub + 3
+	require.Equal(t, fmt.Sprintf("%02x%02x", byte(pc), byte(pc>>8)), c.call("p5"))
+
+	require.Equal(t, "OK", c.call(fmt.Sprintf("z0,%04x,1", sub)))
+	require.Equal(t, "OK", c.call("Z2,0300,1"))
+	require.Equal(t, "T05watch:0300;", c.call("c"))
+	require.Equal(t, "OK", c.call("z2,0300,1"))
+
+	// 运行中收到中断要暂停
+	c.send("c")
+	time.Sleep(10 * time.Millisecond)
+	_, err := c.conn.Write([]byte{0x03})
+	require.NoError(t, err)
+	require.Equal(t, "S05", c.recv())
+	require.Len(t, c.call("m0300,1"), 2)
+	require.Equal(t, "OK", c.call("D"))
+}
+
+func TestReadPacketsQuit(t *testing.T) {
+	// 会话已经结束，没有人再接收包，读goroutine不能一直阻塞
+	quit := make(chan struct{})
+	close(quit)
+	r := bufio.NewReader(strings.NewReader("$g#67\x03"))
+	require.NoError(t, readPackets(r, make(chan string), quit, func() {}))
+
+	out := make(chan string, 2)
+	r = bufio.NewReader(strings.NewReader("$g#00$g#67"))
+	naks := 0
+	require.Error(t, readPackets(r, out, make(chan struct{}), func() { naks++ }))
+	require.Equal(t, 1, naks)
+	require.Equal(t, "g", <-out)
+}
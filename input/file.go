@@ -0,0 +1,190 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 按键设置文件按段保存，[default]是所有游戏共用的设置，段名是rom.NesRom.Hash()时是这个游戏专用的设置。
+// 每一段都是完整的一套设置，游戏有自己的段时不再使用[default]:
+//
+//	[default]
+//	turbo-rate = 15
+//	allow-opposite = false
+//	W = 1 up
+//	L = 1 turbo a
+//	Up = 2 up
+//
+// 左边是主机的按键名，右边是手柄编号、可选的turbo和按键名。# 开头的行是注释。
+
+// DefaultSection 所有游戏共用的设置的段名
+const DefaultSection = "default"
+
+// LoadFile 读取romHash对应的设置，没有时使用[default]，文件不存在或者两段都没有时返回DefaultMapping
+func LoadFile(fileName, romHash string) (*Mapping, error) {
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return DefaultMapping(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sections, _, err := parseFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	lines, ok := sections[strings.ToLower(romHash)]
+	if !ok {
+		lines, ok = sections[DefaultSection]
+	}
+	if !ok {
+		return DefaultMapping(), nil
+	}
+	m, err := parseMapping(lines)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return m, nil
+}
+
+// SaveFile 把设置保存到section段，section是DefaultSection或者游戏的Hash，文件中其它的段保持不变
+func SaveFile(fileName, section string, m *Mapping) error {
+	sections := map[string][]string{}
+	order := make([]string, 0)
+	if f, err := os.Open(fileName); err == nil {
+		sections, order, err = parseFile(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	section = strings.ToLower(section)
+	if _, ok := sections[section]; !ok {
+		order = append(order, section)
+	}
+	sections[section] = formatMapping(m)
+
+	sb := &strings.Builder{}
+	for _, name := range order {
+		fmt.Fprintf(sb, "[%s]\n", name)
+		for _, line := range sections[name] {
+			sb.WriteString(line + "\n")
+		}
+	}
+	return ioutil.WriteFile(fileName, []byte(sb.String()), 0644)
+}
+
+// HasSection 文件中是否有section段
+func HasSection(fileName, section string) bool {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sections, _, err := parseFile(f)
+	if err != nil {
+		return false
+	}
+	_, ok := sections[strings.ToLower(section)]
+	return ok
+}
+
+func sectionName(line string) (string, bool) {
+	if len(line) > 2 && line[0] == '[' && line[len(line)-1] == ']' {
+		return strings.ToLower(line[1 : len(line)-1]), true
+	}
+	return "", false
+}
+
+// parseFile 返回每一段的内容，以及段在文件中出现的顺序
+func parseFile(r io.Reader) (map[string][]string, []string, error) {
+	sections := map[string][]string{}
+	order := make([]string, 0)
+	current := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if name, ok := sectionName(line); ok {
+			current = name
+			if _, ok := sections[name]; !ok {
+				sections[name] = nil
+				order = append(order, name)
+			}
+			continue
+		}
+		if current == "" {
+			return nil, nil, fmt.Errorf("line %q is outside of a section", line)
+		}
+		sections[current] = append(sections[current], line)
+	}
+	return sections, order, scanner.Err()
+}
+
+func parseMapping(lines []string) (*Mapping, error) {
+	m := &Mapping{Keys: map[string]Binding{}, TurboRate: DefaultTurboRate}
+	for _, line := range lines {
+		// 按键名本身可能是"="，右边不会有"="，从最后一个"="分开
+		i := strings.LastIndex(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %q should be key = value", line)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		var err error
+		switch key {
+		case "turbo-rate":
+			m.TurboRate, err = strconv.Atoi(value)
+			if err == nil && (m.TurboRate < 1 || m.TurboRate > 30) {
+				err = fmt.Errorf("turbo-rate %d should be 1-30", m.TurboRate)
+			}
+		case "allow-opposite":
+			m.AllowOpposite, err = strconv.ParseBool(value)
+		default:
+			m.Keys[key], err = ParseBinding(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// formatMapping 按手柄、按键排序，保存的文件每次都一样
+func formatMapping(m *Mapping) []string {
+	lines := []string{
+		fmt.Sprintf("turbo-rate = %d", m.TurboRate),
+		fmt.Sprintf("allow-opposite = %v", m.AllowOpposite),
+	}
+	keys := make([]string, 0, len(m.Keys))
+	for k := range m.Keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := m.Keys[keys[i]], m.Keys[keys[j]]
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Turbo != b.Turbo {
+			return !a.Turbo
+		}
+		if a.Button != b.Button {
+			return a.Button > b.Button
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%s = %s", k, m.Keys[k]))
+	}
+	return lines
+}
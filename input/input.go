@@ -0,0 +1,88 @@
+package input
+
+import (
+	"fc-emulator/pad"
+	"sync"
+)
+
+// Input 按照Mapping把主机的按键交给手柄，一个手柄按键可以绑定多个主机按键，都松开时才松开
+type Input struct {
+	mu      sync.Mutex
+	mapping *Mapping
+	pads    []*pad.LatchedPad
+	held    map[string]bool // 按住的主机按键
+}
+
+// New pads按编号排列，没有的手柄可以是nil
+func New(m *Mapping, pads []*pad.LatchedPad) *Input {
+	in := &Input{pads: pads, held: map[string]bool{}}
+	in.SetMapping(m)
+	return in
+}
+
+// Mapping 当前设置的副本
+func (in *Input) Mapping() *Mapping {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.mapping.Clone()
+}
+
+// SetMapping 换一套设置，按住的键都会松开
+func (in *Input) SetMapping(m *Mapping) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.mapping != nil {
+		for key := range in.held {
+			in.release(key)
+		}
+	}
+	in.mapping = m.Clone()
+	for _, p := range in.pads {
+		if p != nil {
+			p.SetTurboPeriod(m.TurboPeriod())
+			p.SetAllowOpposite(m.AllowOpposite)
+		}
+	}
+}
+
+// HandleKey 处理主机按键，返回这个键是否绑定了手柄按键
+func (in *Input) HandleKey(key string, pressed bool) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	b, ok := in.mapping.Keys[key]
+	if !ok {
+		return false
+	}
+	if pressed {
+		in.held[key] = true
+		in.update(b, true)
+	} else {
+		in.release(key)
+	}
+	return true
+}
+
+func (in *Input) release(key string) {
+	delete(in.held, key)
+	b, ok := in.mapping.Keys[key]
+	if !ok {
+		return
+	}
+	for k := range in.held {
+		if in.mapping.Keys[k] == b {
+			return
+		}
+	}
+	in.update(b, false)
+}
+
+func (in *Input) update(b Binding, pressed bool) {
+	if b.Port >= len(in.pads) || in.pads[b.Port] == nil {
+		return
+	}
+	if b.Turbo {
+		in.pads[b.Port].UpdateTurbo(b.Button, pressed)
+	} else {
+		in.pads[b.Port].UpdateButton(b.Button, pressed)
+	}
+}
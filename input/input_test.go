@@ -0,0 +1,104 @@
+package input
+
+import (
+	"fc-emulator/pad"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newPads() []*pad.LatchedPad {
+	pads := make([]*pad.LatchedPad, Ports)
+	for i := range pads {
+		pads[i] = pad.NewLatchedPad(pad.NewPad())
+	}
+	return pads
+}
+
+func TestInput(t *testing.T) {
+	pads := newPads()
+	m := DefaultMapping()
+	m.Keys["Space"] = Binding{Port: 0, Button: pad.BUTTON_A}
+	in := New(m, pads)
+
+	require.True(t, in.HandleKey("J", true))
+	require.True(t, in.HandleKey("Up", true))
+	require.False(t, in.HandleKey("F12", true))
+	require.Equal(t, byte(pad.BUTTON_A), pads[0].Latch())
+	require.Equal(t, byte(pad.BUTTON_UP), pads[1].Latch())
+
+	// 两个键绑定到A，都松开时才松开
+	in.HandleKey("Space", true)
+	in.HandleKey("J", false)
+	require.Equal(t, byte(pad.BUTTON_A), pads[0].Latch())
+	in.HandleKey("Space", false)
+	require.Equal(t, byte(0), pads[0].Latch())
+
+	// 连发
+	in.HandleKey("L", true)
+	require.Equal(t, byte(pad.BUTTON_A), pads[0].Latch())
+	require.Equal(t, byte(pad.BUTTON_A), pads[0].Latch())
+	require.Equal(t, byte(0), pads[0].Latch())
+
+	// 换设置时按住的键都松开
+	m = in.Mapping()
+	m.TurboRate = 30
+	m.AllowOpposite = true
+	in.SetMapping(m)
+	require.Equal(t, byte(0), pads[0].Latch())
+	require.Equal(t, byte(0), pads[1].Latch())
+	in.HandleKey("Left", true)
+	in.HandleKey("Right", true)
+	require.Equal(t, byte(pad.BUTTON_LEFT|pad.BUTTON_RIGHT), pads[1].Latch())
+}
+
+func TestBind(t *testing.T) {
+	m := DefaultMapping()
+	a := Binding{Port: 0, Button: pad.BUTTON_A}
+	m.Bind("Z", a)
+	require.Equal(t, []string{"Joy1 East", "Z"}, m.KeysOf(a))
+	m.Bind("W", a) // W原来是上
+	require.Equal(t, []string{"Joy1 East", "W"}, m.KeysOf(a))
+	require.Equal(t, []string{"Joy1 Up"}, m.KeysOf(Binding{Port: 0, Button: pad.BUTTON_UP}))
+	m.Bind("Joy1 South", a)
+	require.Equal(t, []string{"Joy1 South", "W"}, m.KeysOf(a))
+
+	b, err := ParseBinding("2 turbo B")
+	require.NoError(t, err)
+	require.Equal(t, Binding{Port: 1, Button: pad.BUTTON_B, Turbo: true}, b)
+	require.Equal(t, "2 turbo b", b.String())
+	_, err = ParseBinding("5 a")
+	require.Error(t, err)
+	_, err = ParseBinding("1 x")
+	require.Error(t, err)
+}
+
+func TestFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "input")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "input.cfg")
+
+	// 文件不存在时使用默认设置
+	m, err := LoadFile(fileName, "abcd")
+	require.NoError(t, err)
+	require.Equal(t, DefaultMapping(), m)
+
+	m.TurboRate = 10
+	require.NoError(t, SaveFile(fileName, DefaultSection, m))
+	game := &Mapping{Keys: map[string]Binding{"=": {Port: 2, Button: pad.BUTTON_START}}, TurboRate: 20, AllowOpposite: true}
+	require.NoError(t, SaveFile(fileName, "ABCD", game))
+	require.True(t, HasSection(fileName, "abcd"))
+
+	loaded, err := LoadFile(fileName, "1234")
+	require.NoError(t, err)
+	require.Equal(t, m, loaded)
+	loaded, err = LoadFile(fileName, "abcd")
+	require.NoError(t, err)
+	require.Equal(t, game, loaded)
+
+	require.NoError(t, os.WriteFile(fileName, []byte("[default]\nW = 1 jump\n"), 0644))
+	_, err = LoadFile(fileName, "abcd")
+	require.Error(t, err)
+}
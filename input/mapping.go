@@ -0,0 +1,173 @@
+// Package input 把主机的按键绑定到各个手柄的按键上
+package input
+
+import (
+	"fc-emulator/pad"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ports 可以绑定的手柄数量，对应Emu的Pad1-Pad4
+const Ports = 4
+
+// Binding 主机按键绑定的手柄按键
+type Binding struct {
+	Port   int // 0-3
+	Button pad.ButtonType
+	Turbo  bool
+}
+
+var buttonNames = map[pad.ButtonType]string{
+	pad.BUTTON_UP:     "up",
+	pad.BUTTON_DOWN:   "down",
+	pad.BUTTON_LEFT:   "left",
+	pad.BUTTON_RIGHT:  "right",
+	pad.BUTTON_SELECT: "select",
+	pad.BUTTON_START:  "start",
+	pad.BUTTON_B:      "b",
+	pad.BUTTON_A:      "a",
+}
+
+// ButtonName 按键的名字，例如 "a"、"up"
+func ButtonName(button pad.ButtonType) string {
+	return buttonNames[button]
+}
+
+func parseButton(s string) (pad.ButtonType, error) {
+	for button, name := range buttonNames {
+		if name == strings.ToLower(s) {
+			return button, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown button %q", s)
+}
+
+// String 配置文件中的格式: 手柄编号(从1开始)，可选的turbo，按键名，例如 "1 a"、"2 turbo b"
+func (b Binding) String() string {
+	if b.Turbo {
+		return fmt.Sprintf("%d turbo %s", b.Port+1, ButtonName(b.Button))
+	}
+	return fmt.Sprintf("%d %s", b.Port+1, ButtonName(b.Button))
+}
+
+// ParseBinding 解析Binding.String的格式
+func ParseBinding(s string) (Binding, error) {
+	fields := strings.Fields(s)
+	var b Binding
+	if len(fields) == 3 && strings.ToLower(fields[1]) == "turbo" {
+		b.Turbo = true
+		fields = []string{fields[0], fields[2]}
+	}
+	if len(fields) != 2 {
+		return b, fmt.Errorf("binding %q should be: port [turbo] button", s)
+	}
+	port, err := strconv.Atoi(fields[0])
+	if err != nil || port < 1 || port > Ports {
+		return b, fmt.Errorf("port %q should be 1-%d", fields[0], Ports)
+	}
+	b.Port = port - 1
+	b.Button, err = parseButton(fields[1])
+	return b, err
+}
+
+// Mapping 一套按键设置
+type Mapping struct {
+	Keys          map[string]Binding // 主机的按键名(和fyne的KeyName相同)到手柄按键
+	TurboRate     int                // 连发每秒按下的次数
+	AllowOpposite bool               // 允许同时按下左右或上下，TAS使用
+}
+
+// DefaultTurboRate 60帧每秒时和pad.DefaultTurboPeriod一样
+const DefaultTurboRate = 60 / pad.DefaultTurboPeriod
+
+// DefaultMapping 手柄1用WASD和JKUI，L、;是连发A、B；手柄2用方向键和附近的几个键。
+// 第1、2个游戏手柄(见joystick包)分别对应手柄1、2，按NES手柄的位置，右边的键是A，下面的键是B。
+func DefaultMapping() *Mapping {
+	m := &Mapping{
+		Keys: map[string]Binding{
+			"W": {0, pad.BUTTON_UP, false},
+			"S": {0, pad.BUTTON_DOWN, false},
+			"A": {0, pad.BUTTON_LEFT, false},
+			"D": {0, pad.BUTTON_RIGHT, false},
+			"J": {0, pad.BUTTON_A, false},
+			"K": {0, pad.BUTTON_B, false},
+			"U": {0, pad.BUTTON_SELECT, false},
+			"I": {0, pad.BUTTON_START, false},
+			"L": {0, pad.BUTTON_A, true},
+			";": {0, pad.BUTTON_B, true},
+
+			"Up":         {1, pad.BUTTON_UP, false},
+			"Down":       {1, pad.BUTTON_DOWN, false},
+			"Left":       {1, pad.BUTTON_LEFT, false},
+			"Right":      {1, pad.BUTTON_RIGHT, false},
+			".":          {1, pad.BUTTON_A, false},
+			",":          {1, pad.BUTTON_B, false},
+			"RightShift": {1, pad.BUTTON_SELECT, false},
+			"Return":     {1, pad.BUTTON_START, false},
+		},
+		TurboRate: DefaultTurboRate,
+	}
+	for port := 0; port < 2; port++ {
+		joy := fmt.Sprintf("Joy%d ", port+1)
+		m.Keys[joy+"Up"] = Binding{port, pad.BUTTON_UP, false}
+		m.Keys[joy+"Down"] = Binding{port, pad.BUTTON_DOWN, false}
+		m.Keys[joy+"Left"] = Binding{port, pad.BUTTON_LEFT, false}
+		m.Keys[joy+"Right"] = Binding{port, pad.BUTTON_RIGHT, false}
+		m.Keys[joy+"East"] = Binding{port, pad.BUTTON_A, false}
+		m.Keys[joy+"South"] = Binding{port, pad.BUTTON_B, false}
+		m.Keys[joy+"North"] = Binding{port, pad.BUTTON_A, true}
+		m.Keys[joy+"West"] = Binding{port, pad.BUTTON_B, true}
+		m.Keys[joy+"Select"] = Binding{port, pad.BUTTON_SELECT, false}
+		m.Keys[joy+"Start"] = Binding{port, pad.BUTTON_START, false}
+	}
+	return m
+}
+
+func (m *Mapping) Clone() *Mapping {
+	c := *m
+	c.Keys = make(map[string]Binding, len(m.Keys))
+	for k, v := range m.Keys {
+		c.Keys[k] = v
+	}
+	return &c
+}
+
+// TurboPeriod 按60帧每秒换算成连发一次的帧数
+func (m *Mapping) TurboPeriod() int {
+	if m.TurboRate <= 0 {
+		return pad.DefaultTurboPeriod
+	}
+	return 60 / m.TurboRate
+}
+
+// device 按键所在的设备，游戏手柄的按键名是 "Joy1 South" 这样的，空格前面是设备，键盘的按键名没有空格
+func device(key string) string {
+	if i := strings.Index(key, " "); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// Bind 把key绑定到b上，同一个设备上b原来绑定的按键都会解除，键盘和游戏手柄可以同时绑定
+func (m *Mapping) Bind(key string, b Binding) {
+	for k, v := range m.Keys {
+		if v == b && device(k) == device(key) {
+			delete(m.Keys, k)
+		}
+	}
+	m.Keys[key] = b
+}
+
+// KeysOf 绑定到b上的按键，按名字排序
+func (m *Mapping) KeysOf(b Binding) []string {
+	keys := make([]string, 0)
+	for k, v := range m.Keys {
+		if v == b {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,88 @@
+//go:build linux
+// +build linux
+
+package joystick
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl的请求号，见 linux/input.h
+func ioc(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'E'<<8 | nr
+}
+
+const iocRead = 2
+
+// ioctl 不用f.Fd()，它会把文件切换成阻塞模式，之后Close就不能打断正在进行的Read了
+func ioctl(f *os.File, req uintptr, buf []byte) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(&buf[0])))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func testBit(bits []byte, n int) bool {
+	return n/8 < len(bits) && bits[n/8]&(1<<(n%8)) != 0
+}
+
+// openDevice 打开evdev设备，有摇杆或者手柄按键的才当作手柄
+func openDevice(path string) (io.ReadCloser, DeviceInfo, error) {
+	info := DeviceInfo{Abs: map[uint16]AbsInfo{}}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, info, err
+	}
+	keys := make([]byte, KeyMax/8+1)
+	if err := ioctl(f, ioc(iocRead, 0x20+EvKey, uintptr(len(keys))), keys); err != nil {
+		f.Close()
+		return nil, info, fmt.Errorf("%w: %s is not an evdev device: %v", errNotJoystick, path, err)
+	}
+	isJoystick := false
+	for code := BtnJoystick; code < BtnGamepad+0x10; code++ {
+		isJoystick = isJoystick || testBit(keys, code)
+	}
+	if !isJoystick {
+		f.Close()
+		return nil, info, errNotJoystick
+	}
+
+	name := make([]byte, 256)
+	if err := ioctl(f, ioc(iocRead, 0x06, uintptr(len(name))), name); err == nil {
+		info.Name = strings.TrimRight(string(name), "\x00")
+	}
+	abs := make([]byte, AbsMax/8+1)
+	if err := ioctl(f, ioc(iocRead, 0x20+EvAbs, uintptr(len(abs))), abs); err == nil {
+		absinfo := make([]byte, 24) // struct input_absinfo: value, minimum, maximum, fuzz, flat, resolution
+		for code := 0; code <= AbsMax; code++ {
+			if !testBit(abs, code) {
+				continue
+			}
+			if err := ioctl(f, ioc(iocRead, 0x40+uintptr(code), uintptr(len(absinfo))), absinfo); err != nil {
+				continue
+			}
+			info.Abs[uint16(code)] = AbsInfo{
+				Min: int32(binary.LittleEndian.Uint32(absinfo[4:])),
+				Max: int32(binary.LittleEndian.Uint32(absinfo[8:])),
+			}
+		}
+	}
+	return f, info, nil
+}
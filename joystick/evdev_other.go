@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package joystick
+
+import (
+	"fmt"
+	"io"
+)
+
+// openDevice 只有Linux有evdev
+func openDevice(path string) (io.ReadCloser, DeviceInfo, error) {
+	return nil, DeviceInfo{}, fmt.Errorf("%w: joystick input is only supported on linux", errNotJoystick)
+}
@@ -0,0 +1,49 @@
+// Package joystick 直接读取Linux的 /dev/input/event* 设备，把手柄的按键、摇杆和十字键
+// 转换成按键名交给input包，和键盘的按键一样绑定到手柄上
+package joystick
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+)
+
+// Event evdev的struct input_event，去掉了时间
+type Event struct {
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// evdev的事件类型和用到的代码，见 linux/input-event-codes.h
+const (
+	EvSyn = 0x00
+	EvKey = 0x01
+	EvAbs = 0x03
+
+	AbsX     = 0x00
+	AbsY     = 0x01
+	AbsHat0X = 0x10
+	AbsHat0Y = 0x11
+	AbsMax   = 0x3F
+
+	BtnJoystick = 0x120 // 摇杆类设备的第一个按键
+	BtnGamepad  = 0x130 // 手柄类设备的第一个按键，也是BTN_SOUTH
+	KeyMax      = 0x2FF
+)
+
+// eventSize struct input_event的大小: struct timeval是两个long，然后是type、code、value
+var eventSize = 2*strconv.IntSize/8 + 8
+
+// readEvent 读一个事件，字节序按小端处理，Linux常见的平台都是小端
+func readEvent(r io.Reader, buf []byte) (Event, error) {
+	if _, err := io.ReadFull(r, buf[:eventSize]); err != nil {
+		return Event{}, err
+	}
+	data := buf[eventSize-8 : eventSize]
+	return Event{
+		Type:  binary.LittleEndian.Uint16(data[0:]),
+		Code:  binary.LittleEndian.Uint16(data[2:]),
+		Value: int32(binary.LittleEndian.Uint32(data[4:])),
+	}, nil
+}
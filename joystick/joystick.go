@@ -0,0 +1,164 @@
+package joystick
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// AbsInfo 轴的范围
+type AbsInfo struct {
+	Min, Max int32
+}
+
+// DeviceInfo 打开设备时读到的信息
+type DeviceInfo struct {
+	Name string
+	Abs  map[uint16]AbsInfo // 设备有的轴
+}
+
+// DefaultDeadzone 摇杆偏离中心不到一半行程的30%时当作没有按
+const DefaultDeadzone = 0.3
+
+// 设备没有报告范围时，摇杆按16位有符号数处理，十字键是-1到1
+var (
+	defaultStick = AbsInfo{-32768, 32767}
+	defaultHat   = AbsInfo{-1, 1}
+)
+
+// buttonNames 常见手柄按键的名字，其它按键叫 "Btn" 加上代码
+var buttonNames = map[uint16]string{
+	0x130: "South", 0x131: "East", 0x132: "C", 0x133: "North", 0x134: "West", 0x135: "Z",
+	0x136: "TL", 0x137: "TR", 0x138: "TL2", 0x139: "TR2",
+	0x13A: "Select", 0x13B: "Start", 0x13C: "Mode", 0x13D: "ThumbL", 0x13E: "ThumbR",
+	0x220: "DpadUp", 0x221: "DpadDown", 0x222: "DpadLeft", 0x223: "DpadRight",
+}
+
+func buttonName(code uint16) string {
+	if name, ok := buttonNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("Btn%d", code)
+}
+
+// 左摇杆和第一个十字键都当作方向键，按下时的名字是Up、Down、Left、Right
+const (
+	sourceStick = iota
+	sourceHat
+	sources
+)
+
+// Joystick 把一个设备的事件转换成按键名，名字是 "Joy" 加编号、空格、按键，例如 "Joy1 South"、"Joy1 Left"。
+// 方向键由摇杆和十字键共同决定，两个都回到中心时才松开。
+type Joystick struct {
+	Index    int // 从1开始
+	Info     DeviceInfo
+	Deadzone float64
+
+	mu   sync.Mutex
+	emit func(key string, pressed bool)
+	dirs [sources][2]int // 每个来源的x、y方向，-1、0、1
+	held map[string]bool // 已经按下的按键名
+}
+
+func NewJoystick(index int, info DeviceInfo, emit func(key string, pressed bool)) *Joystick {
+	return &Joystick{Index: index, Info: info, Deadzone: DefaultDeadzone, emit: emit,
+		held: map[string]bool{}}
+}
+
+// KeyName 这个设备上的按键名
+func (j *Joystick) KeyName(control string) string {
+	return fmt.Sprintf("Joy%d %s", j.Index, control)
+}
+
+func (j *Joystick) set(control string, pressed bool) {
+	key := j.KeyName(control)
+	if j.held[key] == pressed {
+		return
+	}
+	if pressed {
+		j.held[key] = true
+	} else {
+		delete(j.held, key)
+	}
+	j.emit(key, pressed)
+}
+
+// direction 按死区把轴的值换算成-1、0、1
+func (j *Joystick) direction(code uint16, value int32) int {
+	info, ok := j.Info.Abs[code]
+	if !ok {
+		info = defaultStick
+		if code >= AbsHat0X && code <= AbsHat0X+7 {
+			info = defaultHat
+		}
+	}
+	half := float64(info.Max-info.Min) / 2
+	if half <= 0 {
+		return 0
+	}
+	v := (float64(value) - float64(info.Min) - half) / half
+	switch {
+	case v < -j.Deadzone:
+		return -1
+	case v > j.Deadzone:
+		return 1
+	}
+	return 0
+}
+
+// Handle 处理一个事件
+func (j *Joystick) Handle(ev Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch ev.Type {
+	case EvKey:
+		if ev.Value == 2 { // 自动重复
+			return
+		}
+		j.set(buttonName(ev.Code), ev.Value != 0)
+	case EvAbs:
+		dir := j.direction(ev.Code, ev.Value)
+		switch ev.Code {
+		case AbsX, AbsY:
+			j.dirs[sourceStick][ev.Code-AbsX] = dir
+			j.updateDirections()
+		case AbsHat0X, AbsHat0Y:
+			j.dirs[sourceHat][ev.Code-AbsHat0X] = dir
+			j.updateDirections()
+		default:
+			j.set(fmt.Sprintf("Axis%d-", ev.Code), dir < 0)
+			j.set(fmt.Sprintf("Axis%d+", ev.Code), dir > 0)
+		}
+	}
+}
+
+func (j *Joystick) updateDirections() {
+	var left, right, up, down bool
+	for _, d := range j.dirs {
+		left = left || d[0] < 0
+		right = right || d[0] > 0
+		up = up || d[1] < 0
+		down = down || d[1] > 0
+	}
+	j.set("Left", left)
+	j.set("Right", right)
+	j.set("Up", up)
+	j.set("Down", down)
+}
+
+// Release 松开所有按下的键，设备拔出时调用
+func (j *Joystick) Release() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	keys := make([]string, 0, len(j.held))
+	for key := range j.held {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		delete(j.held, key)
+		j.emit(key, false)
+	}
+	j.dirs = [sources][2]int{}
+}
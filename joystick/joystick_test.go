@@ -0,0 +1,139 @@
+package joystick
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// encode 按struct input_event的格式编码，时间是0
+func encode(events ...Event) []byte {
+	res := make([]byte, 0, len(events)*eventSize)
+	for _, ev := range events {
+		buf := make([]byte, eventSize)
+		data := buf[eventSize-8:]
+		binary.LittleEndian.PutUint16(data[0:], ev.Type)
+		binary.LittleEndian.PutUint16(data[2:], ev.Code)
+		binary.LittleEndian.PutUint32(data[4:], uint32(ev.Value))
+		res = append(res, buf...)
+	}
+	return res
+}
+
+type recorder struct {
+	keys chan string
+}
+
+func newRecorder() *recorder {
+	return &recorder{keys: make(chan string, 100)}
+}
+
+func (r *recorder) emit(key string, pressed bool) {
+	if pressed {
+		r.keys <- "+" + key
+	} else {
+		r.keys <- "-" + key
+	}
+}
+
+// next 按顺序取出n个按键事件
+func (r *recorder) next(t *testing.T, n int) []string {
+	res := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case k := <-r.keys:
+			res = append(res, k)
+		case <-time.After(time.Second):
+			t.Fatalf("got %v, want %d events", res, n)
+		}
+	}
+	return res
+}
+
+func TestJoystick(t *testing.T) {
+	r := newRecorder()
+	j := NewJoystick(1, DeviceInfo{Abs: map[uint16]AbsInfo{AbsX: {0, 255}, AbsY: {0, 255}}}, r.emit)
+
+	j.Handle(Event{EvKey, 0x130, 1})
+	j.Handle(Event{EvKey, 0x130, 2}) // 自动重复
+	j.Handle(Event{EvKey, 0x130, 0})
+	j.Handle(Event{EvKey, 0x2C0, 1})
+	require.Equal(t, []string{"+Joy1 South", "-Joy1 South", "+Joy1 Btn704"}, r.next(t, 3))
+
+	// 死区内不算按下
+	j.Handle(Event{EvAbs, AbsX, 128 - 30})
+	j.Handle(Event{EvAbs, AbsX, 10})
+	j.Handle(Event{EvAbs, AbsY, 250})
+	require.Equal(t, []string{"+Joy1 Left", "+Joy1 Down"}, r.next(t, 2))
+
+	// 十字键和摇杆都回到中心才松开
+	j.Handle(Event{EvAbs, AbsHat0X, -1})
+	j.Handle(Event{EvAbs, AbsX, 128})
+	j.Handle(Event{EvAbs, AbsHat0X, 1})
+	require.Equal(t, []string{"-Joy1 Left", "+Joy1 Right"}, r.next(t, 2))
+
+	// 其它轴
+	j.Handle(Event{EvAbs, 0x02, 30000})
+	j.Handle(Event{EvAbs, 0x02, -30000})
+	require.Equal(t, []string{"+Joy1 Axis2+", "+Joy1 Axis2-", "-Joy1 Axis2+"}, r.next(t, 3))
+
+	j.Release()
+	require.Equal(t, []string{"-Joy1 Axis2-", "-Joy1 Btn704", "-Joy1 Down", "-Joy1 Right"}, r.next(t, 4))
+}
+
+func TestManager(t *testing.T) {
+	r := newRecorder()
+	dir, err := ioutil.TempDir("", "joystick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	m := NewManager()
+	m.Dir = dir // 不要读到真正的手柄
+	m.Start(r.emit)
+	defer m.Stop()
+
+	// 拔出的手柄的编号给下一个插入的手柄
+	pr1, pw1 := io.Pipe()
+	pr2, pw2 := io.Pipe()
+	m.Attach("event0", pr1, DeviceInfo{Name: "pad one"})
+	m.Attach("event1", pr2, DeviceInfo{Name: "pad two"})
+	require.Equal(t, []string{"Joy1: pad one", "Joy2: pad two"}, m.Devices())
+
+	_, err = pw2.Write(encode(Event{EvKey, 0x13B, 1}, Event{EvSyn, 0, 0}, Event{EvAbs, AbsHat0Y, -1}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"+Joy2 Start", "+Joy2 Up"}, r.next(t, 2))
+	pw2.CloseWithError(fmt.Errorf("no such device"))
+	require.Equal(t, []string{"-Joy2 Start", "-Joy2 Up"}, r.next(t, 2))
+
+	require.Eventually(t, func() bool { return len(m.Devices()) == 1 }, time.Second, time.Millisecond)
+	pr3, _ := io.Pipe()
+	m.Attach("event2", pr3, DeviceInfo{Name: "pad three"})
+	require.Equal(t, []string{"Joy1: pad one", "Joy2: pad three"}, m.Devices())
+	pw1.Close()
+}
+
+func TestScanIgnoresOtherDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "joystick")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// 普通文件不是evdev设备，不会当作手柄，也不会每次扫描都重新打开
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "event0"), []byte("not a device"), 0644))
+	m := NewManager()
+	m.Dir = dir
+	m.Scan()
+	require.Empty(t, m.Devices())
+	require.True(t, m.ignored[filepath.Join(dir, "event0")])
+
+	// 打不开的设备(还没有创建、没有权限)下次扫描时再试
+	require.NoError(t, os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "event1")))
+	m.Scan()
+	require.False(t, m.ignored[filepath.Join(dir, "event1")])
+	require.NoError(t, os.Remove(filepath.Join(dir, "event0")))
+	m.Scan()
+	require.Empty(t, m.ignored)
+}
@@ -0,0 +1,170 @@
+package joystick
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultDir evdev设备所在的目录
+const DefaultDir = "/dev/input"
+
+// errNotJoystick 设备不是手柄，例如键盘、鼠标
+var errNotJoystick = errors.New("not a joystick")
+
+// Manager 定时扫描设备目录，插入的手柄自动打开，拔出时松开它按下的所有键。
+// 手柄的编号从1开始，拔出之后空出来的编号给下一个插入的手柄。
+type Manager struct {
+	Dir          string
+	Deadzone     float64
+	PollInterval time.Duration
+
+	mu      sync.Mutex
+	handler func(key string, pressed bool)
+	devices map[string]*Joystick // 设备路径到手柄
+	closers map[string]io.Closer
+	ignored map[string]bool // 打开过但不是手柄的设备，打不开(例如没有权限)的下次扫描再试
+	stop    chan struct{}
+}
+
+func NewManager() *Manager {
+	return &Manager{Dir: DefaultDir, Deadzone: DefaultDeadzone, PollInterval: time.Second,
+		devices: map[string]*Joystick{}, closers: map[string]io.Closer{}, ignored: map[string]bool{}}
+}
+
+// Start 开始扫描，handler在读设备的goroutine中调用
+func (m *Manager) Start(handler func(key string, pressed bool)) {
+	m.mu.Lock()
+	m.handler = handler
+	m.stop = make(chan struct{})
+	stop := m.stop
+	m.mu.Unlock()
+	go func() {
+		t := time.NewTicker(m.PollInterval)
+		defer t.Stop()
+		for {
+			m.Scan()
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止扫描并关闭所有设备
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	closers := m.closers
+	m.closers = map[string]io.Closer{}
+	m.mu.Unlock()
+	for _, c := range closers {
+		c.Close()
+	}
+}
+
+// Scan 扫描一次设备目录，打开新插入的手柄
+func (m *Manager) Scan() {
+	paths, _ := filepath.Glob(filepath.Join(m.Dir, "event*"))
+	present := map[string]bool{}
+	for _, path := range paths {
+		present[path] = true
+		m.mu.Lock()
+		_, known := m.devices[path]
+		known = known || m.ignored[path]
+		m.mu.Unlock()
+		if known {
+			continue
+		}
+		r, info, err := openDevice(path)
+		if errors.Is(err, errNotJoystick) {
+			m.mu.Lock()
+			m.ignored[path] = true
+			m.mu.Unlock()
+		}
+		if err != nil {
+			continue
+		}
+		m.Attach(path, r, info)
+	}
+	// 拔出的设备的路径以后可能给别的设备用
+	m.mu.Lock()
+	for path := range m.ignored {
+		if !present[path] {
+			delete(m.ignored, path)
+		}
+	}
+	m.mu.Unlock()
+}
+
+// Attach 开始读一个设备的事件，读出错(设备拔出)时自动移除。测试时可以传入假的事件流。
+func (m *Manager) Attach(path string, r io.ReadCloser, info DeviceInfo) *Joystick {
+	m.mu.Lock()
+	used := map[int]bool{}
+	for _, j := range m.devices {
+		used[j.Index] = true
+	}
+	index := 1
+	for used[index] {
+		index++
+	}
+	j := NewJoystick(index, info, m.emit)
+	j.Deadzone = m.Deadzone
+	m.devices[path] = j
+	m.closers[path] = r
+	m.mu.Unlock()
+
+	go func() {
+		br := bufio.NewReader(r)
+		buf := make([]byte, eventSize)
+		for {
+			ev, err := readEvent(br, buf)
+			if err != nil {
+				break
+			}
+			j.Handle(ev)
+		}
+		r.Close()
+		j.Release()
+		m.mu.Lock()
+		delete(m.devices, path)
+		delete(m.closers, path)
+		m.mu.Unlock()
+	}()
+	return j
+}
+
+func (m *Manager) emit(key string, pressed bool) {
+	m.mu.Lock()
+	handler := m.handler
+	m.mu.Unlock()
+	if handler != nil {
+		handler(key, pressed)
+	}
+}
+
+// Devices 已经打开的手柄，例如 "Joy1: Xbox Wireless Controller"，按编号排序
+func (m *Manager) Devices() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	joysticks := make([]*Joystick, 0, len(m.devices))
+	for _, j := range m.devices {
+		joysticks = append(joysticks, j)
+	}
+	sort.Slice(joysticks, func(a, b int) bool { return joysticks[a].Index < joysticks[b].Index })
+	res := make([]string, 0, len(joysticks))
+	for _, j := range joysticks {
+		res = append(res, fmt.Sprintf("Joy%d: %s", j.Index, j.Info.Name))
+	}
+	return res
+}
@@ -1,30 +1,305 @@
 package main
 
 import (
+	"fc-emulator/apu"
+	"fc-emulator/cheat"
 	"fc-emulator/emu"
+	"fc-emulator/gdbstub"
+	"fc-emulator/input"
+	"fc-emulator/joystick"
+	"fc-emulator/movie"
+	"fc-emulator/nsf"
+	"fc-emulator/pad"
+	"fc-emulator/rom"
 	"fc-emulator/ui"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"strconv"
+	"strings"
 )
 
-var nesFileName = flag.String("nes", "./static/balloon.nes", "nes file path")
+var nesFileName = flag.String("nes", "./static/balloon.nes", "nes, unif, fds or nsf/nsfe file path")
+var fdsBios = flag.String("fds-bios", "", "fds bios file, default is disksys.rom in the directory of the disk image")
+var debugAddr = flag.String("debug-http", "", "serve the debugger http api on this address, e.g. 127.0.0.1:6502")
+var gdbAddr = flag.String("gdb", "", "serve the gdb remote protocol on this address, e.g. 127.0.0.1:2345")
+var recordFile = flag.String("record", "", "record a fm2 movie from power on, saved when the window is closed")
+var playFile = flag.String("play", "", "play back a fm2 movie")
+var cheatFile = flag.String("cheats", "", "cheat file, cheats of the loaded rom are enabled")
+var ramPolicy = flag.String("ram-policy", "zero", "power-on ram content: zero, ff, pattern or random")
+var port2 = flag.String("port2", "pad", "device plugged into port 2: pad, zapper, arkanoid or powerpad. "+
+	"The zapper and arkanoid are controlled with the mouse on the game screen")
+var expansion = flag.String("expansion", "none", "famicom expansion port device: none, arkanoid, familytrainer or keyboard")
+var keyboardLayout = flag.String("keyboard-layout", "", "family basic keyboard keys overriding the default, e.g. F9=STOP,Tab=KANA")
+var tapeFile = flag.String("tape", "", "wav file loaded into the family basic data recorder")
+var powerPadLayout = flag.String("powerpad-layout", "", "power pad keys, e.g. 1=Q,2=W,3=E,4=R,5=A,...,12=V")
+var fourPlayer = flag.String("four-player", "none", "four player adapter: none, nes (Four Score) or famicom (expansion port)")
+var inputFile = flag.String("input", "input.cfg", "key bindings, with a [default] section and optional per-rom sections")
+var joystickEnabled = flag.Bool("joystick", true, "read gamepads from /dev/input/event* (linux only), hot-plug is supported")
+var joystickDeadzone = flag.Float64("joystick-deadzone", joystick.DefaultDeadzone, "stick deadzone, a fraction of the half range")
+var screenshotDir = flag.String("screenshot-dir", "", "directory of the png screenshots taken with F12, default is the current directory")
+var screenshotCrop = flag.Bool("screenshot-crop", false, "crop the top and bottom 8 overscan lines from screenshots")
+var screenshotScale = flag.Int("screenshot-scale", 1, "integer scale of screenshots, pixels are never interpolated")
+var videoFile = flag.String("video", "", "record video from power on, the format is chosen by the extension: "+
+	".gif, .png/.apng or .y4m, audio is written to a .wav file with the same base name")
+var videoDir = flag.String("video-dir", "", "directory of the videos recorded with F9 (start) and F10 (stop), default is the current directory")
+var videoFormat = flag.String("video-format", ".gif", "format of the videos recorded with F9: .gif, .png, .apng or .y4m")
+var videoCrop = flag.Bool("video-crop", false, "crop the top and bottom 8 overscan lines from videos")
+var videoScale = flag.Int("video-scale", 1, "integer scale of videos, pixels are never interpolated")
+var headless = flag.Bool("headless", false, "run as fast as possible without a window, e.g. to record a video of a movie")
+var frames = flag.Int("frames", 0, "number of frames to run in headless mode, 0 means until the movie given with -play ends")
+var videoFrames = flag.String("video-frames", "", "frames recorded to -video in headless mode, e.g. 100-400 or 100-, counted from 1")
+var romDBFile = flag.String("romdb", "", "rom database file in the format of rom/romdb.txt, e.g. generated from NesCartDB with cmd/romdbgen")
+var seed = flag.Int64("seed", 0, "seed of the random ram policy, 0 means a different seed every power on")
+var audioCmd = flag.String("audio", apu.DefaultPlayCommand, "command playing 16-bit mono pcm from stdin, "+
+	"{rate} is replaced by the sample rate. Empty disables sound")
 
-func setupEmulator() *emu.Emu {
+// stringList 可以重复指定的参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+var patchFiles stringList
+
+func parseFlags() {
+	flag.Var(&patchFiles, "patch", "ips/ups/bps patch applied in memory, can be repeated to stack patches. "+
+		"If not given, patches with the same base name as the rom are used")
 	flag.Parse()
 	if nesFileName == nil || len(*nesFileName) == 0 {
 		log.Fatal("please specific nes file path")
 	}
-	emulator := emu.NewEmu(&emu.EmuOpt{Debug: false})
-	err := emulator.Load(*nesFileName)
+}
+
+// loadNSF fileName是NSF或NSFe时返回解析的结果，其他文件返回nil，交给模拟器加载
+func loadNSF(fileName string) *nsf.File {
+	data, err := rom.ReadRomFile(fileName)
+	if err != nil || !nsf.IsFile(data) {
+		return nil
+	}
+	f, err := nsf.Parse(data)
+	if err != nil {
+		log.Fatal("load nsf fail: ", err)
+	}
+	return f
+}
+
+// openAudio 启动-audio的播放程序，没有设置或者启动失败时返回nil，这时没有声音
+func openAudio() *apu.CommandSink {
+	if len(*audioCmd) == 0 {
+		return nil
+	}
+	sink, err := apu.NewCommandSink(*audioCmd, apu.DefaultSampleRate)
+	if err != nil {
+		log.Println("start audio fail: ", err)
+		return nil
+	}
+	return sink
+}
+
+// playNSF NSF没有画面，打开选曲的窗口，声音从-audio播放
+func playNSF(f *nsf.File) {
+	sink := openAudio()
+	if sink == nil {
+		log.Fatal("playing nsf needs a working -audio command")
+	}
+	box, err := nsf.NewJukebox(nsf.NewPlayer(f, apu.DefaultSampleRate))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ui.NewNSFWin(box, sink, &ui.UIConfig{Width: 480, Height: 400}).ShowAndRun()
+	if err := sink.Close(); err != nil {
+		log.Println("stop audio fail: ", err)
+	}
+}
+
+func setupEmulator() *emu.Emu {
+	if len(*romDBFile) > 0 {
+		if err := rom.LoadDB(*romDBFile); err != nil {
+			log.Fatal("load rom database fail: ", err)
+		}
+	}
+	policy, err := emu.ParseRamPolicy(*ramPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fourPlayerMode, err := emu.ParseFourPlayerMode(*fourPlayer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	port2Device, err := emu.ParseDevice(*port2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	expansionDevice, err := emu.ParseExpansion(*expansion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var layout map[string]int
+	if len(*powerPadLayout) > 0 {
+		layout, err = pad.ParsePowerPadLayout(*powerPadLayout)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var keys map[string]string
+	if len(*keyboardLayout) > 0 {
+		keys, err = pad.ParseKeyboardLayout(*keyboardLayout)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	emulator := emu.NewEmu(&emu.EmuOpt{Debug: false, RamPolicy: policy, Seed: *seed, Patches: patchFiles,
+		FdsBios: *fdsBios, Port2: port2Device, Expansion: expansionDevice, FourPlayer: fourPlayerMode,
+		PowerPadLayout: layout, KeyboardLayout: keys,
+		Screenshot: emu.ScreenshotOpt{CropOverscan: *screenshotCrop, Scale: *screenshotScale},
+		Video:      emu.ScreenshotOpt{CropOverscan: *videoCrop, Scale: *videoScale}})
+	err = emulator.Load(*nesFileName)
 	if err != nil {
 		log.Fatal("load nes file fail: ", err)
 	}
+	if len(*tapeFile) > 0 {
+		if err := emulator.LoadTape(*tapeFile); err != nil {
+			log.Fatal("load tape fail: ", err)
+		}
+	}
+	if len(*cheatFile) > 0 {
+		cheats, err := cheat.LoadFile(*cheatFile, emulator.Rom.Hash())
+		if err != nil {
+			log.Fatal("load cheats fail: ", err)
+		}
+		emulator.Cheats.Set(cheats)
+	}
+	if len(*playFile) > 0 {
+		m, err := movie.Load(*playFile)
+		if err != nil {
+			log.Fatal("load movie fail: ", err)
+		}
+		if err := emulator.PlayMovie(m); err != nil {
+			log.Fatal(err)
+		}
+	} else if len(*recordFile) > 0 {
+		if err := emulator.StartRecording(); err != nil {
+			log.Fatal(err)
+		}
+	}
 	return emulator
 }
 
+// parseFrameRange 解析 "100-400"、"100-"，空字符串表示全部，last为0表示直到最后
+func parseFrameRange(s string) (first, last int, err error) {
+	if s == "" {
+		return 1, 0, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("frame range %q should be first-last", s)
+	}
+	if first, err = strconv.Atoi(parts[0]); err != nil || first < 1 {
+		return 0, 0, fmt.Errorf("first frame %q should be a number from 1", parts[0])
+	}
+	if parts[1] != "" {
+		if last, err = strconv.Atoi(parts[1]); err != nil || last < first {
+			return 0, 0, fmt.Errorf("last frame %q should be a number not less than %d", parts[1], first)
+		}
+	}
+	return first, last, nil
+}
+
+// runHeadless 不打开窗口，不等待，运行-frames帧，或者直到录像回放完，-video-frames中的帧写到-video
+func runHeadless(emulator *emu.Emu) {
+	first, last, err := parseFrameRange(*videoFrames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(*videoFrames) > 0 && len(*videoFile) == 0 {
+		log.Fatal("-video-frames needs -video")
+	}
+	total := *frames
+	if total == 0 && !emulator.IsPlaying() {
+		log.Fatal("headless mode needs -frames or -play")
+	}
+	for frame := 1; total == 0 || frame <= total; frame++ {
+		if total == 0 && !emulator.IsPlaying() {
+			break
+		}
+		if len(*videoFile) > 0 && frame == first {
+			if err := emulator.StartVideo(*videoFile); err != nil {
+				log.Fatal("start video fail: ", err)
+			}
+		}
+		emulator.RunFrame()
+		if frame == last {
+			break
+		}
+	}
+}
+
 func main() {
+	parseFlags()
+	if f := loadNSF(*nesFileName); f != nil {
+		playNSF(f)
+		return
+	}
 	emulator := setupEmulator()
-	win := ui.NewUIWin(emulator, &ui.UIConfig{Width: 480, Height: 400})
+	defer func() {
+		if !emulator.IsRecordingVideo() {
+			return
+		}
+		if err := emulator.StopVideo(); err != nil {
+			log.Fatal("save video fail: ", err)
+		}
+		log.Println("video saved")
+	}()
+	defer func() {
+		if m := emulator.StopRecording(); m != nil {
+			if err := m.Save(*recordFile); err != nil {
+				log.Fatal("save movie fail: ", err)
+			}
+		}
+	}()
+	if *headless {
+		runHeadless(emulator)
+		return
+	}
+	if len(*debugAddr) > 0 {
+		go func() {
+			log.Fatal(http.ListenAndServe(*debugAddr, emulator.Debugger.Handler()))
+		}()
+	}
+	if len(*gdbAddr) > 0 {
+		go func() {
+			log.Fatal(gdbstub.ListenAndServe(*gdbAddr, emulator.Debugger))
+		}()
+	}
+	mapping, err := input.LoadFile(*inputFile, emulator.Rom.Hash())
+	if err != nil {
+		log.Fatal("load input config fail: ", err)
+	}
+	in := input.New(mapping, emulator.Pads())
+	var joysticks *joystick.Manager
+	if *joystickEnabled {
+		joysticks = joystick.NewManager()
+		joysticks.Deadzone = *joystickDeadzone
+		defer joysticks.Stop()
+	}
+	win := ui.NewUIWin(emulator, &ui.UIConfig{Width: 480, Height: 400, Input: in, InputFile: *inputFile,
+		Joysticks: joysticks, ScreenshotDir: *screenshotDir, VideoDir: *videoDir, VideoFormat: *videoFormat})
+	if len(*videoFile) > 0 {
+		if err := emulator.StartVideo(*videoFile); err != nil {
+			log.Fatal("start video fail: ", err)
+		}
+	}
+	if sink := openAudio(); sink != nil {
+		emulator.AudioSink = sink
+	}
 	go func() {
 		emulator.Start()
 	}()
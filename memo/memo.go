@@ -1,6 +1,7 @@
 package memo
 
 import (
+	"fc-emulator/apu"
 	"fc-emulator/pad"
 	"fc-emulator/ppu"
 	"fc-emulator/rom"
@@ -12,33 +13,69 @@ type Memo interface {
 	Read(addr uint16) byte
 	ReadWord(addr uint16) uint16
 	Write(addr uint16, val byte)
+	// Peek 返回Read会读到的值，但是不会产生任何副作用，给调试器和内存查看器使用
+	Peek(addr uint16) byte
+	// Poke 直接修改地址上的值，不会触发写寄存器的副作用，ROM区域会直接修改ROM的数据
+	Poke(addr uint16, val byte)
+}
+
+// RomPatcher 在CPU读取$8000-$FFFF时修改读到的值，金手指使用
+type RomPatcher interface {
+	PatchRead(addr uint16, val byte) byte
+}
+
+// Cartridge 接管$4020-$FFFF的访问，用于FDS这种不是普通卡带的设备
+type Cartridge interface {
+	Read(addr uint16) byte
+	Peek(addr uint16) byte
+	Write(addr uint16, val byte)
+	Poke(addr uint16, val byte)
 }
 
 type DefaultMemo struct {
-	Ram     [2 * utils.Kb]byte
-	Trainer []byte
-	PrgRom  []byte
-	ppu     ppu.PPU
-	pad1    pad.Pad
-	pad2    pad.Pad
+	Ram       [2 * utils.Kb]byte
+	Trainer   []byte
+	PrgRom    []byte
+	prgMirror bool // 16k的PrgRom被复制了一份，修改时两份都要改
+	ppu       ppu.PPU
+	Ports     pad.Ports // $4016/$4017后面接的输入设备
+	Patcher   RomPatcher
+	Cart      Cartridge
+	APU       *apu.APU // $4000-$4013、$4015、$4017的声音寄存器，nil时忽略
 }
 
-func NewMemo(rom *rom.NesRom, _ppu ppu.PPU, pad1, pad2 pad.Pad) Memo {
+func NewMemo(rom *rom.NesRom, _ppu ppu.PPU, pad1, pad2 pad.InputDevice) Memo {
 	prgRom := rom.PrgRom
-	if len(rom.PrgRom) == 16*utils.Kb { // Mapper0 Prg Mirror
+	prgMirror := len(rom.PrgRom) == 16*utils.Kb
+	if prgMirror { // Mapper0 Prg Mirror
 		prgRom = append(rom.PrgRom, rom.PrgRom...)
 	}
 	memo := &DefaultMemo{
-		Ram:     [2 * utils.Kb]byte{},
-		Trainer: rom.Trainer,
-		PrgRom:  prgRom,
-		ppu:     _ppu,
-		pad1:    pad1,
-		pad2:    pad2,
+		Ram:       [2 * utils.Kb]byte{},
+		Trainer:   rom.Trainer,
+		PrgRom:    prgRom,
+		prgMirror: prgMirror,
+		ppu:       _ppu,
+		Ports:     pad.NewPorts(pad1, pad2),
 	}
 	return memo
 }
 
+// NewMemoWithCartridge $4020以后的地址都交给cart处理
+func NewMemoWithCartridge(cart Cartridge, _ppu ppu.PPU, pad1, pad2 pad.InputDevice) *DefaultMemo {
+	return &DefaultMemo{
+		Ram:   [2 * utils.Kb]byte{},
+		ppu:   _ppu,
+		Ports: pad.NewPorts(pad1, pad2),
+		Cart:  cart,
+	}
+}
+
+// PowerOn 清空内部RAM
+func (m *DefaultMemo) PowerOn() {
+	m.Ram = [2 * utils.Kb]byte{}
+}
+
 func (m *DefaultMemo) Read(addr uint16) byte {
 	addr = m.handleMirror(addr)
 	if between(addr, 0, 0x07FF) { // 2k RAM
@@ -49,24 +86,75 @@ func (m *DefaultMemo) Read(addr uint16) byte {
 		return 0
 	} else if addr == 0x4014 {
 		return m.ppu.ReadForCPU(addr)
-	} else if addr == 0x4016 {
-		return m.pad1.ReadForCPU()
-	} else if addr == 0x4017 {
-		return m.pad2.ReadForCPU()
+	} else if addr == 0x4015 && m.APU != nil {
+		return m.APU.Read(addr)
+	} else if addr == 0x4016 || addr == 0x4017 {
+		return m.Ports.Read(int(addr - 0x4016))
+	} else if m.Cart != nil && addr >= 0x4020 {
+		return m.patch(addr, m.Cart.Read(addr))
 	} else if between(addr, 0x4015, 0x5fff) {
 		// some io register and expansion Rom
 		return 0
 	} else if between(addr, 0x7000, 0x71FF) {
 		// Trainer, SRAM, 带电池的RAM
-		return m.Trainer[addr]
+		return m.Trainer[addr-0x7000]
 	} else if between(addr, 0x8000, 0xffff) {
-		addr -= 0x8000
-		return m.PrgRom[addr]
+		return m.readPrg(addr)
 	} else {
 		panic(fmt.Sprintf("Read Wrong Data Addr: %X", addr))
 	}
 }
 
+func (m *DefaultMemo) Peek(addr uint16) byte {
+	addr = m.handleMirror(addr)
+	if between(addr, 0, 0x07FF) {
+		return m.Ram[addr]
+	} else if between(addr, 0x2000, 0x3FFF) || addr == 0x4014 {
+		return m.ppu.PeekForCPU(addr)
+	} else if addr == 0x4015 && m.APU != nil {
+		return m.APU.Peek(addr)
+	} else if addr == 0x4016 || addr == 0x4017 {
+		return m.Ports.Peek(int(addr - 0x4016))
+	} else if m.Cart != nil && addr >= 0x4020 {
+		return m.patch(addr, m.Cart.Peek(addr))
+	} else if between(addr, 0x7000, 0x71FF) {
+		return m.Trainer[addr-0x7000]
+	} else if between(addr, 0x8000, 0xffff) {
+		return m.readPrg(addr)
+	}
+	return 0
+}
+
+func (m *DefaultMemo) readPrg(addr uint16) byte {
+	return m.patch(addr, m.PrgRom[addr-0x8000])
+}
+
+func (m *DefaultMemo) patch(addr uint16, val byte) byte {
+	if m.Patcher != nil && addr >= 0x8000 {
+		val = m.Patcher.PatchRead(addr, val)
+	}
+	return val
+}
+
+func (m *DefaultMemo) Poke(addr uint16, val byte) {
+	addr = m.handleMirror(addr)
+	if between(addr, 0, 0x07FF) {
+		m.Ram[addr] = val
+	} else if between(addr, 0x2000, 0x3FFF) || addr == 0x4014 {
+		m.ppu.PokeForCPU(addr, val)
+	} else if m.Cart != nil && addr >= 0x4020 {
+		m.Cart.Poke(addr, val)
+	} else if between(addr, 0x7000, 0x71FF) {
+		m.Trainer[addr-0x7000] = val
+	} else if between(addr, 0x8000, 0xffff) {
+		addr -= 0x8000
+		m.PrgRom[addr] = val
+		if m.prgMirror {
+			m.PrgRom[addr^0x4000] = val
+		}
+	}
+}
+
 func (m *DefaultMemo) ReadWord(addr uint16) uint16 {
 	byte1 := m.Read(addr)
 	byte2 := m.Read(addr + 1)
@@ -79,17 +167,17 @@ func (m *DefaultMemo) Write(addr uint16, val byte) {
 		m.Ram[addr] = val
 	} else if between(addr, 0x2000, 0x3FFF) { // ppu register
 		m.ppu.WriteForCPU(addr, val)
-	} else if between(addr, 0x4000, 0x4013) {
-		// io register
+	} else if between(addr, 0x4000, 0x4013) || addr == 0x4015 || addr == 0x4017 {
+		m.writeAPU(addr, val)
 	} else if addr == 0x4014 {
 		// DMA直写，把整个PAGE的地址写进OAM
 		pageNo := uint16(val)
 		left := pageNo * 256
 		m.ppu.SetOAM(m.copyRam(left, left+256))
-	} else if addr == 0x4016 {
-		m.pad1.WriteForCPU(val)
-	} else if addr == 0x4017 {
-		m.pad2.WriteForCPU(val)
+	} else if addr == 0x4016 { // OUT0同时送到两个口，写$4017是APU的帧计数器
+		m.Ports.Write(val)
+	} else if m.Cart != nil && addr >= 0x4020 {
+		m.Cart.Write(addr, val)
 	} else if between(addr, 0x4015, 0x5fff) {
 		// some io register and expansion Rom
 	} else {
@@ -97,6 +185,12 @@ func (m *DefaultMemo) Write(addr uint16, val byte) {
 	}
 }
 
+func (m *DefaultMemo) writeAPU(addr uint16, val byte) {
+	if m.APU != nil {
+		m.APU.Write(addr, val)
+	}
+}
+
 func (m *DefaultMemo) copyRam(begin, end uint16) []byte {
 	res := make([]byte, 0, end-begin)
 	for begin < end {
@@ -0,0 +1,87 @@
+package memo
+
+import (
+	"fc-emulator/apu"
+	"fc-emulator/pad"
+	"fc-emulator/ppu"
+	"fc-emulator/rom"
+	"fc-emulator/utils"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// 16k PrgRom 的Mapper0镜像
+func newTestMemo(t *testing.T) (*DefaultMemo, ppu.PPU, pad.Pad) {
+	image := []byte{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	image = append(image, make([]byte, 16*utils.Kb+8*utils.Kb)...)
+	nesRom, err := rom.ParseBytes(image)
+	require.NoError(t, err)
+	p := ppu.NewPPU(nesRom)
+	pad1 := pad.NewPad()
+	return NewMemo(nesRom, p, pad1, pad.NewPad()).(*DefaultMemo), p, pad1
+}
+
+func TestPeekHasNoSideEffect(t *testing.T) {
+	m, _, pad1 := newTestMemo(t)
+
+	// $2002: 读取会清除vblank标志
+	require.Equal(t, byte(0x80), m.Peek(0x2002)&0x80)
+	require.Equal(t, byte(0x80), m.Peek(0x200A)&0x80) // mirror
+	require.Equal(t, byte(0x80), m.Read(0x2002)&0x80)
+	require.Equal(t, byte(0x00), m.Peek(0x2002)&0x80)
+
+	// $2007: 读取会移动PPUADDR
+	m.Write(0x2006, 0x3F)
+	m.Write(0x2006, 0x01)
+	m.Poke(0x2007, 0x21)
+	require.Equal(t, byte(0x21), m.Peek(0x2007))
+	require.Equal(t, byte(0x21), m.Read(0x2007))
+	require.NotEqual(t, byte(0x21), m.Peek(0x2007))
+
+	// $4016: 读取会移动到下一个按键
+	pad1.UpdateButton(pad.BUTTON_B, true)
+	m.Write(0x4016, 1)
+	m.Write(0x4016, 0)
+	require.Equal(t, byte(0), m.Peek(0x4016))
+	require.Equal(t, byte(0), m.Read(0x4016))
+	require.Equal(t, byte(1), m.Peek(0x4016))
+	require.Equal(t, byte(1), m.Read(0x4016))
+}
+
+func TestPoke(t *testing.T) {
+	m, p, _ := newTestMemo(t)
+	m.Poke(0x0801, 0x42)
+	require.Equal(t, byte(0x42), m.Read(0x0001))
+
+	// 16k PrgRom 的两份镜像都要修改
+	m.Poke(0x8010, 0xEA)
+	require.Equal(t, byte(0xEA), m.Peek(0x8010))
+	require.Equal(t, byte(0xEA), m.Peek(0xC010))
+
+	// Poke PPUCTRL 不会像写寄存器那样打印日志或者产生别的影响，只修改值
+	m.Poke(0x2000, 0x80)
+	require.True(t, p.CanInterrupt())
+	m.Poke(0x7000, 0x12)
+	require.Equal(t, byte(0x12), m.Read(0x7000))
+}
+
+func TestAPURegisters(t *testing.T) {
+	m, _, _ := newTestMemo(t)
+	m.Write(0x4015, 0x01)
+	m.Write(0x4003, 0x08)
+	require.Equal(t, byte(0), m.Read(0x4015)) // 没有APU时忽略
+
+	m.APU = apu.New(m.Read)
+	m.Write(0x4015, 0x01)
+	m.Write(0x4003, 0x08) // 方波1的长度计数器不为0
+	require.Equal(t, byte(0x01), m.Peek(0x4015))
+	require.Equal(t, byte(0x01), m.Read(0x4015))
+
+	// $4017 4步模式，允许帧中断，读$4015清除帧中断
+	m.Write(0x4017, 0x00)
+	m.APU.Clock(29830)
+	require.True(t, m.APU.IRQ())
+	require.Equal(t, byte(0x40), m.Peek(0x4015)&0x40)
+	m.Read(0x4015)
+	require.False(t, m.APU.IRQ())
+}
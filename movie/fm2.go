@@ -0,0 +1,290 @@
+// Package movie 读写FCEUX的FM2录像文件，格式见 https://fceux.com/web/FM2.html
+package movie
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fc-emulator/rom"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Command 是每一帧开始时执行的命令
+type Command byte
+
+const (
+	SoftReset Command = 1 << 0
+	PowerOn   Command = 1 << 1 // FM2中叫hard reset
+)
+
+// buttonChars 手柄按键在FM2中的写法，顺序和pad.Buttons一致
+const buttonChars = "RLDUTSBA"
+
+// Frame 一帧的输入，手柄状态的每一位和pad.ButtonType一致
+type Frame struct {
+	Commands Command
+	Pad1     byte
+	Pad2     byte
+	Pad3     byte // 只有FourScore的录像才有手柄3、4
+	Pad4     byte
+}
+
+type Movie struct {
+	Version       int
+	EmuVersion    int
+	RerecordCount int
+	PAL           bool
+	RomFilename   string
+	RomChecksum   string // base64:md5
+	GUID          string
+	FourScore     bool // 是否接了NES的Four Score，这时每一帧记录四个手柄
+	Comments      []string
+	Frames        []Frame
+}
+
+// New 创建一个用于录制nesRom的空录像
+func New(nesRom *rom.NesRom, romFilename string) *Movie {
+	return &Movie{
+		Version:     3,
+		EmuVersion:  22020,
+		RomFilename: romFilename,
+		RomChecksum: RomChecksum(nesRom),
+		GUID:        newGUID(),
+	}
+}
+
+// RomChecksum 计算PRG和CHR的md5，和FCEUX的写法一样
+func RomChecksum(nesRom *rom.NesRom) string {
+	h := md5.New()
+	h.Write(nesRom.PrgRom)
+	h.Write(nesRom.ChrRom)
+	return "base64:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func newGUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func Load(fileName string) (*Movie, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func (m *Movie) Save(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Parse 解析文本格式的FM2，不支持二进制格式的输入记录
+func Parse(r io.Reader) (*Movie, error) {
+	m := &Movie{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo += 1
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '|' {
+			frame, err := parseFrame(line, m.FourScore)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			m.Frames = append(m.Frames, frame)
+			continue
+		}
+		key, value := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			key, value = line[:i], line[i+1:]
+		}
+		if err := m.setHeader(key, value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.Version == 0 {
+		return nil, errors.New("not a fm2 file: missing version")
+	}
+	return m, nil
+}
+
+func (m *Movie) setHeader(key, value string) error {
+	atoi := func() (int, error) {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("bad %s %q", key, value)
+		}
+		return v, nil
+	}
+	var err error
+	switch key {
+	case "version":
+		m.Version, err = atoi()
+	case "emuVersion":
+		m.EmuVersion, err = atoi()
+	case "rerecordCount":
+		m.RerecordCount, err = atoi()
+	case "palFlag":
+		var v int
+		v, err = atoi()
+		m.PAL = v != 0
+	case "romFilename":
+		m.RomFilename = value
+	case "romChecksum":
+		m.RomChecksum = value
+	case "guid":
+		m.GUID = value
+	case "comment":
+		m.Comments = append(m.Comments, value)
+	case "binary":
+		if value != "0" && value != "false" {
+			return errors.New("binary fm2 is not supported")
+		}
+	case "fourscore":
+		if value != "0" && value != "1" {
+			return fmt.Errorf("%s %s is not supported", key, value)
+		}
+		m.FourScore = value == "1"
+	case "port2":
+		if value != "0" {
+			return fmt.Errorf("%s %s is not supported", key, value)
+		}
+	case "port0", "port1":
+		if value != "0" && value != "1" { // 0: 没有设备 1: 标准手柄
+			return fmt.Errorf("%s %s is not supported", key, value)
+		}
+	default:
+		// subtitle、savestate等其它字段，回放时用不到
+	}
+	return err
+}
+
+// parseFrame 解析 |commands|port0|port1|port2| 格式的一行，
+// 接了Four Score时是 |commands|pad1|pad2|pad3|pad4|port2|
+func parseFrame(line string, fourScore bool) (Frame, error) {
+	fields := strings.Split(line, "|")
+	pads := 2
+	if fourScore {
+		pads = 4
+	}
+	if len(fields) < pads+3 {
+		return Frame{}, fmt.Errorf("bad input log %q", line)
+	}
+	var frame Frame
+	commands, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Frame{}, fmt.Errorf("bad commands %q", fields[1])
+	}
+	frame.Commands = Command(commands)
+	states := []*byte{&frame.Pad1, &frame.Pad2, &frame.Pad3, &frame.Pad4}
+	for i := 0; i < pads; i++ {
+		if *states[i], err = parsePad(fields[i+2]); err != nil {
+			return Frame{}, err
+		}
+	}
+	return frame, nil
+}
+
+func parsePad(s string) (byte, error) {
+	if len(s) == 0 { // 这个端口没有设备
+		return 0, nil
+	}
+	if len(s) != len(buttonChars) {
+		return 0, fmt.Errorf("bad gamepad %q", s)
+	}
+	var state byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '.' && s[i] != ' ' {
+			state |= 0x80 >> i
+		}
+	}
+	return state, nil
+}
+
+func formatPad(state byte) string {
+	res := []byte(strings.Repeat(".", len(buttonChars)))
+	for i := range res {
+		if state&(0x80>>i) != 0 {
+			res[i] = buttonChars[i]
+		}
+	}
+	return string(res)
+}
+
+func (m *Movie) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	pal, fourScore := 0, 0
+	if m.PAL {
+		pal = 1
+	}
+	if m.FourScore {
+		fourScore = 1
+	}
+	fmt.Fprintf(bw, "version %d\n", m.Version)
+	fmt.Fprintf(bw, "emuVersion %d\n", m.EmuVersion)
+	fmt.Fprintf(bw, "rerecordCount %d\n", m.RerecordCount)
+	fmt.Fprintf(bw, "palFlag %d\n", pal)
+	fmt.Fprintf(bw, "romFilename %s\n", m.RomFilename)
+	fmt.Fprintf(bw, "romChecksum %s\n", m.RomChecksum)
+	fmt.Fprintf(bw, "guid %s\n", m.GUID)
+	fmt.Fprintf(bw, "fourscore %d\nmicrophone 0\nport0 1\nport1 1\nport2 0\nFDS 0\nNewPPU 0\n", fourScore)
+	for _, comment := range m.Comments {
+		fmt.Fprintf(bw, "comment %s\n", comment)
+	}
+	for _, frame := range m.Frames {
+		if m.FourScore {
+			fmt.Fprintf(bw, "|%d|%s|%s|%s|%s||\n", frame.Commands, formatPad(frame.Pad1), formatPad(frame.Pad2),
+				formatPad(frame.Pad3), formatPad(frame.Pad4))
+		} else {
+			fmt.Fprintf(bw, "|%d|%s|%s||\n", frame.Commands, formatPad(frame.Pad1), formatPad(frame.Pad2))
+		}
+	}
+	return bw.Flush()
+}
+
+// Player 按顺序取出每一帧的输入
+type Player struct {
+	movie *Movie
+	frame int
+}
+
+func NewPlayer(m *Movie) *Player {
+	return &Player{movie: m}
+}
+
+// Next 返回下一帧的输入，录像结束时返回false
+func (p *Player) Next() (Frame, bool) {
+	if p.frame >= len(p.movie.Frames) {
+		return Frame{}, false
+	}
+	frame := p.movie.Frames[p.frame]
+	p.frame += 1
+	return frame, true
+}
+
+// Frame 返回已经回放的帧数
+func (p *Player) Frame() int {
+	return p.frame
+}
@@ -0,0 +1,67 @@
+package movie
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const testFM2 = `version 3
+emuVersion 22020
+rerecordCount 7
+palFlag 0
+romFilename smb
+romChecksum base64:jjYwGG411HcjG/j9UOVM3Q==
+guid 452DE2C3-EF43-2FA9-77AC-0677FC51543B
+fourscore 0
+microphone 0
+port0 1
+port1 1
+port2 0
+comment author someone
+|0|........|........||
+|2|R......A|........||
+|1|.L..T...|.......A||
+`
+
+func TestParseAndWrite(t *testing.T) {
+	m, err := Parse(strings.NewReader(testFM2))
+	require.NoError(t, err)
+	require.Equal(t, 3, m.Version)
+	require.Equal(t, 7, m.RerecordCount)
+	require.Equal(t, "smb", m.RomFilename)
+	require.Equal(t, []string{"author someone"}, m.Comments)
+	require.Equal(t, []Frame{
+		{},
+		{Commands: PowerOn, Pad1: 0x81},
+		{Commands: SoftReset, Pad1: 0x48, Pad2: 0x01},
+	}, m.Frames)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, m.Write(buf))
+	require.Contains(t, buf.String(), "|1|.L..T...|.......A||\n")
+	again, err := Parse(buf)
+	require.NoError(t, err)
+	require.Equal(t, m, again)
+
+	_, err = Parse(strings.NewReader("version 3\nbinary 1\n"))
+	require.Error(t, err)
+	_, err = Parse(strings.NewReader("version 3\n|0|RLD|........||\n"))
+	require.Error(t, err)
+}
+
+func TestFourScore(t *testing.T) {
+	m, err := Parse(strings.NewReader("version 3\nfourscore 1\n|0|R.......|........|.......A|......B.||\n"))
+	require.NoError(t, err)
+	require.True(t, m.FourScore)
+	require.Equal(t, []Frame{{Pad1: 0x80, Pad3: 0x01, Pad4: 0x02}}, m.Frames)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, m.Write(buf))
+	require.Contains(t, buf.String(), "fourscore 1\n")
+	require.Contains(t, buf.String(), "|0|R.......|........|.......A|......B.||\n")
+
+	_, err = Parse(strings.NewReader("version 3\nfourscore 1\n|0|........|........||\n"))
+	require.Error(t, err)
+}
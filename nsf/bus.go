@@ -0,0 +1,182 @@
+package nsf
+
+import (
+	"fc-emulator/apu"
+	"fc-emulator/fds"
+	"fc-emulator/utils"
+)
+
+const bankSize = 4 * utils.Kb
+
+// Bus 播放NSF用的最小的CPU地址空间，没有PPU:
+//
+//	$0000-$07FF 2k RAM
+//	$4000-$4017 APU
+//	$5FF8-$5FFF bankswitch，每个寄存器选择$8000-$FFFF中一个4k的bank
+//	$6000-$7FFF 8k RAM，FDS时是程序
+//	$8000-$FFFF 程序
+//
+// 文件头里声明的扩展音源加到APU上，寄存器写入都转发给它们，各个音源自己过滤地址。
+// MMC5还有$5205/$5206的乘法器和$5C00-$5FF5的扩展RAM。
+//
+// FDS的程序在磁盘系统的RAM里: $6000-$DFFF都可以写，程序可以从$6000开始，
+// 使用bankswitch时$5FF6/$5FF7切换$6000-$7FFF的bank。
+type Bus struct {
+	Ram  [2 * utils.Kb]byte
+	Sram [8 * utils.Kb]byte
+	APU  *apu.APU
+	N163 *apu.N163
+	MMC5 *apu.MMC5
+	FDS  *fds.Audio
+
+	exRam      [1 * utils.Kb]byte
+	multiplier [2]byte
+
+	image        []byte // 程序数据，FDS的程序会修改rom，换曲目时从这里恢复
+	rom          []byte
+	banks        [10]int // $6000-$FFFF每4k一个bank，$6000-$7FFF只有FDS使用
+	bankswitched bool
+	fdsRam       bool
+}
+
+// NewBus 按照NSF的规则把程序数据放到地址空间里
+func NewBus(f *File) *Bus {
+	b := &Bus{bankswitched: f.Bankswitched(), fdsRam: f.Expansion&ExpansionFDS != 0}
+	if b.bankswitched {
+		// 数据前面补上load地址在4k中的偏移，然后补齐到4k的整数倍
+		padding := int(f.LoadAddr & 0x0FFF)
+		size := (padding + len(f.Data) + bankSize - 1) / bankSize * bankSize
+		b.image = make([]byte, size)
+		copy(b.image[padding:], f.Data)
+	} else {
+		b.image = make([]byte, 40*utils.Kb)
+		copy(b.image[f.LoadAddr-0x6000:], f.Data)
+		for i := range b.banks {
+			b.banks[i] = i
+		}
+	}
+	b.rom = b.image
+	if b.fdsRam {
+		b.rom = append([]byte{}, b.image...)
+	}
+	b.APU = apu.New(b.Read)
+	b.resetExpansions(f.Expansion)
+	return b
+}
+
+// resetMemory 清空RAM，FDS的程序恢复成文件中的数据，切换曲目时使用
+func (b *Bus) resetMemory() {
+	b.Ram = [len(b.Ram)]byte{}
+	b.Sram = [len(b.Sram)]byte{}
+	b.exRam = [len(b.exRam)]byte{}
+	if b.fdsRam {
+		copy(b.rom, b.image)
+	}
+}
+
+// resetExpansions 重新创建扩展音源，切换曲目时使用
+func (b *Bus) resetExpansions(expansion byte) {
+	b.APU.Expansions = nil
+	b.N163, b.MMC5, b.FDS = nil, nil, nil
+	if expansion&ExpansionVRC6 != 0 {
+		b.APU.AddExpansion(apu.NewVRC6())
+	}
+	if expansion&ExpansionVRC7 != 0 {
+		b.APU.AddExpansion(apu.NewVRC7())
+	}
+	if expansion&ExpansionFDS != 0 {
+		b.FDS = &fds.Audio{}
+		b.FDS.PowerOn()
+		b.APU.AddExpansion(b.FDS)
+	}
+	if expansion&ExpansionMMC5 != 0 {
+		b.MMC5 = apu.NewMMC5()
+		b.APU.AddExpansion(b.MMC5)
+	}
+	if expansion&ExpansionN163 != 0 {
+		b.N163 = apu.NewN163()
+		b.APU.AddExpansion(b.N163)
+	}
+	if expansion&ExpansionS5B != 0 {
+		b.APU.AddExpansion(apu.NewS5B())
+	}
+}
+
+func (b *Bus) romOffset(addr uint16) int {
+	return b.banks[int(addr-0x6000)/bankSize]*bankSize + int(addr)%bankSize
+}
+
+func (b *Bus) Read(addr uint16) byte {
+	switch {
+	case addr == 0x4015:
+		return b.APU.Read(addr)
+	case b.N163 != nil && addr >= 0x4800 && addr <= 0x4FFF:
+		return b.N163.Read(addr)
+	}
+	return b.Peek(addr)
+}
+
+func (b *Bus) Peek(addr uint16) byte {
+	switch {
+	case addr < 0x2000:
+		return b.Ram[addr%0x0800]
+	case addr == 0x4015:
+		return b.APU.Peek(addr)
+	case b.FDS != nil && addr >= 0x4040 && addr <= 0x4097:
+		return b.FDS.Peek(addr)
+	case b.N163 != nil && addr >= 0x4800 && addr <= 0x4FFF:
+		return b.N163.Peek(addr)
+	case b.MMC5 != nil && addr == 0x5015:
+		return b.MMC5.Peek(addr)
+	case b.MMC5 != nil && addr == 0x5205:
+		return byte(uint16(b.multiplier[0]) * uint16(b.multiplier[1]))
+	case b.MMC5 != nil && addr == 0x5206:
+		return byte(uint16(b.multiplier[0]) * uint16(b.multiplier[1]) >> 8)
+	case b.MMC5 != nil && addr >= 0x5C00 && addr < 0x5FF6:
+		return b.exRam[addr-0x5C00]
+	case addr >= 0x6000 && addr < 0x8000 && !b.fdsRam:
+		return b.Sram[addr-0x6000]
+	case addr >= 0x6000:
+		return b.rom[b.romOffset(addr)]
+	}
+	return 0
+}
+
+func (b *Bus) ReadWord(addr uint16) uint16 {
+	return uint16(b.Read(addr+1))<<8 | uint16(b.Read(addr))
+}
+
+func (b *Bus) Write(addr uint16, val byte) {
+	switch {
+	case addr < 0x2000:
+		b.Ram[addr%0x0800] = val
+	case addr >= 0x4000 && addr <= 0x4017:
+		b.APU.Write(addr, val)
+	case addr >= 0x5FF6 && addr <= 0x5FFF:
+		if b.bankswitched && (addr >= 0x5FF8 || b.fdsRam) {
+			b.banks[addr-0x5FF6] = int(val) % (len(b.rom) / bankSize)
+		}
+	case b.MMC5 != nil && (addr == 0x5205 || addr == 0x5206):
+		b.multiplier[addr-0x5205] = val
+	case b.MMC5 != nil && addr >= 0x5C00 && addr < 0x5FF6:
+		b.exRam[addr-0x5C00] = val
+	case addr >= 0x6000 && addr < 0x8000 && !b.fdsRam:
+		b.Sram[addr-0x6000] = val
+	case b.fdsRam && addr >= 0x6000 && addr < 0xE000:
+		b.rom[b.romOffset(addr)] = val
+	}
+	if addr >= 0x4018 {
+		for _, e := range b.APU.Expansions {
+			e.Write(addr, val)
+		}
+	}
+}
+
+// Poke 和Write一样，程序区域直接修改数据
+func (b *Bus) Poke(addr uint16, val byte) {
+	if addr >= 0x6000 && (addr >= 0x8000 || b.fdsRam) {
+		b.rom[b.romOffset(addr)] = val
+		return
+	}
+	b.Write(addr, val)
+}
@@ -0,0 +1,126 @@
+package nsf
+
+import (
+	"fc-emulator/apu"
+	"sync"
+	"time"
+)
+
+// Jukebox 按File.Order的顺序连续播放，一首放完(包括淡出)后自动放下一首，最后一首之后回到第一首。
+//
+// 方法可以在不同的goroutine中调用，例如界面切换曲目、播放的goroutine调用Frame，
+// 切换曲目要等正在生成的一帧结束之后才执行。
+type Jukebox struct {
+	mu     sync.Mutex
+	player *Player
+	order  []int
+	pos    int // 当前曲目在order中的位置
+	paused bool
+}
+
+// NewJukebox 从起始曲目开始，它不在播放列表中时从列表的第一首开始
+func NewJukebox(p *Player) (*Jukebox, error) {
+	j := &Jukebox{player: p, order: p.File.Order()}
+	for i, track := range j.order {
+		if track == p.File.StartSong {
+			j.pos = i
+			break
+		}
+	}
+	return j, p.SetTrack(j.order[j.pos])
+}
+
+func (j *Jukebox) File() *File {
+	return j.player.File
+}
+
+// Order 播放的顺序，是曲目号的列表
+func (j *Jukebox) Order() []int {
+	return j.order
+}
+
+// Status Jukebox的播放状态
+type Status struct {
+	Pos     int // 当前曲目在Order中的位置
+	Elapsed time.Duration
+	Length  time.Duration // 包括淡出的时间，为0时不会结束
+	Paused  bool
+}
+
+func (j *Jukebox) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := Status{Pos: j.pos, Elapsed: j.player.Elapsed(), Paused: j.paused}
+	if j.player.Length > 0 {
+		s.Length = j.player.Length + j.player.Fade
+	}
+	return s
+}
+
+// Select 从头播放Order中的第pos首，超出范围时从另一头绕回来
+func (j *Jukebox) Select(pos int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.selectLocked(pos)
+}
+
+func (j *Jukebox) selectLocked(pos int) error {
+	n := len(j.order)
+	pos = (pos%n + n) % n
+	if err := j.player.SetTrack(j.order[pos]); err != nil {
+		return err
+	}
+	j.pos = pos
+	return nil
+}
+
+func (j *Jukebox) Next() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.selectLocked(j.pos + 1)
+}
+
+func (j *Jukebox) Prev() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.selectLocked(j.pos - 1)
+}
+
+func (j *Jukebox) SetPaused(paused bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.paused = paused
+}
+
+// Frame 生成一帧的采样。暂停时返回同样长度的静音，这样播放的速度不变
+func (j *Jukebox) Frame() ([]float32, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.paused {
+		return make([]float32, j.player.period*uint64(j.player.Bus.APU.SampleRate)/apu.CPUFrequency), nil
+	}
+	if j.player.Done() {
+		if err := j.selectLocked(j.pos + 1); err != nil {
+			return nil, err
+		}
+	}
+	return j.player.Frame()
+}
+
+// Play 把采样不停地写到sink，直到stop被关闭或者出错
+func (j *Jukebox) Play(sink apu.Sink, stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		samples, err := j.Frame()
+		if err != nil {
+			return err
+		}
+		if err := sink.WriteSamples(samples); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package nsf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fc-emulator/rom"
+	"fmt"
+	"strings"
+)
+
+// https://www.nesdev.org/wiki/NSF
+// https://www.nesdev.org/wiki/NSFe
+const (
+	nsfMagic  = "NESM\x1A"
+	nsfeMagic = "NSFE"
+
+	DefaultSpeedNTSC = 16639 // 微秒，约60.1Hz
+	DefaultSpeedPAL  = 19997
+)
+
+// 扩展音源，File.Expansion中的位
+const (
+	ExpansionVRC6 byte = 1 << iota
+	ExpansionVRC7
+	ExpansionFDS
+	ExpansionMMC5
+	ExpansionN163
+	ExpansionS5B
+)
+
+type File struct {
+	Songs     int
+	StartSong int // 从0开始
+	LoadAddr  uint16
+	InitAddr  uint16
+	PlayAddr  uint16
+	Name      string
+	Artist    string
+	Copyright string
+	SpeedNTSC uint16 // PLAY的调用周期，单位微秒
+	SpeedPAL  uint16
+	Banks     [8]byte // 不全为0时使用bankswitch
+	PAL       bool
+	Expansion byte
+	Data      []byte
+
+	// 下面是NSFe才有的信息，没有时为nil
+	Times    []int // 每首的长度，毫秒，小于0表示未知
+	Fades    []int // 每首的淡出时间，毫秒，小于0表示未知
+	Labels   []string
+	Playlist []int
+}
+
+// Bankswitched 是否使用$5FF8-$5FFF切换bank
+func (f *File) Bankswitched() bool {
+	for _, b := range f.Banks {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Label 第track首的名字，没有时返回 "Track n"
+func (f *File) Label(track int) string {
+	if track >= 0 && track < len(f.Labels) && f.Labels[track] != "" {
+		return f.Labels[track]
+	}
+	return fmt.Sprintf("Track %d", track+1)
+}
+
+// Order 播放的顺序: NSFe有plst时按plst，超出范围的曲目会被忽略；否则按曲目号的顺序
+func (f *File) Order() []int {
+	order := make([]int, 0, f.Songs)
+	for _, track := range f.Playlist {
+		if track >= 0 && track < f.Songs {
+			order = append(order, track)
+		}
+	}
+	if len(order) == 0 {
+		for i := 0; i < f.Songs; i++ {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+func (f *File) String() string {
+	return fmt.Sprintf("%s - %s (%s), %d songs", f.Name, f.Artist, f.Copyright, f.Songs)
+}
+
+// Load 读取NSF或NSFe文件，和ROM一样可以是zip或gzip压缩的
+func Load(fileName string) (*File, error) {
+	data, err := rom.ReadRomFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// IsFile 是否是NSF或NSFe文件
+func IsFile(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(nsfMagic)) || bytes.HasPrefix(data, []byte(nsfeMagic))
+}
+
+// Parse 根据文件头判断是NSF还是NSFe
+func Parse(data []byte) (*File, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(nsfMagic)):
+		return parseNSF(data)
+	case bytes.HasPrefix(data, []byte(nsfeMagic)):
+		return parseNSFe(data)
+	}
+	return nil, errors.New("not a nsf or nsfe file")
+}
+
+func cString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func parseNSF(data []byte) (*File, error) {
+	if len(data) <= 0x80 {
+		return nil, errors.New("nsf is truncated")
+	}
+	f := &File{
+		Songs:     int(data[0x06]),
+		StartSong: int(data[0x07]) - 1,
+		LoadAddr:  binary.LittleEndian.Uint16(data[0x08:]),
+		InitAddr:  binary.LittleEndian.Uint16(data[0x0A:]),
+		PlayAddr:  binary.LittleEndian.Uint16(data[0x0C:]),
+		Name:      cString(data[0x0E:0x2E]),
+		Artist:    cString(data[0x2E:0x4E]),
+		Copyright: cString(data[0x4E:0x6E]),
+		SpeedNTSC: binary.LittleEndian.Uint16(data[0x6E:]),
+		SpeedPAL:  binary.LittleEndian.Uint16(data[0x78:]),
+		PAL:       data[0x7A]&0x03 == 0x01, // bit1表示两种制式都支持，这时使用NTSC
+		Expansion: data[0x7B],
+		Data:      data[0x80:],
+	}
+	copy(f.Banks[:], data[0x70:0x78])
+	// NSF2的0x7D-0x7F是程序数据的长度，后面可能还有元数据
+	if data[0x05] >= 2 {
+		if size := int(data[0x7D]) | int(data[0x7E])<<8 | int(data[0x7F])<<16; size > 0 && size < len(f.Data) {
+			f.Data = f.Data[:size]
+		}
+	}
+	return f, f.check()
+}
+
+func (f *File) check() error {
+	if f.Songs < 1 {
+		return errors.New("nsf has no songs")
+	}
+	if f.StartSong < 0 || f.StartSong >= f.Songs {
+		f.StartSong = 0
+	}
+	minLoadAddr := uint16(0x8000)
+	if f.Expansion&ExpansionFDS != 0 { // FDS的程序可以放在$6000-$7FFF的RAM里
+		minLoadAddr = 0x6000
+	}
+	if f.LoadAddr < minLoadAddr && !f.Bankswitched() {
+		return fmt.Errorf("nsf load address $%04X is below $%04X", f.LoadAddr, minLoadAddr)
+	}
+	if f.SpeedNTSC == 0 {
+		f.SpeedNTSC = DefaultSpeedNTSC
+	}
+	if f.SpeedPAL == 0 {
+		f.SpeedPAL = DefaultSpeedPAL
+	}
+	return nil
+}
+
+func parseNSFe(data []byte) (*File, error) {
+	f := &File{}
+	hasInfo := false
+	for pos := 4; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, errors.New("nsfe chunk header is truncated")
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos:]))
+		id := string(data[pos+4 : pos+8])
+		pos += 8
+		if size < 0 || pos+size > len(data) {
+			return nil, fmt.Errorf("nsfe chunk %s is truncated", id)
+		}
+		chunk := data[pos : pos+size]
+		pos += size
+		switch id {
+		case "INFO":
+			if len(chunk) < 8 {
+				return nil, errors.New("nsfe INFO chunk is too short")
+			}
+			hasInfo = true
+			f.LoadAddr = binary.LittleEndian.Uint16(chunk[0:])
+			f.InitAddr = binary.LittleEndian.Uint16(chunk[2:])
+			f.PlayAddr = binary.LittleEndian.Uint16(chunk[4:])
+			f.PAL = chunk[6]&0x03 == 0x01
+			f.Expansion = chunk[7]
+			f.Songs = 1
+			if len(chunk) > 8 {
+				f.Songs = int(chunk[8])
+			}
+			if len(chunk) > 9 {
+				f.StartSong = int(chunk[9])
+			}
+		case "DATA":
+			f.Data = chunk
+		case "BANK":
+			copy(f.Banks[:], chunk)
+		case "RATE":
+			if len(chunk) >= 2 {
+				f.SpeedNTSC = binary.LittleEndian.Uint16(chunk)
+			}
+			if len(chunk) >= 4 {
+				f.SpeedPAL = binary.LittleEndian.Uint16(chunk[2:])
+			}
+		case "time":
+			f.Times = int32s(chunk)
+		case "fade":
+			f.Fades = int32s(chunk)
+		case "tlbl":
+			f.Labels = cStrings(chunk)
+		case "auth":
+			auth := append(cStrings(chunk), "", "", "")
+			f.Name, f.Artist, f.Copyright = auth[0], auth[1], auth[2]
+		case "plst":
+			for _, b := range chunk {
+				f.Playlist = append(f.Playlist, int(b))
+			}
+		case "NEND":
+			pos = len(data)
+		default:
+			// 首字母大写的块是必须理解的
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return nil, fmt.Errorf("nsfe has unsupported required chunk %q", id)
+			}
+		}
+	}
+	if !hasInfo || f.Data == nil {
+		return nil, errors.New("nsfe has no INFO or DATA chunk")
+	}
+	return f, f.check()
+}
+
+func int32s(data []byte) []int {
+	res := make([]int, 0, len(data)/4)
+	for i := 0; i+4 <= len(data); i += 4 {
+		res = append(res, int(int32(binary.LittleEndian.Uint32(data[i:]))))
+	}
+	return res
+}
+
+func cStrings(data []byte) []string {
+	res := make([]string, 0)
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			i = len(data)
+		}
+		res = append(res, string(data[:i]))
+		if i == len(data) {
+			break
+		}
+		data = data[i+1:]
+	}
+	return res
+}
@@ -0,0 +1,253 @@
+package nsf
+
+import (
+	"encoding/binary"
+	"fc-emulator/apu"
+	"fc-emulator/cpu"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// INIT把曲目号存到$00，PLAY每次把$01加1，并且让方波1响起来
+const testSong = `
+.org $8000
+init: STA $00
+      LDA #$01
+      STA $4015
+      RTS
+play: INC $01
+      LDA #$BF
+      STA $4000
+      LDA #$FD
+      STA $4002
+      LDA #$08
+      STA $4003
+      RTS
+`
+
+func makeNSF(t *testing.T) []byte {
+	prog, err := cpu.Assemble(testSong)
+	require.NoError(t, err)
+	h := make([]byte, 0x80)
+	copy(h, nsfMagic)
+	h[0x05] = 1
+	h[0x06] = 3
+	h[0x07] = 2
+	binary.LittleEndian.PutUint16(h[0x08:], prog.Origin)
+	binary.LittleEndian.PutUint16(h[0x0A:], prog.Labels["init"])
+	binary.LittleEndian.PutUint16(h[0x0C:], prog.Labels["play"])
+	copy(h[0x0E:], "Test")
+	copy(h[0x2E:], "Someone")
+	binary.LittleEndian.PutUint16(h[0x6E:], DefaultSpeedNTSC)
+	return append(h, prog.Code...)
+}
+
+func chunk(id string, data []byte) []byte {
+	c := make([]byte, 8)
+	binary.LittleEndian.PutUint32(c, uint32(len(data)))
+	copy(c[4:], id)
+	return append(c, data...)
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse(makeNSF(t))
+	require.NoError(t, err)
+	require.Equal(t, 3, f.Songs)
+	require.Equal(t, 1, f.StartSong)
+	require.Equal(t, uint16(0x8000), f.LoadAddr)
+	require.Equal(t, "Test", f.Name)
+	require.Equal(t, "Someone", f.Artist)
+	require.False(t, f.Bankswitched())
+
+	info := []byte{0x00, 0x80, 0x00, 0x80, 0x03, 0x80, 0, 0, 2, 0}
+	times := make([]byte, 8)
+	binary.LittleEndian.PutUint32(times, 1000)
+	binary.LittleEndian.PutUint32(times[4:], 0xFFFFFFFF)
+	data := []byte(nsfeMagic)
+	data = append(data, chunk("INFO", info)...)
+	data = append(data, chunk("DATA", []byte{0x60})...)
+	data = append(data, chunk("time", times)...)
+	data = append(data, chunk("tlbl", []byte("Intro\x00Stage 1\x00"))...)
+	data = append(data, chunk("auth", []byte("Game\x00Artist\x00"))...)
+	data = append(data, chunk("NEND", nil)...)
+	f, err = Parse(data)
+	require.NoError(t, err)
+	require.Equal(t, 2, f.Songs)
+	require.Equal(t, []int{1000, -1}, f.Times)
+	require.Equal(t, "Stage 1", f.Label(1))
+	require.Equal(t, "Track 3", f.Label(2))
+	require.Equal(t, "Artist", f.Artist)
+	require.Equal(t, uint16(DefaultSpeedNTSC), f.SpeedNTSC)
+
+	data = append([]byte(nsfeMagic), chunk("INFO", info)...)
+	data = append(data, chunk("XYZW", nil)...)
+	_, err = Parse(data)
+	require.Error(t, err)
+}
+
+func TestBankswitch(t *testing.T) {
+	f := &File{Songs: 1, LoadAddr: 0x8100, Data: make([]byte, 0x2000), Banks: [8]byte{0, 1}}
+	f.Data[0] = 0x11
+	f.Data[0x1000-0x100] = 0x22
+	b := NewBus(f)
+	b.Write(0x5FF8, 0)
+	b.Write(0x5FF9, 1)
+	require.Equal(t, byte(0x11), b.Read(0x8100))
+	require.Equal(t, byte(0x22), b.Read(0x9000))
+	b.Write(0x5FFF, 1)
+	require.Equal(t, byte(0x22), b.Read(0xF000))
+}
+
+func TestPlayer(t *testing.T) {
+	f, err := Parse(makeNSF(t))
+	require.NoError(t, err)
+	p := NewPlayer(f, 8000)
+	require.NoError(t, p.SetTrack(2))
+	require.Equal(t, byte(2), p.Bus.Ram[0])
+	require.Error(t, p.SetTrack(3))
+
+	p.Length, p.Fade = time.Second, 500*time.Millisecond
+	var samples []float32
+	frames := 0
+	for !p.Done() {
+		s, err := p.Frame()
+		require.NoError(t, err)
+		samples = append(samples, s...)
+		frames += 1
+	}
+	require.Equal(t, byte(frames), p.Bus.Ram[1])
+	require.InDelta(t, 12000, len(samples), 8000/60+1) // 最后一帧可能超出
+
+	peak := func(s []float32) float32 {
+		var m float32
+		for _, v := range s {
+			if v > m {
+				m = v
+			}
+		}
+		return m
+	}
+	require.Greater(t, peak(samples[4000:8000]), float32(0.1))
+	require.Less(t, peak(samples[len(samples)-100:]), float32(0.01))
+}
+
+func TestExpansionBus(t *testing.T) {
+	f := &File{Songs: 1, LoadAddr: 0x8000, Data: []byte{0x60}, Expansion: ExpansionVRC6 | ExpansionN163 | ExpansionMMC5}
+	b := NewBus(f)
+	require.Len(t, b.APU.Expansions, 3)
+
+	vrc6 := b.APU.Expansions[0].(*apu.VRC6)
+	b.Write(0x9000, 0x8F)
+	b.Write(0x9002, 0x80)
+	require.Greater(t, vrc6.Output(), 0.0)
+
+	b.Write(0xF800, 0x85)
+	b.Write(0x4800, 0x12)
+	b.Write(0xF800, 0x05)
+	require.Equal(t, byte(0x12), b.Read(0x4800))
+
+	b.Write(0x5205, 200)
+	b.Write(0x5206, 100)
+	require.Equal(t, byte(20000&0xFF), b.Read(0x5205))
+	require.Equal(t, byte(20000>>8), b.Read(0x5206))
+}
+
+func TestFDSBus(t *testing.T) {
+	// INIT把$6000的值加1，程序在可以写的RAM里
+	code := []byte{0xEE, 0x00, 0x60, 0x60} // INC $6000; RTS
+	f := &File{Songs: 1, LoadAddr: 0x6000, InitAddr: 0x6000, PlayAddr: 0x6003, Expansion: ExpansionFDS,
+		Data: append(code, make([]byte, 0x4000)...)}
+	require.NoError(t, f.check())
+	p := NewPlayer(f, 8000)
+	b := p.Bus
+	require.NotNil(t, b.FDS)
+	require.Equal(t, []apu.Expansion{b.FDS}, b.APU.Expansions)
+	require.NoError(t, p.SetTrack(0))
+	require.Equal(t, byte(0xEF), b.Read(0x6000))
+	b.Write(0x9000, 0x55)
+	require.Equal(t, byte(0x55), b.Read(0x9000))
+	b.Write(0xE000, 0x55) // BIOS的位置不能写
+	require.Equal(t, byte(0), b.Read(0xE000))
+	// 换曲目时恢复文件中的程序
+	require.NoError(t, p.SetTrack(0))
+	require.Equal(t, byte(0xEF), b.Read(0x6000))
+	require.Equal(t, byte(0), b.Read(0x9000))
+
+	b.Write(0x4089, 0x80)
+	b.Write(0x4040, 0x3F)
+	require.Equal(t, byte(0x7F), b.Read(0x4040))
+	b.Write(0x4080, 0x80|0x20)
+	require.Equal(t, byte(0x60), b.Read(0x4090))
+
+	// 使用bankswitch时$5FF6/$5FF7切换$6000-$7FFF
+	f = &File{Songs: 1, LoadAddr: 0x8000, Banks: [8]byte{0, 1, 2, 3, 4, 5, 6, 7},
+		Expansion: ExpansionFDS, Data: make([]byte, 0x8000)}
+	f.Data[0x3000] = 0x33
+	b = NewBus(f)
+	b.Write(0x5FF6, 3)
+	require.Equal(t, byte(0x33), b.Read(0x6000))
+	b.Write(0x6001, 0x44)
+	b.Write(0x5FFB, 3)
+	require.Equal(t, byte(0x44), b.Read(0xB001))
+	require.Error(t, (&File{Songs: 1, LoadAddr: 0x6000}).check())
+}
+
+type countSink struct {
+	writes int
+	stop   chan struct{}
+}
+
+func (s *countSink) WriteSamples(samples []float32) error {
+	s.writes += 1
+	if s.writes == 3 {
+		close(s.stop)
+	}
+	return nil
+}
+
+func TestJukebox(t *testing.T) {
+	f, err := Parse(makeNSF(t))
+	require.NoError(t, err)
+	f.Playlist = []int{2, 0, 9}
+	require.Equal(t, []int{2, 0}, f.Order())
+	j, err := NewJukebox(NewPlayer(f, 8000))
+	require.NoError(t, err)
+	ram := j.player.Bus.Ram[:]
+	require.Equal(t, byte(2), ram[0]) // 起始曲目1不在播放列表中，从列表的第一首开始
+
+	require.NoError(t, j.Next())
+	require.Equal(t, byte(0), ram[0])
+	require.NoError(t, j.Next())
+	require.Equal(t, byte(2), ram[0])
+	require.NoError(t, j.Prev())
+	require.Equal(t, 1, j.Status().Pos)
+	require.Equal(t, DefaultLength+DefaultFade, j.Status().Length)
+	require.Equal(t, byte(0), ram[0])
+
+	// 放完之后自动放下一首
+	j.player.Length, j.player.Fade = 100*time.Millisecond, 0
+	for i := 0; i < 10; i++ {
+		_, err := j.Frame()
+		require.NoError(t, err)
+	}
+	status := j.Status()
+	require.Equal(t, 0, status.Pos)
+	require.Equal(t, byte(2), ram[0])
+	require.Less(t, int64(status.Elapsed), int64(100*time.Millisecond))
+
+	j.SetPaused(true)
+	samples, err := j.Frame()
+	require.NoError(t, err)
+	require.Len(t, samples, 133)
+	require.Equal(t, make([]float32, 133), samples)
+	require.True(t, j.Status().Paused)
+	require.Equal(t, status.Elapsed, j.Status().Elapsed)
+
+	sink := &countSink{stop: make(chan struct{})}
+	require.NoError(t, j.Play(sink, sink.stop))
+	require.Equal(t, 3, sink.writes)
+
+	f.Playlist = nil
+	require.Equal(t, []int{0, 1, 2}, f.Order())
+}
@@ -0,0 +1,196 @@
+package nsf
+
+import (
+	"errors"
+	"fc-emulator/apu"
+	"fc-emulator/cpu"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultLength = 150 * time.Second
+	DefaultFade   = 8 * time.Second
+
+	// INIT和PLAY返回到这个地址时认为调用结束，这里是NSF里不会执行代码的I/O区域
+	returnAddr uint16 = 0x5FF0
+	// INIT或PLAY超过这么多周期还没有返回就认为程序跑飞了
+	callLimit = apu.CPUFrequency * 2
+)
+
+// Player 在cpu.CPU上运行NSF的INIT和PLAY，用APU生成声音
+//
+// 每首曲子播放Length之后用Fade的时间线性淡出，然后Done返回true；
+// Length为0时一直播放不会结束。
+type Player struct {
+	File   *File
+	Bus    *Bus
+	CPU    *cpu.CPU
+	Length time.Duration
+	Fade   time.Duration
+
+	track      int
+	period     uint64 // 两次PLAY之间的CPU周期数
+	lastCycles uint64
+	played     uint64 // 这首曲子已经播放的CPU周期数
+}
+
+func NewPlayer(f *File, sampleRate int) *Player {
+	bus := NewBus(f)
+	bus.APU.SampleRate = sampleRate
+	speed := f.SpeedNTSC
+	if f.PAL {
+		speed = f.SpeedPAL
+	}
+	return &Player{
+		File:   f,
+		Bus:    bus,
+		CPU:    cpu.NewCPU(bus, true),
+		period: uint64(speed) * apu.CPUFrequency / 1000000,
+	}
+}
+
+// Track 当前曲目，从0开始
+func (p *Player) Track() int {
+	return p.track
+}
+
+// SetTrack 按照NSF规定的顺序初始化硬件，然后调用INIT。
+// 曲子的长度和淡出时间优先使用NSFe里的信息，没有时使用默认值。
+func (p *Player) SetTrack(track int) error {
+	if track < 0 || track >= p.File.Songs {
+		return fmt.Errorf("track %d out of range 1-%d", track+1, p.File.Songs)
+	}
+	p.track = track
+	p.Length, p.Fade = DefaultLength, DefaultFade
+	if track < len(p.File.Times) && p.File.Times[track] >= 0 {
+		p.Length = time.Duration(p.File.Times[track]) * time.Millisecond
+	}
+	if track < len(p.File.Fades) && p.File.Fades[track] >= 0 {
+		p.Fade = time.Duration(p.File.Fades[track]) * time.Millisecond
+	}
+
+	b := p.Bus
+	b.resetMemory()
+	b.resetExpansions(p.File.Expansion)
+	b.APU.Reset()
+	for addr := uint16(0x4000); addr <= 0x4013; addr++ {
+		b.Write(addr, 0)
+	}
+	b.Write(0x4015, 0x00)
+	b.Write(0x4015, 0x0F)
+	b.Write(0x4017, 0x40)
+	if p.File.Bankswitched() {
+		for i, bank := range p.File.Banks {
+			b.Write(0x5FF8+uint16(i), bank)
+		}
+		if p.File.Expansion&ExpansionFDS != 0 { // FDS的$6000-$7FFF和$E000-$FFFF用同样的bank
+			b.Write(0x5FF6, p.File.Banks[6])
+			b.Write(0x5FF7, p.File.Banks[7])
+		}
+	}
+
+	reg := p.CPU.Register()
+	reg.A = byte(track)
+	reg.X = 0
+	if p.File.PAL {
+		reg.X = 1
+	}
+	reg.Y = 0
+	reg.S = 0xFF
+	reg.P = 0x24
+	p.lastCycles = p.CPU.Cycles()
+	p.played = 0
+	b.APU.Samples()
+	if err := p.call(p.File.InitAddr); err != nil {
+		return fmt.Errorf("init: %w", err)
+	}
+	b.APU.Samples() // INIT期间的输出不要
+	return nil
+}
+
+// call 模拟JSR调用addr，执行到对应的RTS为止，期间按CPU周期驱动APU
+func (p *Player) call(addr uint16) error {
+	reg := p.CPU.Register()
+	p.CPU.StackPushWord(returnAddr - 1) // RTS会把弹出的地址加1
+	reg.PC = addr
+	start := p.CPU.Cycles()
+	for reg.PC != returnAddr {
+		if p.CPU.Cycles()-start > callLimit {
+			return fmt.Errorf("routine $%04X does not return", addr)
+		}
+		if _, err := p.CPU.ExecuteOneInstruction(); err != nil {
+			return err
+		}
+		p.clockAPU()
+	}
+	return nil
+}
+
+func (p *Player) clockAPU() {
+	cycles := p.CPU.Cycles()
+	p.Bus.APU.Clock(int(cycles - p.lastCycles))
+	p.lastCycles = cycles
+}
+
+// Elapsed 这首曲子已经播放的时间
+func (p *Player) Elapsed() time.Duration {
+	return time.Duration(p.played * uint64(time.Second) / apu.CPUFrequency)
+}
+
+func (p *Player) Done() bool {
+	return p.Length > 0 && p.Elapsed() >= p.Length+p.Fade
+}
+
+// Frame 调用一次PLAY，并让APU运行到下一次PLAY的时间，返回这段时间的采样
+func (p *Player) Frame() ([]float32, error) {
+	start := p.CPU.Cycles()
+	if err := p.call(p.File.PlayAddr); err != nil {
+		return nil, fmt.Errorf("play: %w", err)
+	}
+	if used := p.CPU.Cycles() - start; used < p.period {
+		p.Bus.APU.Clock(int(p.period - used))
+		p.lastCycles = p.CPU.Cycles()
+	}
+	samples := p.Bus.APU.Samples()
+	p.fade(samples)
+	p.played += p.period
+	return samples, nil
+}
+
+// fade 根据播放时间调整音量，到Length之后线性减小到0
+func (p *Player) fade(samples []float32) {
+	if p.Length <= 0 || len(samples) == 0 {
+		return
+	}
+	sampleTime := float64(time.Second) / float64(p.Bus.APU.SampleRate)
+	t := float64(p.Elapsed())
+	for i := range samples {
+		over := t + float64(i)*sampleTime - float64(p.Length)
+		switch {
+		case over <= 0:
+			continue
+		case p.Fade <= 0 || over >= float64(p.Fade):
+			samples[i] = 0
+		default:
+			samples[i] *= float32(1 - over/float64(p.Fade))
+		}
+	}
+}
+
+// Render 播放到Done为止，所有采样写入sink。Length为0时不能使用。
+func (p *Player) Render(sink apu.Sink) error {
+	if p.Length <= 0 {
+		return errors.New("cannot render an endless track")
+	}
+	for !p.Done() {
+		samples, err := p.Frame()
+		if err != nil {
+			return err
+		}
+		if err := sink.WriteSamples(samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
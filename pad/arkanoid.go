@@ -0,0 +1,158 @@
+package pad
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// Arkanoid Vaus手柄，一个旋钮(电位器)和一个按钮 https://www.nesdev.org/wiki/Arkanoid_controller
+//
+// 写$4016的strobe时把旋钮的位置转换成9位的值锁存起来，之后每次读移出一位，高位先出，取反。
+// 前8位就是游戏用到的位置，第9位是最低位。
+//
+// NES版插在2号口，作为InputDevice使用: bit4是旋钮的串行数据(取反)，bit3是按钮。
+// 红白机版插在扩展口，作为Ports使用: $4016的bit1是按钮，$4017的bit1是串行数据。
+//
+// 旋钮由鼠标横向移动控制，Aim的x每移动一个像素，位置变化一格。
+type Arkanoid struct {
+	Min byte // 旋钮转到最左边时的值
+	Max byte // 旋钮转到最右边时的值
+
+	mu       sync.Mutex
+	position int // Min到Max之间
+	button   bool
+	lastX    int
+	tracking bool // lastX是否有效
+
+	strobe bool
+	shift  uint16
+}
+
+// Arkanoid卡带里用到的旋钮范围
+const (
+	ArkanoidMin = 0x62
+	ArkanoidMax = 0xF2
+)
+
+func NewArkanoid() *Arkanoid {
+	return &Arkanoid{Min: ArkanoidMin, Max: ArkanoidMax, position: (ArkanoidMin + ArkanoidMax) / 2}
+}
+
+// Move 旋钮转动dx格，超出范围时停在两端
+func (a *Arkanoid) Move(dx int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.position += dx
+	if a.position < int(a.Min) {
+		a.position = int(a.Min)
+	}
+	if a.position > int(a.Max) {
+		a.position = int(a.Max)
+	}
+}
+
+// Position 旋钮当前的值
+func (a *Arkanoid) Position() byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return byte(a.position)
+}
+
+// Aim 使用鼠标的横向移动，离开画面之后重新进入时不会跳动
+func (a *Arkanoid) Aim(x, y int, onScreen bool) {
+	a.mu.Lock()
+	lastX, tracking := a.lastX, a.tracking
+	a.lastX, a.tracking = x, onScreen
+	a.mu.Unlock()
+	if onScreen && tracking {
+		a.Move(x - lastX)
+	}
+}
+
+func (a *Arkanoid) SetTrigger(pulled bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.button = pulled
+}
+
+// UpdateButton A键当作按钮
+func (a *Arkanoid) UpdateButton(buttonType ButtonType, pressDown bool) {
+	if buttonType == BUTTON_A {
+		a.SetTrigger(pressDown)
+	}
+}
+
+func (a *Arkanoid) latch() {
+	a.shift = ^(uint16(a.position) << 1) & 0x1FF
+}
+
+func (a *Arkanoid) WriteForCPU(value byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.strobe = value&0x01 != 0
+	if a.strobe {
+		a.latch()
+	}
+}
+
+// serial 返回当前要移出的一位，next为true时移动到下一位
+func (a *Arkanoid) serial(next bool) byte {
+	if a.strobe {
+		a.latch()
+	}
+	bit := byte(a.shift >> 8 & 0x01)
+	if next && !a.strobe {
+		a.shift = a.shift<<1&0x1FF | 0x01 // 移完之后一直是1(位置0)
+	}
+	return bit
+}
+
+func (a *Arkanoid) buttonBit() byte {
+	if a.button {
+		return 1
+	}
+	return 0
+}
+
+func (a *Arkanoid) ReadForCPU() byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.serial(true)<<4 | a.buttonBit()<<3
+}
+
+func (a *Arkanoid) PeekForCPU() byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.serial(false)<<4 | a.buttonBit()<<3
+}
+
+// Write 红白机版，和WriteForCPU一样
+func (a *Arkanoid) Write(val byte) {
+	a.WriteForCPU(val)
+}
+
+func (a *Arkanoid) Read(port int) byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if port&1 == 0 {
+		return a.buttonBit() << 1
+	}
+	return a.serial(true) << 1
+}
+
+func (a *Arkanoid) Peek(port int) byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if port&1 == 0 {
+		return a.buttonBit() << 1
+	}
+	return a.serial(false) << 1
+}
+
+func (a *Arkanoid) WriteState(w io.Writer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	binary.Write(w, binary.LittleEndian, []int64{int64(a.position), int64(a.lastX), int64(a.shift)})
+	binary.Write(w, binary.LittleEndian, []bool{a.button, a.tracking, a.strobe})
+}
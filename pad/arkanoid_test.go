@@ -0,0 +1,50 @@
+package pad
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestArkanoid(t *testing.T) {
+	a := NewArkanoid()
+	a.Move(-1000)
+	require.Equal(t, byte(ArkanoidMin), a.Position())
+	a.SetTrigger(true)
+
+	// 0x62 -> 9位 0x0C4 -> 取反 0x13B，高位先出
+	a.WriteForCPU(1)
+	a.WriteForCPU(0)
+	require.Equal(t, byte(0x18), a.PeekForCPU())
+	require.Equal(t, []byte{
+		0x18, 0x08, 0x08, 0x18, 0x18, 0x18, 0x08, 0x18, 0x18,
+		0x18, 0x18,
+	}, readDeviceBits(a, 11))
+
+	// 鼠标从画面外进来不会跳动，之后按横向移动的距离转动
+	a.Aim(200, 0, true)
+	require.Equal(t, byte(ArkanoidMin), a.Position())
+	a.Aim(210, 50, true)
+	require.Equal(t, byte(ArkanoidMin+10), a.Position())
+	a.Aim(0, 0, false)
+	a.Aim(100, 0, true)
+	require.Equal(t, byte(ArkanoidMin+10), a.Position())
+	a.Move(1000)
+	require.Equal(t, byte(ArkanoidMax), a.Position())
+
+	// 红白机版: 按钮在$4016的bit1，串行数据在$4017的bit1
+	a.SetTrigger(false)
+	a.Write(1)
+	a.Write(0)
+	require.Equal(t, byte(0), a.Read(0))
+	// 0xF2 -> 9位 0x1E4 -> 取反 0x01B
+	require.Equal(t, []byte{0, 0, 0, 0, 2, 2, 0, 2, 2, 2}, readBits(a, 1, 10))
+}
+
+// readDeviceBits 连续读n次，返回每次读到的值
+func readDeviceBits(d InputDevice, n int) []byte {
+	res := make([]byte, n)
+	for i := range res {
+		res[i] = d.ReadForCPU()
+	}
+	return res
+}
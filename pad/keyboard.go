@@ -0,0 +1,193 @@
+package pad
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Keyboard Family BASIC键盘，插在红白机的扩展口上，作为Ports使用
+// https://www.nesdev.org/wiki/Family_BASIC_Keyboard
+//
+// 键盘是9行，每行两列，每列4个键的矩阵。写$4016:
+//
+//	bit0 为1时回到第0行第0列
+//	bit1 选择列，从1变成0时移动到下一行
+//	bit2 为1时启用键盘，同时也是送给录音机的信号
+//
+// 读$4017的bit1-4是选中的一列的4个键，按下是0；没有启用键盘时读到0。
+// 录音机接在键盘上，放音的信号从$4016的bit1读到。
+type Keyboard struct {
+	// Layout 主机键盘的按键名到Family BASIC键盘的按键名，UI按这个表把键盘输入交给PressKey
+	Layout map[string]string
+	// Tape 接在键盘上的录音机，可以为nil
+	Tape *DataRecorder
+
+	mu      sync.Mutex
+	pressed [keyboardRows][2]byte // 按下的键，bit1-4
+	row     int
+	column  int
+	enabled bool
+}
+
+const keyboardRows = 9
+
+// keyboardMatrix 每一行两列，每列依次是bit1-4上的键
+var keyboardMatrix = [keyboardRows][2][4]string{
+	{{"]", "[", "RETURN", "F8"}, {"STOP", "YEN", "RSHIFT", "KANA"}},
+	{{";", ":", "@", "F7"}, {"^", "-", "/", "_"}},
+	{{"K", "L", "O", "F6"}, {"0", "P", ",", "."}},
+	{{"J", "U", "I", "F5"}, {"8", "9", "N", "M"}},
+	{{"H", "G", "Y", "F4"}, {"6", "7", "V", "B"}},
+	{{"D", "R", "T", "F3"}, {"4", "5", "C", "F"}},
+	{{"A", "S", "W", "F2"}, {"3", "E", "Z", "X"}},
+	{{"CTR", "Q", "ESC", "F1"}, {"2", "1", "GRPH", "LSHIFT"}},
+	{{"LEFT", "RIGHT", "UP", "CLR"}, {"INS", "DEL", "SPACE", "DOWN"}},
+}
+
+type keyPosition struct {
+	row, column int
+	bit         byte
+}
+
+var keyboardKeys = func() map[string]keyPosition {
+	keys := map[string]keyPosition{}
+	for row, columns := range keyboardMatrix {
+		for column, names := range columns {
+			for i, name := range names {
+				keys[name] = keyPosition{row, column, 1 << (i + 1)}
+			}
+		}
+	}
+	return keys
+}()
+
+// DefaultKeyboardLayout 主机键盘(按键名和fyne的KeyName相同)到Family BASIC键盘，
+// 字母、数字和F1-F8对应同名的键，符号键按日文键盘的位置对应
+var DefaultKeyboardLayout = func() map[string]string {
+	layout := map[string]string{
+		"Return": "RETURN", "Escape": "ESC", "Space": "SPACE",
+		"Left": "LEFT", "Right": "RIGHT", "Up": "UP", "Down": "DOWN",
+		"Home": "CLR", "Insert": "INS", "Delete": "DEL", "BackSpace": "DEL", "End": "STOP",
+		"LeftShift": "LSHIFT", "RightShift": "RSHIFT", "LeftControl": "CTR",
+		"LeftAlt": "GRPH", "RightAlt": "KANA",
+		"-": "-", "=": "^", "`": "YEN", "[": "@", "]": "[", "\\": "]",
+		";": ";", "'": ":", ",": ",", ".": ".", "/": "/", "RightControl": "_",
+	}
+	for c := 'A'; c <= 'Z'; c++ {
+		layout[string(c)] = string(c)
+	}
+	for c := '0'; c <= '9'; c++ {
+		layout[string(c)] = string(c)
+	}
+	for i := 1; i <= 8; i++ {
+		layout[fmt.Sprintf("F%d", i)] = fmt.Sprintf("F%d", i)
+	}
+	return layout
+}()
+
+func NewKeyboard() *Keyboard {
+	layout := make(map[string]string, len(DefaultKeyboardLayout))
+	for k, v := range DefaultKeyboardLayout {
+		layout[k] = v
+	}
+	return &Keyboard{Layout: layout}
+}
+
+// ParseKeyboardLayout 解析 "F9=STOP,Tab=KANA" 格式的按键布局，格式是主机按键名=Family BASIC按键名
+func ParseKeyboardLayout(s string) (map[string]string, error) {
+	layout := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		// 按键名本身可能是"="，从最后一个"="分开
+		i := strings.LastIndex(item, "=")
+		if i <= 0 || i == len(item)-1 {
+			return nil, fmt.Errorf("keyboard layout %q should be host=key", item)
+		}
+		host, key := strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		if _, ok := keyboardKeys[key]; !ok {
+			return nil, fmt.Errorf("unknown family basic key %q", key)
+		}
+		layout[host] = key
+	}
+	return layout, nil
+}
+
+// SetKey 按下或松开Family BASIC键盘上的键，返回是否有这个键
+func (k *Keyboard) SetKey(name string, pressed bool) bool {
+	pos, ok := keyboardKeys[name]
+	if !ok {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if pressed {
+		k.pressed[pos.row][pos.column] |= pos.bit
+	} else {
+		k.pressed[pos.row][pos.column] &^= pos.bit
+	}
+	return true
+}
+
+// PressKey 按照Layout处理主机键盘的输入，返回这个键是否属于Family BASIC键盘
+func (k *Keyboard) PressKey(key string, pressed bool) bool {
+	name, ok := k.Layout[key]
+	if !ok {
+		return false
+	}
+	return k.SetKey(name, pressed)
+}
+
+func (k *Keyboard) Write(val byte) {
+	k.mu.Lock()
+	column := int(val >> 1 & 0x01)
+	if val&0x01 != 0 {
+		k.row = 0
+	} else if k.column == 1 && column == 0 {
+		k.row += 1
+	}
+	k.column = column
+	k.enabled = val&0x04 != 0
+	k.mu.Unlock()
+	if k.Tape != nil {
+		k.Tape.Write(val&0x04 != 0)
+	}
+}
+
+func (k *Keyboard) Read(port int) byte {
+	return k.Peek(port)
+}
+
+func (k *Keyboard) Peek(port int) byte {
+	if port&1 == 0 {
+		if k.Tape != nil {
+			return k.Tape.Read() << 1
+		}
+		return 0
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.enabled {
+		return 0
+	}
+	if k.row >= keyboardRows { // 第9行以后没有键
+		return 0x1E
+	}
+	return ^k.pressed[k.row][k.column] & 0x1E
+}
+
+// WriteState 录音机的状态由DataRecorder自己写出
+func (k *Keyboard) WriteState(w io.Writer) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, row := range k.pressed {
+		w.Write(row[:])
+	}
+	binary.Write(w, binary.LittleEndian, []int64{int64(k.row), int64(k.column)})
+	binary.Write(w, binary.LittleEndian, k.enabled)
+}
@@ -0,0 +1,51 @@
+package pad
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// scanKeyboard 按Family BASIC的方式扫描整个键盘，返回每一行两列读到的值
+func scanKeyboard(k *Keyboard) [][2]byte {
+	var res [][2]byte
+	k.Write(0x05)
+	for row := 0; row < keyboardRows+1; row++ {
+		var r [2]byte
+		k.Write(0x04)
+		r[0] = k.Read(1)
+		k.Write(0x06)
+		r[1] = k.Read(1)
+		res = append(res, r)
+	}
+	return res
+}
+
+func TestKeyboard(t *testing.T) {
+	k := NewKeyboard()
+	require.True(t, k.PressKey("A", true))
+	require.True(t, k.SetKey("X", true))
+	require.True(t, k.PressKey("Return", true))
+	require.False(t, k.PressKey("F12", true))
+
+	rows := scanKeyboard(k)
+	require.Equal(t, [2]byte{0x16, 0x1E}, rows[0]) // RETURN在bit3
+	require.Equal(t, [2]byte{0x1C, 0x0E}, rows[6]) // A在第0列bit1，X在第1列bit4
+	require.Equal(t, [2]byte{0x1E, 0x1E}, rows[8])
+	require.Equal(t, [2]byte{0x1E, 0x1E}, rows[9])
+
+	k.PressKey("A", false)
+	require.Equal(t, [2]byte{0x1E, 0x0E}, scanKeyboard(k)[6])
+
+	k.Write(0x00) // 没有启用键盘
+	require.Equal(t, byte(0), k.Read(1))
+}
+
+func TestParseKeyboardLayout(t *testing.T) {
+	layout, err := ParseKeyboardLayout("F9=STOP, ==^")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"F9": "STOP", "=": "^"}, layout)
+	_, err = ParseKeyboardLayout("F9=PAUSE")
+	require.Error(t, err)
+	_, err = ParseKeyboardLayout("F9")
+	require.Error(t, err)
+}
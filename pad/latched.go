@@ -0,0 +1,156 @@
+package pad
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// Buttons 按照FM2的顺序 RLDUTSBA 排列，正好是从高位到低位
+var Buttons = []ButtonType{
+	BUTTON_RIGHT, BUTTON_LEFT, BUTTON_DOWN, BUTTON_UP,
+	BUTTON_START, BUTTON_SELECT, BUTTON_B, BUTTON_A,
+}
+
+// LatchedPad 键盘输入先记下来，每帧开始调用Latch时才交给手柄，保证一帧之内读到的按键不会变化。
+// 回放录像时用Set直接设置按键状态，并且用SetLocked忽略键盘输入。
+//
+// 连发键按住时每TurboPeriod帧按下、松开一次；默认不允许同时按下左右或上下，
+// 两个都按下时都当作松开，TAS需要时可以用SetAllowOpposite打开。
+type LatchedPad struct {
+	Pad
+	mu            sync.Mutex
+	pending       byte
+	turbo         byte // 按住的连发键
+	turboPeriod   int
+	turboFrame    int
+	allowOpposite bool
+	state         byte // 已经交给手柄的按键状态
+	locked        bool
+}
+
+// DefaultTurboPeriod 连发的周期，60帧每秒时每秒按15次
+const DefaultTurboPeriod = 4
+
+func NewLatchedPad(p Pad) *LatchedPad {
+	return &LatchedPad{Pad: p, turboPeriod: DefaultTurboPeriod}
+}
+
+func (p *LatchedPad) UpdateButton(buttonType ButtonType, pressDown bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.locked {
+		return
+	}
+	if pressDown {
+		p.pending |= byte(buttonType)
+	} else {
+		p.pending &= ^byte(buttonType)
+	}
+}
+
+// UpdateTurbo 按下或松开连发键
+func (p *LatchedPad) UpdateTurbo(buttonType ButtonType, pressDown bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.locked {
+		return
+	}
+	if pressDown {
+		if p.turbo == 0 {
+			p.turboFrame = 0 // 第一次按下时马上生效
+		}
+		p.turbo |= byte(buttonType)
+	} else {
+		p.turbo &= ^byte(buttonType)
+	}
+}
+
+// SetTurboPeriod 连发一次(按下加松开)的帧数，最少2帧
+func (p *LatchedPad) SetTurboPeriod(frames int) {
+	if frames < 2 {
+		frames = 2
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.turboPeriod = frames
+}
+
+// SetAllowOpposite 是否允许同时按下左右或上下
+func (p *LatchedPad) SetAllowOpposite(allow bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.allowOpposite = allow
+}
+
+// Latch 把这一帧之前的键盘输入交给手柄，返回当前的按键状态
+func (p *LatchedPad) Latch() byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := p.pending
+	if p.turbo != 0 {
+		if p.turboFrame < p.turboPeriod/2 {
+			state |= p.turbo
+		}
+		p.turboFrame = (p.turboFrame + 1) % p.turboPeriod
+	}
+	if !p.allowOpposite {
+		state = blockOpposite(state)
+	}
+	p.apply(state)
+	return p.state
+}
+
+// blockOpposite 同时按下左右或上下时都当作松开
+func blockOpposite(state byte) byte {
+	for _, pair := range [2]byte{byte(BUTTON_LEFT | BUTTON_RIGHT), byte(BUTTON_UP | BUTTON_DOWN)} {
+		if state&pair == pair {
+			state &^= pair
+		}
+	}
+	return state
+}
+
+// Set 直接设置按键状态
+func (p *LatchedPad) Set(state byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.apply(state)
+}
+
+// SetLocked 锁定之后忽略UpdateButton和UpdateTurbo，解锁时清空没有交给手柄的输入
+func (p *LatchedPad) SetLocked(locked bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.locked = locked
+	p.pending = 0
+	p.turbo = 0
+}
+
+// State 返回已经交给手柄的按键状态
+func (p *LatchedPad) State() byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// WriteState 写出已经交给手柄的按键、连发的状态和内部手柄的状态，还没有Latch的键盘输入不算
+func (p *LatchedPad) WriteState(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w.Write([]byte{p.state, p.turbo})
+	binary.Write(w, binary.LittleEndian, int64(p.turboFrame))
+	if s, ok := p.Pad.(StateWriter); ok {
+		s.WriteState(w)
+	}
+}
+
+// apply 只把变化的按键交给内部的手柄
+func (p *LatchedPad) apply(state byte) {
+	for _, button := range Buttons {
+		if (state^p.state)&byte(button) != 0 {
+			p.Pad.UpdateButton(button, state&byte(button) != 0)
+		}
+	}
+	p.state = state
+}
@@ -0,0 +1,42 @@
+package pad
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLatchedPadTurbo(t *testing.T) {
+	p := NewLatchedPad(NewPad())
+	p.UpdateTurbo(BUTTON_A, true)
+	p.UpdateButton(BUTTON_B, true)
+	res := make([]byte, 0)
+	for i := 0; i < 8; i++ {
+		res = append(res, p.Latch())
+	}
+	a, b := byte(BUTTON_A), byte(BUTTON_B)
+	require.Equal(t, []byte{a | b, a | b, b, b, a | b, a | b, b, b}, res)
+
+	p.SetTurboPeriod(2)
+	require.Equal(t, a|b, p.Latch()) // 周期变短之后接着当前的帧数
+	require.Equal(t, b, p.Latch())
+	p.UpdateTurbo(BUTTON_A, false)
+	require.Equal(t, b, p.Latch())
+	require.Equal(t, b, p.Latch())
+
+	p.SetLocked(true)
+	p.UpdateTurbo(BUTTON_A, true)
+	require.Equal(t, byte(0), p.Latch())
+}
+
+func TestLatchedPadOpposite(t *testing.T) {
+	p := NewLatchedPad(NewPad())
+	p.UpdateButton(BUTTON_LEFT, true)
+	p.UpdateButton(BUTTON_RIGHT, true)
+	p.UpdateButton(BUTTON_UP, true)
+	require.Equal(t, byte(BUTTON_UP), p.Latch())
+	p.UpdateButton(BUTTON_DOWN, true)
+	require.Equal(t, byte(0), p.Latch())
+
+	p.SetAllowOpposite(true)
+	require.Equal(t, byte(BUTTON_LEFT|BUTTON_RIGHT|BUTTON_UP|BUTTON_DOWN), p.Latch())
+}
@@ -1,13 +1,29 @@
 package pad
 
-import "fmt"
+import (
+	"encoding/binary"
+	"io"
+)
 
-type Pad interface {
+// InputDevice 插在输入端口上的设备: 标准手柄、光枪、Arkanoid手柄、Power Pad等
+type InputDevice interface {
 	ReadForCPU() byte
-	UpdateButton(buttonType ButtonType, pressDown bool)
+	// PeekForCPU 返回ReadForCPU会读到的值，但是不会移动到下一个按键
+	PeekForCPU() byte
 	WriteForCPU(value byte)
 }
 
+// Pad 有8个按键的标准手柄
+type Pad interface {
+	InputDevice
+	UpdateButton(buttonType ButtonType, pressDown bool)
+}
+
+// StateWriter 可以把内部状态写到w的设备，用来计算模拟器状态的hash
+type StateWriter interface {
+	WriteState(w io.Writer)
+}
+
 type DefaultPad struct {
 	strobe      bool
 	data        byte
@@ -36,7 +52,6 @@ const (
 )
 
 func (p *DefaultPad) UpdateButton(buttonType ButtonType, pressDown bool) {
-	fmt.Printf("update button %v %v", buttonType, pressDown)
 	if pressDown {
 		p.data |= byte(buttonType)
 	} else {
@@ -53,6 +68,13 @@ func (p *DefaultPad) WriteForCPU(value byte) {
 	}
 }
 
+func (p *DefaultPad) PeekForCPU() byte {
+	if p.strobe {
+		return p.data & byte(BUTTON_A)
+	}
+	return (p.data >> p.buttonIndex) & 0x01
+}
+
 func (p *DefaultPad) ReadForCPU() byte {
 	if p.strobe {
 		return p.data & byte(BUTTON_A)
@@ -63,3 +85,8 @@ func (p *DefaultPad) ReadForCPU() byte {
 		return res
 	}
 }
+
+func (p *DefaultPad) WriteState(w io.Writer) {
+	binary.Write(w, binary.LittleEndian, p.strobe)
+	binary.Write(w, binary.LittleEndian, []int64{int64(p.data), int64(p.buttonIndex)})
+}
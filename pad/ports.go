@@ -0,0 +1,156 @@
+package pad
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Ports CPU在$4016/$4017看到的输入端口 https://www.nesdev.org/wiki/Input_devices
+//
+// 写$4016的bit0(OUT0)同时送到两个口，两个口分别从$4016(port 0)和$4017(port 1)读。
+// 一个设备可以同时占用两个口，例如Four Score。
+type Ports interface {
+	Write(val byte)
+	Read(port int) byte
+	// Peek 返回Read会读到的值，但是不会移动到下一位
+	Peek(port int) byte
+}
+
+// StandardPorts 两个口各插一个设备，例如标准手柄、光枪，没有插设备的口读到0。
+// 红白机的扩展口也接在$4016/$4017上，Expansion读到的位和两个口的合在一起。
+type StandardPorts struct {
+	Devices   [2]InputDevice
+	Expansion Ports
+}
+
+func NewPorts(port1, port2 InputDevice) *StandardPorts {
+	return &StandardPorts{Devices: [2]InputDevice{port1, port2}}
+}
+
+func (p *StandardPorts) Write(val byte) {
+	for _, d := range p.Devices {
+		if d != nil {
+			d.WriteForCPU(val)
+		}
+	}
+	if p.Expansion != nil {
+		p.Expansion.Write(val)
+	}
+}
+
+func (p *StandardPorts) Read(port int) byte {
+	var val byte
+	if p.Expansion != nil {
+		val = p.Expansion.Read(port)
+	}
+	return val | readDevice(p.Devices[port&1], true)
+}
+
+func (p *StandardPorts) Peek(port int) byte {
+	var val byte
+	if p.Expansion != nil {
+		val = p.Expansion.Peek(port)
+	}
+	return val | readDevice(p.Devices[port&1], false)
+}
+
+// FourScore NES的四人适配器 https://www.nesdev.org/wiki/Four_Score
+//
+// 每个口串行输出24位: 手柄1(2)的8位，手柄3(4)的8位，然后是8位的签名，
+// $4016的签名是$10，$4017是$20，都是低位先出，24位之后一直是1。
+type FourScore struct {
+	Pads   [4]InputDevice
+	strobe bool
+	index  [2]int
+}
+
+var fourScoreSignatures = [2]byte{0x10, 0x20}
+
+func NewFourScore(pads [4]InputDevice) *FourScore {
+	return &FourScore{Pads: pads}
+}
+
+func (f *FourScore) Write(val byte) {
+	f.strobe = val&0x01 != 0
+	if f.strobe {
+		f.index = [2]int{}
+	}
+	for _, p := range f.Pads {
+		if p != nil {
+			p.WriteForCPU(val)
+		}
+	}
+}
+
+// WriteState 只写出适配器自己的位置，手柄的状态由手柄自己写出
+func (f *FourScore) WriteState(w io.Writer) {
+	binary.Write(w, binary.LittleEndian, f.strobe)
+	binary.Write(w, binary.LittleEndian, []int64{int64(f.index[0]), int64(f.index[1])})
+}
+
+func (f *FourScore) Read(port int) byte {
+	port &= 1
+	val := f.peek(port, true)
+	if !f.strobe {
+		f.index[port] += 1
+	}
+	return val
+}
+
+func (f *FourScore) Peek(port int) byte {
+	return f.peek(port&1, false)
+}
+
+func (f *FourScore) peek(port int, read bool) byte {
+	i := f.index[port]
+	if f.strobe {
+		i = 0
+	}
+	switch {
+	case i < 8:
+		return readDevice(f.Pads[port], read)
+	case i < 16:
+		return readDevice(f.Pads[port+2], read)
+	case i < 24:
+		return fourScoreSignatures[port] >> (i - 16) & 0x01
+	}
+	return 1
+}
+
+func readDevice(p InputDevice, read bool) byte {
+	switch {
+	case p == nil:
+		return 0
+	case read:
+		return p.ReadForCPU()
+	}
+	return p.PeekForCPU()
+}
+
+// FamicomFourPlayer 红白机通过扩展口接的第3、4个手柄，
+// 手柄1、2在bit0，手柄3、4分别在$4016和$4017的bit1，不需要签名。
+type FamicomFourPlayer struct {
+	Pads [4]InputDevice
+}
+
+func NewFamicomFourPlayer(pads [4]InputDevice) *FamicomFourPlayer {
+	return &FamicomFourPlayer{Pads: pads}
+}
+
+func (f *FamicomFourPlayer) Write(val byte) {
+	for _, p := range f.Pads {
+		if p != nil {
+			p.WriteForCPU(val)
+		}
+	}
+}
+
+func (f *FamicomFourPlayer) Read(port int) byte {
+	port &= 1
+	return readDevice(f.Pads[port], true) | readDevice(f.Pads[port+2], true)<<1
+}
+
+func (f *FamicomFourPlayer) Peek(port int) byte {
+	port &= 1
+	return readDevice(f.Pads[port], false) | readDevice(f.Pads[port+2], false)<<1
+}
@@ -0,0 +1,64 @@
+package pad
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newPads(buttons ...ButtonType) [4]InputDevice {
+	var pads [4]InputDevice
+	for i := range pads {
+		p := NewPad()
+		p.UpdateButton(buttons[i], true)
+		pads[i] = p
+	}
+	return pads
+}
+
+// readBits 从port连续读n次，返回每次读到的值
+func readBits(p Ports, port, n int) []byte {
+	res := make([]byte, n)
+	for i := range res {
+		res[i] = p.Read(port)
+	}
+	return res
+}
+
+func TestFourScore(t *testing.T) {
+	f := NewFourScore(newPads(BUTTON_A, BUTTON_B, BUTTON_START, BUTTON_RIGHT))
+	f.Write(1)
+	require.Equal(t, byte(1), f.Read(0)) // strobe时一直读A键
+	require.Equal(t, byte(1), f.Read(0))
+	f.Write(0)
+
+	require.Equal(t, []byte{
+		1, 0, 0, 0, 0, 0, 0, 0, // 手柄1 A
+		0, 0, 0, 1, 0, 0, 0, 0, // 手柄3 START
+		0, 0, 0, 0, 1, 0, 0, 0, // 签名$10
+		1, 1,
+	}, readBits(f, 0, 26))
+	require.Equal(t, []byte{
+		0, 1, 0, 0, 0, 0, 0, 0, // 手柄2 B
+		0, 0, 0, 0, 0, 0, 0, 1, // 手柄4 RIGHT
+		0, 0, 0, 0, 0, 1, 0, 0, // 签名$20
+	}, readBits(f, 1, 24))
+	require.Equal(t, byte(1), f.Peek(0))
+}
+
+func TestFamicomFourPlayer(t *testing.T) {
+	f := NewFamicomFourPlayer(newPads(BUTTON_A, BUTTON_B, BUTTON_A, BUTTON_SELECT))
+	f.Write(1)
+	f.Write(0)
+	require.Equal(t, []byte{3, 0, 0, 0}, readBits(f, 0, 4))
+	require.Equal(t, []byte{0, 1, 2, 0}, readBits(f, 1, 4))
+}
+
+func TestStandardPorts(t *testing.T) {
+	pad1 := NewPad()
+	pad1.UpdateButton(BUTTON_B, true)
+	p := NewPorts(pad1, nil)
+	p.Write(1)
+	p.Write(0)
+	require.Equal(t, []byte{0, 1}, readBits(p, 0, 2))
+	require.Equal(t, byte(0), p.Read(1))
+}
@@ -0,0 +1,188 @@
+package pad
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PowerPad Power Pad / Family Trainer 跳舞毯，12个按钮，编号1-12
+// https://www.nesdev.org/wiki/Power_Pad
+//
+//	1  2  3  4
+//	5  6  7  8
+//	9 10 11 12
+//
+// NES版插在2号口，作为InputDevice使用，strobe之后两位串行输出，按下是1:
+// bit3依次是 2 1 5 9 6 10 11 7，bit4依次是 4 3 12 8，之后一直是1。
+//
+// 红白机的Family Trainer插在扩展口，作为Ports使用: 写$4016的bit0-2选择一行(0表示选中)，
+// 读$4017的bit1-4是这一行的4个按钮，按下是0。
+type PowerPad struct {
+	// Layout 主机键盘的按键名到按钮编号，UI按这个表把键盘输入交给PressKey
+	Layout map[string]int
+
+	mu      sync.Mutex
+	buttons uint16 // bit n-1 是按钮n
+	strobe  bool
+	index   int
+	rows    byte // Family Trainer选择的行，$4016写入的低3位
+}
+
+var (
+	powerPadD3 = [8]int{2, 1, 5, 9, 6, 10, 11, 7}
+	powerPadD4 = [4]int{4, 3, 12, 8}
+	// Family Trainer的行选择位和每一行在bit1-4的按钮
+	familyTrainerRows = [3]struct {
+		bit     byte
+		buttons [4]int
+	}{
+		{0x04, [4]int{4, 3, 2, 1}},
+		{0x02, [4]int{8, 7, 6, 5}},
+		{0x01, [4]int{12, 11, 10, 9}},
+	}
+)
+
+// DefaultPowerPadLayout 键盘上的3x4个键对应跳舞毯上的位置
+var DefaultPowerPadLayout = map[string]int{
+	"Q": 1, "W": 2, "E": 3, "R": 4,
+	"A": 5, "S": 6, "D": 7, "F": 8,
+	"Z": 9, "X": 10, "C": 11, "V": 12,
+}
+
+func NewPowerPad() *PowerPad {
+	layout := make(map[string]int, len(DefaultPowerPadLayout))
+	for k, v := range DefaultPowerPadLayout {
+		layout[k] = v
+	}
+	return &PowerPad{Layout: layout, rows: 0x07}
+}
+
+// ParsePowerPadLayout 解析 "1=Q,2=W,..." 格式的按键布局，没有写到的按钮没有按键
+func ParsePowerPadLayout(s string) (map[string]int, error) {
+	layout := map[string]int{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("power pad layout %q should be button=key", item)
+		}
+		button, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil || button < 1 || button > 12 {
+			return nil, fmt.Errorf("power pad button %q should be 1-12", kv[0])
+		}
+		layout[strings.TrimSpace(kv[1])] = button
+	}
+	return layout, nil
+}
+
+// SetButton 按下或松开按钮n(1-12)
+func (p *PowerPad) SetButton(n int, pressed bool) {
+	if n < 1 || n > 12 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pressed {
+		p.buttons |= 1 << (n - 1)
+	} else {
+		p.buttons &^= 1 << (n - 1)
+	}
+}
+
+// PressKey 按照Layout处理键盘输入，返回这个键是否属于跳舞毯
+func (p *PowerPad) PressKey(key string, pressed bool) bool {
+	n, ok := p.Layout[key]
+	if ok {
+		p.SetButton(n, pressed)
+	}
+	return ok
+}
+
+func (p *PowerPad) pressed(n int) byte {
+	return byte(p.buttons >> (n - 1) & 0x01)
+}
+
+func (p *PowerPad) WriteForCPU(value byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.strobe = value&0x01 != 0
+	p.rows = value & 0x07
+	if p.strobe {
+		p.index = 0
+	}
+}
+
+func (p *PowerPad) peek() byte {
+	i := p.index
+	if p.strobe {
+		i = 0
+	}
+	var val byte = 0x18 // 8位之后两位都是1
+	if i < len(powerPadD3) {
+		val = p.pressed(powerPadD3[i]) << 3
+		if i < len(powerPadD4) {
+			val |= p.pressed(powerPadD4[i]) << 4
+		} else {
+			val |= 0x10
+		}
+	}
+	return val
+}
+
+func (p *PowerPad) ReadForCPU() byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	val := p.peek()
+	if !p.strobe {
+		p.index += 1
+	}
+	return val
+}
+
+func (p *PowerPad) PeekForCPU() byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peek()
+}
+
+// Write Family Trainer，和WriteForCPU一样
+func (p *PowerPad) Write(val byte) {
+	p.WriteForCPU(val)
+}
+
+func (p *PowerPad) Read(port int) byte {
+	return p.Peek(port)
+}
+
+// Peek Family Trainer只接在$4017上，选中的几行合在一起
+func (p *PowerPad) Peek(port int) byte {
+	if port&1 == 0 {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var pressed byte
+	for _, row := range familyTrainerRows {
+		if p.rows&row.bit != 0 {
+			continue
+		}
+		for i, n := range row.buttons {
+			pressed |= p.pressed(n) << (i + 1)
+		}
+	}
+	return ^pressed & 0x1E
+}
+
+func (p *PowerPad) WriteState(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	binary.Write(w, binary.LittleEndian, []int64{int64(p.buttons), int64(p.index), int64(p.rows)})
+	binary.Write(w, binary.LittleEndian, p.strobe)
+}
@@ -0,0 +1,40 @@
+package pad
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPowerPad(t *testing.T) {
+	p := NewPowerPad()
+	p.SetButton(1, true)
+	require.True(t, p.PressKey("V", true)) // 按钮12
+	require.False(t, p.PressKey("P", true))
+
+	p.WriteForCPU(1)
+	p.WriteForCPU(0)
+	// bit3: 2 1 5 9 6 10 11 7，bit4: 4 3 12 8
+	require.Equal(t, []byte{
+		0x00, 0x08, 0x10, 0x00, 0x10, 0x10, 0x10, 0x10,
+		0x18, 0x18,
+	}, readDeviceBits(p, 10))
+
+	// Family Trainer: 选中的一行按下的按钮读到0
+	p.Write(0x03)
+	require.Equal(t, byte(0x0E), p.Read(1))
+	p.Write(0x06)
+	require.Equal(t, byte(0x1C), p.Read(1))
+	p.Write(0x07)
+	require.Equal(t, byte(0x1E), p.Read(1))
+	require.Equal(t, byte(0), p.Read(0))
+}
+
+func TestParsePowerPadLayout(t *testing.T) {
+	layout, err := ParsePowerPadLayout("1=Y, 2=U,12=M")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"Y": 1, "U": 2, "M": 12}, layout)
+	_, err = ParsePowerPadLayout("13=Q")
+	require.Error(t, err)
+	_, err = ParsePowerPadLayout("Q")
+	require.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package pad
+
+import (
+	"encoding/binary"
+	"errors"
+	"fc-emulator/apu"
+	"io"
+	"sync"
+	"time"
+)
+
+// TapeState 录音机的状态
+type TapeState int
+
+const (
+	TapeStopped TapeState = iota
+	TapePlaying
+	TapeRecording
+)
+
+var tapeStateNames = map[TapeState]string{
+	TapeStopped:   "stopped",
+	TapePlaying:   "playing",
+	TapeRecording: "recording",
+}
+
+func (s TapeState) String() string {
+	return tapeStateNames[s]
+}
+
+// DefaultTapeSampleRate 新磁带的采样率
+const DefaultTapeSampleRate = 44100
+
+// tapeLevel 录音时写入的振幅
+const tapeLevel = 0.5
+
+// DataRecorder Family BASIC的数据录音机，磁带的内容是WAV的采样
+//
+// 磁带按CPU周期走带，和CPU跑得多快无关: 录音时把键盘送来的1位信号按时间展开成方波，
+// 放音时按当前的时间取采样，大于0读到1。
+type DataRecorder struct {
+	SampleRate int
+	Samples    []float32
+
+	mu     sync.Mutex
+	clock  func() uint64 // CPU周期
+	state  TapeState
+	start  uint64 // 开始放音或录音时的CPU周期
+	offset int    // 开始放音或录音时磁带的位置
+	level  bool   // 录音时当前的信号
+}
+
+// NewDataRecorder clock返回CPU执行过的周期数，是录音机的时钟
+func NewDataRecorder(clock func() uint64) *DataRecorder {
+	return &DataRecorder{SampleRate: DefaultTapeSampleRate, clock: clock}
+}
+
+// Load 从WAV文件读入磁带，回到开头并停止
+func (d *DataRecorder) Load(r io.Reader) error {
+	samples, rate, err := apu.ReadWav(r)
+	if err != nil {
+		return err
+	}
+	if rate <= 0 {
+		return errors.New("invalid tape sample rate")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Samples, d.SampleRate = samples, rate
+	d.state, d.offset = TapeStopped, 0
+	return nil
+}
+
+// Save 把磁带保存成WAV文件，正在录音时先停止
+func (d *DataRecorder) Save(w io.WriteSeeker) error {
+	d.Stop()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ww, err := apu.NewWavWriter(w, d.SampleRate)
+	if err != nil {
+		return err
+	}
+	if err := ww.WriteSamples(d.Samples); err != nil {
+		return err
+	}
+	return ww.Close()
+}
+
+// position 磁带当前的位置，单位是采样
+func (d *DataRecorder) position() int {
+	if d.state == TapeStopped {
+		return d.offset
+	}
+	return d.offset + int((d.clock()-d.start)*uint64(d.SampleRate)/apu.CPUFrequency)
+}
+
+func (d *DataRecorder) setState(state TapeState) {
+	d.flush()
+	d.offset = d.position()
+	d.start = d.clock()
+	d.state = state
+}
+
+// Play 从当前位置开始放音
+func (d *DataRecorder) Play() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setState(TapePlaying)
+}
+
+// Record 从当前位置开始录音，之后的内容会被覆盖
+func (d *DataRecorder) Record() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setState(TapeRecording)
+	if d.offset < len(d.Samples) {
+		d.Samples = d.Samples[:d.offset]
+	}
+}
+
+func (d *DataRecorder) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setState(TapeStopped)
+}
+
+// Rewind 停止并回到磁带开头
+func (d *DataRecorder) Rewind() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setState(TapeStopped)
+	d.offset = 0
+}
+
+func (d *DataRecorder) State() TapeState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// Position 磁带当前的位置和总长度
+func (d *DataRecorder) Position() (pos, length time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, n := d.position(), len(d.Samples)
+	if p > n {
+		n = p
+	}
+	return d.duration(p), d.duration(n)
+}
+
+func (d *DataRecorder) duration(samples int) time.Duration {
+	return time.Duration(samples) * time.Second / time.Duration(d.SampleRate)
+}
+
+// flush 录音时把上次写入之后的信号补到磁带上
+func (d *DataRecorder) flush() {
+	if d.state != TapeRecording {
+		return
+	}
+	var v float32 = -tapeLevel
+	if d.level {
+		v = tapeLevel
+	}
+	for pos := d.position(); len(d.Samples) < pos; {
+		d.Samples = append(d.Samples, v)
+	}
+}
+
+// Write 键盘送来的信号，只在录音时有用
+func (d *DataRecorder) Write(level bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state != TapeRecording || level == d.level {
+		return
+	}
+	d.flush()
+	d.level = level
+}
+
+// Read 放音时读到的信号，磁带放完之后读到0
+func (d *DataRecorder) Read() byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state != TapePlaying {
+		return 0
+	}
+	pos := d.position()
+	if pos < len(d.Samples) && d.Samples[pos] > 0 {
+		return 1
+	}
+	return 0
+}
+
+// WriteState 写出走带的状态和磁带的内容，录音会改变磁带
+func (d *DataRecorder) WriteState(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	binary.Write(w, binary.LittleEndian, []int64{int64(d.state), int64(d.start), int64(d.offset), int64(d.SampleRate)})
+	binary.Write(w, binary.LittleEndian, d.level)
+	binary.Write(w, binary.LittleEndian, d.Samples)
+}
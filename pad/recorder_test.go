@@ -0,0 +1,58 @@
+package pad
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDataRecorder(t *testing.T) {
+	var cycles uint64
+	d := NewDataRecorder(func() uint64 { return cycles })
+	d.SampleRate = 1000
+	k := NewKeyboard()
+	k.Tape = d
+	samples := func(n uint64) uint64 { return n * 1789773 / 1000 } // n个采样对应的CPU周期数
+
+	// 录音: 低10个采样，高10个采样，再低10个采样
+	d.Record()
+	cycles = samples(10) + 1
+	k.Write(0x04)
+	cycles = samples(20) + 1
+	k.Write(0x00)
+	cycles = samples(30) + 1
+	d.Stop()
+	require.Len(t, d.Samples, 30)
+	require.Equal(t, float32(-tapeLevel), d.Samples[9])
+	require.Equal(t, float32(tapeLevel), d.Samples[10])
+	require.Equal(t, float32(tapeLevel), d.Samples[19])
+	require.Equal(t, float32(-tapeLevel), d.Samples[20])
+
+	pos, length := d.Position()
+	require.Equal(t, 30*time.Millisecond, pos)
+	require.Equal(t, 30*time.Millisecond, length)
+
+	// 保存之后重新读入，从头放音
+	f, err := os.CreateTemp("", "tape-*.wav")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	require.NoError(t, d.Save(f))
+	_, err = f.Seek(0, 0)
+	require.NoError(t, err)
+	require.NoError(t, d.Load(f))
+	require.Len(t, d.Samples, 30)
+
+	require.Equal(t, byte(0), k.Read(0)) // 没有放音
+	start := cycles
+	d.Play()
+	require.Equal(t, TapePlaying, d.State())
+	require.Equal(t, byte(0), k.Read(0))
+	cycles = start + samples(15)
+	require.Equal(t, byte(0x02), k.Read(0))
+	cycles = start + samples(25)
+	require.Equal(t, byte(0), k.Read(0))
+	cycles = start + samples(40) // 放完了
+	require.Equal(t, byte(0), k.Read(0))
+}
@@ -0,0 +1,131 @@
+package pad
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+	"sync"
+)
+
+// LightSource Zapper的光电管看到的画面，一般是PPU
+type LightSource interface {
+	Render() image.Image
+	// Beam 电子束当前所在的扫描线和点
+	Beam() (line, dot int)
+	// Drawn 这一帧里(x, y)是不是已经画出来了
+	Drawn(x, y int) bool
+}
+
+const (
+	zapperRadius    = 3    // 光电管看到的是瞄准点周围的一小块区域
+	zapperLightLine = 26   // 电子束扫过之后大约这么多行之内还能感应到光
+	zapperThreshold = 0xC0 // 亮度超过这个值才算白色
+)
+
+// Zapper 光枪，插在2号口 https://www.nesdev.org/wiki/Zapper
+//
+// 读$4017时bit3为0表示感应到光，bit4为1表示扣下了扳机。
+// 光感根据瞄准点周围已经画出来的、电子束刚刚扫过的像素亮度判断。
+// 一帧的画面在第一次读的时候才画出来，然后缓存到NewFrame为止。
+type Zapper struct {
+	light LightSource
+
+	mu       sync.Mutex
+	x, y     int
+	onScreen bool
+	trigger  bool
+	pulled   bool // 这一帧扣过扳机，点击很快的时候也至少保持一帧
+	frame    image.Image
+}
+
+func NewZapper(light LightSource) *Zapper {
+	return &Zapper{light: light}
+}
+
+// Aim 瞄准画面上的(x, y)，onScreen为false表示没有指着屏幕
+func (z *Zapper) Aim(x, y int, onScreen bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.x, z.y, z.onScreen = x, y, onScreen
+}
+
+func (z *Zapper) SetTrigger(pulled bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.trigger = pulled
+	if pulled {
+		z.pulled = true
+	}
+}
+
+// NewFrame 每帧开始时调用，丢掉上一帧的画面
+func (z *Zapper) NewFrame() {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.frame = nil
+	z.pulled = z.trigger
+}
+
+// UpdateButton A键当作扳机，这样键盘和录像也可以开枪
+func (z *Zapper) UpdateButton(buttonType ButtonType, pressDown bool) {
+	if buttonType == BUTTON_A {
+		z.SetTrigger(pressDown)
+	}
+}
+
+func (z *Zapper) WriteForCPU(value byte) {
+}
+
+func (z *Zapper) ReadForCPU() byte {
+	return z.PeekForCPU()
+}
+
+func (z *Zapper) PeekForCPU() byte {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	var val byte
+	if !z.sense() {
+		val |= 0x08
+	}
+	if z.trigger || z.pulled {
+		val |= 0x10
+	}
+	return val
+}
+
+func (z *Zapper) sense() bool {
+	if !z.onScreen || z.light == nil {
+		return false
+	}
+	line, _ := z.light.Beam()
+	for y := z.y - zapperRadius; y <= z.y+zapperRadius; y++ {
+		if line-y > zapperLightLine {
+			continue
+		}
+		for x := z.x - zapperRadius; x <= z.x+zapperRadius; x++ {
+			if !z.light.Drawn(x, y) {
+				continue
+			}
+			if z.frame == nil {
+				z.frame = z.light.Render()
+			}
+			if !(image.Point{X: x, Y: y}.In(z.frame.Bounds())) {
+				continue
+			}
+			r, g, b, _ := z.frame.At(x, y).RGBA()
+			luma := (299*r + 587*g + 114*b) / 1000 >> 8
+			if luma >= zapperThreshold {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteState 缓存的画面可以从PPU重新得到，不用写出
+func (z *Zapper) WriteState(w io.Writer) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	binary.Write(w, binary.LittleEndian, []int64{int64(z.x), int64(z.y)})
+	binary.Write(w, binary.LittleEndian, []bool{z.onScreen, z.trigger, z.pulled})
+}
@@ -0,0 +1,60 @@
+package pad
+
+import (
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// fakeLight 黑色的画面中间有一个白色方块，电子束停在line
+type fakeLight struct {
+	line    int
+	renders int
+}
+
+func (l *fakeLight) Render() image.Image {
+	l.renders += 1
+	m := image.NewRGBA(image.Rect(0, 0, 256, 240))
+	for y := 100; y < 116; y++ {
+		for x := 120; x < 136; x++ {
+			m.Set(x, y, color.White)
+		}
+	}
+	return m
+}
+
+func (l *fakeLight) Beam() (int, int) {
+	return l.line, 0
+}
+
+func (l *fakeLight) Drawn(x, y int) bool {
+	return y < l.line
+}
+
+func TestZapper(t *testing.T) {
+	light := &fakeLight{}
+	z := NewZapper(light)
+	require.Equal(t, byte(0x08), z.ReadForCPU()) // 没有瞄准屏幕
+
+	z.Aim(128, 108, true)
+	light.line = 50 // 还没有画到方块
+	require.Equal(t, byte(0x08), z.ReadForCPU())
+	light.line = 110
+	require.Equal(t, byte(0x00), z.ReadForCPU())
+	light.line = 200 // 光已经消失了
+	require.Equal(t, byte(0x08), z.ReadForCPU())
+	require.Equal(t, 1, light.renders) // 一帧只画一次
+
+	z.Aim(10, 108, true) // 黑色的地方
+	light.line = 110
+	require.Equal(t, byte(0x08), z.ReadForCPU())
+
+	// 很快的点击也要保持到下一帧
+	z.SetTrigger(true)
+	z.SetTrigger(false)
+	require.Equal(t, byte(0x18), z.ReadForCPU())
+	z.NewFrame()
+	require.Equal(t, byte(0x08), z.ReadForCPU())
+	require.Equal(t, 2, light.renders)
+}
@@ -0,0 +1,36 @@
+package ppu
+
+// 模拟器不是逐点渲染的: 每帧先进入vblank，然后执行固定数量的指令，画面在需要的时候用Render一次画出来。
+// 光枪这类依赖电子束位置的设备需要知道这一帧已经画到了哪里，
+// 所以按这一帧已经执行的比例换算出电子束的位置: 从vblank(第241行)开始，经过预渲染行回到第0行往下扫描。
+
+const (
+	ScreenWidth   = 256
+	ScreenHeight  = 240
+	DotsPerLine   = 341
+	LinesPerFrame = 262
+	VblankLine    = 241
+)
+
+// SetFrameProgress progress是这一帧已经运行的比例，[0, 1]
+func (p *PPUImpl) SetFrameProgress(progress float64) {
+	dots := int(progress * DotsPerLine * LinesPerFrame)
+	if dots >= DotsPerLine*LinesPerFrame { // 一帧结束时停在post-render行的最后一个点
+		dots = DotsPerLine*LinesPerFrame - 1
+	}
+	p.beamLine = (VblankLine + dots/DotsPerLine) % LinesPerFrame
+	p.beamDot = dots % DotsPerLine
+}
+
+// Beam 电子束当前所在的扫描线和点
+func (p *PPUImpl) Beam() (line, dot int) {
+	return p.beamLine, p.beamDot
+}
+
+// Drawn 这一帧里(x, y)是不是已经画出来了，vblank和预渲染行期间这一帧还没有开始画
+func (p *PPUImpl) Drawn(x, y int) bool {
+	if p.beamLine >= VblankLine {
+		return false
+	}
+	return y < p.beamLine || y == p.beamLine && x < p.beamDot
+}
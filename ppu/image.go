@@ -0,0 +1,59 @@
+package ppu
+
+import (
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+)
+
+// NTSC电视上下各有8行在画面外面，很多游戏在这里放垃圾
+const (
+	OverscanTop    = 8
+	OverscanBottom = 8
+)
+
+// CopyScreen 复制成一张新的RGBA图片，左上角是(0, 0)
+func CopyScreen(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(res, res.Bounds(), img, b.Min, draw.Src)
+	return res
+}
+
+// CropOverscan 去掉上下的overscan，256x240变成256x224
+func CropOverscan(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	return CopyScreen(img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}).SubImage(image.Rect(b.Min.X, b.Min.Y+OverscanTop, b.Max.X, b.Max.Y-OverscanBottom)))
+}
+
+// ScalePixels 按整数倍放大，每个像素变成scale x scale的方块，不做插值，ScaleImage会让像素变模糊
+func ScalePixels(img image.Image, scale int) *image.RGBA {
+	src := CopyScreen(img)
+	if scale <= 1 {
+		return src
+	}
+	b := src.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale))
+	for y := 0; y < res.Bounds().Dy(); y++ {
+		for x := 0; x < res.Bounds().Dx(); x++ {
+			res.SetRGBA(x, y, src.RGBAAt(x/scale, y/scale))
+		}
+	}
+	return res
+}
+
+// SavePNG PNG是无损的，保存的像素和画面完全一样
+func SavePNG(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
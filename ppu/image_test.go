@@ -0,0 +1,28 @@
+package ppu
+
+import (
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestScalePixels(t *testing.T) {
+	img := NewScreenImage()
+	red := color.RGBA{R: 0xFF, A: 0xFF}
+	img.SetRGBA(1, OverscanTop, red)
+
+	cropped := CropOverscan(img)
+	require.Equal(t, image.Rect(0, 0, 256, 224), cropped.Bounds())
+	require.Equal(t, red, cropped.RGBAAt(1, 0))
+
+	scaled := ScalePixels(cropped, 3)
+	require.Equal(t, image.Rect(0, 0, 768, 672), scaled.Bounds())
+	for y := 0; y < 3; y++ {
+		for x := 3; x < 6; x++ {
+			require.Equal(t, red, scaled.RGBAAt(x, y))
+		}
+	}
+	require.Equal(t, color.RGBA{A: 0xFF}, scaled.RGBAAt(6, 0)) // 没有插值
+	require.Equal(t, color.RGBA{A: 0xFF}, scaled.RGBAAt(2, 0))
+}
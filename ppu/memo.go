@@ -8,10 +8,18 @@ type PPUMemo interface {
 	Read(addr uint16) byte
 	ReadWord(addr uint16) uint16
 	Write(addr uint16, val byte)
+	// Peek 和Read一样，但是不会触发AccessHook
+	Peek(addr uint16) byte
+	// Poke 和Write一样，但是不会触发AccessHook
+	Poke(addr uint16, val byte)
 }
 
+// AccessHook 在PPU内存被读写时调用，供调试器实现watchpoint
+type AccessHook func(addr uint16, val byte, write bool)
+
 type DefaultPPUMemo struct {
-	Data []byte
+	Data       []byte
+	AccessHook AccessHook
 }
 
 func NewPPUMemo(rom *rom.NesRom) *DefaultPPUMemo {
@@ -28,13 +36,28 @@ func (m *DefaultPPUMemo) Read(addr uint16) byte {
 	if addr >= 0x4000 {
 		addr = addr % 0x4000
 	}
-	return m.Data[addr]
+	val := m.Data[addr]
+	if m.AccessHook != nil {
+		m.AccessHook(addr, val, false)
+	}
+	return val
+}
+
+func (m *DefaultPPUMemo) Peek(addr uint16) byte {
+	return m.Data[addr%0x4000]
+}
+
+func (m *DefaultPPUMemo) Poke(addr uint16, val byte) {
+	m.Data[addr%0x4000] = val
 }
 
 func (m *DefaultPPUMemo) Write(addr uint16, val byte) {
 	if addr >= 0x4000 {
 		addr = addr % 0x4000
 	}
+	if m.AccessHook != nil {
+		m.AccessHook(addr, val, true)
+	}
 	m.Data[addr] = val
 }
 
@@ -29,6 +29,15 @@ var AllColor = [64]uint32{
 	0xB3EEFF, 0xDDDDDD, 0x111111, 0x111111,
 }
 
+// ScreenPalette 画面上可能出现的所有颜色，屏幕的底色黑色也在里面，GIF录像使用
+func ScreenPalette() color.Palette {
+	res := make(color.Palette, 0, len(AllColor))
+	for _, u := range AllColor {
+		res = append(res, uint32ToRgb(u))
+	}
+	return res
+}
+
 type BgPaletteImp struct {
 	data           [16]byte
 	UniversalColor byte
@@ -7,15 +7,22 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	"io"
 )
 
 type PPU interface {
 	ReadForCPU(addr uint16) byte
 	WriteForCPU(addr uint16, val byte)
+	// PeekForCPU 返回ReadForCPU会读到的值，但是不修改任何状态
+	PeekForCPU(addr uint16) byte
+	// PokeForCPU 直接修改寄存器的值，不会触发写寄存器的副作用
+	PokeForCPU(addr uint16, val byte)
 	SetOAM(values []byte)
 	Render() image.Image
 	CanInterrupt() bool
 	EnterVblank()
+	// SetFrameProgress 告诉PPU这一帧已经运行了多少，用来换算电子束的位置
+	SetFrameProgress(progress float64)
 	Tick()
 }
 
@@ -25,6 +32,8 @@ type PPUImpl struct {
 	Register       *RegisterManager
 	readDataBuffer byte
 	OAM            [256]byte
+	beamLine       int
+	beamDot        int
 }
 
 func NewPPU(rom *rom.NesRom) PPU {
@@ -32,6 +41,7 @@ func NewPPU(rom *rom.NesRom) PPU {
 	return &PPUImpl{
 		Memo:     memo,
 		Register: NewRegisterManager(),
+		beamLine: VblankLine,
 	}
 }
 
@@ -72,6 +82,76 @@ func (p *PPUImpl) ReadForCPU(addr uint16) byte {
 	}
 }
 
+func (p *PPUImpl) PeekForCPU(addr uint16) byte {
+	if addr == 0x4014 {
+		return p.Register.OAMDMA
+	}
+	addr = 0x2000 + addr&0b111
+	switch addr {
+	case 0x2000:
+		return byte(p.Register.PPUCTRL)
+	case 0x2001:
+		return p.Register.PPUMASK
+	case 0x2002:
+		return p.Register.PPUSTATUS
+	case 0x2003:
+		return p.Register.OAMADDR
+	case 0x2004:
+		return p.OAM[p.Register.OAMADDR]
+	case 0x2007:
+		// 调色板是直接读出的，其他地址读到的是上一次读取时缓存的值
+		addr := p.peekPPUADDR()
+		if addr >= 0x3F00 {
+			return p.Memo.Peek(PaletteMirror(addr))
+		}
+		return p.readDataBuffer
+	default: // $2005 $2006 只能写
+		return 0
+	}
+}
+
+func (p *PPUImpl) PokeForCPU(addr uint16, val byte) {
+	if addr == 0x4014 {
+		p.Register.OAMDMA = val
+		return
+	}
+	addr = 0x2000 + addr&0b111
+	switch addr {
+	case 0x2000:
+		p.Register.PPUCTRL = PPUCTRL(val)
+	case 0x2001:
+		p.Register.PPUMASK = val
+	case 0x2002:
+		p.Register.PPUSTATUS = val
+	case 0x2003:
+		p.Register.OAMADDR = val
+	case 0x2004:
+		p.OAM[p.Register.OAMADDR] = val
+	case 0x2007:
+		addr := p.peekPPUADDR()
+		if addr >= 0x3F00 {
+			addr = PaletteMirror(addr)
+		}
+		p.Memo.Poke(addr, val)
+	}
+}
+
+// peekPPUADDR 和PPUADDR.Value()一样，但是只写了一半时不会panic
+func (p *PPUImpl) peekPPUADDR() uint16 {
+	a := p.Register.PPUADDR
+	return (uint16(a.MostSignificantByte)<<8 | uint16(a.LeastSignificantByte)) & 0x3FFF
+}
+
+// PaletteMirror 调色板$3F00-$3FFF的镜像，$3F10/$3F14/$3F18/$3F1C就是$3F00/$3F04/$3F08/$3F0C
+// https://www.nesdev.org/wiki/PPU_palettes
+func PaletteMirror(addr uint16) uint16 {
+	addr = addr%0x20 + 0x3F00
+	if addr == 0x3f10 || addr == 0x3f14 || addr == 0x3f18 || addr == 0x3f1c {
+		addr = addr - 0x10
+	}
+	return addr
+}
+
 func (p *PPUImpl) DrawSpriteTable() image.Image {
 	return DrawSpritesTable(p.OAM, p.spritePatternTable(), p.SpritePalette())
 }
@@ -93,11 +173,40 @@ func DrawSprite() {
 
 }
 
+// PowerOn 把寄存器、OAM、名称表和调色板恢复到上电时的状态，图案表(CHR)保持不变
+func (p *PPUImpl) PowerOn() {
+	p.Register = NewRegisterManager()
+	p.readDataBuffer = 0
+	p.OAM = [256]byte{}
+	for i := 0x2000; i < len(p.Memo.Data); i++ {
+		p.Memo.Data[i] = 0
+	}
+}
+
+// WriteState 把PPU的全部状态写到w，用来计算状态的hash
+func (p *PPUImpl) WriteState(w io.Writer) {
+	r := p.Register
+	w.Write([]byte{byte(r.PPUCTRL), r.PPUMASK, r.PPUSTATUS, r.OAMADDR, r.OAMDMA,
+		boolByte(r.PPUSCROLL.SecondWrite), r.PPUSCROLL.XScroll, r.PPUSCROLL.YScroll,
+		boolByte(r.PPUADDR.SecondWrite), r.PPUADDR.MostSignificantByte, r.PPUADDR.LeastSignificantByte,
+		p.readDataBuffer})
+	w.Write(p.OAM[:])
+	w.Write(p.Memo.Data[:0x4000])
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func (p *PPUImpl) EnterVblank() {
 	v := p.Register.PPUSTATUS
 	v |= 0b10000000 // set 「v」 flag
 	v ^= 0b01000000 // toggle 「s」 flag
 	p.Register.PPUSTATUS = v
+	p.SetFrameProgress(0)
 }
 
 func (p *PPUImpl) incrementPPUADDR() {
@@ -133,3 +133,24 @@ func TestDrawPalette(t *testing.T) {
 func TestPPU(t *testing.T) {
 
 }
+
+func TestBeam(t *testing.T) {
+	p := &PPUImpl{Register: NewRegisterManager()}
+	p.EnterVblank()
+	line, dot := p.Beam()
+	require.Equal(t, VblankLine, line)
+	require.Equal(t, 0, dot)
+	require.False(t, p.Drawn(0, 0))
+
+	// vblank和预渲染行一共21行，之后开始画第0行
+	p.SetFrameProgress(float64(21*DotsPerLine+100) / (DotsPerLine * LinesPerFrame))
+	line, dot = p.Beam()
+	require.Equal(t, 0, line)
+	require.Equal(t, 100, dot)
+	require.True(t, p.Drawn(99, 0))
+	require.False(t, p.Drawn(100, 0))
+	require.False(t, p.Drawn(0, 1))
+
+	p.SetFrameProgress(1)
+	require.True(t, p.Drawn(255, 239))
+}
@@ -0,0 +1,99 @@
+package rom
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ReadRomFile 读取ROM文件，zip和gzip会被自动解压。
+// name可以是 game.zip#dir/game.nes 的形式，用来指定zip中的文件。
+func ReadRomFile(name string) ([]byte, error) {
+	file, entry := splitArchiveEntry(name)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Decompress(data, entry)
+}
+
+// archivePath 去掉name中的 #entry 部分
+func archivePath(name string) string {
+	file, _ := splitArchiveEntry(name)
+	return file
+}
+
+// splitArchiveEntry 只有在整个名字对应的文件不存在时才把#当成分隔符，文件名本身可以带#
+func splitArchiveEntry(name string) (string, string) {
+	if _, err := os.Stat(name); err == nil {
+		return name, ""
+	}
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// Decompress 根据文件头判断是否是zip或gzip，是的话解压，否则原样返回。
+// zip中使用名字为entry的文件，entry为空时使用第一个ROM文件(RomExts)。
+func Decompress(data []byte, entry string) ([]byte, error) {
+	switch {
+	case hasPrefix(data, "PK\x03\x04"):
+		return unzip(data, entry)
+	case hasPrefix(data, "\x1f\x8b"):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	default:
+		if entry != "" {
+			return nil, fmt.Errorf("not an archive, can not open entry %q", entry)
+		}
+		return data, nil
+	}
+}
+
+func unzip(data []byte, entry string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if entry == "" && !isRomFile(f.Name) || entry != "" && f.Name != entry {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	if entry != "" {
+		return nil, fmt.Errorf("zip has no entry %q", entry)
+	}
+	return nil, errors.New("zip has no rom file")
+}
+
+// RomExts 可以直接打开的ROM扩展名
+var RomExts = []string{".nes", ".unf", ".unif", ".fds"}
+
+func isRomFile(name string) bool {
+	for _, ext := range RomExts {
+		if strings.EqualFold(path.Ext(name), ext) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package rom
+
+import (
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// romDBText 内置的数据库，只有几个测试用的游戏。完整的数据库用cmd/romdbgen从NesCartDB生成，通过LoadDB加载:
+//
+//	go run ./cmd/romdbgen -o romdb.txt NesCarts.xml
+//
+//go:embed romdb.txt
+var romDBText string
+
+type Region int
+
+const (
+	RegionNTSC Region = iota
+	RegionPAL
+	RegionDual
+)
+
+var regionNames = map[Region]string{
+	RegionNTSC: "NTSC",
+	RegionPAL:  "PAL",
+	RegionDual: "dual",
+}
+
+func (r Region) String() string {
+	return regionNames[r]
+}
+
+// DBEntry 数据库中一个游戏的正确信息，用来修正文件头写错的ROM
+type DBEntry struct {
+	CRC32      uint32
+	SHA1       string // 为空时只比较crc32
+	Mapper     int
+	Submapper  int
+	Mirror     NameTableMirrorMode // 为0时由mapper控制，不修正文件头
+	FourScreen bool
+	Battery    bool
+	Region     Region
+	Title      string
+}
+
+func (e *DBEntry) String() string {
+	mirror := MirrorModeNameMap[e.Mirror]
+	if e.Mirror == 0 {
+		mirror = "mapper"
+	}
+	if e.FourScreen {
+		mirror = "FourScreen"
+	}
+	return fmt.Sprintf("%s (mapper %d.%d, %s, battery: %v, %s)",
+		e.Title, e.Mapper, e.Submapper, mirror, e.Battery, e.Region)
+}
+
+var romDB = mustParseDB(romDBText)
+
+func mustParseDB(text string) map[uint32][]*DBEntry {
+	db, err := parseDB(text)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func parseDB(text string) (map[uint32][]*DBEntry, error) {
+	db := map[uint32][]*DBEntry{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		entry, err := parseDBLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("romdb line %d: %w", i+1, err)
+		}
+		db[entry.CRC32] = append(db[entry.CRC32], entry)
+	}
+	return db, nil
+}
+
+func parseDBLine(line string) (*DBEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("expect 8 fields, got %q", line)
+	}
+	crc, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad crc32 %q", fields[0])
+	}
+	entry := &DBEntry{CRC32: uint32(crc), Title: strings.Join(fields[7:], " ")}
+	if fields[1] != "-" {
+		entry.SHA1 = strings.ToLower(fields[1])
+	}
+	if entry.Mapper, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, fmt.Errorf("bad mapper %q", fields[2])
+	}
+	if entry.Submapper, err = strconv.Atoi(fields[3]); err != nil {
+		return nil, fmt.Errorf("bad submapper %q", fields[3])
+	}
+	switch fields[4] {
+	case "horizontal":
+		entry.Mirror = HorizontalMirror
+	case "vertical":
+		entry.Mirror = VerticalMirror
+	case "-":
+	case "four":
+		entry.Mirror = VerticalMirror
+		entry.FourScreen = true
+	default:
+		return nil, fmt.Errorf("bad mirroring %q", fields[4])
+	}
+	entry.Battery = fields[5] == "1"
+	switch fields[6] {
+	case "NTSC":
+		entry.Region = RegionNTSC
+	case "PAL":
+		entry.Region = RegionPAL
+	case "dual":
+		entry.Region = RegionDual
+	default:
+		return nil, fmt.Errorf("bad region %q", fields[6])
+	}
+	return entry, nil
+}
+
+// LoadDB 读取外部的数据库文件，格式和romdb.txt一样，文件中的条目优先于内置的条目
+func LoadDB(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	db, err := parseDB(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	for crc, entries := range db {
+		romDB[crc] = append(entries, romDB[crc]...)
+	}
+	return nil
+}
+
+// LookupDB 按crc32查找，数据库中有sha1时还要求sha1一致
+func LookupDB(crc uint32, sha1 string) *DBEntry {
+	for _, entry := range romDB[crc] {
+		if entry.SHA1 == "" || entry.SHA1 == strings.ToLower(sha1) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// applyDB 用数据库中的信息修正文件头，记录修改了哪些字段。iNES文件头没有submapper和地区，直接使用数据库中的值
+func (r *NesRom) applyDB(entry *DBEntry) {
+	r.Submapper = entry.Submapper
+	r.Region = entry.Region
+	h := r.Header
+	if int(h.MapperNumber) != entry.Mapper {
+		r.HeaderFixes = append(r.HeaderFixes, fmt.Sprintf("mapper %d -> %d", h.MapperNumber, entry.Mapper))
+		h.MapperNumber = byte(entry.Mapper)
+	}
+	if h.Flag6.FourScreenMode != entry.FourScreen {
+		r.HeaderFixes = append(r.HeaderFixes, fmt.Sprintf("four screen %v -> %v", h.Flag6.FourScreenMode, entry.FourScreen))
+		h.Flag6.FourScreenMode = entry.FourScreen
+	}
+	if !entry.FourScreen && entry.Mirror != 0 && h.Flag6.MirrorMode != entry.Mirror {
+		r.HeaderFixes = append(r.HeaderFixes, fmt.Sprintf("mirroring %s -> %s",
+			MirrorModeNameMap[h.Flag6.MirrorMode], MirrorModeNameMap[entry.Mirror]))
+		h.Flag6.MirrorMode = entry.Mirror
+	}
+	if r.HasBattery != entry.Battery {
+		r.HeaderFixes = append(r.HeaderFixes, fmt.Sprintf("battery %v -> %v", r.HasBattery, entry.Battery))
+		r.HasBattery = entry.Battery
+		h.Flag6.HasBattery = entry.Battery
+	}
+}
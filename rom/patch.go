@@ -0,0 +1,345 @@
+package rom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PatchExts 自动查找补丁时使用的扩展名，和ROM同名的补丁会被自动应用
+var PatchExts = []string{".ips", ".ups", ".bps"}
+
+// FindPatches 查找和ROM同名的补丁，例如 game.nes 对应 game.ips
+func FindPatches(romFileName string) []string {
+	base := strings.TrimSuffix(romFileName, filepath.Ext(romFileName))
+	res := make([]string, 0)
+	for _, ext := range PatchExts {
+		for _, name := range []string{base + ext, base + strings.ToUpper(ext)} {
+			if _, err := os.Stat(name); err == nil {
+				res = append(res, name)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// ApplyPatchFiles 按顺序应用多个补丁文件
+func ApplyPatchFiles(data []byte, patchFiles ...string) ([]byte, error) {
+	for _, name := range patchFiles {
+		patch, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ApplyPatch(data, patch); err != nil {
+			return nil, fmt.Errorf("apply patch %s: %w", name, err)
+		}
+	}
+	return data, nil
+}
+
+// ApplyPatch 根据文件头判断补丁格式(IPS、UPS或BPS)并应用，不会修改data
+func ApplyPatch(data, patch []byte) ([]byte, error) {
+	switch {
+	case hasPrefix(patch, "PATCH"):
+		return applyIPS(data, patch)
+	case hasPrefix(patch, "UPS1"):
+		return applyUPS(data, patch)
+	case hasPrefix(patch, "BPS1"):
+		return applyBPS(data, patch)
+	default:
+		return nil, errors.New("unknown patch format")
+	}
+}
+
+func hasPrefix(data []byte, prefix string) bool {
+	return len(data) >= len(prefix) && string(data[:len(prefix)]) == prefix
+}
+
+var errPatchTruncated = errors.New("patch is truncated")
+
+// maxPatchTarget UPS/BPS补丁生成的文件最大16M，再大的不可能是ROM，一定是损坏或者恶意的补丁
+const maxPatchTarget = 16 << 20
+
+// applyIPS https://zerosoft.zophar.net/ips.php
+func applyIPS(data, patch []byte) ([]byte, error) {
+	target := append([]byte{}, data...)
+	pos := 5
+	for {
+		if pos+3 > len(patch) {
+			return nil, errPatchTruncated
+		}
+		if string(patch[pos:pos+3]) == "EOF" {
+			pos += 3
+			break
+		}
+		if pos+5 > len(patch) {
+			return nil, errPatchTruncated
+		}
+		offset := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		size := int(patch[pos+3])<<8 | int(patch[pos+4])
+		pos += 5
+		var chunk []byte
+		if size == 0 { // RLE
+			if pos+3 > len(patch) {
+				return nil, errPatchTruncated
+			}
+			size = int(patch[pos])<<8 | int(patch[pos+1])
+			chunk = make([]byte, size)
+			for i := range chunk {
+				chunk[i] = patch[pos+2]
+			}
+			pos += 3
+		} else {
+			if pos+size > len(patch) {
+				return nil, errPatchTruncated
+			}
+			chunk = patch[pos : pos+size]
+			pos += size
+		}
+		if offset+size > len(target) {
+			target = append(target, make([]byte, offset+size-len(target))...)
+		}
+		copy(target[offset:], chunk)
+	}
+	// EOF之后可以有3个字节的截断长度
+	if pos+3 <= len(patch) {
+		size := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		if size < len(target) {
+			target = target[:size]
+		}
+	}
+	return target, nil
+}
+
+// CreateIPS 生成把source变成target的IPS补丁，target比source短时写入截断长度。
+// 地址只有3个字节，所以只能用于16M以下的文件。
+func CreateIPS(source, target []byte) []byte {
+	patch := []byte("PATCH")
+	for i := 0; i < len(target); {
+		if i < len(source) && source[i] == target[i] {
+			i++
+			continue
+		}
+		start := i
+		if start == 0x454F46 { // 地址是"EOF"时会被当成结束，从前一个字节开始写
+			start--
+		}
+		end := i + 1
+		for end < len(target) && end-start < 0xFFFF && (end >= len(source) || source[end] != target[end]) {
+			end++
+		}
+		patch = append(patch, byte(start>>16), byte(start>>8), byte(start), byte((end-start)>>8), byte(end-start))
+		patch = append(patch, target[start:end]...)
+		i = end
+	}
+	patch = append(patch, "EOF"...)
+	if len(target) < len(source) {
+		patch = append(patch, byte(len(target)>>16), byte(len(target)>>8), byte(len(target)))
+	}
+	return patch
+}
+
+// patchReader 读取UPS和BPS中的变长整数
+type patchReader struct {
+	data []byte
+	pos  int
+	end  int // 末尾12个字节是crc，不属于补丁内容
+}
+
+func (r *patchReader) byte() (byte, error) {
+	if r.pos >= r.end {
+		return 0, errPatchTruncated
+	}
+	b := r.data[r.pos]
+	r.pos += 1
+	return b, nil
+}
+
+// varint 最多读8个字节，结果不能超过int32，这已经远大于任何合理的文件大小和偏移
+func (r *patchReader) varint() (int, error) {
+	var value, shift uint64 = 0, 1
+	for i := 0; i < 8; i++ {
+		x, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		value += uint64(x&0x7F) * shift
+		if value > math.MaxInt32 {
+			break
+		}
+		if x&0x80 != 0 {
+			return int(value), nil
+		}
+		shift <<= 7
+		value += shift
+	}
+	return 0, errors.New("patch has an invalid or overflowing number")
+}
+
+// readSizes 读取UPS/BPS开头的源文件和目标文件的大小
+func (r *patchReader) readSizes(source []byte) (targetSize int, err error) {
+	sourceSize, err := r.varint()
+	if err != nil {
+		return 0, err
+	}
+	if targetSize, err = r.varint(); err != nil {
+		return 0, err
+	}
+	if sourceSize != len(source) {
+		return 0, fmt.Errorf("source size %d does not match rom size %d", sourceSize, len(source))
+	}
+	if targetSize > maxPatchTarget {
+		return 0, fmt.Errorf("target size %d is larger than %d", targetSize, maxPatchTarget)
+	}
+	return targetSize, nil
+}
+
+// checkFooter 校验UPS/BPS末尾的 源文件crc32、目标文件crc32、补丁crc32
+func checkFooter(source, patch []byte) error {
+	if len(patch) < 12 {
+		return errPatchTruncated
+	}
+	footer := patch[len(patch)-12:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return errors.New("patch crc32 mismatch, the patch file is corrupted")
+	}
+	if crc32.ChecksumIEEE(source) != binary.LittleEndian.Uint32(footer[0:]) {
+		return errors.New("source crc32 mismatch, the patch is for a different rom")
+	}
+	return nil
+}
+
+func checkTarget(target, patch []byte) error {
+	if crc32.ChecksumIEEE(target) != binary.LittleEndian.Uint32(patch[len(patch)-8:]) {
+		return errors.New("target crc32 mismatch")
+	}
+	return nil
+}
+
+// applyUPS http://individual.utoronto.ca/dmeunier/ups-spec.pdf
+func applyUPS(source, patch []byte) ([]byte, error) {
+	if err := checkFooter(source, patch); err != nil {
+		return nil, err
+	}
+	r := &patchReader{data: patch, pos: 4, end: len(patch) - 12}
+	targetSize, err := r.readSizes(source)
+	if err != nil {
+		return nil, err
+	}
+	target := make([]byte, targetSize)
+	copy(target, source)
+	offset := 0
+	for r.pos < r.end {
+		skip, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		offset += skip
+		for {
+			x, err := r.byte()
+			if err != nil {
+				return nil, err
+			}
+			if offset >= 0 && offset < targetSize {
+				var v byte
+				if offset < len(source) {
+					v = source[offset]
+				}
+				target[offset] = v ^ x
+			}
+			offset += 1
+			if x == 0 {
+				break
+			}
+		}
+	}
+	return target, checkTarget(target, patch)
+}
+
+// applyBPS https://www.romhacking.net/documents/746/
+func applyBPS(source, patch []byte) ([]byte, error) {
+	if err := checkFooter(source, patch); err != nil {
+		return nil, err
+	}
+	r := &patchReader{data: patch, pos: 4, end: len(patch) - 12}
+	targetSize, err := r.readSizes(source)
+	if err != nil {
+		return nil, err
+	}
+	metadataSize, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if metadataSize > r.end-r.pos {
+		return nil, errPatchTruncated
+	}
+	r.pos += metadataSize
+	target := make([]byte, 0, targetSize)
+	sourceOffset, targetOffset := 0, 0
+	relative := func() (int, error) {
+		v, err := r.varint()
+		if v&1 != 0 {
+			return -(v >> 1), err
+		}
+		return v >> 1, err
+	}
+	for r.pos < r.end {
+		data, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		command, length := data&3, data>>2+1
+		if len(target)+length > targetSize {
+			return nil, errors.New("bps writes past the target size")
+		}
+		switch command {
+		case 0: // SourceRead
+			start := len(target)
+			if start+length > len(source) {
+				return nil, errPatchTruncated
+			}
+			target = append(target, source[start:start+length]...)
+		case 1: // TargetRead
+			if r.pos+length > r.end {
+				return nil, errPatchTruncated
+			}
+			target = append(target, patch[r.pos:r.pos+length]...)
+			r.pos += length
+		case 2: // SourceCopy
+			d, err := relative()
+			if err != nil {
+				return nil, err
+			}
+			sourceOffset += d
+			if sourceOffset < 0 || sourceOffset+length > len(source) {
+				return nil, errors.New("bps source copy out of range")
+			}
+			target = append(target, source[sourceOffset:sourceOffset+length]...)
+			sourceOffset += length
+		case 3: // TargetCopy，可能和正在写的部分重叠，只能一个字节一个字节地复制
+			d, err := relative()
+			if err != nil {
+				return nil, err
+			}
+			targetOffset += d
+			if targetOffset < 0 || targetOffset >= len(target) {
+				return nil, errors.New("bps target copy out of range")
+			}
+			for i := 0; i < length; i++ {
+				target = append(target, target[targetOffset])
+				targetOffset += 1
+			}
+		}
+	}
+	if len(target) != targetSize {
+		return nil, fmt.Errorf("bps produced %d bytes, expect %d", len(target), targetSize)
+	}
+	return target, checkTarget(target, patch)
+}
@@ -0,0 +1,198 @@
+package rom
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func encodeVarint(v int) []byte {
+	res := make([]byte, 0)
+	for {
+		x := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			return append(res, 0x80|x)
+		}
+		res = append(res, x)
+		v -= 1
+	}
+}
+
+func appendFooter(patch, source, target []byte) []byte {
+	crc := make([]byte, 4)
+	binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(source))
+	patch = append(patch, crc...)
+	binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(target))
+	patch = append(patch, crc...)
+	binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(patch))
+	return append(patch, crc...)
+}
+
+func makeUPS(source, target []byte) []byte {
+	patch := append([]byte("UPS1"), encodeVarint(len(source))...)
+	patch = append(patch, encodeVarint(len(target))...)
+	at := func(data []byte, i int) byte {
+		if i < len(data) {
+			return data[i]
+		}
+		return 0
+	}
+	cur := 0
+	for i := 0; i < len(target); {
+		if at(source, i) == at(target, i) {
+			i++
+			continue
+		}
+		patch = append(patch, encodeVarint(i-cur)...)
+		for ; i < len(target) && at(source, i) != at(target, i); i++ {
+			patch = append(patch, at(source, i)^at(target, i))
+		}
+		patch = append(patch, 0)
+		i++
+		cur = i
+	}
+	return appendFooter(patch, source, target)
+}
+
+func TestIPS(t *testing.T) {
+	source := []byte("0123456789")
+	patch := []byte("PATCH")
+	patch = append(patch, 0, 0, 2, 0, 3, 'a', 'b', 'c') // 2: abc
+	patch = append(patch, 0, 0, 8, 0, 0, 0, 4, 'z')     // 8: RLE zzzz，超出原来的长度
+	patch = append(patch, 'E', 'O', 'F')
+	target, err := ApplyPatch(source, patch)
+	require.NoError(t, err)
+	require.Equal(t, "01abc567zzzz", string(target))
+	require.Equal(t, "0123456789", string(source))
+
+	target, err = ApplyPatch(source, append(patch, 0, 0, 5)) // 截断
+	require.NoError(t, err)
+	require.Equal(t, "01abc", string(target))
+
+	_, err = ApplyPatch(source, patch[:10])
+	require.Error(t, err)
+}
+
+func TestCreateIPS(t *testing.T) {
+	source := make([]byte, 0x454F50)
+	for _, target := range [][]byte{
+		[]byte("0123456789"),
+		append(append([]byte{}, source[:0x454F46]...), 1, 2, 3), // 地址正好是"EOF"
+		append([]byte{1}, source[1:100]...),                     // 变短
+		append(append([]byte{}, source...), make([]byte, 0x20000)...),
+	} {
+		target[len(target)-1] = 0xAA
+		patched, err := ApplyPatch(source, CreateIPS(source, target))
+		require.NoError(t, err)
+		require.Equal(t, target, patched)
+	}
+}
+
+func TestUPS(t *testing.T) {
+	source := []byte("hello world, this is a rom")
+	target := []byte("HELLO world, that is a rom!!")
+	patch := makeUPS(source, target)
+	res, err := ApplyPatch(source, patch)
+	require.NoError(t, err)
+	require.Equal(t, string(target), string(res))
+
+	_, err = ApplyPatch([]byte("hello world, this is a rum"), patch)
+	require.Error(t, err)
+	patch[6] ^= 1
+	_, err = ApplyPatch(source, patch)
+	require.Error(t, err)
+}
+
+func TestBPS(t *testing.T) {
+	source := []byte("ABCDEFGH")
+	target := []byte("ABCDxyxyxyEFGH")
+	action := func(command, length int) []byte {
+		return encodeVarint((length-1)<<2 | command)
+	}
+	patch := append([]byte("BPS1"), encodeVarint(len(source))...)
+	patch = append(patch, encodeVarint(len(target))...)
+	patch = append(patch, encodeVarint(2)...)
+	patch = append(patch, "md"...)         // metadata
+	patch = append(patch, action(0, 4)...) // SourceRead ABCD
+	patch = append(patch, action(1, 2)...) // TargetRead xy
+	patch = append(patch, "xy"...)
+	patch = append(patch, action(3, 4)...)       // TargetCopy xyxy
+	patch = append(patch, encodeVarint(4<<1)...) // 目标偏移 +4
+	patch = append(patch, action(2, 4)...)       // SourceCopy EFGH
+	patch = append(patch, encodeVarint(4<<1)...) // 源偏移 +4
+	patch = appendFooter(patch, source, target)
+	res, err := ApplyPatch(source, patch)
+	require.NoError(t, err)
+	require.Equal(t, string(target), string(res))
+
+	_, err = ApplyPatch([]byte("ABCDEFGX"), patch)
+	require.Error(t, err)
+}
+
+// 损坏或者恶意的补丁要返回错误，不能panic，也不能分配巨大的内存
+func TestMalformedPatch(t *testing.T) {
+	source := []byte("hello world")
+	header := func(magic string, targetSize int) []byte {
+		patch := append([]byte(magic), encodeVarint(len(source))...)
+		return append(patch, encodeVarint(targetSize)...)
+	}
+	overflow := []byte{0x7F, 0x7F, 0x7F, 0x7F, 0x7F, 0x7F, 0x7F, 0x7F, 0x7F, 0x7F, 0x80}
+
+	patch := append(header("UPS1", len(source)), overflow...) // 跳过的长度溢出
+	patch = append(patch, 1, 0)
+	_, err := ApplyPatch(source, appendFooter(patch, source, source))
+	require.EqualError(t, err, "patch has an invalid or overflowing number")
+
+	patch = append(header("UPS1", len(source)), encodeVarint(1<<31-1)...) // 跳到目标的范围之外，写入被忽略
+	patch = append(patch, 1, 0)
+	target, err := ApplyPatch(source, appendFooter(patch, source, source))
+	require.NoError(t, err)
+	require.Equal(t, source, target)
+
+	patch = header("UPS1", 1<<30)
+	_, err = ApplyPatch(source, appendFooter(patch, source, source))
+	require.Error(t, err)
+
+	patch = append(header("BPS1", len(source)), encodeVarint(1<<20)...) // metadata比补丁长
+	_, err = ApplyPatch(source, appendFooter(patch, source, source))
+	require.Error(t, err)
+
+	patch = append(header("BPS1", len(source)), overflow...)
+	_, err = ApplyPatch(source, appendFooter(patch, source, source))
+	require.Error(t, err)
+}
+
+func TestLoadNesRomWithPatch(t *testing.T) {
+	dir := t.TempDir()
+	data, err := ioutil.ReadFile("../static/nestest.nes")
+	require.NoError(t, err)
+	romFile := filepath.Join(dir, "game.nes")
+	require.NoError(t, ioutil.WriteFile(romFile, data, 0644))
+
+	// 把PRG的第一个字节改成0x42
+	ips := append([]byte("PATCH"), 0, 0, 16, 0, 1, 0x42, 'E', 'O', 'F')
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "game.ips"), ips, 0644))
+	target := append([]byte{}, data...)
+	target[17] = 0x43
+	ups := makeUPS(data, target)
+	upsFile := filepath.Join(dir, "other.ups")
+	require.NoError(t, ioutil.WriteFile(upsFile, ups, 0644))
+
+	nesRom, err := LoadNesRom(romFile)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x42), nesRom.PrgRom[0])
+
+	// 指定补丁时不再自动查找
+	nesRom, err = LoadNesRom(romFile, upsFile)
+	require.NoError(t, err)
+	require.Equal(t, data[16], nesRom.PrgRom[0])
+	require.Equal(t, byte(0x43), nesRom.PrgRom[1])
+
+	// 叠加的补丁: ips改过的数据和ups的源crc不一致
+	_, err = LoadNesRom(romFile, filepath.Join(dir, "game.ips"), upsFile)
+	require.Error(t, err)
+}
@@ -1,9 +1,13 @@
 package rom
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fc-emulator/utils"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 )
 
@@ -18,6 +22,18 @@ type NesRom struct {
 	ChrRom       []byte
 	Trainer      []byte
 	Header       *Header
+	CRC32        uint32      // PRG+CHR的crc32
+	DBEntry      *DBEntry    // ROM数据库中匹配到的游戏，没有匹配时为nil
+	HeaderFixes  []string    // 文件头中被修正的字段
+	Board        string      // UNIF的板子名字，iNES文件为空
+	Controllers  Controllers // UNIF的CTRL chunk，iNES文件为0
+	Submapper    int         // 来自ROM数据库，没有匹配时为0
+	Region       Region      // 来自ROM数据库，没有匹配时为NTSC
+}
+
+// HeaderOverridden 文件头是否被修正过
+func (r *NesRom) HeaderOverridden() bool {
+	return len(r.HeaderFixes) > 0
 }
 
 type NesFlag1 struct {
@@ -56,6 +72,14 @@ func (r *NesRom) String() string {
 		len(r.PrgRom)/utils.Kb, len(r.ChrRom)/utils.Kb)
 }
 
+// Hash 返回PRG和CHR的sha1，不包括文件头，同一个游戏文件头不同时hash也相同
+func (r *NesRom) Hash() string {
+	h := sha1.New()
+	h.Write(r.PrgRom)
+	h.Write(r.ChrRom)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func NewHeader(data []byte) *Header {
 	if len(data) != 16 {
 		panic("header must has 16 byte")
@@ -85,12 +109,41 @@ var MirrorModeNameMap map[NameTableMirrorMode]string = map[NameTableMirrorMode]s
 	HorizontalMirror: "HorizontalMirror",
 }
 
-func LoadNesRom(filename string) (*NesRom, error) {
-	data, err := ioutil.ReadFile(filename)
+// LoadNesRom 读取ROM文件，解析之前在内存中依次应用patchFiles。
+// 没有指定补丁时，自动使用和ROM同名的 .ips/.ups/.bps 补丁。
+// 文件可以是iNES或UNIF格式，也可以是zip或gzip压缩的，zip中默认使用第一个ROM文件，也可以用 game.zip#dir/game.nes 指定。
+func LoadNesRom(filename string, patchFiles ...string) (*NesRom, error) {
+	data, err := ReadRomFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(patchFiles) == 0 {
+		patchFiles = FindPatches(archivePath(filename))
+	}
+	if data, err = ApplyPatchFiles(data, patchFiles...); err != nil {
+		return nil, err
+	}
+	return ParseBytes(data)
+}
+
+// Parse 从r中读取并解析iNES或UNIF镜像，zip和gzip会被自动解压
+func Parse(r io.Reader) (*NesRom, error) {
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	if string(data[:4]) != nesPrefix {
+	if data, err = Decompress(data, ""); err != nil {
+		return nil, err
+	}
+	return ParseBytes(data)
+}
+
+// ParseBytes 解析内存中的iNES或UNIF镜像
+func ParseBytes(data []byte) (*NesRom, error) {
+	if hasPrefix(data, unifPrefix) {
+		return parseUNIF(data)
+	}
+	if len(data) < 16 || string(data[:4]) != nesPrefix {
 		return nil, errors.New("nes format error")
 	}
 	if data[7]&0x0c == 0x08 {
@@ -101,19 +154,44 @@ func LoadNesRom(filename string) (*NesRom, error) {
 		IsTrainer:  (data[6] & 0x04) != 0x00, // 第4bit
 		HasBattery: (data[6] & 0x02) != 0x00, // 第2bit
 	}
-	if rom.Header.MapperNumber != 0 {
-		return nil, utils.NewError("Not support Mapper ", rom.MapperNumber)
+	trainerSize := 0
+	if rom.IsTrainer {
+		trainerSize = 512
+	}
+	prgSize := rom.Header.PrgCount * 16 * utils.Kb
+	chrSize := rom.Header.ChrCount * 8 * utils.Kb
+	if expect := 16 + trainerSize + prgSize + chrSize; len(data) < expect {
+		return nil, fmt.Errorf("nes file is truncated: header says %d bytes (trainer %d, prg %d, chr %d), got %d",
+			expect, trainerSize, prgSize, chrSize, len(data))
 	}
 	prgStartIndex := 16
 	if rom.IsTrainer {
-		rom.Trainer = data[16:512]
+		rom.Trainer = data[16 : 16+512]
 		prgStartIndex += 512
 	} else {
 		rom.Trainer = make([]byte, 512)
 	}
-	prgSize := rom.Header.PrgCount * 16 * utils.Kb
 	rom.PrgRom = data[prgStartIndex : prgStartIndex+prgSize]
-	rom.ChrRom = data[prgStartIndex+prgSize:]
+	rom.ChrRom = data[prgStartIndex+prgSize : prgStartIndex+prgSize+chrSize]
+
+	// 旧的工具会在12-15字节写入"DiskDude!"之类的内容，这时byte 7也不可信
+	if data[12]|data[13]|data[14]|data[15] != 0 && rom.Header.MapperNumber>>4 != 0 {
+		rom.HeaderFixes = append(rom.HeaderFixes, fmt.Sprintf("dirty header, mapper %d -> %d",
+			rom.Header.MapperNumber, rom.Header.MapperNumber&0x0F))
+		rom.Header.MapperNumber &= 0x0F
+	}
+	h := crc32.NewIEEE()
+	h.Write(rom.PrgRom)
+	h.Write(rom.ChrRom)
+	rom.CRC32 = h.Sum32()
+	if entry := LookupDB(rom.CRC32, rom.Hash()); entry != nil {
+		rom.DBEntry = entry
+		rom.applyDB(entry)
+	}
+	rom.MapperNumber = int(rom.Header.MapperNumber)
+	if rom.MapperNumber != 0 {
+		return nil, utils.NewError("Not support Mapper ", rom.MapperNumber)
+	}
 	return rom, nil
 }
 
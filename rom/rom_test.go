@@ -1,9 +1,15 @@
 package rom
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"fc-emulator/utils"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -25,3 +31,125 @@ func TestLoadNesRom(t *testing.T) {
 	require.Equal(t, len(rom.ChrRom), 8*utils.Kb)
 	fmt.Println(rom.String())
 }
+
+func TestParseArchives(t *testing.T) {
+	data, err := ioutil.ReadFile("../static/nestest.nes")
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	zipBuf := &bytes.Buffer{}
+	zw := zip.NewWriter(zipBuf)
+	w, err := zw.Create("readme.txt")
+	require.NoError(t, err)
+	w.Write([]byte("not a rom"))
+	w, err = zw.Create("roms/nestest.nes")
+	require.NoError(t, err)
+	w.Write(data)
+	w, err = zw.Create("roms/broken.nes")
+	require.NoError(t, err)
+	w.Write(data[:100])
+	require.NoError(t, zw.Close())
+	zipFile := filepath.Join(dir, "nestest.zip")
+	require.NoError(t, ioutil.WriteFile(zipFile, zipBuf.Bytes(), 0644))
+
+	gzBuf := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzBuf)
+	gw.Write(data)
+	require.NoError(t, gw.Close())
+	gzFile := filepath.Join(dir, "nestest.nes.gz")
+	require.NoError(t, ioutil.WriteFile(gzFile, gzBuf.Bytes(), 0644))
+
+	for _, name := range []string{zipFile, zipFile + "#roms/nestest.nes", gzFile} {
+		nesRom, err := LoadNesRom(name)
+		require.NoError(t, err, name)
+		require.Equal(t, data[16:16+16*utils.Kb], nesRom.PrgRom, name)
+	}
+	_, err = LoadNesRom(zipFile + "#roms/broken.nes")
+	require.Error(t, err)
+	_, err = LoadNesRom(zipFile + "#missing.nes")
+	require.Error(t, err)
+
+	nesRom, err := Parse(bytes.NewReader(gzBuf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, nesRom.ChrRom, 8*utils.Kb)
+}
+
+func TestParseTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("../static/nestest.nes")
+	require.NoError(t, err)
+	for _, size := range []int{0, 3, 15, 16, 16 + 16*utils.Kb, len(data) - 1} {
+		_, err := ParseBytes(data[:size])
+		require.Error(t, err, size)
+	}
+}
+
+func TestRomDB(t *testing.T) {
+	data, err := ioutil.ReadFile("../static/nestest.nes")
+	require.NoError(t, err)
+	nesRom, err := ParseBytes(data)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0x158B0388), nesRom.CRC32)
+	require.NotNil(t, nesRom.DBEntry)
+	require.Equal(t, "nestest", nesRom.DBEntry.Title)
+	require.False(t, nesRom.HeaderOverridden())
+
+	// 错误的镜像，并且7-15字节是垃圾
+	dirty := append([]byte{}, data...)
+	dirty[6] |= 0x01
+	copy(dirty[7:16], "DiskDude!")
+	nesRom, err = ParseBytes(dirty)
+	require.NoError(t, err)
+	require.True(t, nesRom.HeaderOverridden())
+	require.Equal(t, HorizontalMirror, nesRom.Header.Flag6.MirrorMode)
+	require.Equal(t, 0, nesRom.MapperNumber)
+	require.Len(t, nesRom.HeaderFixes, 2)
+
+	// 不在数据库中的ROM保持原来的文件头
+	unknown := append([]byte{}, data...)
+	unknown[16] ^= 0xFF
+	unknown[6] |= 0x01
+	nesRom, err = ParseBytes(unknown)
+	require.NoError(t, err)
+	require.Nil(t, nesRom.DBEntry)
+	require.Equal(t, VerticalMirror, nesRom.Header.Flag6.MirrorMode)
+}
+
+func TestParseDB(t *testing.T) {
+	db, err := parseDB("# comment\n0000ABCD - 4 1 four 1 PAL Some Game (E)\n")
+	require.NoError(t, err)
+	entry := db[0xABCD][0]
+	require.Equal(t, "Some Game (E)", entry.Title)
+	require.True(t, entry.FourScreen)
+	require.True(t, entry.Battery)
+	require.Equal(t, RegionPAL, entry.Region)
+	require.Equal(t, 1, entry.Submapper)
+	_, err = parseDB("0000ABCD - 4 1 diagonal 1 PAL x\n")
+	require.Error(t, err)
+}
+
+func TestLoadDB(t *testing.T) {
+	data, err := ioutil.ReadFile("../static/nestest.nes")
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "romdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "romdb.txt")
+
+	// 外部的条目优先于内置的条目，镜像为 - 时不修正文件头
+	require.NoError(t, ioutil.WriteFile(file, []byte("158B0388 - 0 2 - 0 dual nestest (external)\n"), 0644))
+	builtin := romDB[0x158B0388]
+	defer func() { romDB[0x158B0388] = builtin }()
+	require.NoError(t, LoadDB(file))
+	data[6] |= 0x01
+	nesRom, err := ParseBytes(data)
+	require.NoError(t, err)
+	require.Equal(t, "nestest (external)", nesRom.DBEntry.Title)
+	require.Equal(t, 2, nesRom.Submapper)
+	require.Equal(t, RegionDual, nesRom.Region)
+	require.False(t, nesRom.HeaderOverridden())
+	require.Equal(t, VerticalMirror, nesRom.Header.Flag6.MirrorMode)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("158B0388 - 0 0 sideways 0 NTSC x\n"), 0644))
+	require.Error(t, LoadDB(file))
+	require.Error(t, LoadDB(filepath.Join(dir, "missing.txt")))
+}
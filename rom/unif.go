@@ -0,0 +1,191 @@
+package rom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fc-emulator/utils"
+	"fmt"
+	"strings"
+)
+
+// https://www.nesdev.org/wiki/UNIF
+const unifPrefix = "UNIF"
+
+// unifBoards 模拟器能运行的UNIF板子(去掉NES-、HVC-前缀)对应的iNES mapper号，现在只实现了NROM
+var unifBoards = map[string]int{
+	"NROM": 0, "NROM-128": 0, "NROM-256": 0, "RROM": 0, "RROM-128": 0,
+}
+
+// unifUnimplementedBoards 知道mapper号但是还没有实现的板子，只用来给出清楚的错误
+var unifUnimplementedBoards = map[string]int{
+	"SAROM": 1, "SBROM": 1, "SCROM": 1, "SEROM": 1, "SGROM": 1, "SKROM": 1,
+	"SLROM": 1, "SL1ROM": 1, "SNROM": 1, "SOROM": 1,
+	"UNROM": 2, "UOROM": 2,
+	"CNROM": 3,
+	"TBROM": 4, "TEROM": 4, "TFROM": 4, "TGROM": 4, "TKROM": 4, "TLROM": 4,
+	"TR1ROM": 4, "TSROM": 4, "TVROM": 4,
+	"EKROM": 5, "ELROM": 5, "ETROM": 5, "EWROM": 5,
+	"AMROM": 7, "ANROM": 7, "AOROM": 7,
+	"PNROM": 9,
+	"CPROM": 13,
+	"GNROM": 66, "MHROM": 66,
+}
+
+var unifBoardPrefixes = []string{"NES-", "HVC-"}
+
+// UnifBoardMapper 返回板子对应的mapper号，模拟器不能运行这个板子时返回错误
+func UnifBoardMapper(board string) (int, error) {
+	name := strings.ToUpper(board)
+	for _, prefix := range unifBoardPrefixes {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if mapper, ok := unifBoards[name]; ok {
+		return mapper, nil
+	}
+	if mapper, ok := unifUnimplementedBoards[name]; ok {
+		return 0, fmt.Errorf("unif board %s (mapper %d) is not implemented", board, mapper)
+	}
+	return 0, fmt.Errorf("unif board %q is not supported", board)
+}
+
+// Controllers UNIF的CTRL chunk，卡带支持的输入设备，每一位是一种设备
+type Controllers byte
+
+const (
+	ControllerPad Controllers = 1 << iota
+	ControllerZapper
+	ControllerROB
+	ControllerArkanoid
+	ControllerPowerPad
+	ControllerFourScore
+)
+
+var controllerNames = []string{"pad", "zapper", "R.O.B.", "arkanoid", "power pad", "four score"}
+
+func (c Controllers) String() string {
+	names := make([]string, 0)
+	for i, name := range controllerNames {
+		if c&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+type unifChunk struct {
+	id   string
+	data []byte
+}
+
+func readUnifChunks(data []byte) ([]unifChunk, error) {
+	if len(data) < 32 || string(data[:4]) != unifPrefix {
+		return nil, errors.New("unif format error")
+	}
+	chunks := make([]unifChunk, 0)
+	pos := 32 // 4字节标志 + 4字节版本 + 24字节保留
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errors.New("unif chunk header is truncated")
+		}
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if size < 0 || pos+size > len(data) {
+			return nil, fmt.Errorf("unif chunk %s is truncated", id)
+		}
+		chunks = append(chunks, unifChunk{id: id, data: data[pos : pos+size]})
+		pos += size
+	}
+	return chunks, nil
+}
+
+// parseUNIF 把UNIF转成iNES镜像之后用ParseBytes解析，所以得到的NesRom和iNES文件完全一样
+func parseUNIF(data []byte) (*NesRom, error) {
+	chunks, err := readUnifChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	var board string
+	var controllers Controllers
+	var prgChunks, chrChunks [16][]byte
+	var flag6 byte
+	for _, c := range chunks {
+		switch {
+		case c.id == "MAPR":
+			board = strings.TrimRight(string(c.data), "\x00")
+		case strings.HasPrefix(c.id, "PRG") || strings.HasPrefix(c.id, "CHR"):
+			var n int
+			if _, err := fmt.Sscanf(c.id[3:], "%X", &n); err != nil || n > 15 {
+				continue
+			}
+			if c.id[0] == 'P' {
+				prgChunks[n] = c.data
+			} else {
+				chrChunks[n] = c.data
+			}
+		case c.id == "MIRR" && len(c.data) > 0:
+			switch c.data[0] {
+			case 1:
+				flag6 |= 0x01 // 垂直镜像
+			case 4:
+				flag6 |= 0x08 // 4屏
+			}
+		case c.id == "BATR" && len(c.data) > 0:
+			flag6 |= 0x02
+		case c.id == "CTRL" && len(c.data) > 0:
+			controllers = Controllers(c.data[0])
+		}
+	}
+	if board == "" {
+		return nil, errors.New("unif has no MAPR chunk")
+	}
+	mapper, err := UnifBoardMapper(board)
+	if err != nil {
+		return nil, err
+	}
+	prg := concatChunks(prgChunks)
+	chr := concatChunks(chrChunks)
+	if len(prg) == 0 {
+		return nil, errors.New("unif has no PRG chunk")
+	}
+	if prg, err = padToUnit(prg, 16*utils.Kb); err != nil {
+		return nil, fmt.Errorf("unif PRG: %w", err)
+	}
+	if chr, err = padToUnit(chr, 8*utils.Kb); err != nil {
+		return nil, fmt.Errorf("unif CHR: %w", err)
+	}
+	header := []byte{'N', 'E', 'S', 0x1A, byte(len(prg) / (16 * utils.Kb)), byte(len(chr) / (8 * utils.Kb)),
+		flag6 | byte(mapper&0x0F)<<4, byte(mapper & 0xF0), 0, 0, 0, 0, 0, 0, 0, 0}
+	image := append(header, prg...)
+	image = append(image, chr...)
+	rom, err := ParseBytes(image)
+	if err != nil {
+		return nil, err
+	}
+	rom.Board = board
+	rom.Controllers = controllers
+	return rom, nil
+}
+
+func concatChunks(chunks [16][]byte) []byte {
+	res := make([]byte, 0)
+	for _, c := range chunks {
+		res = append(res, c...)
+	}
+	return res
+}
+
+// padToUnit iNES的PRG和CHR大小必须是16k和8k的整数倍，小于一个单位的用镜像补齐，例如8k的PRG重复一次
+func padToUnit(data []byte, unit int) ([]byte, error) {
+	if len(data)%unit == 0 {
+		return data, nil
+	}
+	if len(data) < unit && unit%len(data) == 0 {
+		res := make([]byte, 0, unit)
+		for len(res) < unit {
+			res = append(res, data...)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("size %d is not a multiple of %d", len(data), unit)
+}
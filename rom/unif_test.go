@@ -0,0 +1,81 @@
+package rom
+
+import (
+	"encoding/binary"
+	"fc-emulator/utils"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"testing"
+)
+
+func unifChunkBytes(id string, data []byte) []byte {
+	res := make([]byte, 8, 8+len(data))
+	copy(res, id)
+	binary.LittleEndian.PutUint32(res[4:], uint32(len(data)))
+	return append(res, data...)
+}
+
+func makeUNIF(chunks ...[]byte) []byte {
+	res := make([]byte, 32)
+	copy(res, unifPrefix)
+	res[4] = 7
+	for _, c := range chunks {
+		res = append(res, c...)
+	}
+	return res
+}
+
+func TestParseUNIF(t *testing.T) {
+	data, err := ioutil.ReadFile("../static/nestest.nes")
+	require.NoError(t, err)
+	prg := data[16 : 16+16*utils.Kb]
+	chr := data[16+16*utils.Kb:]
+
+	// PRG分成两块，校验和chunk会被忽略
+	unif := makeUNIF(
+		unifChunkBytes("MAPR", []byte("NES-NROM-128\x00")),
+		unifChunkBytes("NAME", []byte("nestest\x00")),
+		unifChunkBytes("PRG0", prg[:8*utils.Kb]),
+		unifChunkBytes("PRG1", prg[8*utils.Kb:]),
+		unifChunkBytes("PCK0", []byte{1, 2, 3, 4}),
+		unifChunkBytes("CHR0", chr),
+		unifChunkBytes("MIRR", []byte{0}),
+		unifChunkBytes("CTRL", []byte{0x09}),
+	)
+	nesRom, err := ParseBytes(unif)
+	require.NoError(t, err)
+	require.Equal(t, "NES-NROM-128", nesRom.Board)
+	require.Equal(t, ControllerPad|ControllerArkanoid, nesRom.Controllers)
+	require.Equal(t, "pad, arkanoid", nesRom.Controllers.String())
+	require.Equal(t, prg, nesRom.PrgRom)
+	require.Equal(t, chr, nesRom.ChrRom)
+	require.Equal(t, uint32(0x158B0388), nesRom.CRC32)
+	require.NotNil(t, nesRom.DBEntry)
+
+	// 8k的PRG被镜像成16k
+	unif = makeUNIF(
+		unifChunkBytes("MAPR", []byte("HVC-NROM\x00")),
+		unifChunkBytes("PRG0", prg[:8*utils.Kb]),
+		unifChunkBytes("MIRR", []byte{1}),
+		unifChunkBytes("BATR", []byte{1}),
+	)
+	nesRom, err = ParseBytes(unif)
+	require.NoError(t, err)
+	require.Equal(t, prg[:8*utils.Kb], nesRom.PrgRom[8*utils.Kb:])
+	require.Len(t, nesRom.ChrRom, 0)
+	require.Equal(t, VerticalMirror, nesRom.Header.Flag6.MirrorMode)
+	require.True(t, nesRom.Header.Flag6.HasBattery)
+
+	for board, msg := range map[string]string{
+		"NES-SLROM":    "unif board NES-SLROM (mapper 1) is not implemented",
+		"UNL-FOO":      `unif board "UNL-FOO" is not supported`,
+		"BMC-NROM-128": `unif board "BMC-NROM-128" is not supported`, // 合卡不是普通的NROM
+	} {
+		_, err = ParseBytes(makeUNIF(unifChunkBytes("MAPR", []byte(board)), unifChunkBytes("PRG0", prg)))
+		require.EqualError(t, err, msg, board)
+	}
+	_, err = ParseBytes(makeUNIF(unifChunkBytes("PRG0", prg)))
+	require.Error(t, err)
+	_, err = ParseBytes(unif[:len(unif)-1])
+	require.Error(t, err)
+}
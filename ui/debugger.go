@@ -0,0 +1,341 @@
+package ui
+
+import (
+	"fc-emulator/cpu"
+	"fc-emulator/debug"
+	"fmt"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"strings"
+	"time"
+)
+
+// DebuggerTabItem 调试器界面: 反汇编、寄存器、栈、断点管理以及运行控制按钮
+func DebuggerTabItem(d *debug.Debugger) *container.TabItem {
+	status := widget.NewLabel("")
+	disasm := widget.NewTextGrid()
+	stack := widget.NewTextGrid()
+	regs := newRegisterPanel(d)
+	breakpoints := newBreakpointPanel(d)
+
+	refresh := func() {
+		state := d.State()
+		status.SetText(debuggerStatusStr(state))
+		disasm.SetText(disassemblyStr(d, state))
+		stack.SetText(stackStr(d.Stack(), state.Register.S))
+		regs.update(state)
+		breakpoints.refresh()
+	}
+
+	runCommand := func(fn func() error) func() {
+		return func() {
+			if err := fn(); err != nil {
+				status.SetText(err.Error())
+			}
+		}
+	}
+	toolbar := container.NewHBox(
+		widget.NewButton("Run", runCommand(d.Continue)),
+		widget.NewButton("Pause", d.Pause),
+		widget.NewButton("Step Into", runCommand(d.StepInto)),
+		widget.NewButton("Step Over", runCommand(d.StepOver)),
+		widget.NewButton("Step Out", runCommand(d.StepOut)),
+		widget.NewButton("Run To NMI", runCommand(d.RunToNMI)),
+		widget.NewButton("Refresh", refresh),
+	)
+
+	d.PauseCallback = func(state *debug.State) {
+		refresh()
+	}
+	go func() {
+		c := time.Tick(1 * time.Second)
+		for {
+			<-c
+			if !d.IsPaused() {
+				status.SetText(debuggerStatusStr(d.State()))
+				regs.setEditable(false)
+			}
+		}
+	}()
+	refresh()
+
+	right := container.NewVBox(
+		widget.NewLabel("Registers"), regs.content,
+		widget.NewLabel("Stack"), stack,
+	)
+	center := container.NewHSplit(container.NewScroll(disasm), container.NewScroll(right))
+	center.SetOffset(0.6)
+	content := container.NewBorder(
+		container.NewVBox(toolbar, status), nil, nil, nil,
+		container.NewVSplit(center, breakpoints.content),
+	)
+	return container.NewTabItem("Debugger", content)
+}
+
+func debuggerStatusStr(state *debug.State) string {
+	if !state.Paused {
+		return fmt.Sprintf("Running  frame: %d", state.Frame)
+	}
+	return fmt.Sprintf("Paused (%s)  frame: %d  scanline: %d  cycles: %d",
+		state.Hit, state.Frame, state.Scanline, state.Cycles)
+}
+
+func disassemblyStr(d *debug.Debugger, state *debug.State) string {
+	marks := map[uint16]bool{}
+	for _, bp := range d.Breakpoints() {
+		if bp.Enabled {
+			marks[bp.Addr] = true
+		}
+	}
+	lines := d.Disassemble(10, 20)
+	res := make([]string, 0, len(lines))
+	for _, line := range lines {
+		prefix := "  "
+		if marks[line.Addr] {
+			prefix = "* "
+		}
+		if line.Addr == state.Register.PC {
+			prefix = prefix[:1] + ">"
+		}
+		res = append(res, prefix+line.String())
+	}
+	return strings.Join(res, "\n")
+}
+
+func stackStr(stack []byte, s uint8) string {
+	res := make([]string, 0, len(stack))
+	for i, v := range stack {
+		res = append(res, fmt.Sprintf("%04X: %02X", 0x0100+int(s)+1+i, v))
+	}
+	return strings.Join(res, "\n")
+}
+
+var flagNames = []struct {
+	flag cpu.Flag
+	name string
+}{
+	{cpu.FLAG_N, "N"}, {cpu.FLAG_V, "V"}, {cpu.FLAG_U, "U"}, {cpu.FLAG_B, "B"},
+	{cpu.FLAG_D, "D"}, {cpu.FLAG_I, "I"}, {cpu.FLAG_Z, "Z"}, {cpu.FLAG_C, "C"},
+}
+
+type registerPanel struct {
+	content *fyne.Container
+	entries map[string]*widget.Entry
+	flags   map[cpu.Flag]*widget.Check
+	// 刷新界面时会触发Check的回调，这时不能回写寄存器
+	updating bool
+}
+
+func newRegisterPanel(d *debug.Debugger) *registerPanel {
+	p := &registerPanel{
+		entries: map[string]*widget.Entry{},
+		flags:   map[cpu.Flag]*widget.Check{},
+	}
+	form := container.NewGridWithColumns(4)
+	for _, name := range []string{"PC", "A", "X", "Y", "S", "P"} {
+		name := name
+		entry := widget.NewEntry()
+		entry.OnSubmitted = func(text string) {
+			v, err := debug.ParseNumber(text)
+			if err != nil || !d.IsPaused() {
+				return
+			}
+			d.SetRegister(func(reg *cpu.Register) {
+				setRegister(reg, name, v)
+			})
+		}
+		p.entries[name] = entry
+		form.Add(widget.NewLabel(name))
+		form.Add(entry)
+	}
+	flagBox := container.NewHBox()
+	for _, f := range flagNames {
+		f := f
+		check := widget.NewCheck(f.name, func(checked bool) {
+			if p.updating || !d.IsPaused() {
+				return
+			}
+			d.SetRegister(func(reg *cpu.Register) {
+				if checked {
+					reg.P |= uint8(f.flag)
+				} else {
+					reg.P &= ^uint8(f.flag)
+				}
+			})
+		})
+		p.flags[f.flag] = check
+		flagBox.Add(check)
+	}
+	p.content = container.NewVBox(form, flagBox)
+	return p
+}
+
+func setRegister(reg *cpu.Register, name string, v int) {
+	switch name {
+	case "PC":
+		reg.PC = uint16(v)
+	case "A":
+		reg.A = uint8(v)
+	case "X":
+		reg.X = uint8(v)
+	case "Y":
+		reg.Y = uint8(v)
+	case "S":
+		reg.S = uint8(v)
+	case "P":
+		reg.P = uint8(v)
+	}
+}
+
+// setEditable 只有暂停时才能修改寄存器，CPU运行时修改会和执行指令冲突
+func (p *registerPanel) setEditable(editable bool) {
+	for _, entry := range p.entries {
+		if editable {
+			entry.Enable()
+		} else {
+			entry.Disable()
+		}
+	}
+	for _, check := range p.flags {
+		if editable {
+			check.Enable()
+		} else {
+			check.Disable()
+		}
+	}
+}
+
+func (p *registerPanel) update(state *debug.State) {
+	reg := state.Register
+	p.updating = true
+	defer func() { p.updating = false }()
+	p.setEditable(state.Paused)
+	p.entries["PC"].SetText(fmt.Sprintf("$%04X", reg.PC))
+	p.entries["A"].SetText(fmt.Sprintf("$%02X", reg.A))
+	p.entries["X"].SetText(fmt.Sprintf("$%02X", reg.X))
+	p.entries["Y"].SetText(fmt.Sprintf("$%02X", reg.Y))
+	p.entries["S"].SetText(fmt.Sprintf("$%02X", reg.S))
+	p.entries["P"].SetText(fmt.Sprintf("$%02X", reg.P))
+	for flag, check := range p.flags {
+		check.SetChecked(reg.P&uint8(flag) != 0)
+	}
+}
+
+type breakpointPanel struct {
+	content  fyne.CanvasObject
+	list     *widget.List
+	items    []string
+	ids      []int
+	selected int
+	refresh  func()
+}
+
+func newBreakpointPanel(d *debug.Debugger) *breakpointPanel {
+	p := &breakpointPanel{selected: -1}
+	status := widget.NewLabel("")
+	p.list = widget.NewList(
+		func() int { return len(p.items) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			o.(*widget.Label).SetText(p.items[i])
+		},
+	)
+	p.list.OnSelected = func(id widget.ListItemID) {
+		p.selected = id
+	}
+	p.refresh = func() {
+		p.items = p.items[:0]
+		p.ids = p.ids[:0]
+		for _, bp := range d.Breakpoints() {
+			p.items = append(p.items, fmt.Sprintf("#%d  break $%04X  %s  hits: %d  %s",
+				bp.ID, bp.Addr, enabledStr(bp.Enabled), bp.Hits, bp.Cond))
+			p.ids = append(p.ids, bp.ID)
+		}
+		for _, wp := range d.Watchpoints() {
+			p.items = append(p.items, fmt.Sprintf("#%d  watch %s %s $%04X-$%04X  %s  hits: %d  %s",
+				wp.ID, wp.Space, wp.Kind, wp.Start, wp.End, enabledStr(wp.Enabled), wp.Hits, wp.Cond))
+			p.ids = append(p.ids, wp.ID)
+		}
+		p.list.Refresh()
+	}
+
+	addrEntry := widget.NewEntry()
+	addrEntry.SetPlaceHolder("$C000 or $0300-$03FF")
+	condEntry := widget.NewEntry()
+	condEntry.SetPlaceHolder("condition, e.g. A == #$FF && [$0300] > 3")
+	kindSelect := widget.NewSelect([]string{"exec", "cpu r", "cpu w", "cpu rw", "ppu r", "ppu w", "ppu rw"}, nil)
+	kindSelect.SetSelected("exec")
+
+	add := func() {
+		err := addBreakOrWatch(d, kindSelect.Selected, addrEntry.Text, condEntry.Text)
+		if err != nil {
+			status.SetText(err.Error())
+			return
+		}
+		status.SetText("")
+		p.refresh()
+	}
+	withSelected := func(fn func(id int)) func() {
+		return func() {
+			if p.selected >= 0 && p.selected < len(p.ids) {
+				fn(p.ids[p.selected])
+				p.list.UnselectAll()
+				p.selected = -1
+				p.refresh()
+			}
+		}
+	}
+	buttons := container.NewHBox(
+		widget.NewButton("Add", add),
+		widget.NewButton("Remove", withSelected(func(id int) { d.Remove(id) })),
+		widget.NewButton("Enable", withSelected(func(id int) { d.SetEnabled(id, true) })),
+		widget.NewButton("Disable", withSelected(func(id int) { d.SetEnabled(id, false) })),
+	)
+	form := container.NewVBox(
+		container.NewGridWithColumns(3, kindSelect, addrEntry, condEntry),
+		buttons, status,
+	)
+	p.content = container.NewBorder(form, nil, nil, nil, p.list)
+	return p
+}
+
+func enabledStr(enabled bool) string {
+	if enabled {
+		return "on "
+	}
+	return "off"
+}
+
+func addBreakOrWatch(d *debug.Debugger, kind, addrText, cond string) error {
+	parts := strings.SplitN(strings.TrimSpace(addrText), "-", 2)
+	start, err := debug.ParseNumber(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return fmt.Errorf("bad address %q", addrText)
+	}
+	end := start
+	if len(parts) == 2 {
+		if end, err = debug.ParseNumber(strings.TrimSpace(parts[1])); err != nil {
+			return fmt.Errorf("bad address %q", addrText)
+		}
+	}
+	if kind == "exec" {
+		if start == end {
+			_, err = d.AddBreakpoint(uint16(start), cond)
+		} else {
+			_, err = d.AddWatchpoint(debug.CPUSpace, uint16(start), uint16(end), debug.AccessExec, cond)
+		}
+		return err
+	}
+	fields := strings.Fields(kind)
+	space := debug.CPUSpace
+	if fields[0] == "ppu" {
+		space = debug.PPUSpace
+	}
+	access, err := debug.ParseAccessKind(fields[1])
+	if err != nil {
+		return err
+	}
+	_, err = d.AddWatchpoint(space, uint16(start), uint16(end), access, cond)
+	return err
+}
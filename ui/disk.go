@@ -0,0 +1,51 @@
+package ui
+
+import (
+	"fc-emulator/emu"
+	"fc-emulator/fds"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"time"
+)
+
+// DiskTabItem FDS换面和拔出磁盘，只有加载的是磁盘镜像时才显示
+func DiskTabItem(emulator *emu.Emu) *container.TabItem {
+	names := make([]string, 0, emulator.Disk.Sides())
+	for i := 0; i < emulator.Disk.Sides(); i++ {
+		names = append(names, fds.SideName(i))
+	}
+	status := widget.NewLabel("")
+	refresh := func() {
+		if side := emulator.Disk.Disk(); side == fds.NoDisk {
+			status.SetText("No disk inserted")
+		} else {
+			status.SetText("Inserted: " + fds.SideName(side))
+		}
+	}
+	sideSelect := widget.NewSelect(names, nil)
+	insert := widget.NewButton("Insert", func() {
+		if sideSelect.SelectedIndex() < 0 {
+			status.SetText("select a side first")
+			return
+		}
+		if err := emulator.InsertDisk(sideSelect.SelectedIndex()); err != nil {
+			status.SetText(err.Error())
+		}
+	})
+	eject := widget.NewButton("Eject", func() {
+		_ = emulator.InsertDisk(fds.NoDisk)
+	})
+	go func() {
+		c := time.Tick(1 * time.Second)
+		for {
+			<-c
+			refresh()
+		}
+	}()
+	refresh()
+	return container.NewTabItem("Disk", container.NewVBox(
+		container.NewHBox(sideSelect, insert, eject),
+		status,
+		widget.NewLabel("Writes are saved to "+emu.DiskSaveFile(emulator.RomFileName)+", the image is never modified"),
+	))
+}
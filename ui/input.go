@@ -0,0 +1,197 @@
+package ui
+
+import (
+	"fc-emulator/input"
+	"fc-emulator/joystick"
+	"fc-emulator/pad"
+	"fmt"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inputPanel 按键设置，点一下要设置的手柄按键，再按一个键盘或游戏手柄上的键就绑定上去，改动马上生效。
+// 向导会依次等待当前手柄的每一个按键，按Esc跳过。
+type inputPanel struct {
+	in       *input.Input
+	fileName string
+	romHash  string
+
+	mu      sync.Mutex
+	port    int
+	waiting []input.Binding // 等待按键的手柄按键，第一个是当前的
+	buttons []*widget.Button
+	status  *widget.Label
+}
+
+// inputRows 每个手柄可以设置的按键
+var inputRows = []input.Binding{
+	{Button: pad.BUTTON_UP}, {Button: pad.BUTTON_DOWN}, {Button: pad.BUTTON_LEFT}, {Button: pad.BUTTON_RIGHT},
+	{Button: pad.BUTTON_SELECT}, {Button: pad.BUTTON_START}, {Button: pad.BUTTON_B}, {Button: pad.BUTTON_A},
+	{Button: pad.BUTTON_B, Turbo: true}, {Button: pad.BUTTON_A, Turbo: true},
+}
+
+func rowName(b input.Binding) string {
+	name := strings.ToUpper(input.ButtonName(b.Button))
+	if b.Turbo {
+		return "Turbo " + name
+	}
+	return name
+}
+
+// inputTabItem fileName是按键设置文件，romHash是当前游戏，用来保存游戏专用的设置，joysticks可以是nil
+func inputTabItem(in *input.Input, fileName, romHash string, joysticks *joystick.Manager) (*container.TabItem, *inputPanel) {
+	p := &inputPanel{in: in, fileName: fileName, romHash: romHash, status: widget.NewLabel("")}
+	form := container.NewGridWithColumns(2)
+	for _, row := range inputRows {
+		row := row
+		button := widget.NewButton("", func() {
+			p.wait([]input.Binding{row})
+		})
+		p.buttons = append(p.buttons, button)
+		form.Add(widget.NewLabel(rowName(row)))
+		form.Add(button)
+	}
+	wizard := widget.NewButton("Wizard", func() {
+		p.wait(inputRows)
+	})
+
+	players := make([]string, 0, input.Ports)
+	for i := 0; i < input.Ports; i++ {
+		players = append(players, fmt.Sprintf("Player %d", i+1))
+	}
+	player := widget.NewSelect(players, func(s string) {
+		p.mu.Lock()
+		for i, name := range players {
+			if name == s {
+				p.port = i
+			}
+		}
+		p.waiting = nil
+		p.mu.Unlock()
+		p.refresh()
+	})
+
+	m := in.Mapping()
+	turboLabel := widget.NewLabel("")
+	turbo := widget.NewSlider(1, 30)
+	turbo.Step = 1
+	turbo.Value = float64(m.TurboRate)
+	turbo.OnChanged = func(v float64) {
+		m := in.Mapping()
+		m.TurboRate = int(v)
+		in.SetMapping(m)
+		turboLabel.SetText(fmt.Sprintf("Turbo: %d/s", m.TurboRate))
+	}
+	turboLabel.SetText(fmt.Sprintf("Turbo: %d/s", m.TurboRate))
+	opposite := widget.NewCheck("Allow left+right / up+down (TAS)", func(allow bool) {
+		m := in.Mapping()
+		m.AllowOpposite = allow
+		in.SetMapping(m)
+	})
+	opposite.Checked = m.AllowOpposite
+
+	save := func(section string) {
+		if err := input.SaveFile(p.fileName, section, in.Mapping()); err != nil {
+			p.status.SetText(err.Error())
+			return
+		}
+		p.status.SetText(fmt.Sprintf("Saved [%s] to %s", section, p.fileName))
+	}
+	saveDefault := widget.NewButton("Save as default", func() { save(input.DefaultSection) })
+	saveGame := widget.NewButton("Save for this game", func() { save(p.romHash) })
+
+	devices := widget.NewLabel("")
+	if joysticks != nil {
+		refreshDevices := func() {
+			if names := joysticks.Devices(); len(names) > 0 {
+				devices.SetText(strings.Join(names, "\n"))
+			} else {
+				devices.SetText("No joystick")
+			}
+		}
+		go func() {
+			c := time.Tick(1 * time.Second)
+			for {
+				<-c
+				refreshDevices()
+			}
+		}()
+		refreshDevices()
+	}
+
+	player.SetSelectedIndex(0)
+	return container.NewTabItem("Input", container.NewVBox(
+		container.NewHBox(player, wizard),
+		form,
+		container.NewBorder(nil, nil, turboLabel, nil, turbo),
+		opposite,
+		container.NewHBox(saveDefault, saveGame),
+		p.status,
+		devices,
+	)), p
+}
+
+// wait 依次等待rows(不含手柄编号)的按键
+func (p *inputPanel) wait(rows []input.Binding) {
+	p.mu.Lock()
+	p.waiting = make([]input.Binding, 0, len(rows))
+	for _, b := range rows {
+		b.Port = p.port
+		p.waiting = append(p.waiting, b)
+	}
+	p.mu.Unlock()
+	p.refresh()
+}
+
+func (p *inputPanel) refresh() {
+	p.mu.Lock()
+	port := p.port
+	var waiting *input.Binding
+	if len(p.waiting) > 0 {
+		waiting = &p.waiting[0]
+	}
+	p.mu.Unlock()
+	m := p.in.Mapping()
+	for i, row := range inputRows {
+		b := row
+		b.Port = port
+		text := strings.Join(m.KeysOf(b), ", ")
+		if waiting != nil && *waiting == b {
+			text = "..."
+		} else if text == "" {
+			text = "(none)"
+		}
+		p.buttons[i].SetText(text)
+	}
+	if waiting != nil {
+		p.status.SetText(fmt.Sprintf("Press a key or joystick button for player %d %s (Esc to skip)",
+			waiting.Port+1, rowName(*waiting)))
+	}
+}
+
+// capture 正在等待按键时，把按下的键绑定到当前的手柄按键上，返回是否用掉了这个键
+func (p *inputPanel) capture(key string) bool {
+	p.mu.Lock()
+	if len(p.waiting) == 0 {
+		p.mu.Unlock()
+		return false
+	}
+	b := p.waiting[0]
+	p.waiting = p.waiting[1:]
+	done := len(p.waiting) == 0
+	p.mu.Unlock()
+	if key != string(fyne.KeyEscape) {
+		m := p.in.Mapping()
+		m.Bind(key, b)
+		p.in.SetMapping(m)
+	}
+	if done {
+		p.status.SetText("")
+	}
+	p.refresh()
+	return true
+}
@@ -0,0 +1,208 @@
+package ui
+
+import (
+	"fc-emulator/debug"
+	"fmt"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"image/color"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hexBytesPerRow = 16
+	hexRows        = 32
+	hexPageSize    = hexBytesPerRow * hexRows
+	// 变化过的字节保持高亮的刷新次数
+	hexHighlightTicks = 3
+)
+
+var changedByteStyle = &widget.CustomTextGridStyle{FGColor: color.RGBA{R: 0xFF, G: 0x40, B: 0x40, A: 0xFF}}
+
+type hexViewer struct {
+	mu      sync.Mutex
+	views   []debug.MemoryView
+	view    debug.MemoryView
+	offset  int
+	prev    []byte
+	changed map[int]int // 地址 -> 剩余的高亮次数
+	grid    *widget.TextGrid
+	status  *widget.Label
+}
+
+// MemoryTabItem 十六进制内存查看器，可以查看和修改CPU、PPU、OAM和调色板
+func MemoryTabItem(views ...debug.MemoryView) *container.TabItem {
+	v := &hexViewer{
+		views:   views,
+		view:    views[0],
+		changed: map[int]int{},
+		grid:    widget.NewTextGrid(),
+		status:  widget.NewLabel(""),
+	}
+	names := make([]string, 0, len(views))
+	for _, view := range views {
+		names = append(names, view.Name())
+	}
+	spaceSelect := widget.NewSelect(names, func(name string) {
+		for _, view := range v.views {
+			if view.Name() == name {
+				v.mu.Lock()
+				v.view = view
+				v.mu.Unlock()
+			}
+		}
+		v.goTo(0)
+	})
+	spaceSelect.SetSelected(names[0])
+
+	gotoEntry := widget.NewEntry()
+	gotoEntry.SetPlaceHolder("goto $0300")
+	gotoEntry.OnSubmitted = func(text string) {
+		addr, err := debug.ParseNumber(text)
+		if err != nil || addr < 0 || addr >= v.view.Size() {
+			v.status.SetText(fmt.Sprintf("bad address %q", text))
+			return
+		}
+		v.goTo(addr)
+	}
+
+	searchEntry := widget.NewEntry()
+	searchEntry.SetPlaceHolder(`search: A9 10 or "TEXT"`)
+	lastFound := -1
+	searchEntry.OnSubmitted = func(text string) {
+		pattern, err := debug.ParseSearchPattern(text)
+		if err != nil {
+			v.status.SetText(err.Error())
+			return
+		}
+		addr, ok := debug.Find(v.view, pattern, lastFound+1)
+		if !ok {
+			v.status.SetText("not found")
+			return
+		}
+		lastFound = addr
+		v.goTo(addr)
+		v.status.SetText(fmt.Sprintf("found at $%04X", addr))
+	}
+
+	editAddr := widget.NewEntry()
+	editAddr.SetPlaceHolder("address")
+	editValue := widget.NewEntry()
+	editValue.SetPlaceHolder("value, e.g. $FF or A9 10")
+	write := func() {
+		addr, err := debug.ParseNumber(editAddr.Text)
+		if err != nil {
+			v.status.SetText(fmt.Sprintf("bad address %q", editAddr.Text))
+			return
+		}
+		values, err := debug.ParseSearchPattern(editValue.Text)
+		if err != nil {
+			values = nil
+			if n, err := debug.ParseNumber(editValue.Text); err == nil {
+				values = []byte{byte(n)}
+			}
+		}
+		if len(values) == 0 {
+			v.status.SetText(fmt.Sprintf("bad value %q", editValue.Text))
+			return
+		}
+		for i, val := range values {
+			if addr+i >= v.view.Size() || !v.view.Poke(addr+i, val) {
+				v.status.SetText(fmt.Sprintf("$%04X is read only", addr+i))
+				return
+			}
+		}
+		v.status.SetText(fmt.Sprintf("wrote %d byte(s) at $%04X", len(values), addr))
+		v.refresh()
+	}
+	editValue.OnSubmitted = func(string) { write() }
+
+	prevPage := widget.NewButton("<", func() { v.goTo(v.offset - hexPageSize) })
+	nextPage := widget.NewButton(">", func() { v.goTo(v.offset + hexPageSize) })
+
+	go func() {
+		c := time.Tick(500 * time.Millisecond)
+		for {
+			<-c
+			v.refresh()
+		}
+	}()
+
+	toolbar := container.NewVBox(
+		container.NewGridWithColumns(5, spaceSelect, prevPage, nextPage, gotoEntry, searchEntry),
+		container.NewGridWithColumns(3, editAddr, editValue, widget.NewButton("Write", write)),
+		v.status,
+	)
+	return container.NewTabItem("Memory", container.NewBorder(toolbar, nil, nil, nil, container.NewScroll(v.grid)))
+}
+
+func (v *hexViewer) goTo(addr int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	size := v.view.Size()
+	if addr < 0 {
+		addr = 0
+	}
+	if addr >= size {
+		addr = size - 1
+	}
+	v.offset = addr - addr%hexBytesPerRow
+	v.prev = nil
+	v.changed = map[int]int{}
+	v.render()
+}
+
+func (v *hexViewer) refresh() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.render()
+}
+
+func (v *hexViewer) render() {
+	data := debug.Snapshot(v.view, v.offset, hexPageSize)
+	for addr := range v.changed {
+		v.changed[addr] -= 1
+		if v.changed[addr] <= 0 {
+			delete(v.changed, addr)
+		}
+	}
+	if len(v.prev) == len(data) {
+		for i := range data {
+			if data[i] != v.prev[i] {
+				v.changed[v.offset+i] = hexHighlightTicks
+			}
+		}
+	}
+	v.prev = data
+
+	rows := make([]string, 0, hexRows)
+	for row := 0; row*hexBytesPerRow < len(data); row++ {
+		line := data[row*hexBytesPerRow:]
+		if len(line) > hexBytesPerRow {
+			line = line[:hexBytesPerRow]
+		}
+		hexPart := make([]string, 0, hexBytesPerRow)
+		asciiPart := make([]byte, 0, hexBytesPerRow)
+		for _, b := range line {
+			hexPart = append(hexPart, fmt.Sprintf("%02X", b))
+			if b >= 0x20 && b < 0x7F {
+				asciiPart = append(asciiPart, b)
+			} else {
+				asciiPart = append(asciiPart, '.')
+			}
+		}
+		rows = append(rows, fmt.Sprintf("%04X  %-47s  %s", v.offset+row*hexBytesPerRow, strings.Join(hexPart, " "), asciiPart))
+	}
+	v.grid.SetText(strings.Join(rows, "\n"))
+	for addr := range v.changed {
+		i := addr - v.offset
+		if i < 0 || i >= len(data) {
+			continue
+		}
+		// 每行的格式: 地址(4) + 2个空格 + 每个字节3列
+		row, col := i/hexBytesPerRow, 6+(i%hexBytesPerRow)*3
+		v.grid.SetStyleRange(row, col, row, col+1, changedByteStyle)
+	}
+}
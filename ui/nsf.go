@@ -0,0 +1,107 @@
+package ui
+
+import (
+	"fc-emulator/apu"
+	"fc-emulator/nsf"
+	"fmt"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"log"
+	"time"
+)
+
+// NewNSFWin NSF播放器的窗口: 按播放列表显示曲目，点击曲目或者用上一首、下一首切换。
+// 声音在后台写到sink，关闭窗口时停止，sink由调用者关闭。
+func NewNSFWin(box *nsf.Jukebox, sink apu.Sink, config *UIConfig) fyne.Window {
+	config.Clean()
+	f := box.File()
+	order := box.Order()
+	list := widget.NewList(
+		func() int { return len(order) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			mark := "   "
+			if id == box.Status().Pos {
+				mark = "▶ "
+			}
+			item.(*widget.Label).SetText(fmt.Sprintf("%s%d. %s", mark, order[id]+1, f.Label(order[id])))
+		})
+	status := widget.NewLabel("")
+	message := widget.NewLabel("")
+	showErr := func(err error) {
+		if err != nil {
+			message.SetText(err.Error())
+		}
+	}
+	list.OnSelected = func(id widget.ListItemID) {
+		showErr(box.Select(id))
+		list.UnselectAll()
+	}
+	pause := widget.NewButton("Pause", nil)
+	pause.OnTapped = func() {
+		paused := !box.Status().Paused
+		box.SetPaused(paused)
+		if paused {
+			pause.SetText("Play")
+		} else {
+			pause.SetText("Pause")
+		}
+	}
+	current := -1 // 列表上次刷新时正在播放的位置，只在refresh中使用
+	refresh := func() {
+		s := box.Status()
+		length := "∞"
+		if s.Length > 0 {
+			length = s.Length.Truncate(time.Second).String()
+		}
+		status.SetText(fmt.Sprintf("%d/%d %s  %s / %s", s.Pos+1, len(order), f.Label(order[s.Pos]),
+			s.Elapsed.Truncate(time.Second), length))
+		if s.Pos != current {
+			current = s.Pos
+			list.Refresh()
+		}
+	}
+	refresh()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := box.Play(sink, stop); err != nil {
+			log.Println("play nsf fail: ", err)
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+
+	win := app.New().NewWindow("FC Emulator - " + f.Name)
+	win.SetOnClosed(func() {
+		close(stop)
+		<-done
+	})
+	win.SetContent(container.NewBorder(
+		container.NewVBox(
+			widget.NewLabel(f.String()),
+			container.NewHBox(
+				widget.NewButton("Prev", func() { showErr(box.Prev()) }),
+				pause,
+				widget.NewButton("Next", func() { showErr(box.Next()) }),
+			),
+			status,
+		),
+		message, nil, nil, list))
+	win.Resize(fyne.NewSize(float32(config.Width), float32(config.Height)))
+	return win
+}
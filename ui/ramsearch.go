@@ -0,0 +1,139 @@
+package ui
+
+import (
+	"fc-emulator/cheat"
+	"fc-emulator/debug"
+	"fmt"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ramSearchShowLimit 候选太多时只显示前面的一部分
+const ramSearchShowLimit = 500
+
+// RamSearchTabItem 内存搜索，用来找命数、血量、坐标这些变量，找到后可以直接锁定成金手指
+func RamSearchTabItem(mem cheat.Peeker, cheats *cheat.Engine) *container.TabItem {
+	var mu sync.Mutex
+	search := cheat.NewSearch(mem, cheat.DefaultRegions)
+	status := widget.NewLabel("")
+	items := make([]cheat.Candidate, 0)
+	selected := -1
+
+	list := widget.NewList(
+		func() int { return len(items) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			o.(*widget.Label).SetText(items[i].String())
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		selected = id
+	}
+	refresh := func() {
+		mu.Lock()
+		items = search.Candidates(ramSearchShowLimit)
+		count := search.Count()
+		mu.Unlock()
+		msg := fmt.Sprintf("%d candidates", count)
+		if count > len(items) {
+			msg += fmt.Sprintf(", showing first %d", len(items))
+		}
+		status.SetText(msg)
+		list.Refresh()
+	}
+
+	sizeSelect := widget.NewSelect([]string{"8 bit", "16 bit"}, nil)
+	sizeSelect.SetSelected("8 bit")
+	signedCheck := widget.NewCheck("signed", nil)
+	setView := func() {
+		size := 1
+		if sizeSelect.Selected == "16 bit" {
+			size = 2
+		}
+		mu.Lock()
+		_ = search.SetView(size, signedCheck.Checked)
+		mu.Unlock()
+		refresh()
+	}
+	sizeSelect.OnChanged = func(string) { setView() }
+	signedCheck.OnChanged = func(bool) { setView() }
+
+	filter := func(op cheat.SearchOp) func() {
+		return func() {
+			mu.Lock()
+			search.Filter(op, 0)
+			mu.Unlock()
+			list.UnselectAll()
+			selected = -1
+			refresh()
+		}
+	}
+	valueEntry := widget.NewEntry()
+	valueEntry.SetPlaceHolder("value, e.g. 3 or $FF")
+	filterValue := func() {
+		v, err := debug.ParseNumber(valueEntry.Text)
+		if err != nil {
+			status.SetText(fmt.Sprintf("bad value %q", valueEntry.Text))
+			return
+		}
+		mu.Lock()
+		search.Filter(cheat.OpValue, v)
+		mu.Unlock()
+		refresh()
+	}
+	valueEntry.OnSubmitted = func(string) { filterValue() }
+
+	freeze := func() {
+		if selected < 0 || selected >= len(items) {
+			status.SetText("select a candidate first")
+			return
+		}
+		mu.Lock()
+		pars, err := search.Freeze(items[selected]) // 16位时是两个字节的码
+		mu.Unlock()
+		if err != nil {
+			status.SetText(err.Error())
+			return
+		}
+		codes := make([]string, 0, len(pars))
+		for _, par := range pars {
+			cheats.Add(par)
+			codes = append(codes, par.Code)
+		}
+		status.SetText(fmt.Sprintf("freeze %s added", strings.Join(codes, " ")))
+	}
+
+	go func() {
+		c := time.Tick(1 * time.Second)
+		for {
+			<-c
+			refresh()
+		}
+	}()
+	refresh()
+
+	toolbar := container.NewVBox(
+		container.NewHBox(
+			sizeSelect, signedCheck,
+			widget.NewButton("Reset", func() {
+				mu.Lock()
+				search.Reset()
+				mu.Unlock()
+				refresh()
+			}),
+			widget.NewButton("Equal", filter(cheat.OpEqual)),
+			widget.NewButton("Changed", filter(cheat.OpChanged)),
+			widget.NewButton("Increased", filter(cheat.OpIncreased)),
+			widget.NewButton("Decreased", filter(cheat.OpDecreased)),
+		),
+		container.NewGridWithColumns(3, valueEntry,
+			widget.NewButton("Equal To", filterValue),
+			widget.NewButton("Freeze Selected", freeze)),
+		status,
+	)
+	return container.NewTabItem("RAM Search", container.NewBorder(toolbar, nil, nil, nil, list))
+}
@@ -0,0 +1,83 @@
+package ui
+
+import (
+	"fc-emulator/ppu"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/widget"
+	"image"
+)
+
+// PointerDevice 用鼠标操作的输入设备，例如光枪，坐标是游戏画面的像素坐标
+type PointerDevice interface {
+	Aim(x, y int, onScreen bool)
+	SetTrigger(pulled bool)
+}
+
+// gameScreen 显示游戏画面，把鼠标的位置换算成256x240的画面坐标交给pointer
+type gameScreen struct {
+	widget.BaseWidget
+	raster  *canvas.Raster
+	pointer PointerDevice
+}
+
+func newGameScreen(screenFn func() image.Image, pointer PointerDevice) *gameScreen {
+	raster := canvas.NewRaster(func(w, h int) image.Image {
+		return screenFn()
+	})
+	raster.ScaleMode = canvas.ImageScalePixels
+	s := &gameScreen{raster: raster, pointer: pointer}
+	s.ExtendBaseWidget(s)
+	return s
+}
+
+func (s *gameScreen) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(s.raster)
+}
+
+// screenPos 画面是拉伸到整个控件的，按比例换算
+func (s *gameScreen) screenPos(pos fyne.Position) (int, int, bool) {
+	size := s.Size()
+	if size.Width <= 0 || size.Height <= 0 {
+		return 0, 0, false
+	}
+	x := int(pos.X / size.Width * ppu.ScreenWidth)
+	y := int(pos.Y / size.Height * ppu.ScreenHeight)
+	onScreen := x >= 0 && x < ppu.ScreenWidth && y >= 0 && y < ppu.ScreenHeight
+	return x, y, onScreen
+}
+
+func (s *gameScreen) aim(ev *desktop.MouseEvent) {
+	if s.pointer != nil {
+		s.pointer.Aim(s.screenPos(ev.Position))
+	}
+}
+
+func (s *gameScreen) MouseDown(ev *desktop.MouseEvent) {
+	s.aim(ev)
+	if s.pointer != nil && ev.Button == desktop.MouseButtonPrimary {
+		s.pointer.SetTrigger(true)
+	}
+}
+
+func (s *gameScreen) MouseUp(ev *desktop.MouseEvent) {
+	if s.pointer != nil && ev.Button == desktop.MouseButtonPrimary {
+		s.pointer.SetTrigger(false)
+	}
+}
+
+func (s *gameScreen) MouseIn(ev *desktop.MouseEvent) {
+	s.aim(ev)
+}
+
+func (s *gameScreen) MouseMoved(ev *desktop.MouseEvent) {
+	s.aim(ev)
+}
+
+func (s *gameScreen) MouseOut() {
+	if s.pointer != nil {
+		s.pointer.Aim(0, 0, false)
+		s.pointer.SetTrigger(false)
+	}
+}
@@ -0,0 +1,61 @@
+package ui
+
+import (
+	"fc-emulator/emu"
+	"fmt"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"time"
+)
+
+// TapeTabItem Family BASIC录音机的操作，只有插了键盘时才显示，stop关闭后不再刷新
+func TapeTabItem(emulator *emu.Emu, stop <-chan struct{}) *container.TabItem {
+	tape := emulator.Tape
+	status := widget.NewLabel("")
+	message := widget.NewLabel("")
+	refresh := func() {
+		pos, length := tape.Position()
+		status.SetText(fmt.Sprintf("%s  %s / %s", tape.State(),
+			pos.Truncate(time.Second), length.Truncate(time.Second)))
+	}
+	file := widget.NewEntry()
+	file.SetPlaceHolder("tape.wav")
+	load := widget.NewButton("Load", func() {
+		if err := emulator.LoadTape(file.Text); err != nil {
+			message.SetText(err.Error())
+			return
+		}
+		message.SetText("Loaded " + file.Text)
+	})
+	save := widget.NewButton("Save", func() {
+		if err := emulator.SaveTape(file.Text); err != nil {
+			message.SetText(err.Error())
+			return
+		}
+		message.SetText("Saved " + file.Text)
+	})
+	go func() {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+	refresh()
+	return container.NewTabItem("Tape", container.NewVBox(
+		container.NewHBox(
+			widget.NewButton("Play", tape.Play),
+			widget.NewButton("Record", tape.Record),
+			widget.NewButton("Stop", tape.Stop),
+			widget.NewButton("Rewind", tape.Rewind),
+		),
+		status,
+		container.NewBorder(nil, nil, nil, container.NewHBox(load, save), file),
+		message,
+	))
+}
@@ -1,8 +1,10 @@
 package ui
 
 import (
+	"fc-emulator/debug"
 	"fc-emulator/emu"
-	"fc-emulator/pad"
+	"fc-emulator/input"
+	"fc-emulator/joystick"
 	"fc-emulator/ppu"
 	"fc-emulator/rom"
 	"fc-emulator/utils"
@@ -14,6 +16,9 @@ import (
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/widget"
 	"image"
+	"log"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -24,12 +29,9 @@ func PaletteTabItem(name string, getPaletteFn func() ppu.Palette) *container.Tab
 	return container.NewTabItem(name, raster)
 }
 
-func GameScreenTabItem(screenFn func() image.Image) *container.TabItem {
-	raster := canvas.NewRaster(func(w, h int) image.Image {
-		return screenFn()
-	})
-	raster.ScaleMode = canvas.ImageScalePixels
-	return container.NewTabItem("Game", raster)
+// GameScreenTabItem pointer不为nil时，鼠标在画面上的位置和左键交给它
+func GameScreenTabItem(screenFn func() image.Image, pointer PointerDevice) *container.TabItem {
+	return container.NewTabItem("Game", newGameScreen(screenFn, pointer))
 }
 
 func SpriteTableTabItem(source func() image.Image) *container.TabItem {
@@ -81,7 +83,27 @@ func RomInfoTabItem(nesRom *rom.NesRom) *container.TabItem {
 	headerMsg := fmt.Sprintf("MapperNumber: %d \n prgCount: %d \n chrCount: %d \n Flag6: %s \n ",
 		h.MapperNumber, h.PrgCount, h.ChrCount, f6Info)
 	romMsg := fmt.Sprintf("PrgRomSize(kb): %d \n ChrRomSize(kb): %d  \n ", len(nesRom.PrgRom)/utils.Kb, len(nesRom.ChrRom)/utils.Kb)
-	return container.NewTabItem("Rom Info", widget.NewTextGridFromString(headerMsg+"\n"+romMsg))
+	return container.NewTabItem("Rom Info", widget.NewTextGridFromString(headerMsg+"\n"+romMsg+"\n"+romDBStr(nesRom)))
+}
+
+func romDBStr(nesRom *rom.NesRom) string {
+	msg := fmt.Sprintf("CRC32: %08X \n SHA1: %s \n Submapper: %d \n Region: %s \n ",
+		nesRom.CRC32, nesRom.Hash(), nesRom.Submapper, nesRom.Region)
+	if nesRom.DBEntry == nil {
+		msg += "Database: not found \n "
+	} else {
+		msg += fmt.Sprintf("Database: %s \n ", nesRom.DBEntry)
+	}
+	if nesRom.Board != "" {
+		msg += "UNIF board: " + nesRom.Board + " \n "
+	}
+	if nesRom.Controllers != 0 {
+		msg += "UNIF controllers: " + nesRom.Controllers.String() + " \n "
+	}
+	if nesRom.HeaderOverridden() {
+		msg += "Header overridden: " + strings.Join(nesRom.HeaderFixes, ", ") + " \n "
+	}
+	return msg
 }
 
 func PPUCtrlStatusTabItem(pu *ppu.PPUImpl) *container.TabItem {
@@ -117,10 +139,23 @@ CanGenerateNMIBreakAtStartOfVerticalBlankingInterval: %v`,
 }
 
 type UIConfig struct {
-	Width  int
-	Height int
+	Width         int
+	Height        int
+	Input         *input.Input      // 为nil时使用input.DefaultMapping
+	InputFile     string            // Input标签页保存按键设置的文件
+	Joysticks     *joystick.Manager // 不为nil时读取游戏手柄的输入
+	ScreenshotDir string            // 按ScreenshotKey截图时保存的目录，为空时是当前目录
+	VideoDir      string            // 按VideoStartKey录像时保存的目录，为空时是当前目录
+	VideoFormat   string            // 录像的扩展名，见emu.VideoFormats，默认是.gif
 }
 
+// ScreenshotKey 截图的快捷键，VideoStartKey、VideoStopKey 开始、停止录像的快捷键
+const (
+	ScreenshotKey = fyne.KeyF12
+	VideoStartKey = fyne.KeyF9
+	VideoStopKey  = fyne.KeyF10
+)
+
 func (c *UIConfig) Clean() {
 	if c.Width <= 0 {
 		c.Width = 480
@@ -128,12 +163,51 @@ func (c *UIConfig) Clean() {
 	if c.Height <= 0 {
 		c.Height = 400
 	}
+	if c.InputFile == "" {
+		c.InputFile = "input.cfg"
+	}
+	if c.VideoFormat == "" {
+		c.VideoFormat = ".gif"
+	}
+}
+
+func saveScreenshot(emulator *emu.Emu, dir string) {
+	path, err := emulator.SaveScreenshot(dir)
+	if err != nil {
+		log.Println("save screenshot fail: ", err)
+		return
+	}
+	log.Println("screenshot saved to", path)
+}
+
+func startVideo(emulator *emu.Emu, dir, format string) {
+	path := filepath.Join(dir, emulator.VideoName(format))
+	if err := emulator.StartVideo(path); err != nil {
+		log.Println("start video fail: ", err)
+		return
+	}
+	log.Println("recording video to", path, "and", emu.AudioName(path))
+}
+
+func stopVideo(emulator *emu.Emu) {
+	if err := emulator.StopVideo(); err != nil {
+		log.Println("stop video fail: ", err)
+		return
+	}
+	log.Println("video stopped")
 }
 
 func NewUIWin(emulator *emu.Emu, config *UIConfig) fyne.Window {
 	config.Clean()
 	pu := emulator.PPU.(*ppu.PPUImpl)
-	gameTabItem := GameScreenTabItem(pu.Render)
+	var pointer PointerDevice
+	switch {
+	case emulator.Zapper != nil:
+		pointer = emulator.Zapper
+	case emulator.Arkanoid != nil:
+		pointer = emulator.Arkanoid
+	}
+	gameTabItem := GameScreenTabItem(pu.Render, pointer)
 	bgPaletteTabItem := PaletteTabItem("BG Palette", pu.BgPalette)
 	spritePaletteTabItem := PaletteTabItem("Sprite Palette", pu.SpritePalette)
 	bgPatternTableTabItem := BgPatternTableTabItem(pu.DrawBGPatternTable)
@@ -154,43 +228,69 @@ func NewUIWin(emulator *emu.Emu, config *UIConfig) fyne.Window {
 		bgPatternTableTabItem,
 		spritePatternTableTabItem,
 		PaletteColorTabItem(),
+		DebuggerTabItem(emulator.Debugger),
+		MemoryTabItem(debug.NewCPUView(emulator.Memo), debug.NewPPUView(pu), debug.NewOAMView(pu), debug.NewPaletteView(pu)),
+		RamSearchTabItem(emulator.Memo, emulator.Cheats),
 	)
+	in := config.Input
+	if in == nil {
+		in = input.New(input.DefaultMapping(), emulator.Pads())
+	}
+	inputTab, inputPanel := inputTabItem(in, config.InputFile, emulator.Rom.Hash(), config.Joysticks)
+	tabs.Append(inputTab)
+	if config.Joysticks != nil {
+		// 游戏手柄不管当前在哪个标签页都有效
+		config.Joysticks.Start(func(key string, pressed bool) {
+			if pressed && inputPanel.capture(key) {
+				return
+			}
+			in.HandleKey(key, pressed)
+		})
+	}
+	if emulator.Disk != nil {
+		tabs.Append(DiskTabItem(emulator))
+	}
+	if emulator.Tape != nil {
+		stop := make(chan struct{})
+		win.SetOnClosed(func() { close(stop) })
+		tabs.Append(TapeTabItem(emulator, stop))
+	}
 
-	win.Canvas().(desktop.Canvas).SetOnKeyDown(func(event *fyne.KeyEvent) {
-		if tabs.Selected() != gameTabItem {
+	handleKey := func(event *fyne.KeyEvent, pressed bool) {
+		if pressed && inputPanel.capture(string(event.Name)) {
 			return
 		}
-		keyMap := map[fyne.KeyName]pad.ButtonType{
-			fyne.KeyW: pad.BUTTON_UP,
-			fyne.KeyS: pad.BUTTON_DOWN,
-			fyne.KeyA: pad.BUTTON_LEFT,
-			fyne.KeyD: pad.BUTTON_RIGHT,
-			fyne.KeyJ: pad.BUTTON_A,
-			fyne.KeyK: pad.BUTTON_B,
-			fyne.KeyU: pad.BUTTON_SELECT,
-			fyne.KeyI: pad.BUTTON_START,
+		if event.Name == ScreenshotKey {
+			if pressed {
+				saveScreenshot(emulator, config.ScreenshotDir)
+			}
+			return
 		}
-		if button, ok := keyMap[event.Name]; ok {
-			emulator.Pad1.UpdateButton(button, true)
+		if event.Name == VideoStartKey || event.Name == VideoStopKey {
+			if pressed && event.Name == VideoStartKey {
+				startVideo(emulator, config.VideoDir, config.VideoFormat)
+			} else if pressed {
+				stopVideo(emulator)
+			}
+			return
 		}
-	})
-	win.Canvas().(desktop.Canvas).SetOnKeyUp(func(event *fyne.KeyEvent) {
-		if tabs.Selected() != gameTabItem {
+		// 只在游戏画面上接受按下，松开总是送出去，否则切换标签页时按着的键会一直按着
+		if pressed && tabs.Selected() != gameTabItem {
 			return
 		}
-		keyMap := map[fyne.KeyName]pad.ButtonType{
-			fyne.KeyW: pad.BUTTON_UP,
-			fyne.KeyS: pad.BUTTON_DOWN,
-			fyne.KeyA: pad.BUTTON_LEFT,
-			fyne.KeyD: pad.BUTTON_RIGHT,
-			fyne.KeyJ: pad.BUTTON_A,
-			fyne.KeyK: pad.BUTTON_B,
-			fyne.KeyU: pad.BUTTON_SELECT,
-			fyne.KeyI: pad.BUTTON_START,
+		if emulator.PowerPad != nil && emulator.PowerPad.PressKey(string(event.Name), pressed) {
+			return
 		}
-		if button, ok := keyMap[event.Name]; ok {
-			emulator.Pad1.UpdateButton(button, false)
+		if emulator.Keyboard != nil && emulator.Keyboard.PressKey(string(event.Name), pressed) {
+			return
 		}
+		in.HandleKey(string(event.Name), pressed)
+	}
+	win.Canvas().(desktop.Canvas).SetOnKeyDown(func(event *fyne.KeyEvent) {
+		handleKey(event, true)
+	})
+	win.Canvas().(desktop.Canvas).SetOnKeyUp(func(event *fyne.KeyEvent) {
+		handleKey(event, false)
 	})
 
 	tabs.SetTabLocation(container.TabLocationLeading)
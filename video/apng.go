@@ -0,0 +1,150 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// APNGWriter 流式写APNG。每一帧用png.Encode编码，取出里面的IDAT数据，
+// 第一帧原样作为默认图像，之后的帧改写成fdAT。帧数在Close时回填到acTL。
+type APNGWriter struct {
+	w      io.WriteSeeker
+	rate   FrameRate
+	frames int
+	seq    uint32
+	ihdr   []byte
+	actl   int64 // acTL块在文件中的位置
+	buf    bytes.Buffer
+}
+
+func NewAPNGWriter(w io.WriteSeeker, rate FrameRate) *APNGWriter {
+	return &APNGWriter{w: w, rate: rate}
+}
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+func readChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("apng: not a png")
+	}
+	data = data[len(pngSignature):]
+	var chunks []pngChunk
+	for len(data) >= 12 {
+		n := int(binary.BigEndian.Uint32(data))
+		if len(data) < 12+n {
+			break
+		}
+		chunks = append(chunks, pngChunk{string(data[4:8]), data[8 : 8+n]})
+		data = data[12+n:]
+	}
+	if len(data) != 0 {
+		return nil, errors.New("apng: truncated png")
+	}
+	return chunks, nil
+}
+
+func (a *APNGWriter) writeChunk(typ string, data []byte) error {
+	buf := make([]byte, 12+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], typ)
+	copy(buf[8:], data)
+	binary.BigEndian.PutUint32(buf[8+len(data):], crc32.ChecksumIEEE(buf[4:8+len(data)]))
+	_, err := a.w.Write(buf)
+	return err
+}
+
+// actlData 帧数和循环次数，0表示无限循环
+func (a *APNGWriter) actlData() []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, uint32(a.frames))
+	return data
+}
+
+func (a *APNGWriter) WriteFrame(img image.Image) error {
+	a.buf.Reset()
+	if err := png.Encode(&a.buf, img); err != nil {
+		return err
+	}
+	chunks, err := readChunks(a.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" {
+		return errors.New("apng: missing IHDR")
+	}
+	if a.frames == 0 {
+		a.ihdr = append([]byte(nil), chunks[0].data...)
+		if _, err := a.w.Write(pngSignature); err != nil {
+			return err
+		}
+		if err := a.writeChunk("IHDR", a.ihdr); err != nil {
+			return err
+		}
+		if a.actl, err = a.w.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+		if err := a.writeChunk("acTL", a.actlData()); err != nil {
+			return err
+		}
+	} else if !bytes.Equal(a.ihdr, chunks[0].data) {
+		// 所有帧的大小和颜色类型必须和第一帧一样
+		return errors.New("apng: frame format differs from the first frame")
+	}
+
+	// 延迟以1/1000秒为单位累计取整
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], a.seq)
+	copy(fctl[4:12], a.ihdr[0:8]) // 宽和高
+	binary.BigEndian.PutUint16(fctl[20:], uint16(delay(a.rate, a.frames, 1, 1000)))
+	binary.BigEndian.PutUint16(fctl[22:], 1000)
+	a.seq++
+	if err := a.writeChunk("fcTL", fctl); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if c.typ != "IDAT" {
+			continue
+		}
+		if a.frames == 0 {
+			err = a.writeChunk("IDAT", c.data)
+		} else {
+			data := make([]byte, 4, 4+len(c.data))
+			binary.BigEndian.PutUint32(data, a.seq)
+			a.seq++
+			err = a.writeChunk("fdAT", append(data, c.data...))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	a.frames++
+	return nil
+}
+
+// Close 写入IEND并回填帧数，不会关闭底层的文件
+func (a *APNGWriter) Close() error {
+	if a.frames == 0 {
+		return errors.New("apng: no frames")
+	}
+	if err := a.writeChunk("IEND", nil); err != nil {
+		return err
+	}
+	if _, err := a.w.Seek(a.actl, io.SeekStart); err != nil {
+		return err
+	}
+	if err := a.writeChunk("acTL", a.actlData()); err != nil {
+		return err
+	}
+	_, err := a.w.Seek(0, io.SeekEnd)
+	return err
+}
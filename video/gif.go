@@ -0,0 +1,79 @@
+package video
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// GIFSkip GIF的延迟以1/100秒为单位，而且很多浏览器会把小于2/100秒的延迟当作1/10秒，所以每2帧取一帧
+const GIFSkip = 2
+
+// GIFWriter 流式写GIF动画。gif.EncodeAll要把所有帧放在内存里，
+// 这里每一帧用gif.Encode单独编码，再把图像块拼到同一个文件里，所有帧共用第一帧的全局调色板。
+type GIFWriter struct {
+	w       io.Writer
+	palette color.Palette
+	rate    FrameRate
+	frames  int // 收到的帧数，包括跳过的
+	buf     bytes.Buffer
+}
+
+// NewGIFWriter palette是画面中所有的颜色，不在里面的颜色按最接近的颜色处理，最多256个
+func NewGIFWriter(w io.Writer, palette color.Palette, rate FrameRate) *GIFWriter {
+	return &GIFWriter{w: w, palette: palette, rate: rate}
+}
+
+func (g *GIFWriter) WriteFrame(img image.Image) error {
+	n := g.frames
+	g.frames++
+	if n%GIFSkip != 0 {
+		return nil
+	}
+	paletted := image.NewPaletted(img.Bounds(), g.palette)
+	draw.Draw(paletted, paletted.Rect, img, img.Bounds().Min, draw.Src)
+	g.buf.Reset()
+	if err := gif.Encode(&g.buf, paletted, nil); err != nil {
+		return err
+	}
+	data := g.buf.Bytes()
+	// 文件头6字节，逻辑屏幕描述7字节，然后是全局调色板
+	if len(data) < 14 || data[len(data)-1] != 0x3B {
+		return errors.New("gif: unexpected encoder output")
+	}
+	header := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		header += 3 << (flags&7 + 1)
+	}
+	if n == 0 {
+		if _, err := g.w.Write(data[:header]); err != nil {
+			return err
+		}
+		// NETSCAPE2.0扩展，无限循环
+		loop := []byte{0x21, 0xFF, 0x0B, 'N', 'E', 'T', 'S', 'C', 'A', 'P', 'E', '2', '.', '0', 0x03, 0x01, 0, 0, 0}
+		if _, err := g.w.Write(loop); err != nil {
+			return err
+		}
+	}
+	// 图形控制扩展，只用来设置这一帧的延迟
+	d := delay(g.rate, n, GIFSkip, 100)
+	gce := []byte{0x21, 0xF9, 0x04, 0x00, byte(d), byte(d >> 8), 0x00, 0x00}
+	if _, err := g.w.Write(gce); err != nil {
+		return err
+	}
+	_, err := g.w.Write(data[header : len(data)-1])
+	return err
+}
+
+// Close 写入文件结束符，不会关闭底层的文件
+func (g *GIFWriter) Close() error {
+	if g.frames == 0 {
+		return errors.New("gif: no frames")
+	}
+	_, err := g.w.Write([]byte{0x3B})
+	return err
+}
@@ -0,0 +1,34 @@
+// Package video 把模拟器的画面逐帧写成视频文件，只用标准库:
+// GIF和APNG是用image/gif、image/png编码每一帧之后再拼起来的，Y4M是没有压缩的YUV。
+// 所有的格式都是流式写入的，录多长都不会把帧留在内存里。
+package video
+
+import "image"
+
+// Encoder 逐帧写入，Close之后文件才完整，不会关闭底层的文件
+type Encoder interface {
+	WriteFrame(img image.Image) error
+	Close() error
+}
+
+// FrameRate 帧率 Num/Den 帧每秒
+type FrameRate struct {
+	Num, Den int
+}
+
+// NTSC NES的帧率 39375000/655171，大约60.0988
+var NTSC = FrameRate{39375000, 655171}
+
+func (r FrameRate) FPS() float64 {
+	return float64(r.Num) / float64(r.Den)
+}
+
+// delay 把帧的时间换算成unit分之一秒，累计取整，长时间录制也不会和声音错开。
+// 返回第n帧(从0开始)到第n+skip帧之间的时间。
+func delay(r FrameRate, n, skip, unit int) int {
+	at := func(frame int) int64 {
+		// frame * Den / Num 秒，四舍五入到1/unit秒
+		return (int64(frame)*int64(r.Den)*int64(unit)*2 + int64(r.Num)) / (int64(r.Num) * 2)
+	}
+	return int(at(n+skip) - at(n))
+}
@@ -0,0 +1,116 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"testing"
+)
+
+var (
+	black = color.RGBA{A: 0xFF}
+	red   = color.RGBA{R: 0xFF, A: 0xFF}
+)
+
+// testFrame 第n帧，(n, 0)是红色的
+func testFrame(n int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for i := range img.Pix {
+		if i%4 == 3 {
+			img.Pix[i] = 0xFF
+		}
+	}
+	img.SetRGBA(n, 0, red)
+	return img
+}
+
+func TestDelay(t *testing.T) {
+	// 60.0988帧每秒，每2帧大约3.33/100秒，累计起来不会漂移
+	total := 0
+	for n := 0; n < 600; n += 2 {
+		d := delay(NTSC, n, 2, 100)
+		require.True(t, d == 3 || d == 4)
+		total += d
+	}
+	require.InDelta(t, 600/NTSC.FPS()*100, total, 1)
+}
+
+func TestGIFWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewGIFWriter(&buf, color.Palette{black, red}, NTSC)
+	for n := 0; n < 6; n++ {
+		require.NoError(t, w.WriteFrame(testFrame(n)))
+	}
+	require.NoError(t, w.Close())
+
+	g, err := gif.DecodeAll(&buf)
+	require.NoError(t, err)
+	require.Len(t, g.Image, 3) // 每2帧取一帧
+	require.Equal(t, []int{3, 4, 3}, g.Delay)
+	require.Equal(t, 0, g.LoopCount)
+	for i, img := range g.Image {
+		r, _, _, _ := img.At(i*GIFSkip, 0).RGBA()
+		require.Equal(t, uint32(0xFFFF), r)
+		r, _, _, _ = img.At(i*GIFSkip+1, 0).RGBA()
+		require.Equal(t, uint32(0), r)
+	}
+}
+
+func TestAPNGWriter(t *testing.T) {
+	f, err := os.CreateTemp("", "video-*.png")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := NewAPNGWriter(f, NTSC)
+	for n := 0; n < 3; n++ {
+		require.NoError(t, w.WriteFrame(testFrame(n)))
+	}
+	require.Error(t, w.WriteFrame(image.NewRGBA(image.Rect(0, 0, 8, 8))))
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	chunks, err := readChunks(data)
+	require.NoError(t, err)
+	var types []string
+	for _, c := range chunks {
+		if len(types) == 0 || types[len(types)-1] != c.typ {
+			types = append(types, c.typ)
+		}
+	}
+	require.Equal(t, []string{"IHDR", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "fcTL", "fdAT", "IEND"}, types)
+	require.Equal(t, uint32(3), binary.BigEndian.Uint32(chunks[1].data))
+
+	// 不支持APNG的解码器只看到第一帧
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 16, 8), img.Bounds())
+	r, _, _, _ := img.At(0, 0).RGBA()
+	require.Equal(t, uint32(0xFFFF), r)
+}
+
+func TestY4MWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewY4MWriter(&buf, NTSC)
+	require.NoError(t, w.WriteFrame(testFrame(0)))
+	require.NoError(t, w.WriteFrame(testFrame(1)))
+	require.Error(t, w.WriteFrame(image.NewRGBA(image.Rect(0, 0, 8, 8))))
+	require.NoError(t, w.Close())
+
+	header := "YUV4MPEG2 W16 H8 F39375000:655171 Ip A1:1 C444 XCOLORRANGE=FULL\n"
+	frame := len("FRAME\n") + 3*16*8
+	data := buf.Bytes()
+	require.Equal(t, header, string(data[:len(header)]))
+	require.Len(t, data, len(header)+2*frame)
+	y, cb, cr := color.RGBToYCbCr(0xFF, 0, 0)
+	second := data[len(header)+frame+len("FRAME\n"):]
+	require.Equal(t, []byte{0, y}, second[0:2])
+	require.Equal(t, cb, second[16*8+1])
+	require.Equal(t, cr, second[2*16*8+1])
+}
@@ -0,0 +1,63 @@
+package video
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Y4MWriter 写没有压缩的YUV4MPEG2视频，4:4:4采样，颜色是全范围的(和JPEG一样)。
+// 文件很大，但是不会损失任何信息，以后可以和WAV一起交给ffmpeg转码。
+type Y4MWriter struct {
+	w      *bufio.Writer
+	rate   FrameRate
+	size   image.Point
+	frames int
+	planes [3][]byte
+}
+
+func NewY4MWriter(w io.Writer, rate FrameRate) *Y4MWriter {
+	return &Y4MWriter{w: bufio.NewWriter(w), rate: rate}
+}
+
+func (y *Y4MWriter) WriteFrame(img image.Image) error {
+	b := img.Bounds()
+	if y.frames == 0 {
+		y.size = b.Size()
+		_, err := fmt.Fprintf(y.w, "YUV4MPEG2 W%d H%d F%d:%d Ip A1:1 C444 XCOLORRANGE=FULL\n",
+			y.size.X, y.size.Y, y.rate.Num, y.rate.Den)
+		if err != nil {
+			return err
+		}
+		for i := range y.planes {
+			y.planes[i] = make([]byte, y.size.X*y.size.Y)
+		}
+	} else if b.Size() != y.size {
+		return fmt.Errorf("y4m: frame size %v differs from %v", b.Size(), y.size)
+	}
+	i := 0
+	for py := b.Min.Y; py < b.Max.Y; py++ {
+		for px := b.Min.X; px < b.Max.X; px++ {
+			r, g, bl, _ := img.At(px, py).RGBA()
+			y.planes[0][i], y.planes[1][i], y.planes[2][i] = color.RGBToYCbCr(byte(r>>8), byte(g>>8), byte(bl>>8))
+			i++
+		}
+	}
+	if _, err := y.w.WriteString("FRAME\n"); err != nil {
+		return err
+	}
+	for _, plane := range y.planes {
+		if _, err := y.w.Write(plane); err != nil {
+			return err
+		}
+	}
+	y.frames++
+	return nil
+}
+
+// Close 写出缓冲的数据，不会关闭底层的文件
+func (y *Y4MWriter) Close() error {
+	return y.w.Flush()
+}